@@ -0,0 +1,345 @@
+// Package cloudevents formats outbox messages as CloudEvents, using the github.com/cloudevents/sdk-go module, so that
+// consumers expecting CloudEvents can receive them without the application encoding each payload as one
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/types"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// TypeHeader optionally overrides the Config.Type of the CloudEvent a message is formatted as, for publishing
+// events of several types through one Formatter. It is removed from the message's headers when it is formatted.
+const TypeHeader = "cloudevents-type"
+
+// ContentTypeHeader is the header recording the content type of a formatted message's payload, which is
+// event.ApplicationCloudEventsJSON for messages in the EncodingStructured, and the event's data content type for
+// messages in the EncodingBinary
+const ContentTypeHeader = "content-type"
+
+// DefaultHeaderPrefix is the default Config.HeaderPrefix, as used by the CloudEvents Kafka protocol binding
+const DefaultHeaderPrefix = "ce_"
+
+// Encoding selects how messages are formatted as CloudEvents
+type Encoding int
+
+const (
+	// EncodingStructured replaces the payload of each message with the whole CloudEvent encoded as JSON, including
+	// the original payload as its data
+	EncodingStructured Encoding = iota
+	// EncodingBinary keeps the payload of each message as the CloudEvent's data, recording the rest of the
+	// CloudEvent in headers prefixed with the Config.HeaderPrefix
+	EncodingBinary
+)
+
+// String returns the name of the encoding
+func (e Encoding) String() string {
+	switch e {
+	case EncodingStructured:
+		return "structured"
+	case EncodingBinary:
+		return "binary"
+	default:
+		return fmt.Sprintf("Encoding(%d)", int(e))
+	}
+}
+
+// Config configures the behaviour of the Formatter
+type Config struct {
+	// Source is the source of every CloudEvent, identifying the context in which they happened, e.g. the URI of the
+	// service publishing them. Required.
+	Source string
+	// Type is the type of the CloudEvents, unless overridden by a message's TypeHeader. Required.
+	Type string
+	// DataContentType is the content type of the message payloads included as the CloudEvents' data, defaults to
+	// event.ApplicationJSON, in which case payloads must be valid JSON
+	DataContentType string
+	// Encoding selects how messages are formatted as CloudEvents, defaults to EncodingStructured
+	Encoding Encoding
+	// HeaderPrefix prefixes the headers recording the CloudEvents' attributes in the EncodingBinary, defaults to
+	// DefaultHeaderPrefix
+	HeaderPrefix string
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.DataContentType == "" {
+		c.DataContentType = event.ApplicationJSON
+	}
+
+	if c.HeaderPrefix == "" {
+		c.HeaderPrefix = DefaultHeaderPrefix
+	}
+
+	if c.Source == "" {
+		return errors.New("source is required")
+	}
+
+	if c.Type == "" {
+		return errors.New("type is required")
+	}
+
+	if c.Encoding != EncodingStructured && c.Encoding != EncodingBinary {
+		return fmt.Errorf("unsupported encoding %v", c.Encoding)
+	}
+
+	return nil
+}
+
+// Formatter maps outbox messages to CloudEvents and back
+type Formatter struct {
+	config Config
+}
+
+// New attempts to construct a Formatter from the provided Config, if the Config is valid
+func New(cfg Config) (*Formatter, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Formatter{
+		config: cfg,
+	}, nil
+}
+
+// ToEvent maps a message to a CloudEvent, identified by the message's Message.DedupKey, which the Outbox sets for
+// every message it publishes, with the message's Message.Key as its subject, its Message.EnqueuedAt as its time and
+// its Message.Payload as its data
+func (f *Formatter) ToEvent(msg outbox.Message) (event.Event, error) {
+	if msg.DedupKey == "" {
+		return event.Event{}, errors.New("message has no dedup key to identify the event")
+	}
+
+	e := event.New()
+	e.SetID(msg.DedupKey)
+	e.SetSource(f.config.Source)
+	e.SetType(f.config.Type)
+	if eventType, ok := msg.Headers[TypeHeader]; ok {
+		e.SetType(string(eventType))
+	}
+	if len(msg.Key) > 0 {
+		e.SetSubject(string(msg.Key))
+	}
+	if !msg.EnqueuedAt.IsZero() {
+		e.SetTime(msg.EnqueuedAt)
+	}
+
+	if isJSON(f.config.DataContentType) {
+		if len(msg.Payload) > 0 && !json.Valid(msg.Payload) {
+			return event.Event{}, fmt.Errorf("payload is not valid %s", f.config.DataContentType)
+		}
+
+		e.SetDataContentType(f.config.DataContentType)
+		e.DataEncoded = msg.Payload
+	} else if err := e.SetData(f.config.DataContentType, msg.Payload); err != nil {
+		return event.Event{}, fmt.Errorf("error setting event data: %w", err)
+	}
+
+	if err := e.Validate(); err != nil {
+		return event.Event{}, fmt.Errorf("invalid event: %w", err)
+	}
+
+	return e, nil
+}
+
+// FromEvent maps a CloudEvent back to the message that ToEvent would map to it, recording its type in the TypeHeader
+// if it differs from the Config.Type
+func (f *Formatter) FromEvent(e event.Event) outbox.Message {
+	msg := outbox.Message{
+		Key:        []byte(e.Subject()),
+		Payload:    e.Data(),
+		DedupKey:   e.ID(),
+		EnqueuedAt: e.Time(),
+	}
+	if len(msg.Key) == 0 {
+		msg.Key = nil
+	}
+	if e.Type() != f.config.Type {
+		msg.Headers = map[string][]byte{TypeHeader: []byte(e.Type())}
+	}
+
+	return msg
+}
+
+// Encode formats a message as a CloudEvent, in the Config.Encoding, keeping the rest of the message's fields and
+// headers other than the TypeHeader as they are
+func (f *Formatter) Encode(msg outbox.Message) (outbox.Message, error) {
+	e, err := f.ToEvent(msg)
+	if err != nil {
+		return outbox.Message{}, err
+	}
+
+	headers := make(map[string][]byte, len(msg.Headers)+1)
+	for k, v := range msg.Headers {
+		if k != TypeHeader {
+			headers[k] = v
+		}
+	}
+
+	switch f.config.Encoding {
+	case EncodingBinary:
+		attributes, err := f.binaryHeaders(e)
+		if err != nil {
+			return outbox.Message{}, err
+		}
+		for k, v := range attributes {
+			headers[k] = v
+		}
+	default:
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return outbox.Message{}, fmt.Errorf("error encoding event: %w", err)
+		}
+
+		msg.Payload = payload
+		headers[ContentTypeHeader] = []byte(event.ApplicationCloudEventsJSON)
+	}
+
+	msg.Headers = headers
+	return msg, nil
+}
+
+// binaryHeaders records the attributes of the CloudEvent, other than its data, in headers for the EncodingBinary
+func (f *Formatter) binaryHeaders(e event.Event) (map[string][]byte, error) {
+	headers := map[string][]byte{
+		f.config.HeaderPrefix + "specversion": []byte(e.SpecVersion()),
+		f.config.HeaderPrefix + "id":          []byte(e.ID()),
+		f.config.HeaderPrefix + "source":      []byte(e.Source()),
+		f.config.HeaderPrefix + "type":        []byte(e.Type()),
+		ContentTypeHeader:                     []byte(e.DataContentType()),
+	}
+	if e.Subject() != "" {
+		headers[f.config.HeaderPrefix+"subject"] = []byte(e.Subject())
+	}
+	if !e.Time().IsZero() {
+		headers[f.config.HeaderPrefix+"time"] = []byte(types.FormatTime(e.Time()))
+	}
+
+	for name, value := range e.Extensions() {
+		formatted, err := types.Format(value)
+		if err != nil {
+			return nil, fmt.Errorf("error formatting extension %s: %w", name, err)
+		}
+		headers[f.config.HeaderPrefix+name] = []byte(formatted)
+	}
+
+	return headers, nil
+}
+
+// Decode parses the CloudEvent from a message formatted by Encode, in either encoding, e.g. for consumers
+func (f *Formatter) Decode(msg outbox.Message) (event.Event, error) {
+	if string(msg.Headers[ContentTypeHeader]) == event.ApplicationCloudEventsJSON {
+		e := event.New()
+		if err := json.Unmarshal(msg.Payload, &e); err != nil {
+			return event.Event{}, fmt.Errorf("error decoding event: %w", err)
+		}
+
+		return e, nil
+	}
+
+	if _, ok := msg.Headers[f.config.HeaderPrefix+"specversion"]; !ok {
+		return event.Event{}, errors.New("message is not a cloud event")
+	}
+
+	e := event.New(string(msg.Headers[f.config.HeaderPrefix+"specversion"]))
+	names := make([]string, 0, len(msg.Headers))
+	for name := range msg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, f.config.HeaderPrefix) {
+			continue
+		}
+
+		value := string(msg.Headers[name])
+		switch attribute := strings.TrimPrefix(name, f.config.HeaderPrefix); attribute {
+		case "specversion":
+		case "id":
+			e.SetID(value)
+		case "source":
+			e.SetSource(value)
+		case "type":
+			e.SetType(value)
+		case "subject":
+			e.SetSubject(value)
+		case "time":
+			at, err := types.ParseTime(value)
+			if err != nil {
+				return event.Event{}, fmt.Errorf("error decoding event time: %w", err)
+			}
+			e.SetTime(at)
+		default:
+			e.SetExtension(attribute, value)
+		}
+	}
+
+	if contentType, ok := msg.Headers[ContentTypeHeader]; ok {
+		e.SetDataContentType(string(contentType))
+	}
+	e.DataEncoded = msg.Payload
+
+	if err := e.Validate(); err != nil {
+		return event.Event{}, fmt.Errorf("invalid event: %w", err)
+	}
+
+	return e, nil
+}
+
+// Middleware formats the messages published through the outbox.Publisher it wraps as CloudEvents with Encode, for use
+// with outbox.Chain. Messages that can't be formatted are rejected with an error wrapping outbox.ErrPermanent,
+// while the rest are still published.
+func (f *Formatter) Middleware(next outbox.Publisher) outbox.Publisher {
+	return outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+		errs := make([]error, len(messages))
+		encoded := make([]outbox.Message, 0, len(messages))
+		indices := make([]int, 0, len(messages))
+		for idx, msg := range messages {
+			msg, err := f.Encode(msg)
+			if err != nil {
+				errs[idx] = outbox.Permanent(fmt.Errorf("error formatting message as a cloud event: %w", err))
+				continue
+			}
+
+			encoded = append(encoded, msg)
+			indices = append(indices, idx)
+		}
+
+		if len(encoded) == len(messages) {
+			return next.Publish(ctx, encoded...)
+		}
+
+		if len(encoded) > 0 {
+			err := next.Publish(ctx, encoded...)
+			var publishErr *outbox.PublishError
+			for idx, msgIdx := range indices {
+				switch {
+				case err == nil:
+				case errors.As(err, &publishErr) && len(publishErr.Errors) == len(encoded):
+					errs[msgIdx] = publishErr.Errors[idx]
+				default:
+					errs[msgIdx] = err
+				}
+			}
+		}
+
+		return &outbox.PublishError{Errors: errs}
+	})
+}
+
+// isJSON reports whether the content type is for JSON data, which CloudEvents in the EncodingStructured include as
+// JSON rather than as a base64 encoded string
+func isJSON(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.ToLower(strings.SplitN(contentType, ";", 2)[0]))
+	return mediaType == event.ApplicationJSON || mediaType == event.TextJSON || strings.HasSuffix(mediaType, "+json")
+}
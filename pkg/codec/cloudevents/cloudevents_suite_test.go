@@ -0,0 +1,13 @@
+package cloudevents_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCloudevents(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Cloudevents Suite")
+}
@@ -0,0 +1,271 @@
+package cloudevents_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/codec/cloudevents"
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Formatter", func() {
+	var cfg cloudevents.Config
+	var formatter *cloudevents.Formatter
+	var enqueuedAt time.Time
+	var msg outbox.Message
+
+	BeforeEach(func() {
+		cfg = cloudevents.Config{
+			Source: "/orders",
+			Type:   "com.example.order.created",
+		}
+
+		enqueuedAt = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		msg = outbox.Message{
+			Key:        []byte("order-1"),
+			Payload:    []byte(`{"total": 3}`),
+			Headers:    map[string][]byte{"tenant": []byte("acme")},
+			DedupKey:   "entry-1",
+			EnqueuedAt: enqueuedAt,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		formatter, err = cloudevents.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("defaults the configuration", func() {
+		Expect(cfg.DefaultAndValidate()).To(Succeed())
+		Expect(cfg.DataContentType).To(Equal(event.ApplicationJSON))
+		Expect(cfg.Encoding).To(Equal(cloudevents.EncodingStructured))
+		Expect(cfg.HeaderPrefix).To(Equal(cloudevents.DefaultHeaderPrefix))
+	})
+
+	table.DescribeTable("rejects invalid configuration",
+		func(mutate func(cfg *cloudevents.Config)) {
+			mutate(&cfg)
+			Expect(cloudevents.New(cfg)).Error().ToNot(Succeed())
+		},
+		table.Entry("without a source", func(cfg *cloudevents.Config) { cfg.Source = "" }),
+		table.Entry("without a type", func(cfg *cloudevents.Config) { cfg.Type = "" }),
+		table.Entry("with an unknown encoding", func(cfg *cloudevents.Config) { cfg.Encoding = 5 }),
+	)
+
+	It("maps messages to events and back", func() {
+		e, err := formatter.ToEvent(msg)
+		Expect(err).To(Succeed())
+		Expect(e.ID()).To(Equal("entry-1"))
+		Expect(e.Source()).To(Equal("/orders"))
+		Expect(e.Type()).To(Equal("com.example.order.created"))
+		Expect(e.Subject()).To(Equal("order-1"))
+		Expect(e.Time()).To(Equal(enqueuedAt))
+		Expect(e.Data()).To(MatchJSON(`{"total": 3}`))
+
+		Expect(formatter.FromEvent(e)).To(Equal(outbox.Message{
+			Key:        []byte("order-1"),
+			Payload:    []byte(`{"total": 3}`),
+			DedupKey:   "entry-1",
+			EnqueuedAt: enqueuedAt,
+		}))
+	})
+
+	It("overrides the type with the type header", func() {
+		msg.Headers[cloudevents.TypeHeader] = []byte("com.example.order.shipped")
+
+		e, err := formatter.ToEvent(msg)
+		Expect(err).To(Succeed())
+		Expect(e.Type()).To(Equal("com.example.order.shipped"))
+
+		Expect(formatter.FromEvent(e).Headers).To(Equal(map[string][]byte{
+			cloudevents.TypeHeader: []byte("com.example.order.shipped"),
+		}))
+	})
+
+	It("fails to map messages without a dedup key", func() {
+		msg.DedupKey = ""
+		Expect(formatter.ToEvent(msg)).Error().To(MatchError(ContainSubstring("no dedup key")))
+	})
+
+	It("fails to map payloads that aren't JSON", func() {
+		msg.Payload = []byte("not json")
+		Expect(formatter.ToEvent(msg)).Error().To(MatchError(ContainSubstring("not valid application/json")))
+	})
+
+	Describe("the structured encoding", func() {
+		It("encodes the whole event as the payload", func() {
+			encoded, err := formatter.Encode(msg)
+			Expect(err).To(Succeed())
+
+			Expect(encoded.Key).To(Equal(msg.Key))
+			Expect(encoded.Headers).To(Equal(map[string][]byte{
+				"tenant":                      []byte("acme"),
+				cloudevents.ContentTypeHeader: []byte("application/cloudevents+json"),
+			}))
+			Expect(encoded.Payload).To(MatchJSON(`{
+				"specversion": "1.0",
+				"id": "entry-1",
+				"source": "/orders",
+				"type": "com.example.order.created",
+				"subject": "order-1",
+				"time": "2024-01-02T03:04:05Z",
+				"datacontenttype": "application/json",
+				"data": {"total": 3}
+			}`))
+
+			e, err := formatter.Decode(encoded)
+			Expect(err).To(Succeed())
+			Expect(formatter.FromEvent(e)).To(Equal(outbox.Message{
+				Key:        []byte("order-1"),
+				Payload:    []byte(`{"total":3}`),
+				DedupKey:   "entry-1",
+				EnqueuedAt: enqueuedAt,
+			}))
+		})
+
+		When("the payloads aren't JSON", func() {
+			BeforeEach(func() {
+				cfg.DataContentType = "application/octet-stream"
+				msg.Payload = []byte("binary")
+			})
+
+			It("encodes the data as base64", func() {
+				encoded, err := formatter.Encode(msg)
+				Expect(err).To(Succeed())
+				Expect(encoded.Payload).To(MatchJSON(`{
+					"specversion": "1.0",
+					"id": "entry-1",
+					"source": "/orders",
+					"type": "com.example.order.created",
+					"subject": "order-1",
+					"time": "2024-01-02T03:04:05Z",
+					"datacontenttype": "application/octet-stream",
+					"data_base64": "YmluYXJ5"
+				}`))
+
+				e, err := formatter.Decode(encoded)
+				Expect(err).To(Succeed())
+				Expect(e.Data()).To(Equal([]byte("binary")))
+			})
+		})
+	})
+
+	Describe("the binary encoding", func() {
+		BeforeEach(func() {
+			cfg.Encoding = cloudevents.EncodingBinary
+		})
+
+		It("records the event's attributes in headers", func() {
+			encoded, err := formatter.Encode(msg)
+			Expect(err).To(Succeed())
+
+			Expect(encoded.Payload).To(Equal(msg.Payload))
+			Expect(encoded.Headers).To(Equal(map[string][]byte{
+				"tenant":                      []byte("acme"),
+				"ce_specversion":              []byte("1.0"),
+				"ce_id":                       []byte("entry-1"),
+				"ce_source":                   []byte("/orders"),
+				"ce_type":                     []byte("com.example.order.created"),
+				"ce_subject":                  []byte("order-1"),
+				"ce_time":                     []byte("2024-01-02T03:04:05Z"),
+				cloudevents.ContentTypeHeader: []byte("application/json"),
+			}))
+
+			e, err := formatter.Decode(encoded)
+			Expect(err).To(Succeed())
+			Expect(formatter.FromEvent(e)).To(Equal(outbox.Message{
+				Key:        []byte("order-1"),
+				Payload:    []byte(`{"total": 3}`),
+				DedupKey:   "entry-1",
+				EnqueuedAt: enqueuedAt,
+			}))
+		})
+
+		It("uses the configured header prefix", func() {
+			cfg.HeaderPrefix = "ce-"
+			formatter, err := cloudevents.New(cfg)
+			Expect(err).To(Succeed())
+
+			encoded, err := formatter.Encode(msg)
+			Expect(err).To(Succeed())
+			Expect(encoded.Headers).To(HaveKeyWithValue("ce-id", []byte("entry-1")))
+
+			e, err := formatter.Decode(encoded)
+			Expect(err).To(Succeed())
+			Expect(e.ID()).To(Equal("entry-1"))
+		})
+	})
+
+	It("fails to decode messages that aren't cloud events", func() {
+		Expect(formatter.Decode(msg)).Error().To(MatchError("message is not a cloud event"))
+	})
+
+	Describe("Middleware", func() {
+		It("publishes messages as cloud events, rejecting those that can't be formatted", func() {
+			ctx := context.Background()
+			clock := clockwork.NewFakeClock()
+			storage := &fake.EntryStorage{
+				Clock: clock,
+			}
+			publisher := &fake.Publisher{
+				Logger: logr.Discard(),
+			}
+			deadLetters := &fake.DeadLetterHandler{}
+
+			ob, err := outbox.New(outbox.Config{
+				Clock:             clock,
+				Storage:           storage,
+				Publisher:         outbox.Chain(publisher, formatter.Middleware),
+				DeadLetterHandler: deadLetters,
+				ProcessorID:       "test",
+			})
+			Expect(err).To(Succeed())
+
+			Expect(ob.Publish(ctx, nil,
+				outbox.Message{Key: []byte("order-1"), Payload: []byte(`{"total": 3}`)},
+				outbox.Message{Key: []byte("order-2"), Payload: []byte("not json")},
+			)).To(Succeed())
+
+			Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+			published := publisher.GetPublished()
+			Expect(published).To(HaveLen(1))
+			e, err := formatter.Decode(published[0].Message)
+			Expect(err).To(Succeed())
+			Expect(e.Subject()).To(Equal("order-1"))
+			Expect(e.ID()).To(Equal(published[0].DedupKey))
+
+			Expect(deadLetters.GetDeadLettered()).To(ConsistOf(HaveField("Key", []byte("order-2"))))
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+
+		It("passes on the errors of the publisher it wraps", func() {
+			publisher := &fake.Publisher{
+				Logger: logr.Discard(),
+				FailFunc: func(msg outbox.Message) error {
+					return errors.New("broker unavailable")
+				},
+			}
+
+			err := formatter.Middleware(publisher).Publish(context.Background(),
+				outbox.Message{DedupKey: "entry-1", Payload: []byte(`{}`)},
+				outbox.Message{DedupKey: "entry-2", Payload: []byte("not json")},
+			)
+
+			var publishErr *outbox.PublishError
+			Expect(errors.As(err, &publishErr)).To(BeTrue())
+			Expect(publishErr.Errors[0]).To(MatchError("broker unavailable"))
+			Expect(publishErr.Errors[1]).To(MatchError(outbox.ErrPermanent))
+		})
+	})
+})
@@ -0,0 +1,69 @@
+// Package json implements outbox.Codec using JSON, from the encoding/json package
+package json
+
+import (
+	"bytes"
+	stdjson "encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// Config configures the behaviour of the Codec
+type Config struct {
+	// DisallowUnknownFields fails to unmarshal payloads with fields that the value being decoded into doesn't have,
+	// rather than ignoring them, e.g. to detect consumers that are out of date
+	DisallowUnknownFields bool
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	return nil
+}
+
+// Codec implements outbox.Codec using JSON
+type Codec struct {
+	config Config
+}
+
+// New attempts to construct a Codec from the provided Config, if the Config is valid
+func New(cfg Config) (*Codec, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Codec{
+		config: cfg,
+	}, nil
+}
+
+// Marshal implements the outbox.Codec interface
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	payload, err := stdjson.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling json: %w", err)
+	}
+
+	return payload, nil
+}
+
+// Unmarshal implements the outbox.Codec interface
+func (c *Codec) Unmarshal(payload []byte, v interface{}) error {
+	decoder := stdjson.NewDecoder(bytes.NewReader(payload))
+	if c.config.DisallowUnknownFields {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("error unmarshalling json: %w", err)
+	}
+	if decoder.More() {
+		return errors.New("error unmarshalling json: unexpected data after value")
+	}
+
+	return nil
+}
+
+var _ outbox.Codec = (*Codec)(nil)
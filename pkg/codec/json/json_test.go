@@ -0,0 +1,102 @@
+package json_test
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/codec/json"
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+type order struct {
+	ID    string   `json:"id"`
+	Items []string `json:"items"`
+	Total int      `json:"total"`
+}
+
+var _ = Describe("Codec", func() {
+	var codec *json.Codec
+
+	BeforeEach(func() {
+		var err error
+		codec, err = json.New(json.Config{})
+		Expect(err).To(Succeed())
+	})
+
+	It("round trips values", func() {
+		payload, err := codec.Marshal(order{ID: "order-1", Items: []string{"apple"}, Total: 3})
+		Expect(err).To(Succeed())
+		Expect(payload).To(MatchJSON(`{"id": "order-1", "items": ["apple"], "total": 3}`))
+
+		var decoded order
+		Expect(codec.Unmarshal(payload, &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(order{ID: "order-1", Items: []string{"apple"}, Total: 3}))
+	})
+
+	It("fails to marshal values that can't be encoded", func() {
+		Expect(codec.Marshal(make(chan int))).Error().ToNot(Succeed())
+	})
+
+	It("fails to unmarshal payloads that aren't json", func() {
+		var decoded order
+		Expect(codec.Unmarshal([]byte("not json"), &decoded)).ToNot(Succeed())
+		Expect(codec.Unmarshal([]byte(`{"id": "order-1"} {}`), &decoded)).ToNot(Succeed())
+	})
+
+	It("ignores unknown fields by default", func() {
+		var decoded order
+		Expect(codec.Unmarshal([]byte(`{"id": "order-1", "status": "new"}`), &decoded)).To(Succeed())
+		Expect(decoded.ID).To(Equal("order-1"))
+	})
+
+	It("optionally disallows unknown fields", func() {
+		strict, err := json.New(json.Config{DisallowUnknownFields: true})
+		Expect(err).To(Succeed())
+
+		var decoded order
+		Expect(strict.Unmarshal([]byte(`{"id": "order-1", "status": "new"}`), &decoded)).ToNot(Succeed())
+	})
+
+	It("round trips values through the outbox", func() {
+		ctx := context.Background()
+		clock := clockwork.NewFakeClock()
+		publisher := &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		ob, err := outbox.New(outbox.Config{
+			Clock: clock,
+			Storage: &fake.EntryStorage{
+				Clock: clock,
+			},
+			Publisher:   publisher,
+			ProcessorID: "test",
+			Codec:       codec,
+		})
+		Expect(err).To(Succeed())
+
+		Expect(ob.PublishValues(ctx, nil, order{ID: "order-1", Items: []string{"apple", "pear"}, Total: 5})).To(Succeed())
+
+		msg, err := outbox.EncodeMessage(codec, order{ID: "order-2", Total: 1}, outbox.WithKey([]byte("order-2")))
+		Expect(err).To(Succeed())
+		Expect(ob.Publish(ctx, nil, msg)).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+		var decoded []order
+		for _, published := range publisher.GetPublished() {
+			var o order
+			Expect(outbox.DecodePayload(codec, published.Message, &o)).To(Succeed())
+			decoded = append(decoded, o)
+		}
+		Expect(decoded).To(ConsistOf(
+			order{ID: "order-1", Items: []string{"apple", "pear"}, Total: 5},
+			order{ID: "order-2", Total: 1},
+		))
+	})
+})
@@ -0,0 +1,135 @@
+// Package proto implements outbox.Codec using protocol buffers, wrapping each message in a google.protobuf.Any so
+// that payloads carry the type URL of the message they contain, letting consumers of polymorphic event streams
+// dispatch on the type of each payload without a side channel
+package proto
+
+import (
+	"errors"
+	"fmt"
+
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// Config configures the behaviour of the Codec
+type Config struct {
+	// Types resolves the type URLs of wrapped messages when unwrapping them, defaults to protoregistry.GlobalTypes,
+	// which contains every message type linked into the application
+	Types *protoregistry.Types
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.Types == nil {
+		c.Types = protoregistry.GlobalTypes
+	}
+
+	return nil
+}
+
+// Codec implements outbox.Codec by marshalling proto messages wrapped in a google.protobuf.Any
+type Codec struct {
+	config Config
+}
+
+// New attempts to construct a Codec from the provided Config, if the Config is valid
+func New(cfg Config) (*Codec, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Codec{
+		config: cfg,
+	}, nil
+}
+
+// Marshal implements the outbox.Codec interface, v must be a proto message
+func (c *Codec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(protobuf.Message)
+	if !ok {
+		return nil, fmt.Errorf("unsupported type %T, expected a proto message", v)
+	}
+
+	wrapped, err := anypb.New(msg)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping message: %w", err)
+	}
+
+	payload, err := protobuf.Marshal(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling message: %w", err)
+	}
+
+	return payload, nil
+}
+
+// Unmarshal implements the outbox.Codec interface, v must be a proto message of the same type as the wrapped message
+func (c *Codec) Unmarshal(payload []byte, v interface{}) error {
+	msg, ok := v.(protobuf.Message)
+	if !ok {
+		return fmt.Errorf("unsupported type %T, expected a proto message", v)
+	}
+
+	wrapped, err := unmarshalAny(payload)
+	if err != nil {
+		return err
+	}
+
+	if err := anypb.UnmarshalTo(wrapped, msg, protobuf.UnmarshalOptions{Resolver: c.config.Types}); err != nil {
+		return fmt.Errorf("error unwrapping message: %w", err)
+	}
+
+	return nil
+}
+
+// Wrap constructs an outbox.Message whose payload is the proto message wrapped in a google.protobuf.Any, configured
+// by any options
+func (c *Codec) Wrap(msg protobuf.Message, opts ...outbox.MessageOption) (outbox.Message, error) {
+	return outbox.EncodeMessage(c, msg, opts...)
+}
+
+// Unwrap returns the proto message wrapped in the payload of an outbox.Message, as a new message of the type named
+// by its type URL, which must be known to the Config.Types
+func (c *Codec) Unwrap(msg outbox.Message) (protobuf.Message, error) {
+	wrapped, err := unmarshalAny(msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	unwrapped, err := anypb.UnmarshalNew(wrapped, protobuf.UnmarshalOptions{Resolver: c.config.Types})
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping message of type %q: %w", wrapped.GetTypeUrl(), err)
+	}
+
+	return unwrapped, nil
+}
+
+// TypeURL returns the type URL of the proto message wrapped in the payload of an outbox.Message, without unwrapping
+// it, e.g. for consumers to dispatch on
+func TypeURL(msg outbox.Message) (string, error) {
+	wrapped, err := unmarshalAny(msg.Payload)
+	if err != nil {
+		return "", err
+	}
+
+	return wrapped.GetTypeUrl(), nil
+}
+
+// unmarshalAny unmarshals a payload marshalled by Marshal back into its google.protobuf.Any
+func unmarshalAny(payload []byte) (*anypb.Any, error) {
+	wrapped := &anypb.Any{}
+	if err := protobuf.Unmarshal(payload, wrapped); err != nil {
+		return nil, fmt.Errorf("error unmarshalling payload: %w", err)
+	}
+	if wrapped.GetTypeUrl() == "" {
+		return nil, errors.New("error unmarshalling payload: missing type URL")
+	}
+
+	return wrapped, nil
+}
+
+var _ outbox.Codec = (*Codec)(nil)
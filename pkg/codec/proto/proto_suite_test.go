@@ -0,0 +1,13 @@
+package proto_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestProto(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Proto Suite")
+}
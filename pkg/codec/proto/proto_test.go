@@ -0,0 +1,125 @@
+package proto_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/omaskery/outboxen/pkg/codec/proto"
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Codec", func() {
+	var codec *proto.Codec
+
+	BeforeEach(func() {
+		var err error
+		codec, err = proto.New(proto.Config{})
+		Expect(err).To(Succeed())
+	})
+
+	It("defaults to the global types", func() {
+		cfg := proto.Config{}
+		Expect(cfg.DefaultAndValidate()).To(Succeed())
+		Expect(cfg.Types).To(BeIdenticalTo(protoregistry.GlobalTypes))
+	})
+
+	table.DescribeTable("round trips messages along with their type URL",
+		func(msg protobuf.Message, typeURL string) {
+			wrapped, err := codec.Wrap(msg, outbox.WithKey([]byte("key")))
+			Expect(err).To(Succeed())
+			Expect(wrapped.Key).To(Equal([]byte("key")))
+
+			Expect(proto.TypeURL(wrapped)).To(Equal(typeURL))
+
+			unwrapped, err := codec.Unwrap(wrapped)
+			Expect(err).To(Succeed())
+			Expect(protobuf.Equal(unwrapped, msg)).To(BeTrue())
+
+			decoded := msg.ProtoReflect().New().Interface()
+			Expect(outbox.DecodePayload(codec, wrapped, decoded)).To(Succeed())
+			Expect(protobuf.Equal(decoded, msg)).To(BeTrue())
+		},
+		table.Entry("a string", wrapperspb.String("hello"), "type.googleapis.com/google.protobuf.StringValue"),
+		table.Entry("a timestamp", timestamppb.New(time.Unix(1700000000, 0)), "type.googleapis.com/google.protobuf.Timestamp"),
+		table.Entry("a duration", durationpb.New(time.Minute), "type.googleapis.com/google.protobuf.Duration"),
+	)
+
+	It("fails to marshal values that aren't proto messages", func() {
+		Expect(codec.Marshal("hello")).Error().To(MatchError(ContainSubstring("expected a proto message")))
+	})
+
+	It("fails to unmarshal into a message of a different type", func() {
+		wrapped, err := codec.Wrap(wrapperspb.String("hello"))
+		Expect(err).To(Succeed())
+
+		Expect(codec.Unmarshal(wrapped.Payload, &durationpb.Duration{})).To(MatchError(ContainSubstring("mismatched message type")))
+	})
+
+	It("fails to unwrap payloads that aren't wrapped messages", func() {
+		Expect(codec.Unwrap(outbox.Message{Payload: []byte("not a proto")})).Error().ToNot(Succeed())
+		Expect(codec.Unwrap(outbox.Message{})).Error().To(MatchError(ContainSubstring("missing type URL")))
+		Expect(proto.TypeURL(outbox.Message{})).Error().ToNot(Succeed())
+	})
+
+	It("fails to unwrap messages of types it doesn't know", func() {
+		wrapped, err := codec.Wrap(wrapperspb.String("hello"))
+		Expect(err).To(Succeed())
+
+		restricted, err := proto.New(proto.Config{Types: &protoregistry.Types{}})
+		Expect(err).To(Succeed())
+		Expect(restricted.Unwrap(wrapped)).Error().ToNot(Succeed())
+	})
+
+	It("lets consumers dispatch on the type of messages published through the outbox", func() {
+		ctx := context.Background()
+		clock := clockwork.NewFakeClock()
+		publisher := &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		ob, err := outbox.New(outbox.Config{
+			Clock: clock,
+			Storage: &fake.EntryStorage{
+				Clock: clock,
+			},
+			Publisher:   publisher,
+			ProcessorID: "test",
+			Codec:       codec,
+		})
+		Expect(err).To(Succeed())
+
+		Expect(ob.PublishValues(ctx, nil, wrapperspb.String("hello"), durationpb.New(time.Second))).To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+		var strings []string
+		var durations []time.Duration
+		for _, published := range publisher.GetPublished() {
+			unwrapped, err := codec.Unwrap(published.Message)
+			Expect(err).To(Succeed())
+
+			switch msg := unwrapped.(type) {
+			case *wrapperspb.StringValue:
+				strings = append(strings, msg.GetValue())
+			case *durationpb.Duration:
+				durations = append(durations, msg.AsDuration())
+			default:
+				Fail("unexpected message type")
+			}
+		}
+
+		Expect(strings).To(Equal([]string{"hello"}))
+		Expect(durations).To(Equal([]time.Duration{time.Second}))
+	})
+})
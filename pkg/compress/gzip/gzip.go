@@ -0,0 +1,85 @@
+// Package gzip implements outbox.Compressor using gzip, from the compress/gzip package
+package gzip
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// Config configures the behaviour of the Compressor
+type Config struct {
+	// Level is the compress/gzip compression level, from gzip.BestSpeed to gzip.BestCompression, or
+	// gzip.HuffmanOnly. Defaults to gzip.DefaultCompression, as storing payloads without compression is better
+	// achieved by not configuring an outbox.Compressor.
+	Level int
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.Level == stdgzip.NoCompression {
+		c.Level = stdgzip.DefaultCompression
+	}
+
+	if c.Level < stdgzip.HuffmanOnly || c.Level > stdgzip.BestCompression {
+		return fmt.Errorf("invalid compression level %d", c.Level)
+	}
+
+	return nil
+}
+
+// Compressor implements outbox.Compressor using gzip
+type Compressor struct {
+	config Config
+}
+
+// New attempts to construct a Compressor from the provided Config, if the Config is valid
+func New(cfg Config) (*Compressor, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Compressor{
+		config: cfg,
+	}, nil
+}
+
+// Compress implements the outbox.Compressor interface
+func (c *Compressor) Compress(payload []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer, err := stdgzip.NewWriterLevel(&buf, c.config.Level)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gzip writer: %w", err)
+	}
+	if _, err := writer.Write(payload); err != nil {
+		return nil, fmt.Errorf("error compressing payload: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("error compressing payload: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decompress implements the outbox.Compressor interface
+func (c *Compressor) Decompress(compressed []byte) ([]byte, error) {
+	reader, err := stdgzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("error reading gzip header: %w", err)
+	}
+	defer reader.Close()
+
+	payload, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing payload: %w", err)
+	}
+
+	return payload, nil
+}
+
+var _ outbox.Compressor = (*Compressor)(nil)
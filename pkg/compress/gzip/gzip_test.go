@@ -0,0 +1,46 @@
+package gzip_test
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/compress/gzip"
+)
+
+var _ = Describe("Compressor", func() {
+	var compressor *gzip.Compressor
+
+	BeforeEach(func() {
+		var err error
+		compressor, err = gzip.New(gzip.Config{})
+		Expect(err).To(Succeed())
+	})
+
+	It("defaults the compression level", func() {
+		cfg := gzip.Config{}
+		Expect(cfg.DefaultAndValidate()).To(Succeed())
+		Expect(cfg.Level).To(Equal(stdgzip.DefaultCompression))
+	})
+
+	It("rejects invalid compression levels", func() {
+		_, err := gzip.New(gzip.Config{Level: stdgzip.BestCompression + 1})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("round trips payloads", func() {
+		payload := bytes.Repeat([]byte("compressible "), 100)
+
+		compressed, err := compressor.Compress(payload)
+		Expect(err).To(Succeed())
+		Expect(len(compressed)).To(BeNumerically("<", len(payload)))
+
+		Expect(compressor.Decompress(compressed)).To(Equal(payload))
+	})
+
+	It("fails to decompress payloads that aren't gzipped", func() {
+		Expect(compressor.Decompress([]byte("not gzipped"))).Error().ToNot(Succeed())
+	})
+})
@@ -0,0 +1,134 @@
+// Package aesgcm implements outbox.Encryptor using AES-GCM, supporting key rotation by prefixing each ciphertext
+// with the ID of the key that encrypted it.
+//
+// Ciphertexts are laid out as a single byte holding the length of the key ID, the key ID, the random nonce, then
+// the sealed payload.
+package aesgcm
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// maxKeyIDLength is the longest key ID that fits in the single byte length prefix of a ciphertext
+const maxKeyIDLength = 255
+
+// Config configures the behaviour of the Encryptor
+type Config struct {
+	// Keys holds the AES keys, each of 16, 24 or 32 bytes, by their key ID. Payloads can be decrypted with any of
+	// them, so when rotating keys keep the old key until every entry encrypted with it has been published.
+	Keys map[string][]byte
+	// KeyID identifies the key in Keys that encrypts new payloads
+	KeyID string
+	// Rand provides the nonces, defaults to crypto/rand.Reader
+	Rand io.Reader
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if len(c.Keys) == 0 {
+		return errors.New("no keys provided")
+	}
+
+	for keyID := range c.Keys {
+		if keyID == "" || len(keyID) > maxKeyIDLength {
+			return fmt.Errorf("key ID %q must be between 1 and %d bytes long", keyID, maxKeyIDLength)
+		}
+	}
+
+	if _, ok := c.Keys[c.KeyID]; !ok {
+		return fmt.Errorf("no key provided for key ID %q", c.KeyID)
+	}
+
+	if c.Rand == nil {
+		c.Rand = rand.Reader
+	}
+
+	return nil
+}
+
+// Encryptor implements outbox.Encryptor using AES-GCM
+type Encryptor struct {
+	config Config
+	aeads  map[string]cipher.AEAD
+}
+
+// New attempts to construct an Encryptor from the provided Config, if the Config is valid
+func New(cfg Config) (*Encryptor, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	aeads := make(map[string]cipher.AEAD, len(cfg.Keys))
+	for keyID, key := range cfg.Keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", keyID, err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", keyID, err)
+		}
+
+		aeads[keyID] = aead
+	}
+
+	return &Encryptor{
+		config: cfg,
+		aeads:  aeads,
+	}, nil
+}
+
+// Encrypt implements the outbox.Encryptor interface, encrypting with the key identified by Config.KeyID
+func (e *Encryptor) Encrypt(plaintext []byte) ([]byte, error) {
+	keyID := e.config.KeyID
+	aead := e.aeads[keyID]
+
+	header := make([]byte, 0, 1+len(keyID)+aead.NonceSize())
+	header = append(header, byte(len(keyID)))
+	header = append(header, keyID...)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(e.config.Rand, nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+	header = append(header, nonce...)
+
+	return aead.Seal(header, nonce, plaintext, nil), nil
+}
+
+// Decrypt implements the outbox.Encryptor interface, decrypting with whichever key the ciphertext was encrypted by
+func (e *Encryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < 1 || len(ciphertext) < 1+int(ciphertext[0]) {
+		return nil, errors.New("ciphertext too short for its key ID")
+	}
+
+	keyID := string(ciphertext[1 : 1+ciphertext[0]])
+	ciphertext = ciphertext[1+len(keyID):]
+
+	aead, ok := e.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("no key provided for key ID %q", keyID)
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short for its nonce")
+	}
+
+	plaintext, err := aead.Open(nil, ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("error decrypting with key %q: %w", keyID, err)
+	}
+
+	return plaintext, nil
+}
+
+var _ outbox.Encryptor = (*Encryptor)(nil)
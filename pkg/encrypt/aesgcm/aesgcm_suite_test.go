@@ -0,0 +1,13 @@
+package aesgcm_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestAESGCM(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "AES-GCM Suite")
+}
@@ -0,0 +1,89 @@
+package aesgcm_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/encrypt/aesgcm"
+)
+
+var _ = Describe("Encryptor", func() {
+	oldKey := bytes.Repeat([]byte{1}, 32)
+	newKey := bytes.Repeat([]byte{2}, 32)
+	plaintext := []byte("personal data")
+
+	newEncryptor := func(keyID string, keys map[string][]byte) *aesgcm.Encryptor {
+		encryptor, err := aesgcm.New(aesgcm.Config{Keys: keys, KeyID: keyID})
+		Expect(err).To(Succeed())
+		return encryptor
+	}
+
+	DescribeTable(
+		"rejects invalid configs",
+		func(cfg aesgcm.Config) {
+			_, err := aesgcm.New(cfg)
+			Expect(err).ToNot(Succeed())
+		},
+		Entry("without keys", aesgcm.Config{KeyID: "key"}),
+		Entry("without a key for the key ID", aesgcm.Config{Keys: map[string][]byte{"other": oldKey}, KeyID: "key"}),
+		Entry("with an empty key ID", aesgcm.Config{Keys: map[string][]byte{"": oldKey}}),
+		Entry("with a key of an invalid size", aesgcm.Config{Keys: map[string][]byte{"key": []byte("short")}, KeyID: "key"}),
+	)
+
+	It("round trips payloads", func() {
+		encryptor := newEncryptor("old", map[string][]byte{"old": oldKey})
+
+		ciphertext, err := encryptor.Encrypt(plaintext)
+		Expect(err).To(Succeed())
+		Expect(ciphertext).ToNot(ContainSubstring(string(plaintext)))
+
+		Expect(encryptor.Decrypt(ciphertext)).To(Equal(plaintext))
+	})
+
+	It("uses a fresh nonce for each payload", func() {
+		encryptor := newEncryptor("old", map[string][]byte{"old": oldKey})
+
+		first, err := encryptor.Encrypt(plaintext)
+		Expect(err).To(Succeed())
+		second, err := encryptor.Encrypt(plaintext)
+		Expect(err).To(Succeed())
+		Expect(first).ToNot(Equal(second))
+	})
+
+	It("decrypts payloads encrypted with a rotated key", func() {
+		ciphertext, err := newEncryptor("old", map[string][]byte{"old": oldKey}).Encrypt(plaintext)
+		Expect(err).To(Succeed())
+
+		rotated := newEncryptor("new", map[string][]byte{"old": oldKey, "new": newKey})
+		Expect(rotated.Decrypt(ciphertext)).To(Equal(plaintext))
+
+		ciphertext, err = rotated.Encrypt(plaintext)
+		Expect(err).To(Succeed())
+		Expect(rotated.Decrypt(ciphertext)).To(Equal(plaintext))
+	})
+
+	It("fails to decrypt with the wrong key", func() {
+		ciphertext, err := newEncryptor("key", map[string][]byte{"key": oldKey}).Encrypt(plaintext)
+		Expect(err).To(Succeed())
+
+		wrongKey := newEncryptor("key", map[string][]byte{"key": newKey})
+		Expect(wrongKey.Decrypt(ciphertext)).Error().ToNot(Succeed())
+	})
+
+	It("fails to decrypt with an unknown key ID", func() {
+		ciphertext, err := newEncryptor("old", map[string][]byte{"old": oldKey}).Encrypt(plaintext)
+		Expect(err).To(Succeed())
+
+		retired := newEncryptor("new", map[string][]byte{"new": newKey})
+		Expect(retired.Decrypt(ciphertext)).Error().To(MatchError(ContainSubstring(`no key provided for key ID "old"`)))
+	})
+
+	It("fails to decrypt truncated ciphertexts", func() {
+		encryptor := newEncryptor("key", map[string][]byte{"key": oldKey})
+		Expect(encryptor.Decrypt(nil)).Error().ToNot(Succeed())
+		Expect(encryptor.Decrypt([]byte{10, 'k'})).Error().ToNot(Succeed())
+	})
+})
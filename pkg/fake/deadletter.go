@@ -0,0 +1,40 @@
+package fake
+
+import (
+	"context"
+	"sync"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// DeadLetterHandler is a simple in-memory fake that records the entries it is asked to dead letter
+type DeadLetterHandler struct {
+	// Err, if set, is returned from DeadLetter and the entries are not recorded
+	Err          error
+	lock         sync.RWMutex
+	deadLettered []outbox.ClaimedEntry
+}
+
+// DeadLetter implements the outbox.DeadLetterHandler interface
+func (d *DeadLetterHandler) DeadLetter(_ context.Context, entries ...outbox.ClaimedEntry) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.Err != nil {
+		return d.Err
+	}
+
+	d.deadLettered = append(d.deadLettered, entries...)
+
+	return nil
+}
+
+// GetDeadLettered retrieves a copy of the dead lettered entries
+func (d *DeadLetterHandler) GetDeadLettered() []outbox.ClaimedEntry {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+
+	return append([]outbox.ClaimedEntry(nil), d.deadLettered...)
+}
+
+var _ outbox.DeadLetterHandler = (*DeadLetterHandler)(nil)
@@ -0,0 +1,86 @@
+package fake
+
+import (
+	"sync"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// EventType identifies which outbox.EventSink method an Event was recorded from
+type EventType string
+
+const (
+	EventClaimed   EventType = "claimed"
+	EventPublished EventType = "published"
+	EventFailed    EventType = "failed"
+	EventDeleted   EventType = "deleted"
+)
+
+// Event records a single call to one of the outbox.EventSink methods
+type Event struct {
+	outbox.MessageEvent
+
+	Type EventType
+	// Err is the error passed to MessageFailed, and nil for other events
+	Err error
+}
+
+// EventSink is a simple in-memory fake that records the events it receives, in the order it receives them, so that
+// tests can make assertions about them
+type EventSink struct {
+	lock   sync.RWMutex
+	events []Event
+}
+
+// MessageClaimed implements the outbox.EventSink interface
+func (e *EventSink) MessageClaimed(event outbox.MessageEvent) {
+	e.record(Event{MessageEvent: event, Type: EventClaimed})
+}
+
+// MessagePublished implements the outbox.EventSink interface
+func (e *EventSink) MessagePublished(event outbox.MessageEvent) {
+	e.record(Event{MessageEvent: event, Type: EventPublished})
+}
+
+// MessageFailed implements the outbox.EventSink interface
+func (e *EventSink) MessageFailed(event outbox.MessageEvent, err error) {
+	e.record(Event{MessageEvent: event, Type: EventFailed, Err: err})
+}
+
+// MessageDeleted implements the outbox.EventSink interface
+func (e *EventSink) MessageDeleted(event outbox.MessageEvent) {
+	e.record(Event{MessageEvent: event, Type: EventDeleted})
+}
+
+// record appends the event to those received
+func (e *EventSink) record(event Event) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.events = append(e.events, event)
+}
+
+// GetEvents retrieves a copy of the events received, in the order they were received
+func (e *EventSink) GetEvents() []Event {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return append([]Event(nil), e.events...)
+}
+
+// GetEventTypes retrieves the types of the events received for the entry, in the order they were received
+func (e *EventSink) GetEventTypes(entryID string) []EventType {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	var types []EventType
+	for _, event := range e.events {
+		if event.EntryID == entryID {
+			types = append(types, event.Type)
+		}
+	}
+
+	return types
+}
+
+var _ outbox.EventSink = (*EventSink)(nil)
@@ -0,0 +1,128 @@
+package fake
+
+import (
+	"sync"
+	"time"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// BatchProcessed records a single call to Metrics.RecordBatchProcessed
+type BatchProcessed struct {
+	Size     int
+	Duration time.Duration
+}
+
+// Metrics is a simple in-memory fake that records all measurements it receives, so that tests
+// can make assertions about them
+type Metrics struct {
+	lock             sync.RWMutex
+	claimDurations   []time.Duration
+	batchesProcessed []BatchProcessed
+	published        int
+	publishFailures  int
+	expired          int
+	backlogSizes     []int
+}
+
+// RecordClaimDuration implements the outbox.Metrics interface
+func (m *Metrics) RecordClaimDuration(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.claimDurations = append(m.claimDurations, d)
+}
+
+// RecordBatchProcessed implements the outbox.Metrics interface
+func (m *Metrics) RecordBatchProcessed(size int, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.batchesProcessed = append(m.batchesProcessed, BatchProcessed{
+		Size:     size,
+		Duration: duration,
+	})
+}
+
+// RecordPublished implements the outbox.Metrics interface
+func (m *Metrics) RecordPublished(count int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.published += count
+}
+
+// RecordPublishFailure implements the outbox.Metrics interface
+func (m *Metrics) RecordPublishFailure(count int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.publishFailures += count
+}
+
+// RecordExpired implements the outbox.Metrics interface
+func (m *Metrics) RecordExpired(count int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.expired += count
+}
+
+// RecordBacklogSize implements the outbox.BacklogMetrics interface
+func (m *Metrics) RecordBacklogSize(size int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.backlogSizes = append(m.backlogSizes, size)
+}
+
+// GetClaimDurations retrieves a copy of the recorded claim durations
+func (m *Metrics) GetClaimDurations() []time.Duration {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return append([]time.Duration(nil), m.claimDurations...)
+}
+
+// GetBatchesProcessed retrieves a copy of the recorded batches
+func (m *Metrics) GetBatchesProcessed() []BatchProcessed {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return append([]BatchProcessed(nil), m.batchesProcessed...)
+}
+
+// GetPublishedCount retrieves the total number of messages recorded as published
+func (m *Metrics) GetPublishedCount() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.published
+}
+
+// GetPublishFailureCount retrieves the total number of messages recorded as failing to publish
+func (m *Metrics) GetPublishFailureCount() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.publishFailures
+}
+
+// GetExpiredCount retrieves the total number of entries recorded as expired
+func (m *Metrics) GetExpiredCount() int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.expired
+}
+
+// GetBacklogSizes retrieves a copy of the recorded backlog sizes
+func (m *Metrics) GetBacklogSizes() []int {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return append([]int(nil), m.backlogSizes...)
+}
+
+var _ outbox.Metrics = (*Metrics)(nil)
+var _ outbox.BacklogMetrics = (*Metrics)(nil)
@@ -3,8 +3,10 @@ package fake
 import (
 	"context"
 	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
 
 	"github.com/omaskery/outboxen/pkg/outbox"
 )
@@ -20,31 +22,118 @@ type PublishedMessage struct {
 // a fake, but it does function without configuration from the caller's point of view.
 type Publisher struct {
 	// Logger can be provided to receive log output
-	Logger    logr.Logger
+	Logger logr.Logger
+	// FailFunc, if set, is called with each message and any error it returns fails that message, which is then not
+	// recorded as published. If any messages fail, Publish returns an outbox.PublishError describing them.
+	FailFunc func(msg outbox.Message) error
+	// Delay, if set, is how long Publish blocks before recording messages, to simulate a slow destination. If the
+	// context is done first Publish records nothing and returns the context's error.
+	Delay time.Duration
+	// MessageDelay, if set, is how long PublishMessage blocks before recording each message, to simulate messages
+	// that are slow to publish. If the context is done first PublishMessage returns the context's error.
+	MessageDelay func(msg outbox.Message) time.Duration
+	// OnPublish, if set, is called by Publish and PublishMessage with their context and messages before publishing
+	// them, e.g. to inspect the context they were passed
+	OnPublish func(ctx context.Context, messages ...outbox.Message)
+	// Clock abstracts the time package when waiting for the Delay, defaults to a real clock implementation
+	Clock     outbox.Clock
 	published []PublishedMessage
 	lock      sync.RWMutex
 }
 
 // Publish implements the outbox.Publisher interface
 func (p *Publisher) Publish(ctx context.Context, messages ...outbox.Message) error {
+	if p.OnPublish != nil {
+		p.OnPublish(ctx, messages...)
+	}
+
+	if err := p.wait(ctx, p.Delay); err != nil {
+		return err
+	}
+
 	p.lock.Lock()
 	defer p.lock.Unlock()
 
 	namespace := outbox.NamespaceFromContext(ctx)
 	published := make([]PublishedMessage, 0, len(messages))
-	for _, m := range messages {
+	publishErr := &outbox.PublishError{Errors: make([]error, len(messages))}
+	for idx, m := range messages {
+		if p.FailFunc != nil {
+			if err := p.FailFunc(m); err != nil {
+				publishErr.Errors[idx] = err
+				continue
+			}
+		}
+
 		published = append(published, PublishedMessage{
 			Message:   m,
 			Namespace: namespace,
 		})
 	}
 
-	p.Logger.Info("publishing messages", "count", len(messages))
+	p.Logger.Info("publishing messages", "count", len(messages), "failed", publishErr.ErrorCount())
 	p.published = append(p.published, published...)
 
+	if publishErr.ErrorCount() > 0 {
+		return publishErr
+	}
+	return nil
+}
+
+// PublishMessage implements the outbox.SingleMessagePublisher interface, waiting for the MessageDelay of the message
+// rather than the Delay
+func (p *Publisher) PublishMessage(ctx context.Context, msg outbox.Message) error {
+	if p.OnPublish != nil {
+		p.OnPublish(ctx, msg)
+	}
+
+	if p.MessageDelay != nil {
+		if err := p.wait(ctx, p.MessageDelay(msg)); err != nil {
+			return err
+		}
+	}
+
+	if p.FailFunc != nil {
+		if err := p.FailFunc(msg); err != nil {
+			p.Logger.Info("publishing message", "failed", true)
+			return err
+		}
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.Logger.Info("publishing message", "failed", false)
+	p.published = append(p.published, PublishedMessage{
+		Message:   msg,
+		Namespace: outbox.NamespaceFromContext(ctx),
+	})
+
 	return nil
 }
 
+// wait blocks for the delay, unless the context is done first
+func (p *Publisher) wait(ctx context.Context, delay time.Duration) error {
+	if delay <= 0 {
+		return nil
+	}
+
+	clock := p.Clock
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+
+	timer := clock.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.Chan():
+		return nil
+	}
+}
+
 // GetPublished retrieves a copy of the published messages
 func (p *Publisher) GetPublished() []PublishedMessage {
 	p.lock.RLock()
@@ -78,3 +167,6 @@ func (p *Publisher) Clear() []PublishedMessage {
 
 	return result
 }
+
+var _ outbox.Publisher = (*Publisher)(nil)
+var _ outbox.SingleMessagePublisher = (*Publisher)(nil)
@@ -1,13 +1,14 @@
 package fake
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/omaskery/outboxen/pkg/outbox"
-
-	"github.com/google/uuid"
 )
 
 // Clock abstracts the time package
@@ -15,92 +16,400 @@ type Clock interface {
 	Now() time.Time
 }
 
+// outboxEntry is the full record the EntryStorage keeps for each entry, including the claim bookkeeping that is
+// omitted from the outbox.ClaimedEntry returned to the outbox.Outbox
 type outboxEntry struct {
 	Namespace          string
 	ID                 string
 	Key                []byte
 	Payload            []byte
+	Headers            map[string][]byte
+	NotBefore          *time.Time
+	Attempts           int
+	TraceContext       map[string]string
+	DedupKey           string
+	Priority           int
+	CreatedAt          time.Time
 	ProcessorID        string
 	ProcessingDeadline *time.Time
 }
 
-// EntryStorage is a simple fake implementation of two outbox interfaces:
-//   - outbox.ProcessorStorage: for use directly by the outbox.Outbox to process Outbox ClaimedEntry objects
-//   - outbox.Publisher: for applications to treat as the outbox.Outbox that records their
-//     messages during a transaction
+// inNamespace reports whether the entry is in the namespace of the context, if it has one
+func (o *outboxEntry) inNamespace(ctx context.Context) bool {
+	namespace, ok := outbox.LookupNamespace(ctx)
+	return !ok || o.Namespace == namespace
+}
+
+// inShard reports whether the entry is in the shard of the context, if it has one
+func (o *outboxEntry) inShard(ctx context.Context) bool {
+	index, count := outbox.ShardFromContext(ctx)
+	return count < 1 || outbox.PartitionForKey(o.Key, count) == index
+}
+
+// claimed returns the outbox.ClaimedEntry view of the entry
+func (o *outboxEntry) claimed() outbox.ClaimedEntry {
+	return outbox.ClaimedEntry{
+		Namespace:    o.Namespace,
+		ID:           o.ID,
+		Key:          o.Key,
+		Payload:      o.Payload,
+		Headers:      o.Headers,
+		NotBefore:    o.NotBefore,
+		Attempts:     o.Attempts,
+		TraceContext: o.TraceContext,
+		DedupKey:     o.DedupKey,
+		Priority:     o.Priority,
+		CreatedAt:    o.CreatedAt,
+	}
+}
+
+// message returns the entry's message as it was written
+func (o *outboxEntry) message() outbox.Message {
+	return outbox.Message{
+		Key:          o.Key,
+		Payload:      o.Payload,
+		Headers:      o.Headers,
+		NotBefore:    o.NotBefore,
+		TraceContext: o.TraceContext,
+		DedupKey:     o.DedupKey,
+		Priority:     o.Priority,
+	}
+}
+
+// due reports whether the entry may be published at the given time
+func (o *outboxEntry) due(now time.Time) bool {
+	return o.NotBefore == nil || !now.Before(*o.NotBefore)
+}
+
+// EntryStorage is a simple fake implementation of outbox.ProcessorStorage, which serves two purposes:
+//   - for use directly by the outbox.Outbox to process Outbox ClaimedEntry objects
+//   - for applications to treat as the outbox.Outbox that records their messages during a transaction, as its
+//     Publish takes a transaction just as outbox.Outbox.Publish does, unlike the outbox.Publisher interface
 type EntryStorage struct {
 	// Clock abstracts the time package
-	Clock   Clock
+	Clock Clock
+	// IDGenerator generates the IDs of the entries, defaults to outbox.UUIDGenerator
+	IDGenerator outbox.IDGenerator
+	// MaxPayloadSize rejects messages with larger payloads from Publish, as outbox.CheckPayloadSize does, and
+	// defaults to zero, allowing payloads of any size
+	MaxPayloadSize int
+	// ClaimErr, if set, is called by ClaimEntries and any error it returns is returned without claiming any entries
+	ClaimErr func() error
+	// GetErr, if set, is called by GetClaimedEntries, GetUnclaimedEntries, PeekEntries and CountPending and any error
+	// it returns is returned without any entries
+	GetErr func() error
+	// DeleteErr, if set, is called by DeleteEntries and any error it returns is returned without deleting any entries
+	DeleteErr func() error
+	// PublishErr, if set, is called by Publish and Republish and any error it returns is returned without recording
+	// any entries
+	PublishErr func() error
+	// ReleaseErr, if set, is called by ReleaseEntries and any error it returns is returned without releasing any
+	// entries
+	ReleaseErr func() error
+	// PingErr, if set, is called by Ping and any error it returns is returned
+	PingErr func() error
 	lock    sync.RWMutex
 	entries []*outboxEntry
 }
 
-// Publish records the provided messages to the outbox.ProcessorStorage
-func (e *EntryStorage) Publish(ctx context.Context, _ interface{}, messages ...outbox.Message) error {
-	e.lock.Lock()
-	defer e.lock.Unlock()
+// FailFirst returns a function, for use as one of the EntryStorage error hooks, that returns err from its first n
+// calls and nil after that, to simulate a transient failure
+func FailFirst(n int, err error) func() error {
+	var lock sync.Mutex
+	calls := 0
+
+	return func() error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if calls >= n {
+			return nil
+		}
+		calls++
+		return err
+	}
+}
+
+// FailAlways returns a function, for use as one of the EntryStorage error hooks, that always returns err
+func FailAlways(err error) func() error {
+	return func() error {
+		return err
+	}
+}
+
+// injectedErr calls the error hook, if it is set
+func injectedErr(hook func() error) error {
+	if hook == nil {
+		return nil
+	}
+
+	return hook()
+}
+
+// Publish records the provided messages to the outbox.ProcessorStorage, unless any exceeds the MaxPayloadSize or
+// PublishErr fails it. If txn is nil the messages are recorded immediately, as if in a transaction of their own,
+// otherwise txn must be a *Txn from Begin and the messages are only recorded once it commits. It supports
+// outbox.DedupWithinPublishFromContext.
+func (e *EntryStorage) Publish(ctx context.Context, txn interface{}, messages ...outbox.Message) error {
+	var tx *Txn
+	if txn != nil {
+		var ok bool
+		if tx, ok = txn.(*Txn); !ok || tx.storage != e {
+			return fmt.Errorf("unsupported transaction type %T, expected *fake.Txn from this EntryStorage", txn)
+		}
+	}
+
+	if err := injectedErr(e.PublishErr); err != nil {
+		return err
+	}
+
+	if err := outbox.CheckPayloadSize(e.MaxPayloadSize, messages...); err != nil {
+		return err
+	}
 
 	namespace := outbox.NamespaceFromContext(ctx)
+	generateID := e.IDGenerator
+	if generateID == nil {
+		generateID = outbox.UUIDGenerator
+	}
 
+	dedup := outbox.DedupWithinPublishFromContext(ctx)
+	seen := make(map[string]bool)
+
+	entries := make([]*outboxEntry, 0, len(messages))
 	for _, message := range messages {
-		e.entries = append(e.entries, &outboxEntry{
-			Namespace: namespace,
-			ID:        uuid.NewString(),
-			Key:       message.Key,
-			Payload:   message.Payload,
+		if dedup {
+			hash := outbox.ContentHash(message)
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+		}
+
+		entries = append(entries, &outboxEntry{
+			Namespace:    namespace,
+			ID:           generateID(message),
+			Key:          message.Key,
+			Payload:      message.Payload,
+			Headers:      message.Headers,
+			NotBefore:    message.NotBefore,
+			TraceContext: message.TraceContext,
+			DedupKey:     message.DedupKey,
+			Priority:     message.Priority,
+			CreatedAt:    e.Clock.Now(),
+		})
+	}
+
+	if tx != nil {
+		return tx.stage(entries)
+	}
+
+	e.record(entries)
+	return nil
+}
+
+// Republish implements outbox.EntryRepublisher interface, recording the entries again with new IDs
+func (e *EntryStorage) Republish(_ context.Context, entries ...outbox.ClaimedEntry) error {
+	if err := injectedErr(e.PublishErr); err != nil {
+		return err
+	}
+
+	generateID := e.IDGenerator
+	if generateID == nil {
+		generateID = outbox.UUIDGenerator
+	}
+
+	republished := make([]*outboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		republished = append(republished, &outboxEntry{
+			Namespace: entry.Namespace,
+			ID: generateID(outbox.Message{
+				Key:          entry.Key,
+				Payload:      entry.Payload,
+				Headers:      entry.Headers,
+				TraceContext: entry.TraceContext,
+				DedupKey:     entry.DedupKey,
+				Priority:     entry.Priority,
+			}),
+			Key:          entry.Key,
+			Payload:      entry.Payload,
+			Headers:      entry.Headers,
+			TraceContext: entry.TraceContext,
+			DedupKey:     entry.DedupKey,
+			Priority:     entry.Priority,
+			CreatedAt:    e.Clock.Now(),
 		})
 	}
 
+	e.record(republished)
 	return nil
 }
 
-// ClaimEntries implements outbox.ProcessorStorage interface
-func (e *EntryStorage) ClaimEntries(_ context.Context, processorID string, claimDeadline time.Time) error {
+// record adds the entries to the storage
+func (e *EntryStorage) record(entries []*outboxEntry) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.entries = append(e.entries, entries...)
+}
+
+// ClaimEntries implements outbox.ProcessorStorage interface, including ClaimAffinityFromContext and ShardFromContext
+func (e *EntryStorage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	if err := injectedErr(e.ClaimErr); err != nil {
+		return err
+	}
+
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
 	now := e.Clock.Now()
+	affinity := outbox.ClaimAffinityFromContext(ctx)
 	for _, entry := range e.entries {
 		if entry.ProcessorID != "" && entry.ProcessingDeadline != nil && now.Before(*entry.ProcessingDeadline) {
 			continue
 		}
 
+		// entries are left for the processor that last claimed them during the affinity grace period
+		if affinity > 0 && entry.ProcessorID != "" && entry.ProcessorID != processorID &&
+			entry.ProcessingDeadline != nil && now.Before(entry.ProcessingDeadline.Add(affinity)) {
+			continue
+		}
+
+		if !entry.due(now) || !entry.inNamespace(ctx) || !entry.inShard(ctx) {
+			continue
+		}
+
 		entry.ProcessorID = processorID
 		entry.ProcessingDeadline = &claimDeadline
+		entry.Attempts += 1
+	}
+
+	return nil
+}
+
+// RenewClaim implements outbox.ProcessorStorage interface
+func (e *EntryStorage) RenewClaim(_ context.Context, processorID string, claimDeadline time.Time) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, entry := range e.entries {
+		if entry.ProcessorID == processorID {
+			entry.ProcessingDeadline = &claimDeadline
+		}
 	}
 
 	return nil
 }
 
 // GetClaimedEntries implements outbox.ProcessorStorage interface
-func (e *EntryStorage) GetClaimedEntries(_ context.Context, processorID string, batchSize int) ([]outbox.ClaimedEntry, error) {
-	var entries []outbox.ClaimedEntry
+func (e *EntryStorage) GetClaimedEntries(ctx context.Context, processorID string, batchSize int) ([]outbox.ClaimedEntry, error) {
+	return e.getEntries(ctx, batchSize, func(entry *outboxEntry) bool {
+		return entry.ProcessorID == processorID
+	})
+}
+
+// GetUnclaimedEntries implements outbox.UnclaimedEntryGetter interface
+func (e *EntryStorage) GetUnclaimedEntries(ctx context.Context, batchSize int) ([]outbox.ClaimedEntry, error) {
+	return e.getEntries(ctx, batchSize, func(*outboxEntry) bool {
+		return true
+	})
+}
+
+// getEntries retrieves a batch of the due entries in the context's namespace that match the filter
+func (e *EntryStorage) getEntries(ctx context.Context, batchSize int, filter func(entry *outboxEntry) bool) ([]outbox.ClaimedEntry, error) {
+	if err := injectedErr(e.GetErr); err != nil {
+		return nil, err
+	}
 
 	e.lock.RLock()
 	defer e.lock.RUnlock()
 
+	now := e.Clock.Now()
+	var claimed []*outboxEntry
 	for _, entry := range e.entries {
-		if entry.ProcessorID != processorID {
+		if !filter(entry) {
 			continue
 		}
 
-		entries = append(entries, outbox.ClaimedEntry{
-			Namespace: entry.Namespace,
-			ID:        entry.ID,
-			Key:       entry.Key,
-			Payload:   entry.Payload,
+		if !entry.due(now) || !entry.inNamespace(ctx) || !entry.inShard(ctx) {
+			continue
+		}
+
+		claimed = append(claimed, entry)
+	}
+
+	// entries are kept in the order they were published, so a stable sort keeps them oldest first within each key,
+	// or each priority, or newest first once reversed
+	if outbox.OrderingFromContext(ctx) != outbox.OrderingPerKey && outbox.SelectionOrderFromContext(ctx) == outbox.NewestFirst {
+		for i, j := 0, len(claimed)-1; i < j; i, j = i+1, j-1 {
+			claimed[i], claimed[j] = claimed[j], claimed[i]
+		}
+	}
+	if outbox.OrderingFromContext(ctx) == outbox.OrderingPerKey {
+		sort.SliceStable(claimed, func(i, j int) bool {
+			return bytes.Compare(claimed[i].Key, claimed[j].Key) < 0
+		})
+	} else {
+		sort.SliceStable(claimed, func(i, j int) bool {
+			return claimed[i].Priority > claimed[j].Priority
 		})
+	}
+
+	claimed = limitPerKey(claimed, outbox.MaxEntriesPerKeyFromContext(ctx))
+	claimed = interleave(claimed, outbox.FairnessKeyFromContext(ctx))
+
+	if len(claimed) > batchSize {
+		claimed = claimed[:batchSize]
+	}
+
+	entries := make([]outbox.ClaimedEntry, 0, len(claimed))
+	for _, entry := range claimed {
+		entries = append(entries, entry.claimed())
+	}
+
+	return entries, nil
+}
+
+// PeekEntries implements outbox.EntryPeeker interface, returning a snapshot of the entries that later claims don't
+// change, although the entries share their Key, Payload and Headers with the storage so mustn't be modified
+func (e *EntryStorage) PeekEntries(ctx context.Context, limit int) ([]outbox.ClaimedEntry, error) {
+	if err := injectedErr(e.GetErr); err != nil {
+		return nil, err
+	}
 
-		if len(entries) >= batchSize {
-			break
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	var peeked []*outboxEntry
+	for _, entry := range e.entries {
+		if entry.inNamespace(ctx) {
+			peeked = append(peeked, entry)
 		}
 	}
 
+	// entries are kept in the order they were published, so a stable sort keeps them oldest first within each priority
+	sort.SliceStable(peeked, func(i, j int) bool {
+		return peeked[i].Priority > peeked[j].Priority
+	})
+
+	if len(peeked) > limit {
+		peeked = peeked[:limit]
+	}
+
+	entries := make([]outbox.ClaimedEntry, 0, len(peeked))
+	for _, entry := range peeked {
+		entries = append(entries, entry.claimed())
+	}
+
 	return entries, nil
 }
 
 // DeleteEntries implements outbox.ProcessorStorage interface
 func (e *EntryStorage) DeleteEntries(_ context.Context, entryIDs ...string) error {
+	if err := injectedErr(e.DeleteErr); err != nil {
+		return err
+	}
+
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
@@ -125,6 +434,109 @@ func (e *EntryStorage) DeleteEntries(_ context.Context, entryIDs ...string) erro
 	return nil
 }
 
+// ReleaseEntries implements outbox.EntryReleaser interface
+func (e *EntryStorage) ReleaseEntries(_ context.Context, processorID string, entryIDs ...string) error {
+	if err := injectedErr(e.ReleaseErr); err != nil {
+		return err
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	released := make(map[string]bool, len(entryIDs))
+	for _, id := range entryIDs {
+		released[id] = true
+	}
+
+	for _, entry := range e.entries {
+		if entry.ProcessorID == processorID && released[entry.ID] {
+			entry.ProcessorID = ""
+			entry.ProcessingDeadline = nil
+		}
+	}
+
+	return nil
+}
+
+// limitPerKey skips entries once maxEntriesPerKey entries with the same non-empty key have been seen, unless
+// maxEntriesPerKey is zero
+func limitPerKey(entries []*outboxEntry, maxEntriesPerKey int) []*outboxEntry {
+	if maxEntriesPerKey < 1 {
+		return entries
+	}
+
+	seen := make(map[string]int)
+	limited := make([]*outboxEntry, 0, len(entries))
+	for _, entry := range entries {
+		if len(entry.Key) > 0 {
+			key := string(entry.Key)
+			if seen[key] >= maxEntriesPerKey {
+				continue
+			}
+			seen[key]++
+		}
+
+		limited = append(limited, entry)
+	}
+
+	return limited
+}
+
+// interleave groups the entries by the fairness key of their messages, if any, and takes an entry from each group in
+// turn, keeping the groups in the order of their first entry and each group's entries in order
+func interleave(entries []*outboxEntry, fairnessKey func(msg outbox.Message) string) []*outboxEntry {
+	if fairnessKey == nil {
+		return entries
+	}
+
+	var keys []string
+	groups := make(map[string][]*outboxEntry)
+	for _, entry := range entries {
+		key := fairnessKey(entry.message())
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], entry)
+	}
+
+	interleaved := make([]*outboxEntry, 0, len(entries))
+	for len(interleaved) < len(entries) {
+		for _, key := range keys {
+			if group := groups[key]; len(group) > 0 {
+				interleaved = append(interleaved, group[0])
+				groups[key] = group[1:]
+			}
+		}
+	}
+
+	return interleaved
+}
+
+// Ping implements the outbox.HealthChecker interface
+func (e *EntryStorage) Ping(context.Context) error {
+	return injectedErr(e.PingErr)
+}
+
+// CountPending implements the outbox.PendingCounter interface. Unlike CountEntries, it only counts the entries in the
+// namespace of the context, if it has one.
+func (e *EntryStorage) CountPending(ctx context.Context) (int, error) {
+	if err := injectedErr(e.GetErr); err != nil {
+		return 0, err
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	count := 0
+	for _, entry := range e.entries {
+		if entry.inNamespace(ctx) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
 // CountEntries is a test function for counting the number of entries currently in storage
 func (e *EntryStorage) CountEntries() int {
 	e.lock.RLock()
@@ -134,3 +546,9 @@ func (e *EntryStorage) CountEntries() int {
 }
 
 var _ outbox.ProcessorStorage = (*EntryStorage)(nil)
+var _ outbox.HealthChecker = (*EntryStorage)(nil)
+var _ outbox.UnclaimedEntryGetter = (*EntryStorage)(nil)
+var _ outbox.EntryReleaser = (*EntryStorage)(nil)
+var _ outbox.EntryRepublisher = (*EntryStorage)(nil)
+var _ outbox.EntryPeeker = (*EntryStorage)(nil)
+var _ outbox.PendingCounter = (*EntryStorage)(nil)
@@ -0,0 +1,68 @@
+package fake
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrTxnDone is returned when publishing to, committing or rolling back a Txn that has already been committed or
+// rolled back
+var ErrTxnDone = errors.New("transaction has already been committed or rolled back")
+
+// Txn is a transaction on an EntryStorage, standing in for the application's database transaction. Messages
+// published with it are only recorded by the EntryStorage once it commits, and are discarded if it rolls back.
+type Txn struct {
+	storage *EntryStorage
+	lock    sync.Mutex
+	entries []*outboxEntry
+	done    bool
+}
+
+// Begin starts a new transaction to pass to EntryStorage.Publish
+func (e *EntryStorage) Begin() *Txn {
+	return &Txn{
+		storage: e,
+	}
+}
+
+// Commit records the messages published within the transaction
+func (t *Txn) Commit() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+
+	t.storage.record(t.entries)
+	t.entries = nil
+	return nil
+}
+
+// Rollback discards the messages published within the transaction
+func (t *Txn) Rollback() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.done {
+		return ErrTxnDone
+	}
+	t.done = true
+
+	t.entries = nil
+	return nil
+}
+
+// stage holds the entries until the transaction commits
+func (t *Txn) stage(entries []*outboxEntry) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.done {
+		return ErrTxnDone
+	}
+
+	t.entries = append(t.entries, entries...)
+	return nil
+}
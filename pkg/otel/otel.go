@@ -0,0 +1,81 @@
+// Package otel propagates OpenTelemetry trace context through outbox messages, so that publishing
+// a message can be linked to the trace that originally wrote it to the outbox.
+package otel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+const instrumentationName = "github.com/omaskery/outboxen/pkg/otel"
+
+// InjectTraceContext records the trace context from ctx into the message, using the globally configured
+// propagation.TextMapPropagator. If ctx carries no trace context the message is left unchanged.
+func InjectTraceContext(ctx context.Context, msg *outbox.Message) {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+
+	if msg.TraceContext == nil {
+		msg.TraceContext = make(map[string]string, len(carrier))
+	}
+	for key, value := range carrier {
+		msg.TraceContext[key] = value
+	}
+}
+
+// ExtractTraceContext returns a context carrying the trace context recorded in the message, using the
+// globally configured propagation.TextMapPropagator. If the message has no trace context, the returned
+// context carries no span.
+func ExtractTraceContext(msg outbox.Message) context.Context {
+	return otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier(msg.TraceContext))
+}
+
+// Tracer implements outbox.Tracer, starting a span for each batch of messages that is linked to the
+// spans that wrote each message to the outbox
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer creates a Tracer that starts spans using the provided trace.TracerProvider
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{
+		tracer: provider.Tracer(instrumentationName),
+	}
+}
+
+// StartBatch implements the outbox.Tracer interface
+func (t *Tracer) StartBatch(ctx context.Context, messages []outbox.Message) (context.Context, func(err error)) {
+	var links []trace.Link
+	for _, msg := range messages {
+		spanContext := trace.SpanContextFromContext(ExtractTraceContext(msg))
+		if !spanContext.IsValid() {
+			continue
+		}
+
+		links = append(links, trace.Link{SpanContext: spanContext})
+	}
+
+	ctx, span := t.tracer.Start(ctx, "outbox publish batch",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithLinks(links...),
+	)
+
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+var _ outbox.Tracer = (*Tracer)(nil)
@@ -0,0 +1,147 @@
+package otel_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	outboxotel "github.com/omaskery/outboxen/pkg/otel"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, ...outbox.Message) error {
+	return errors.New("publisher unavailable")
+}
+
+var _ = Describe("Otel", func() {
+	var ctx context.Context
+	var recorder *tracetest.SpanRecorder
+	var provider *sdktrace.TracerProvider
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+
+		recorder = tracetest.NewSpanRecorder()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	})
+
+	Describe("InjectTraceContext", func() {
+		It("leaves the message untouched without a span", func() {
+			msg := outbox.Message{}
+			outboxotel.InjectTraceContext(ctx, &msg)
+			Expect(msg.TraceContext).To(BeNil())
+		})
+
+		It("round trips the trace context", func() {
+			spanCtx, span := provider.Tracer("test").Start(ctx, "write")
+			defer span.End()
+
+			msg := outbox.Message{}
+			outboxotel.InjectTraceContext(spanCtx, &msg)
+			Expect(msg.TraceContext).To(HaveKey("traceparent"))
+
+			extracted := trace.SpanContextFromContext(outboxotel.ExtractTraceContext(msg))
+			Expect(extracted.TraceID()).To(Equal(span.SpanContext().TraceID()))
+			Expect(extracted.SpanID()).To(Equal(span.SpanContext().SpanID()))
+		})
+	})
+
+	Describe("ExtractTraceContext", func() {
+		It("tolerates missing trace context", func() {
+			extracted := trace.SpanContextFromContext(outboxotel.ExtractTraceContext(outbox.Message{}))
+			Expect(extracted.IsValid()).To(BeFalse())
+		})
+
+		It("tolerates malformed trace context", func() {
+			msg := outbox.Message{TraceContext: map[string]string{"traceparent": "garbage"}}
+			extracted := trace.SpanContextFromContext(outboxotel.ExtractTraceContext(msg))
+			Expect(extracted.IsValid()).To(BeFalse())
+		})
+	})
+
+	Describe("Tracer", func() {
+		var storage *fake.EntryStorage
+		var publisher outbox.Publisher
+		var writeSpan trace.Span
+		var ob *outbox.Outbox
+
+		BeforeEach(func() {
+			storage = &fake.EntryStorage{
+				Clock: clockwork.NewFakeClock(),
+			}
+			publisher = &fake.Publisher{
+				Logger: logr.Discard(),
+			}
+
+			var writeCtx context.Context
+			writeCtx, writeSpan = provider.Tracer("test").Start(ctx, "write")
+			traced := outbox.Message{Payload: []byte("traced")}
+			outboxotel.InjectTraceContext(writeCtx, &traced)
+			writeSpan.End()
+
+			Expect(storage.Publish(ctx, nil, traced, outbox.Message{Payload: []byte("untraced")})).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			ob, err = outbox.New(outbox.Config{
+				Storage:       storage,
+				Publisher:     publisher,
+				ClaimDuration: 5 * time.Second,
+				ProcessorID:   "test",
+				Tracer:        outboxotel.NewTracer(provider),
+			})
+			Expect(err).To(Succeed())
+		})
+
+		findBatchSpans := func() []sdktrace.ReadOnlySpan {
+			var spans []sdktrace.ReadOnlySpan
+			for _, span := range recorder.Ended() {
+				if span.Name() == "outbox publish batch" {
+					spans = append(spans, span)
+				}
+			}
+			return spans
+		}
+
+		It("starts a span per batch linked to the traced messages", func() {
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			spans := findBatchSpans()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Links()).To(HaveLen(1))
+			Expect(spans[0].Links()[0].SpanContext.SpanID()).To(Equal(writeSpan.SpanContext().SpanID()))
+			Expect(spans[0].Status().Code).To(Equal(codes.Unset))
+		})
+
+		When("publishing fails", func() {
+			BeforeEach(func() {
+				publisher = failingPublisher{}
+			})
+
+			It("records the error on the span", func() {
+				_, err := ob.PumpOutbox(ctx)
+				Expect(err).ToNot(Succeed())
+
+				spans := findBatchSpans()
+				Expect(spans).To(HaveLen(1))
+				Expect(spans[0].Status().Code).To(Equal(codes.Error))
+			})
+		})
+	})
+})
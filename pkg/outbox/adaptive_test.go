@@ -0,0 +1,125 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Adaptive interval", func() {
+	const processInterval = 8 * time.Second
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var pumps chan int
+	var errChan chan error
+
+	publish := func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("message")})).To(Succeed())
+	}
+
+	// expectPumpAfter checks that the processor stays idle until the wait has elapsed, then pumps the outbox,
+	// returning how many entries the pump processed
+	expectPumpAfter := func(wait time.Duration) int {
+		clock.BlockUntil(1)
+		clock.Advance(wait - time.Millisecond)
+		Consistently(pumps, 50*time.Millisecond).ShouldNot(Receive())
+
+		clock.Advance(time.Millisecond)
+		var processed int
+		Eventually(pumps).Should(Receive(&processed))
+		return processed
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		pumps = make(chan int, 10)
+
+		cfg = outbox.Config{
+			Clock:            clock,
+			Storage:          storage,
+			Publisher:        &fake.Publisher{},
+			ProcessInterval:  processInterval,
+			ClaimDuration:    5 * time.Second,
+			ProcessorID:      "test",
+			BatchSize:        1,
+			AdaptiveInterval: true,
+			OnPumpComplete: func(processed int, err error) {
+				pumps <- processed
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		ob, err := outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(errChan, time.Second).Should(Receive(BeNil()))
+	})
+
+	It("shrinks the wait while pumps are full, then grows it back once the outbox is empty", func() {
+		publish()
+		Expect(expectPumpAfter(processInterval)).To(Equal(1))
+
+		publish()
+		Expect(expectPumpAfter(processInterval / 2)).To(Equal(1))
+
+		publish()
+		Expect(expectPumpAfter(processInterval / 4)).To(Equal(1))
+
+		Expect(expectPumpAfter(processInterval / 8)).To(BeZero())
+		Expect(expectPumpAfter(processInterval / 4)).To(BeZero())
+		Expect(expectPumpAfter(processInterval / 2)).To(BeZero())
+		Expect(expectPumpAfter(processInterval)).To(BeZero())
+		Expect(expectPumpAfter(processInterval)).To(BeZero())
+	})
+
+	When("pumps are neither full nor empty", func() {
+		BeforeEach(func() {
+			cfg.BatchSize = 2
+		})
+
+		It("keeps the wait", func() {
+			publish()
+			Expect(expectPumpAfter(processInterval)).To(Equal(1))
+
+			publish()
+			Expect(expectPumpAfter(processInterval)).To(Equal(1))
+		})
+	})
+
+	When("the interval is not adaptive", func() {
+		BeforeEach(func() {
+			cfg.AdaptiveInterval = false
+		})
+
+		It("always waits the process interval", func() {
+			publish()
+			Expect(expectPumpAfter(processInterval)).To(Equal(1))
+
+			publish()
+			Expect(expectPumpAfter(processInterval)).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,94 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("ClaimAffinity", func() {
+	const processInterval = 10 * time.Second
+	const claimDuration = 2 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var first, second *outbox.Outbox
+
+	// claimedBy returns how many entries the processor currently holds a claim on
+	claimedBy := func(processorID string) int {
+		entries, err := storage.GetClaimedEntries(ctx, processorID, 10)
+		Expect(err).To(Succeed())
+		return len(entries)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+
+		cfg = outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			Publisher: &fake.Publisher{
+				Logger: logr.Discard(),
+			},
+			ProcessInterval: processInterval,
+			ClaimDuration:   claimDuration,
+			ClaimAffinity:   true,
+		}
+
+		Expect(storage.Publish(ctx, nil, outbox.Message{}, outbox.Message{})).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		cfg.ProcessorID = "first"
+		first, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		cfg.ProcessorID = "second"
+		second, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		// the first processor claimed the entries, but its claim expired before it published them
+		Expect(storage.ClaimEntries(ctx, "first", clock.Now().Add(claimDuration))).To(Succeed())
+		clock.Advance(claimDuration)
+	})
+
+	It("leaves entries for their previous claimant while it is still active", func() {
+		Expect(second.PumpOutbox(ctx)).To(Equal(0))
+		Expect(claimedBy("first")).To(Equal(2))
+
+		Expect(first.PumpOutbox(ctx)).To(Equal(2))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("lets another processor claim the entries once their previous claimant is inactive", func() {
+		clock.Advance(processInterval)
+
+		Expect(second.PumpOutbox(ctx)).To(Equal(2))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	When("claim affinity is disabled", func() {
+		BeforeEach(func() {
+			cfg.ClaimAffinity = false
+		})
+
+		It("lets another processor claim the entries as soon as their claim expires", func() {
+			Expect(second.PumpOutbox(ctx)).To(Equal(2))
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+	})
+})
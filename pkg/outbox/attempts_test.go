@@ -0,0 +1,192 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Attempts", func() {
+	const processorID = "test"
+	const claimDuration = 5 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	// poisonPublisher fails to publish any message with a "poison" payload
+	poisonPublisher := outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+		publishErr := &outbox.PublishError{Errors: make([]error, len(messages))}
+		for idx, msg := range messages {
+			if string(msg.Payload) == "poison" {
+				publishErr.Errors[idx] = errors.New("poison message")
+			}
+		}
+
+		if publishErr.ErrorCount() > 0 {
+			return publishErr
+		}
+		return nil
+	})
+
+	claimedAttempts := func() []int {
+		entries, err := storage.GetClaimedEntries(ctx, processorID, 10)
+		Expect(err).To(Succeed())
+
+		attempts := make([]int, 0, len(entries))
+		for _, entry := range entries {
+			attempts = append(attempts, entry.Attempts)
+		}
+		return attempts
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+
+		cfg = outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     poisonPublisher,
+			ClaimDuration: claimDuration,
+			ProcessorID:   processorID,
+		}
+
+		Expect(storage.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("poison")},
+			outbox.Message{Payload: []byte("healthy")},
+		)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("counts the first claim as the first attempt", func() {
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).ToNot(Succeed())
+
+		Expect(storage.CountEntries()).To(Equal(1))
+		Expect(claimedAttempts()).To(Equal([]int{1}))
+	})
+
+	It("increments the attempts each time a failing entry is reclaimed", func() {
+		for attempt := 1; attempt <= 3; attempt++ {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).ToNot(Succeed())
+			Expect(claimedAttempts()).To(Equal([]int{attempt}))
+
+			clock.Advance(claimDuration)
+		}
+	})
+
+	It("does not increment the attempts while the claim is held", func() {
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).ToNot(Succeed())
+		_, err = ob.PumpOutbox(ctx)
+		Expect(err).ToNot(Succeed())
+
+		Expect(claimedAttempts()).To(Equal([]int{1}))
+	})
+
+	When("a max attempts threshold is configured", func() {
+		const maxAttempts = 2
+
+		var deadLetters *fake.DeadLetterHandler
+
+		// pumpUntilExhausted pumps the outbox until the poison message has used up all of its attempts
+		pumpUntilExhausted := func() {
+			for attempt := 1; attempt <= maxAttempts; attempt++ {
+				_, err := ob.PumpOutbox(ctx)
+				Expect(err).ToNot(Succeed())
+				clock.Advance(claimDuration)
+			}
+		}
+
+		BeforeEach(func() {
+			deadLetters = &fake.DeadLetterHandler{}
+			cfg.MaxAttempts = maxAttempts
+			cfg.DeadLetterHandler = deadLetters
+		})
+
+		It("retries the entry until it reaches max attempts", func() {
+			pumpUntilExhausted()
+
+			Expect(deadLetters.GetDeadLettered()).To(BeEmpty())
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+
+		It("dead letters and deletes the entry once it exceeds max attempts", func() {
+			pumpUntilExhausted()
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			deadLettered := deadLetters.GetDeadLettered()
+			Expect(deadLettered).To(HaveLen(1))
+			Expect(deadLettered[0].Payload).To(Equal([]byte("poison")))
+			Expect(deadLettered[0].Attempts).To(Equal(maxAttempts + 1))
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+
+		When("dead lettering fails", func() {
+			var metrics *fake.Metrics
+
+			BeforeEach(func() {
+				deadLetters.Err = errors.New("dead letter queue unavailable")
+				metrics = &fake.Metrics{}
+				cfg.Metrics = metrics
+			})
+
+			It("keeps the entry", func() {
+				pumpUntilExhausted()
+				_, err := ob.PumpOutbox(ctx)
+				Expect(err).To(MatchError(ContainSubstring("dead letter queue unavailable")))
+
+				Expect(storage.CountEntries()).To(Equal(1))
+			})
+
+			It("still publishes healthy entries in the same batch", func() {
+				pumpUntilExhausted()
+				Expect(metrics.GetPublishFailureCount()).To(Equal(maxAttempts))
+
+				Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("late")})).To(Succeed())
+				_, err := ob.PumpOutbox(ctx)
+				Expect(err).To(MatchError(ContainSubstring("dead letter queue unavailable")))
+
+				entries, err := storage.GetClaimedEntries(ctx, processorID, 10)
+				Expect(err).To(Succeed())
+				Expect(entries).To(HaveLen(1))
+				Expect(entries[0].Payload).To(Equal([]byte("poison")))
+
+				Expect(metrics.GetPublishedCount()).To(Equal(2))
+				Expect(metrics.GetPublishFailureCount()).To(Equal(maxAttempts))
+			})
+		})
+
+		When("no dead letter handler is configured", func() {
+			BeforeEach(func() {
+				cfg.DeadLetterHandler = nil
+			})
+
+			It("discards the entry", func() {
+				pumpUntilExhausted()
+				Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+				Expect(storage.CountEntries()).To(Equal(0))
+			})
+		})
+	})
+})
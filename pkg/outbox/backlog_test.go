@@ -0,0 +1,105 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("BacklogSize", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var metrics *fake.Metrics
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		metrics = &fake.Metrics{}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			Metrics:     metrics,
+			ProcessorID: "test",
+			BatchSize:   2,
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("counts the entries published but not yet delivered, until the outbox is drained", func() {
+		Expect(ob.BacklogSize(ctx)).To(Equal(0))
+
+		Expect(ob.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("first")},
+			outbox.Message{Payload: []byte("second")},
+			outbox.Message{Payload: []byte("third")},
+		)).To(Succeed())
+		Expect(ob.BacklogSize(ctx)).To(Equal(3))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(ob.BacklogSize(ctx)).To(Equal(0))
+
+		Expect(metrics.GetBacklogSizes()).To(Equal([]int{0, 3, 0}))
+	})
+
+	It("counts entries that are claimed or not yet due", func() {
+		Expect(ob.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("claimed")},
+		)).To(Succeed())
+		Expect(storage.ClaimEntries(ctx, "other", clock.Now().Add(time.Minute))).To(Succeed())
+		Expect(ob.Publish(ctx, nil,
+			outbox.NewMessage([]byte("scheduled"), outbox.WithNotBefore(clock.Now().Add(time.Hour))),
+		)).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+		Expect(ob.BacklogSize(ctx)).To(Equal(2))
+	})
+
+	It("only counts entries in the namespace of the context", func() {
+		Expect(ob.Publish(outbox.WithNamespace(ctx, "orders"), nil, outbox.Message{Payload: []byte("order")})).To(Succeed())
+		Expect(ob.Publish(outbox.WithNamespace(ctx, "payments"), nil, outbox.Message{Payload: []byte("payment")})).To(Succeed())
+
+		Expect(ob.BacklogSize(outbox.WithNamespace(ctx, "orders"))).To(Equal(1))
+		Expect(ob.BacklogSize(ctx)).To(Equal(2))
+	})
+
+	It("fails without recording metrics if the storage fails", func() {
+		storage.GetErr = fake.FailAlways(errors.New("connection reset"))
+
+		_, err := ob.BacklogSize(ctx)
+		Expect(err).To(MatchError("connection reset"))
+		Expect(metrics.GetBacklogSizes()).To(BeEmpty())
+	})
+
+	It("fails with a storage that can't count pending entries", func() {
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Storage:     struct{ outbox.ProcessorStorage }{storage},
+			Publisher:   publisher,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		_, err = ob.BacklogSize(ctx)
+		Expect(err).To(MatchError(outbox.ErrCountPendingUnsupported))
+	})
+})
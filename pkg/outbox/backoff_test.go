@@ -0,0 +1,139 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Backoff", func() {
+	const retryInterval = 3 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var attemptsLock sync.Mutex
+	var attempts []time.Time
+	var failures int
+	var failedPumps chan struct{}
+	var ob *outbox.Outbox
+	var errChan chan error
+
+	getAttempts := func() []time.Time {
+		attemptsLock.Lock()
+		defer attemptsLock.Unlock()
+
+		return append([]time.Time(nil), attempts...)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		failedPumps = make(chan struct{}, 10)
+		attempts = nil
+		failures = 2
+
+		cfg = outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			// records when each attempt is made, failing until the configured failures are used up
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				attemptsLock.Lock()
+				defer attemptsLock.Unlock()
+
+				attempts = append(attempts, clock.Now())
+				if len(attempts) <= failures {
+					return errors.New("publisher unavailable")
+				}
+				return nil
+			}),
+			ProcessInterval: time.Minute,
+			ClaimDuration:   time.Second,
+			ProcessorID:     "test",
+			OnPumpComplete: func(_ int, err error) {
+				if err != nil {
+					failedPumps <- struct{}{}
+				}
+			},
+			BackoffFactory: func() backoff.BackOff {
+				return backoff.NewConstantBackOff(retryInterval)
+			},
+		}
+
+		Expect(storage.Publish(ctx, nil, outbox.Message{})).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+		clock.BlockUntil(1)
+	})
+
+	AfterEach(func() {
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+	})
+
+	It("retries a failed pump with the configured spacing", func() {
+		start := clock.Now()
+		ob.WakeProcessor()
+
+		// once each failed pump completes, the processor waits on both its idle timer and the backoff between attempts
+		for retry := 1; retry <= failures; retry++ {
+			Eventually(getAttempts).Should(HaveLen(retry))
+			Eventually(failedPumps).Should(Receive())
+			clock.BlockUntil(2)
+			clock.Advance(retryInterval)
+		}
+
+		Eventually(getAttempts).Should(Equal([]time.Time{
+			start,
+			start.Add(retryInterval),
+			start.Add(2 * retryInterval),
+		}))
+		Eventually(storage.CountEntries).Should(Equal(0))
+	})
+
+	When("the backoff stops retrying", func() {
+		BeforeEach(func() {
+			failures = 10
+			cfg.BackoffFactory = func() backoff.BackOff {
+				return backoff.WithMaxRetries(backoff.NewConstantBackOff(retryInterval), 1)
+			}
+		})
+
+		It("gives up until the processor next wakes up", func() {
+			ob.WakeProcessor()
+			Eventually(getAttempts).Should(HaveLen(1))
+			Eventually(failedPumps).Should(Receive())
+			clock.BlockUntil(2)
+			clock.Advance(retryInterval)
+			Eventually(getAttempts).Should(HaveLen(2))
+
+			Consistently(getAttempts).Should(HaveLen(2))
+			Expect(storage.CountEntries()).To(Equal(1))
+
+			ob.WakeProcessor()
+			Eventually(getAttempts).Should(HaveLen(3))
+		})
+	})
+})
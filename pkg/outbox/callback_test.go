@@ -0,0 +1,120 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("OnPublish", func() {
+	// outcome records a message passed to the OnPublish callback
+	type outcome struct {
+		Namespace string
+		Payload   string
+		Err       error
+	}
+
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var outcomesLock sync.Mutex
+	var outcomes []outcome
+	var ob *outbox.Outbox
+
+	poisonErr := errors.New("poison message")
+
+	getOutcomes := func() []outcome {
+		outcomesLock.Lock()
+		defer outcomesLock.Unlock()
+
+		return append([]outcome(nil), outcomes...)
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		outcomes = nil
+
+		cfg = outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			// fails any message with a "poison" payload
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				publishErr := &outbox.PublishError{Errors: make([]error, len(messages))}
+				for idx, msg := range messages {
+					if string(msg.Payload) == "poison" {
+						publishErr.Errors[idx] = poisonErr
+					}
+				}
+
+				if publishErr.ErrorCount() > 0 {
+					return publishErr
+				}
+				return nil
+			}),
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			OnPublish: func(ctx context.Context, msg outbox.Message, err error) {
+				outcomesLock.Lock()
+				defer outcomesLock.Unlock()
+
+				outcomes = append(outcomes, outcome{
+					Namespace: outbox.NamespaceFromContext(ctx),
+					Payload:   string(msg.Payload),
+					Err:       err,
+				})
+			},
+		}
+
+		Expect(storage.Publish(outbox.WithNamespace(ctx, "first"), nil,
+			outbox.Message{Payload: []byte("healthy-1")},
+			outbox.Message{Payload: []byte("poison")},
+		)).To(Succeed())
+		Expect(storage.Publish(outbox.WithNamespace(ctx, "second"), nil,
+			outbox.Message{Payload: []byte("healthy-2")},
+		)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("reports the outcome of every message", func() {
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).ToNot(Succeed())
+
+		Expect(getOutcomes()).To(ConsistOf(
+			outcome{Namespace: "first", Payload: "healthy-1"},
+			outcome{Namespace: "first", Payload: "poison", Err: poisonErr},
+			outcome{Namespace: "second", Payload: "healthy-2"},
+		))
+	})
+
+	When("the callback panics", func() {
+		BeforeEach(func() {
+			cfg.OnPublish = func(context.Context, outbox.Message, error) {
+				panic("callback failed")
+			}
+		})
+
+		It("still deletes the published entries", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(MatchError(ContainSubstring("failed to publish 1/2 messages")))
+
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+	})
+})
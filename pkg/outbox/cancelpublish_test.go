@@ -0,0 +1,132 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// cancellableStorage fails to delete entries with a context that is done, as a database driver would, recording the
+// namespace of each context it deletes entries with. If block is set, it waits for the context to be done instead.
+type cancellableStorage struct {
+	*fake.EntryStorage
+	block bool
+
+	lock       sync.Mutex
+	namespaces []string
+}
+
+func (c *cancellableStorage) DeleteEntries(ctx context.Context, entryIDs ...string) error {
+	if c.block {
+		<-ctx.Done()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	c.namespaces = append(c.namespaces, outbox.NamespaceFromContext(ctx))
+	c.lock.Unlock()
+
+	return c.EntryStorage.DeleteEntries(ctx, entryIDs...)
+}
+
+func (c *cancellableStorage) getNamespaces() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]string(nil), c.namespaces...)
+}
+
+var _ = Describe("Cancelling mid publish", func() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var clock clockwork.FakeClock
+	var storage *cancellableStorage
+	var publisher *fake.Publisher
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(outbox.WithNamespace(context.Background(), "orders"))
+		clock = clockwork.NewFakeClock()
+		storage = &cancellableStorage{
+			EntryStorage: &fake.EntryStorage{
+				Clock: clock,
+			},
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		// the first two messages are published before the context is cancelled, failing the rest
+		cancelling := outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+			errs := make([]error, len(messages))
+			for idx, msg := range messages {
+				if idx < 2 {
+					errs[idx] = publisher.Publish(ctx, msg)
+					continue
+				}
+
+				cancel()
+				errs[idx] = ctx.Err()
+			}
+
+			return &outbox.PublishError{Errors: errs}
+		})
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   cancelling,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		for _, payload := range []string{"0", "1", "2", "3", "4"} {
+			Expect(storage.Publish(outbox.WithNamespace(ctx, "orders"), nil, outbox.Message{Payload: []byte(payload)})).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("still deletes the entries that were published", func() {
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("failed to publish 3/5 messages")))
+
+		Expect(publisher.GetPublishedCount()).To(Equal(2))
+		Expect(storage.CountEntries()).To(Equal(3))
+		Expect(storage.getNamespaces()).To(Equal([]string{"orders"}))
+	})
+
+	It("gives up deleting them after the cleanup timeout", func() {
+		storage.block = true
+
+		errChan := make(chan error, 1)
+		go func() {
+			_, err := ob.PumpOutbox(ctx)
+			errChan <- err
+		}()
+
+		// the claim renewal and cleanup timeout timers
+		clock.BlockUntil(2)
+		clock.Advance(outbox.DefaultCleanupTimeout)
+
+		var err error
+		Eventually(errChan).Should(Receive(&err))
+		var storageErr *outbox.StorageError
+		Expect(errors.As(err, &storageErr)).To(BeTrue())
+		Expect(storageErr.Op).To(Equal("deleting entries"))
+		Expect(storage.CountEntries()).To(Equal(5))
+	})
+})
@@ -0,0 +1,122 @@
+package outbox_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// deadlineRecordingStorage records the claim deadlines the processor requests
+type deadlineRecordingStorage struct {
+	*fake.EntryStorage
+	lock      sync.Mutex
+	deadlines []time.Time
+}
+
+func (d *deadlineRecordingStorage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	d.record(claimDeadline)
+	return d.EntryStorage.ClaimEntries(ctx, processorID, claimDeadline)
+}
+
+func (d *deadlineRecordingStorage) RenewClaim(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	d.record(claimDeadline)
+	return d.EntryStorage.RenewClaim(ctx, processorID, claimDeadline)
+}
+
+func (d *deadlineRecordingStorage) record(deadline time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.deadlines = append(d.deadlines, deadline)
+}
+
+func (d *deadlineRecordingStorage) getDeadlines() []time.Time {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return append([]time.Time(nil), d.deadlines...)
+}
+
+var _ = Describe("ClaimDurationFunc", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *deadlineRecordingStorage
+	var cfg outbox.Config
+
+	// claimDuration gives each entry a tenth of a second on top of a second
+	claimDuration := func(batchSize int) time.Duration {
+		return time.Second + time.Duration(batchSize)*100*time.Millisecond
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &deadlineRecordingStorage{
+			EntryStorage: &fake.EntryStorage{
+				Clock: clock,
+			},
+		}
+
+		cfg = outbox.Config{
+			Clock:             clock,
+			Storage:           storage,
+			Publisher:         &fake.Publisher{Logger: logr.Discard()},
+			ProcessorID:       "test",
+			ClaimDurationFunc: claimDuration,
+		}
+	})
+
+	DescribeTable("claims entries for the duration computed from the expected batch size",
+		func(batchSize, concurrency int, expected time.Duration) {
+			cfg.BatchSize = batchSize
+			cfg.Concurrency = concurrency
+			ob, err := outbox.New(cfg)
+			Expect(err).To(Succeed())
+
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+			Expect(storage.getDeadlines()).To(Equal([]time.Time{clock.Now().Add(expected)}))
+		},
+		Entry("a single entry", 1, 1, 1100*time.Millisecond),
+		Entry("a batch", 10, 1, 2*time.Second),
+		Entry("concurrent batches", 10, 4, 5*time.Second),
+	)
+
+	It("renews claims for the computed duration", func() {
+		const delay = 10 * time.Second
+
+		cfg.BatchSize = 10
+		cfg.Publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+			Delay:  delay,
+			Clock:  clock,
+		}
+		ob, err := outbox.New(cfg)
+		Expect(err).To(Succeed())
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+		errChan := make(chan error, 1)
+		go func(ob *outbox.Outbox, errChan chan<- error) {
+			_, err := ob.PumpOutbox(ctx)
+			errChan <- err
+		}(ob, errChan)
+
+		// the claim renewal and the publisher's delay timers
+		clock.BlockUntil(2)
+		renewalInterval := 2 * time.Second / 3
+		clock.Advance(renewalInterval)
+		Eventually(storage.getDeadlines).Should(HaveLen(2))
+		Expect(storage.getDeadlines()[1]).To(Equal(clock.Now().Add(2 * time.Second)))
+
+		clock.Advance(delay)
+		Eventually(errChan).Should(Receive(BeNil()))
+	})
+})
@@ -0,0 +1,55 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("ClaimedEntry", func() {
+	It("carries every field written to the fake storage", func() {
+		clock := clockwork.NewFakeClock()
+		storage := &fake.EntryStorage{
+			Clock: clock,
+			IDGenerator: func(outbox.Message) string {
+				return "entry"
+			},
+		}
+
+		var processorStorage outbox.ProcessorStorage = storage
+		ctx := outbox.WithNamespace(context.Background(), "namespace")
+		createdAt := clock.Now()
+		notBefore := createdAt.Add(-time.Second)
+
+		Expect(processorStorage.Publish(ctx, nil, outbox.Message{
+			Key:          []byte("key"),
+			Payload:      []byte("payload"),
+			Headers:      map[string][]byte{"header": []byte("value")},
+			NotBefore:    &notBefore,
+			TraceContext: map[string]string{"traceparent": "trace"},
+			DedupKey:     "dedup",
+			Priority:     3,
+		})).To(Succeed())
+
+		Expect(processorStorage.ClaimEntries(ctx, "test", clock.Now().Add(time.Minute))).To(Succeed())
+		Expect(processorStorage.GetClaimedEntries(ctx, "test", 10)).To(ConsistOf(outbox.ClaimedEntry{
+			Namespace:    "namespace",
+			ID:           "entry",
+			Key:          []byte("key"),
+			Payload:      []byte("payload"),
+			Headers:      map[string][]byte{"header": []byte("value")},
+			NotBefore:    &notBefore,
+			Attempts:     1,
+			TraceContext: map[string]string{"traceparent": "trace"},
+			DedupKey:     "dedup",
+			Priority:     3,
+			CreatedAt:    createdAt,
+		}))
+	})
+})
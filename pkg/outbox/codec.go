@@ -0,0 +1,57 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Codec encodes application values into message payloads, so that applications can publish values rather than bytes,
+// e.g. with Outbox.PublishValues, and decodes them back again, e.g. for consumers with DecodePayload
+type Codec interface {
+	// Marshal encodes the value into a payload
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal decodes a payload encoded by Marshal into the value pointed to by v
+	Unmarshal(payload []byte, v interface{}) error
+}
+
+// EncodeMessage constructs a Message with NewMessage whose payload is the value encoded by the codec, configured by
+// any options
+func EncodeMessage(codec Codec, v interface{}, opts ...MessageOption) (Message, error) {
+	payload, err := codec.Marshal(v)
+	if err != nil {
+		return Message{}, fmt.Errorf("error encoding payload: %w", err)
+	}
+
+	return NewMessage(payload, opts...), nil
+}
+
+// DecodePayload decodes the Payload of a message created with EncodeMessage, or published with Outbox.PublishValues,
+// into the value pointed to by v, using the same codec the message was encoded with
+func DecodePayload(codec Codec, msg Message, v interface{}) error {
+	if err := codec.Unmarshal(msg.Payload, v); err != nil {
+		return fmt.Errorf("error decoding payload: %w", err)
+	}
+
+	return nil
+}
+
+// PublishValues publishes each of the values as the Payload of a message, encoded by the Config.Codec, as Publish
+// does. No messages are published if any of the values fails to encode.
+func (o *Outbox) PublishValues(ctx context.Context, txn interface{}, values ...interface{}) error {
+	if o.config.Codec == nil {
+		return errors.New("no codec is configured")
+	}
+
+	messages := make([]Message, len(values))
+	for idx, v := range values {
+		msg, err := EncodeMessage(o.config.Codec, v)
+		if err != nil {
+			return fmt.Errorf("error encoding value %d: %w", idx, err)
+		}
+
+		messages[idx] = msg
+	}
+
+	return o.Publish(ctx, txn, messages...)
+}
@@ -0,0 +1,78 @@
+package outbox_test
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/codec/json"
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("PublishValues", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		codec, err := json.New(json.Config{})
+		Expect(err).To(Succeed())
+
+		cfg = outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+			Codec:       codec,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("publishes each value encoded by the codec", func() {
+		Expect(ob.PublishValues(ctx, nil, map[string]int{"count": 1}, "hello")).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+		Expect(publisher.GetPublished()).To(ConsistOf(
+			HaveField("Message.Payload", MatchJSON(`{"count": 1}`)),
+			HaveField("Message.Payload", MatchJSON(`"hello"`)),
+		))
+	})
+
+	It("publishes nothing if any value fails to encode", func() {
+		err := ob.PublishValues(ctx, nil, "hello", make(chan int))
+		Expect(err).To(MatchError(ContainSubstring("error encoding value 1")))
+
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	When("no codec is configured", func() {
+		BeforeEach(func() {
+			cfg.Codec = nil
+		})
+
+		It("fails to publish values", func() {
+			Expect(ob.PublishValues(ctx, nil, "hello")).To(MatchError("no codec is configured"))
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,14 @@
+package outbox
+
+// CompressionHeader marks the entries whose Message.Payload was compressed by the Config.Compressor, so that
+// entries written without compression can still be published. The processor removes it before publishing.
+const CompressionHeader = "outboxen-compressed"
+
+// Compressor compresses message payloads while they are stored in the outbox, to reduce the storage used by
+// large payloads
+type Compressor interface {
+	// Compress compresses a payload before it is written to the ProcessorStorage
+	Compress(payload []byte) ([]byte, error)
+	// Decompress restores a payload compressed by Compress before it is published
+	Decompress(compressed []byte) ([]byte, error)
+}
@@ -0,0 +1,135 @@
+package outbox_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/compress/gzip"
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Compression", func() {
+	const processorID = "test"
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	largePayload := bytes.Repeat([]byte("compressible "), 100)
+
+	// stored claims the entries in storage, returning them as they are stored
+	stored := func() []outbox.ClaimedEntry {
+		Expect(storage.ClaimEntries(ctx, "inspector", clock.Now().Add(time.Second))).To(Succeed())
+		entries, err := storage.GetClaimedEntries(ctx, "inspector", 10)
+		Expect(err).To(Succeed())
+
+		clock.Advance(time.Second)
+		return entries
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{}
+
+		compressor, err := gzip.New(gzip.Config{})
+		Expect(err).To(Succeed())
+
+		cfg = outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   processorID,
+			Compressor:    compressor,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("stores payloads compressed", func() {
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: largePayload})).To(Succeed())
+
+		entries := stored()
+		Expect(entries).To(HaveLen(1))
+		Expect(len(entries[0].Payload)).To(BeNumerically("<", len(largePayload)))
+		Expect(entries[0].Headers).To(HaveKey(outbox.CompressionHeader))
+	})
+
+	It("publishes the original payload and headers", func() {
+		headers := map[string][]byte{"content-type": []byte("text/plain")}
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: largePayload, Headers: headers})).To(Succeed())
+		Expect(headers).To(HaveLen(1))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+		published := publisher.GetPublished()
+		Expect(published).To(HaveLen(1))
+		Expect(published[0].Payload).To(Equal(largePayload))
+		Expect(published[0].Headers).To(Equal(headers))
+	})
+
+	It("publishes uncompressed legacy entries as they are", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("legacy")})).To(Succeed())
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: largePayload})).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+		published := publisher.GetPublished()
+		Expect(published).To(HaveLen(2))
+		Expect(published[0].Payload).To(Equal([]byte("legacy")))
+		Expect(published[0].Headers).To(BeNil())
+		Expect(published[1].Payload).To(Equal(largePayload))
+		Expect(published[1].Headers).To(BeNil())
+	})
+
+	When("a compression threshold is configured", func() {
+		BeforeEach(func() {
+			cfg.CompressionThreshold = len(largePayload)
+		})
+
+		It("stores smaller payloads uncompressed", func() {
+			Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("small")})).To(Succeed())
+
+			entries := stored()
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Payload).To(Equal([]byte("small")))
+			Expect(entries[0].Headers).To(BeNil())
+		})
+	})
+
+	When("a compressed entry is processed without a compressor", func() {
+		JustBeforeEach(func() {
+			Expect(ob.Publish(ctx, nil, outbox.Message{Payload: largePayload})).To(Succeed())
+
+			cfg.Compressor = nil
+			var err error
+			ob, err = outbox.New(cfg)
+			Expect(err).To(Succeed())
+		})
+
+		It("fails to publish the entry, leaving it to be retried", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(MatchError(ContainSubstring("no compressor is configured")))
+
+			Expect(publisher.GetPublishedCount()).To(BeZero())
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,161 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Concurrency", func() {
+	const concurrency = 3
+	const batchSize = 2
+
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var publisher outbox.Publisher
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		storage = &fake.EntryStorage{
+			Clock: clockwork.NewFakeClock(),
+		}
+
+		for i := 0; i < concurrency*batchSize; i++ {
+			Expect(storage.Publish(ctx, nil, outbox.Message{
+				Payload: []byte(fmt.Sprintf("message-%d", i)),
+			})).To(Succeed())
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			BatchSize:     batchSize,
+			Concurrency:   concurrency,
+		})
+		Expect(err).To(Succeed())
+	})
+
+	When("every batch publishes successfully", func() {
+		var lock sync.Mutex
+		var published []string
+		var inFlight, maxInFlight int
+
+		BeforeEach(func() {
+			published = nil
+			inFlight, maxInFlight = 0, 0
+			allStarted := make(chan struct{})
+
+			publisher = outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				lock.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				if inFlight == concurrency {
+					close(allStarted)
+				}
+				lock.Unlock()
+
+				// hold every batch until all workers are publishing at once, proving they run in parallel
+				select {
+				case <-allStarted:
+				case <-time.After(time.Second):
+					return errors.New("timed out waiting for concurrent batches")
+				}
+
+				lock.Lock()
+				defer lock.Unlock()
+				inFlight--
+				for _, msg := range messages {
+					published = append(published, string(msg.Payload))
+				}
+				return nil
+			})
+		})
+
+		It("publishes the batches in parallel, publishing each message exactly once", func() {
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			Expect(maxInFlight).To(Equal(concurrency))
+			Expect(published).To(ConsistOf(
+				"message-0", "message-1", "message-2", "message-3", "message-4", "message-5",
+			))
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+	})
+
+	When("some batches fail to publish", func() {
+		BeforeEach(func() {
+			publisher = outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				for _, msg := range messages {
+					switch string(msg.Payload) {
+					case "message-0", "message-4":
+						return fmt.Errorf("failed to publish %s", msg.Payload)
+					}
+				}
+				return nil
+			})
+		})
+
+		It("aggregates the errors from every worker", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(MatchError(ContainSubstring("failed to publish message-0")))
+			Expect(err).To(MatchError(ContainSubstring("failed to publish message-4")))
+		})
+
+		It("only deletes the batches that were published", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).ToNot(Succeed())
+			Expect(storage.CountEntries()).To(Equal(2 * batchSize))
+		})
+	})
+
+	When("the context is cancelled while publishing", func() {
+		var started chan struct{}
+
+		BeforeEach(func() {
+			started = make(chan struct{}, concurrency)
+			publisher = outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				started <- struct{}{}
+				<-ctx.Done()
+				return ctx.Err()
+			})
+		})
+
+		It("stops every worker promptly", func() {
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := ob.PumpOutbox(ctx)
+				errChan <- err
+			}()
+
+			for i := 0; i < concurrency; i++ {
+				Eventually(started).Should(Receive())
+			}
+			cancel()
+
+			var err error
+			Eventually(errChan, time.Second).Should(Receive(&err))
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(storage.CountEntries()).To(Equal(concurrency * batchSize))
+		})
+	})
+})
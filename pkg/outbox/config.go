@@ -1,17 +1,72 @@
 package outbox
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/cenkalti/backoff"
 	"github.com/go-logr/logr"
 	"github.com/jonboulle/clockwork"
 )
 
-var (
+// The defaults DefaultAndValidate provides are constants, so that they can't be changed for every Outbox in the
+// process. Configure a different value in the Config instead, starting from Defaults if convenient.
+const (
+	// DefaultProcessInterval is how often the outbox is processed by default, see Config.ProcessInterval
 	DefaultProcessInterval = 10 * time.Second
-	DefaultClaimDuration   = 2 * time.Second
-	DefaultBatchSize       = 20
+	// DefaultClaimDuration is how long entries are claimed for by default, see Config.ClaimDuration
+	DefaultClaimDuration = 2 * time.Second
+	// DefaultBatchSize is how many entries are claimed at once by default, see Config.BatchSize
+	DefaultBatchSize = 20
+	// DefaultDeleteChunkSize is how many entries are deleted at once by default, see Config.DeleteChunkSize
+	DefaultDeleteChunkSize = 1000
+	// DefaultRoutineLogInterval is how often routine events are logged by default, see Config.RoutineLogInterval
+	DefaultRoutineLogInterval = time.Minute
+	// DefaultCleanupTimeout is how long published entries are given to be deleted by default once the pump's context
+	// is done, see Config.CleanupTimeout
+	DefaultCleanupTimeout = 5 * time.Second
+)
+
+// OrderingMode determines what order the Outbox publishes entries in
+type OrderingMode int
+
+const (
+	// OrderingNone publishes entries roughly in the order they were written, but concurrent batches may publish
+	// entries with the same key out of order
+	OrderingNone OrderingMode = iota
+	// OrderingPerKey publishes entries with the same ClaimedEntry.Key in the order they were written, by retrieving
+	// them grouped by key and never splitting a key's entries across concurrent batches
+	OrderingPerKey
+)
+
+// OrderMode determines whether the Outbox selects the oldest or the newest waiting entries to publish first
+type OrderMode int
+
+const (
+	// OldestFirst selects the entries that were written first, within each priority, so entries are published
+	// roughly in the order they were written
+	OldestFirst OrderMode = iota
+	// NewestFirst selects the entries that were written last, within each priority, e.g. for latest-state-wins
+	// workloads such as cache invalidation, where the newest entries matter most
+	NewestFirst
+)
+
+// FailureMode determines how the Outbox reacts to a message failing to publish
+type FailureMode int
+
+const (
+	// ContinueBatch publishes every claimed entry, even after some fail, retrying only those that failed
+	ContinueBatch FailureMode = iota
+	// HaltOnFirstFailure stops processing the claimed entries once any message fails to publish, leaving the rest
+	// for the next pump, so that no entry is published after an earlier one failed. Messages are published one
+	// at a time, trading throughput for ordering.
+	HaltOnFirstFailure
 )
 
 // Config configures the behaviour of the Outbox
@@ -22,54 +77,484 @@ type Config struct {
 	Storage ProcessorStorage
 	// Publisher is used to publish Message objects, made from ClaimedEntry objects, pulled from ProcessorStorage
 	Publisher Publisher
+	// PublisherResolver optionally selects the Publisher for the entries of each namespace, e.g. to route namespaces
+	// to different brokers, falling back to the Publisher for namespaces it returns nil for. It is called whenever
+	// messages are published, so it should be cheap, e.g. a map lookup.
+	PublisherResolver func(namespace string) Publisher
 	// ProcessInterval specifies how long the processor should spend idle without checking for work, this
 	// is reset if Outbox.WakeProcessor is called
 	ProcessInterval time.Duration
-	// ClaimDuration specifies how long the processor will claim ClaimedEntry objects in ProcessorStorage
+	// WakeDebounce delays the pump that Outbox.WakeProcessor wakes the processor for by the debounce, coalescing
+	// every other wake within that window into the same pump, so that waking after each of many writes in a burst
+	// doesn't cause many small pumps. Defaults to zero, pumping as soon as the processor is woken.
+	WakeDebounce time.Duration
+	// AdaptiveInterval adapts how long the processor spends idle to how busy the outbox is, shrinking the wait
+	// towards zero after pumps that process a full BatchSize of entries for every Concurrency worker, and growing it
+	// back towards the ProcessInterval after pumps that find the outbox empty
+	AdaptiveInterval bool
+	// IntervalJitter randomises how long the processor spends idle to within the ProcessInterval plus or minus the
+	// jitter, so that horizontally scaled processors sharing a ProcessInterval don't all claim entries at once.
+	// Defaults to zero, waiting exactly the ProcessInterval, and cannot exceed the ProcessInterval.
+	IntervalJitter time.Duration
+	// RandSource is drawn from by every randomised decision the processor makes, such as the IntervalJitter, so that
+	// tests can provide a fixed seed source to make them reproducible. Defaults to a source seeded with the current
+	// time. It is only used by the processor, so needn't be safe for concurrent use unless shared between processors.
+	// The default BackoffFactory randomises its retries internally, so provide a BackoffFactory without
+	// randomisation for reproducible retries.
+	RandSource rand.Source
+	// ClaimDuration specifies how long the processor will claim ClaimedEntry objects in ProcessorStorage. A warning
+	// is logged if it, or the ClaimDurationFunc, is set but isn't longer than the ProcessInterval, as then a pump that
+	// takes as long as the processor spends idle relies on renewals to keep its entries from being claimed by another
+	// processor.
 	ClaimDuration time.Duration
+	// ClaimDurationFunc computes how long the processor claims entries for from how many entries it expects each
+	// pump to process at once, the BatchSize times the Concurrency, so that larger batches can be given longer to
+	// publish while smaller ones are retried sooner if their processor fails. Defaults to always returning the
+	// ClaimDuration, which it overrides when set.
+	ClaimDurationFunc func(batchSize int) time.Duration
+	// ClaimRenewalInterval specifies how often the processor renews its claim while publishing, so that slow
+	// batches are not claimed by another processor. Defaults to a third of the claim duration, and must be
+	// shorter than it. A warning is logged if it is over half the claim duration, as then a single slow or failed
+	// renewal lets the claim expire while entries are being published.
+	ClaimRenewalInterval time.Duration
+	// Namespace restricts the processor to entries in the specified namespace, defaults to processing entries in
+	// every namespace. Empty namespaces can instead be processed by passing a context from WithNamespace to
+	// Outbox.StartProcessing or Outbox.PumpOutbox. It cannot be combined with Namespaces.
+	Namespace string
+	// Namespaces restricts the processor to entries in the specified namespaces, which may include the empty
+	// namespace. Each pump takes turns publishing a batch from each namespace until they are all drained, so
+	// that a busy namespace can't starve the others.
+	Namespaces []string
 	// ProcessorID is a unique identifier for any instance of the outbox, so a horizontally scaled app
-	// can run many Outbox instances, each claiming ClaimedEntry objects and publishing them
+	// can run many Outbox instances, each claiming ClaimedEntry objects and publishing them. Processors restricted
+	// to a Namespace, or Namespaces, claim entries with the ProcessorID scoped to their namespaces, as composed by
+	// EffectiveProcessorID, so that processors for different namespaces may share a ProcessorID.
 	ProcessorID string
 	// BatchSize indicates how many ClaimedEntry objects to attempt to retrieve & publish in one go
 	BatchSize int
+	// DeleteChunkSize limits how many entries are deleted by each call to ProcessorStorage.DeleteEntries, so that
+	// large batches stay within database limits on the number of parameters in a query, e.g. 65535 for PostgreSQL.
+	// Defaults to DefaultDeleteChunkSize.
+	DeleteChunkSize int
+	// Concurrency indicates how many batches may be published in parallel, defaults to 1 so that batches
+	// are published one at a time
+	Concurrency int
+	// Ordering determines what order entries are published in, defaults to OrderingNone
+	Ordering OrderingMode
+	// SelectionOrder determines whether the oldest or newest entries within each priority are retrieved first,
+	// defaults to OldestFirst. NewestFirst can't be combined with OrderingPerKey, which publishes each key's entries
+	// oldest first. It is an optional storage hint, see ProcessorStorage.
+	SelectionOrder OrderMode
+	// MaxEntriesPerKeyPerBatch limits how many entries with the same non-empty ClaimedEntry.Key are retrieved for
+	// each batch, leaving the rest for later batches, so that a key with a large backlog can't delay every other key.
+	// It is an optional storage hint, see ProcessorStorage. Defaults to zero, which doesn't limit entries per key.
+	MaxEntriesPerKeyPerBatch int
+	// ClaimAffinity keeps entries with the processor that last claimed them while it is still active, rather than
+	// letting another processor claim them as soon as their claim expires, so that entries don't bounce between
+	// processors. Entries are left for their previous claimant for the ProcessInterval after their claim expires,
+	// giving it the chance to claim them again on its next pump. It is an optional storage hint, see
+	// ProcessorStorage.
+	ClaimAffinity bool
+	// ShardCount divides the entries into that many shards by their ClaimedEntry.Key, as assigned by PartitionForKey,
+	// restricting the processor to the entries in the ShardIndex shard, so that each of a very large number of
+	// processors only contends over its own share of the entries. Entries with the same key belong to the same shard,
+	// so the OrderingPerKey still holds, while entries without a key all belong to one shard. Every shard needs a
+	// processor, or its entries are never published. It is an optional storage hint, see ProcessorStorage. Defaults
+	// to zero, which doesn't shard entries.
+	ShardCount int
+	// ShardIndex is the shard the processor is restricted to if ShardCount is set, from zero up to but excluding
+	// the ShardCount
+	ShardIndex int
+	// FairnessKey optionally groups the entries of each batch by the key it returns for their messages, e.g. a tenant
+	// identifier from the Key or a header, taking entries from each group in turn so that every group gets a fair
+	// share of each batch, rather than a group with many waiting entries filling batches and delaying the others. It
+	// receives messages as they were written to storage, after any compression or encryption of the Payload. With
+	// OrderingPerKey, messages with the same Key must have the same fairness key, or they may be published out of
+	// order. It is an optional storage hint, see ProcessorStorage.
+	FairnessKey func(msg Message) string
+	// FailureMode determines whether entries continue to be published after one fails, defaults to ContinueBatch
+	FailureMode FailureMode
 	// Logger can be provided to receive logging output
 	Logger logr.Logger
+	// RoutineLogInterval limits how often the processor logs routine events that happen on every pump, such as
+	// starting to pump the outbox, logging them at most once per interval along with how many were suppressed in
+	// between. Defaults to DefaultRoutineLogInterval, and may be negative to log every routine event.
+	RoutineLogInterval time.Duration
+	// Metrics can be provided to receive measurements of the processor's behaviour, defaults to
+	// discarding all measurements
+	Metrics Metrics
+	// Tracer can be provided to trace the publishing of each batch, defaults to tracing nothing
+	Tracer Tracer
+	// EventSink can be provided to receive an event for each step the processor takes with each message, e.g. to
+	// build an audit log, defaults to discarding all events
+	EventSink EventSink
+	// MaxPayloadSize limits the size in bytes of the Message.Payload that Outbox.Publish accepts, rejecting larger
+	// messages with an error wrapping ErrPayloadTooLarge, so that messages a broker would reject fail when they are
+	// written rather than when they are published. Defaults to zero, allowing payloads of any size.
+	MaxPayloadSize int
+	// Compressor optionally compresses message payloads while they are stored in the outbox, marking compressed
+	// entries with the CompressionHeader so that entries stored without compression can still be published.
+	// Payloads are decompressed before they are published or dead lettered.
+	Compressor Compressor
+	// CompressionThreshold is the smallest payload, in bytes, that the Compressor compresses, so that payloads too
+	// small to benefit are stored as they are. Defaults to zero, compressing every non-empty payload.
+	CompressionThreshold int
+	// Encryptor optionally encrypts message payloads while they are stored in the outbox, after any compression,
+	// marking encrypted entries with the EncryptionHeader so that entries stored without encryption can still be
+	// published. Payloads are decrypted before they are published or dead lettered.
+	Encryptor Encryptor
+	// Codec optionally encodes the values published with Outbox.PublishValues into message payloads, which consumers
+	// can decode with the same Codec using DecodePayload. Outbox.Publish doesn't use it.
+	Codec Codec
+	// MaxAttempts limits how many times an entry may be claimed for publishing, after which it is passed to
+	// the DeadLetterHandler and deleted. Zero, the default, retries entries indefinitely.
+	MaxAttempts int
+	// DeadLetterHandler receives entries that exceed MaxAttempts, that the Publisher rejects permanently, or that
+	// exceed MaxEntryAge if DeadLetterExpired is set, defaults to logging and discarding them
+	DeadLetterHandler DeadLetterHandler
+	// MaxEntryAge limits how long an entry may wait to be published, measured from its ClaimedEntry.CreatedAt, after
+	// which it is deleted without being published the next time it is claimed, so that stale messages (e.g. expired
+	// one-time codes) are never delivered. Zero, the default, never expires entries.
+	MaxEntryAge time.Duration
+	// DeadLetterExpired passes entries that exceed MaxEntryAge to the DeadLetterHandler, rather than deleting them
+	DeadLetterExpired bool
+	// OnPublish is optionally called once for every message the processor attempts to publish, with the
+	// error it failed with or nil if it was published. It is called before published entries are deleted,
+	// but cannot prevent their deletion, and any panic is recovered and logged. It may be called
+	// concurrently if Concurrency is greater than 1.
+	OnPublish func(ctx context.Context, msg Message, err error)
+	// Transform is optionally called with each batch of messages after they are claimed and before they are published,
+	// e.g. to redact fields or filter messages by a feature flag. It returns the messages to publish in their original
+	// order, which may be rewritten, and drops the others, whose entries are deleted without being published. If it
+	// drops any, the messages it returns must keep the DedupKey they were given, which defaults to the entry's ID, so
+	// that they can be matched with their entries. If it fails, none of the batch is published, to be retried.
+	Transform func(ctx context.Context, messages []Message) ([]Message, error)
+	// OnStart is optionally called when StartProcessing starts processing, before it first waits for work
+	OnStart func()
+	// OnStop is optionally called when StartProcessing stops processing, whether due to Shutdown or its
+	// context being cancelled. It is only called if OnStart was.
+	OnStop func()
+	// OnPumpComplete is optionally called each time PumpOutbox returns, with the number of entries it
+	// processed, whether or not they were published successfully, and the error it returned, if any
+	OnPumpComplete func(processed int, err error)
+	// PumpTimeout limits how long each pump made by StartProcessing may take, after which its context is cancelled
+	// and the pump is retried like any other failure. Zero, the default, never times out pumps.
+	PumpTimeout time.Duration
+	// MessagePublishTimeout, if set, publishes messages one at a time with SingleMessagePublisher.PublishMessage,
+	// which the Publisher must implement, limiting how long each may take so that one slow message can't hold up
+	// the rest of its batch. Messages that time out fail, to be retried. Defaults to zero, publishing each batch in
+	// one call to Publisher.Publish.
+	MessagePublishTimeout time.Duration
+	// ShutdownGrace limits how long StartProcessing waits, once its context is cancelled, for a pump in progress to
+	// return, after which StartProcessing returns ErrShutdownGraceExceeded and leaves the pump to finish in the
+	// background. Zero, the default, waits for the pump however long it takes.
+	ShutdownGrace time.Duration
+	// CleanupTimeout limits how long entries that were published, or otherwise handled, are given to be deleted
+	// once the context of the pump handling them is done, e.g. cancelled or timed out part way through publishing,
+	// as they are then deleted with a context detached from the pump's, rather than left to be published again.
+	// Defaults to DefaultCleanupTimeout.
+	CleanupTimeout time.Duration
+	// BackoffFactory provides the strategy StartProcessing uses to retry a pump that failed, and is called for
+	// a fresh backoff each time the processor wakes up. Retries stop when the backoff returns backoff.Stop.
+	// Defaults to backoff.NewExponentialBackOff.
+	BackoffFactory func() backoff.BackOff
+	// MaxProcessingRetryElapsed limits how long StartProcessing may spend failing to pump the outbox, across
+	// retries and wake ups, before the failure is treated as fatal. Zero, the default, never treats failures
+	// as fatal, retrying indefinitely.
+	MaxProcessingRetryElapsed time.Duration
+	// OnFatalError optionally receives the error once pumps have been failing for MaxProcessingRetryElapsed, or
+	// as soon as a pump fails with a StorageError that isn't Temporary, after which StartProcessing continues,
+	// reporting the error again if pumps keep failing. If not provided, StartProcessing instead returns the error.
+	OnFatalError func(err error)
+	// HealthStaleness is how long may pass without a successful pump, measured from when the Outbox was created
+	// until its first successful pump, before Outbox.Healthy reports the processor as unhealthy. Zero, the default,
+	// only checks that the storage is reachable.
+	HealthStaleness time.Duration
+	// SkipClaim skips claiming entries before publishing them, retrieving every entry with
+	// UnclaimedEntryGetter.GetUnclaimedEntries instead, which the Storage must implement. This saves a write per pump
+	// for deployments guaranteed to run a single processor. It must not be enabled if more than one processor may
+	// run at once, as they would publish the same entries concurrently. ClaimedEntry.Attempts aren't counted
+	// without claims, so entries are never dead lettered for exceeding MaxAttempts.
+	SkipClaim bool
+	// DedupWithinPublish writes only the first of any messages with the same Key and Payload passed to a single call
+	// to Outbox.Publish, so that an event accidentally enqueued twice in one unit of work is only published once.
+	// Messages are compared as written to storage, after any compression or encryption, so duplicates aren't
+	// recognised with an Encryptor that encrypts identical payloads differently each time. It is an optional storage
+	// hint, see ProcessorStorage.
+	DedupWithinPublish bool
+	// ReleaseFailed releases the claims on entries that fail to publish with EntryReleaser.ReleaseEntries, which
+	// the Storage must implement, so that any processor can retry them straight away, rather than only this one
+	// until their claims expire after the ClaimDuration. Each retry is then a fresh claim, counting towards
+	// MaxAttempts.
+	ReleaseFailed bool
 }
 
+// EffectiveProcessorID composes the identity the processor claims entries with from the ProcessorID and the
+// namespaces it is restricted to by Namespace or Namespaces, if any, e.g. worker-1["orders","payments"]. The
+// namespaces are quoted and sorted, so that the identity is the same however they are listed and distinct for every
+// set of namespaces. Namespaces provided through the context passed to Outbox.StartProcessing or Outbox.PumpOutbox
+// aren't included, so processors restricted that way must use distinct ProcessorIDs.
+func (c *Config) EffectiveProcessorID() string {
+	namespaces := c.Namespaces
+	if c.Namespace != "" {
+		namespaces = []string{c.Namespace}
+	}
+	if len(namespaces) == 0 {
+		return c.ProcessorID
+	}
+
+	quoted := make([]string, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		quoted = append(quoted, strconv.Quote(namespace))
+	}
+	sort.Strings(quoted)
+
+	return c.ProcessorID + "[" + strings.Join(quoted, ",") + "]"
+}
+
+// Defaults returns a new Config with the defaults DefaultAndValidate provides for the fields that don't depend on
+// others, e.g. to inspect them or to adjust them before providing the Storage, Publisher and ProcessorID. Every call
+// returns a distinct Config, so modifying one doesn't affect any other.
+func Defaults() Config {
+	return Config{
+		Clock:              clockwork.NewRealClock(),
+		ProcessInterval:    DefaultProcessInterval,
+		RandSource:         rand.NewSource(time.Now().UnixNano()),
+		ClaimDuration:      DefaultClaimDuration,
+		BatchSize:          DefaultBatchSize,
+		DeleteChunkSize:    DefaultDeleteChunkSize,
+		Concurrency:        1,
+		Logger:             logr.Discard(),
+		RoutineLogInterval: DefaultRoutineLogInterval,
+		Metrics:            noopMetrics{},
+		Tracer:             noopTracer{},
+		EventSink:          noopEventSink{},
+		CleanupTimeout:     DefaultCleanupTimeout,
+		BackoffFactory: func() backoff.BackOff {
+			return backoff.NewExponentialBackOff()
+		},
+	}
+}
+
+// minClaimRenewals is how many times the processor should be able to attempt renewing its claims before they
+// expire, below which DefaultAndValidate logs a warning
+const minClaimRenewals = 2
+
 // DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
 // default values where no value is provided
 func (c *Config) DefaultAndValidate() error {
 	if c.Storage == nil {
-		return errors.New("no storage provided")
+		return ErrNoStorage
 	}
 
 	if c.Publisher == nil {
-		return errors.New("no publisher provided")
+		return ErrNoPublisher
 	}
 
 	if c.ProcessorID == "" {
-		return errors.New("no processor ID provided")
+		return ErrNoProcessorID
+	}
+
+	if c.Namespace != "" {
+		if len(c.Namespaces) > 0 {
+			return errors.New("only one of namespace or namespaces may be provided")
+		}
+		c.Namespaces = []string{c.Namespace}
+	}
+
+	seenNamespaces := make(map[string]bool, len(c.Namespaces))
+	for _, namespace := range c.Namespaces {
+		if seenNamespaces[namespace] {
+			return fmt.Errorf("namespace %q provided more than once", namespace)
+		}
+		seenNamespaces[namespace] = true
 	}
 
 	if c.Clock == nil {
 		c.Clock = clockwork.NewRealClock()
 	}
 
-	if c.Logger == nil {
-		c.Logger = &logr.DiscardLogger{}
+	if c.Logger.GetSink() == nil {
+		c.Logger = logr.Discard()
+	}
+
+	if c.RoutineLogInterval == 0 {
+		c.RoutineLogInterval = DefaultRoutineLogInterval
+	}
+
+	if c.CleanupTimeout == 0 {
+		c.CleanupTimeout = DefaultCleanupTimeout
+	}
+
+	if c.MaxPayloadSize < 0 {
+		return errors.New("max payload size cannot be negative")
+	}
+
+	if c.CompressionThreshold < 0 {
+		return errors.New("compression threshold cannot be negative")
+	}
+
+	if c.MaxAttempts < 0 {
+		return errors.New("max attempts cannot be negative")
+	}
+
+	if c.MaxEntryAge < 0 {
+		return errors.New("max entry age cannot be negative")
+	}
+
+	if c.DeadLetterHandler == nil {
+		c.DeadLetterHandler = &loggingDeadLetterHandler{
+			logger: c.Logger.WithName("dead-letter"),
+		}
 	}
 
+	if c.Metrics == nil {
+		c.Metrics = noopMetrics{}
+	}
+
+	if c.Tracer == nil {
+		c.Tracer = noopTracer{}
+	}
+
+	if c.EventSink == nil {
+		c.EventSink = noopEventSink{}
+	}
+
+	if c.ProcessInterval < 0 {
+		return errors.New("process interval cannot be negative")
+	}
 	if c.ProcessInterval == 0 {
 		c.ProcessInterval = DefaultProcessInterval
 	}
 
-	if c.ClaimDuration == 0 {
-		c.ClaimDuration = DefaultClaimDuration
+	if c.RandSource == nil {
+		c.RandSource = rand.NewSource(time.Now().UnixNano())
+	}
+
+	if c.IntervalJitter < 0 || c.IntervalJitter > c.ProcessInterval {
+		return errors.New("interval jitter cannot be negative or exceed the process interval")
 	}
 
 	if c.BatchSize < 1 {
 		c.BatchSize = DefaultBatchSize
 	}
 
+	if c.DeleteChunkSize < 1 {
+		c.DeleteChunkSize = DefaultDeleteChunkSize
+	}
+
+	if c.Concurrency < 1 {
+		c.Concurrency = 1
+	}
+
+	if c.ClaimDuration < 0 {
+		return errors.New("claim duration cannot be negative")
+	}
+	// only claim durations that were configured are compared with the process interval, as the defaults leave that to
+	// claim renewals
+	claimDurationSet := c.ClaimDuration != 0 || c.ClaimDurationFunc != nil
+	if c.ClaimDuration == 0 {
+		c.ClaimDuration = DefaultClaimDuration
+	}
+
+	if c.ClaimDurationFunc == nil {
+		claimDuration := c.ClaimDuration
+		c.ClaimDurationFunc = func(int) time.Duration {
+			return claimDuration
+		}
+	}
+	claimDuration := c.ClaimDurationFunc(c.BatchSize * c.Concurrency)
+	if claimDuration <= 0 {
+		return errors.New("claim duration must be positive")
+	}
+	if claimDurationSet && claimDuration <= c.ProcessInterval && !c.SkipClaim {
+		c.Logger.Info("claim duration isn't longer than the process interval, entries may be claimed by another "+
+			"processor while they are being published", "claimDuration", claimDuration,
+			"processInterval", c.ProcessInterval)
+	}
+
+	if c.ClaimRenewalInterval == 0 {
+		c.ClaimRenewalInterval = claimDuration / 3
+	}
+	if c.ClaimRenewalInterval < 0 || c.ClaimRenewalInterval >= claimDuration {
+		return errors.New("claim renewal interval must be positive and shorter than the claim duration")
+	}
+	if claimDuration < minClaimRenewals*c.ClaimRenewalInterval && !c.SkipClaim {
+		// a single slow or failed renewal would let another processor claim entries while they are being published
+		c.Logger.Info("claim duration leaves little time to renew claims, entries may be published more than once",
+			"claimDuration", claimDuration, "claimRenewalInterval", c.ClaimRenewalInterval)
+	}
+
+	if c.MaxEntriesPerKeyPerBatch < 0 {
+		return errors.New("max entries per key per batch cannot be negative")
+	}
+
+	if c.ShardCount < 0 {
+		return errors.New("shard count cannot be negative")
+	}
+	if c.ShardIndex < 0 || (c.ShardIndex > 0 && c.ShardIndex >= c.ShardCount) {
+		return fmt.Errorf("shard index %d is outside of the %d shards", c.ShardIndex, c.ShardCount)
+	}
+
+	if c.Ordering != OrderingNone && c.Ordering != OrderingPerKey {
+		return fmt.Errorf("unknown ordering mode %v", c.Ordering)
+	}
+
+	if c.SelectionOrder != OldestFirst && c.SelectionOrder != NewestFirst {
+		return fmt.Errorf("unknown selection order %v", c.SelectionOrder)
+	}
+	if c.SelectionOrder == NewestFirst && c.Ordering == OrderingPerKey {
+		return errors.New("newest first selection order can't be combined with per key ordering")
+	}
+
+	if c.FailureMode != ContinueBatch && c.FailureMode != HaltOnFirstFailure {
+		return fmt.Errorf("unknown failure mode %v", c.FailureMode)
+	}
+
+	if c.PumpTimeout < 0 {
+		return errors.New("pump timeout cannot be negative")
+	}
+
+	if c.MessagePublishTimeout < 0 {
+		return errors.New("message publish timeout cannot be negative")
+	}
+	if _, ok := c.Publisher.(SingleMessagePublisher); c.MessagePublishTimeout > 0 && !ok {
+		return errors.New("publisher does not support publishing single messages")
+	}
+
+	if c.ShutdownGrace < 0 {
+		return errors.New("shutdown grace cannot be negative")
+	}
+
+	if c.CleanupTimeout < 0 {
+		return errors.New("cleanup timeout cannot be negative")
+	}
+
+	if c.WakeDebounce < 0 {
+		return errors.New("wake debounce cannot be negative")
+	}
+
+	if c.MaxProcessingRetryElapsed < 0 {
+		return errors.New("max processing retry elapsed cannot be negative")
+	}
+
+	if c.HealthStaleness < 0 {
+		return errors.New("health staleness cannot be negative")
+	}
+
+	if _, ok := c.Storage.(UnclaimedEntryGetter); c.SkipClaim && !ok {
+		return errors.New("storage does not support skipping claims")
+	}
+
+	if _, ok := c.Storage.(EntryReleaser); c.ReleaseFailed && !ok {
+		return errors.New("storage does not support releasing entries")
+	}
+
+	if c.BackoffFactory == nil {
+		c.BackoffFactory = func() backoff.BackOff {
+			return backoff.NewExponentialBackOff()
+		}
+	}
+
 	return nil
 }
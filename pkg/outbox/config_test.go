@@ -1,7 +1,12 @@
 package outbox_test
 
 import (
+	"errors"
+	"sync"
+	"time"
+
 	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
 	"github.com/jonboulle/clockwork"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
@@ -31,15 +36,250 @@ var _ = Describe("Config", func() {
 		Entry("fails without storage", func() { cfg.Storage = nil }),
 		Entry("fails without a publisher", func() { cfg.Publisher = nil }),
 		Entry("fails without a processor ID", func() { cfg.ProcessorID = "" }),
+		Entry("fails with a negative max payload size", func() { cfg.MaxPayloadSize = -1 }),
+		Entry("fails with a negative compression threshold", func() { cfg.CompressionThreshold = -1 }),
+		Entry("fails with negative max attempts", func() { cfg.MaxAttempts = -1 }),
+		Entry("fails with a negative max entry age", func() { cfg.MaxEntryAge = -1 }),
+		Entry("fails with a negative max processing retry elapsed", func() { cfg.MaxProcessingRetryElapsed = -1 }),
+		Entry("fails with both a namespace and namespaces", func() {
+			cfg.Namespace = "first"
+			cfg.Namespaces = []string{"second"}
+		}),
+		Entry("fails with a negative max entries per key per batch", func() { cfg.MaxEntriesPerKeyPerBatch = -1 }),
+		Entry("fails with an unknown ordering mode", func() { cfg.Ordering = outbox.OrderingPerKey + 1 }),
+		Entry("fails with an unknown failure mode", func() { cfg.FailureMode = outbox.HaltOnFirstFailure + 1 }),
+		Entry("fails with an unknown selection order", func() { cfg.SelectionOrder = outbox.NewestFirst + 1 }),
+		Entry("fails with newest first selection order and per key ordering", func() {
+			cfg.SelectionOrder = outbox.NewestFirst
+			cfg.Ordering = outbox.OrderingPerKey
+		}),
+		Entry("fails with a negative process interval", func() { cfg.ProcessInterval = -time.Second }),
+		Entry("fails with a negative claim duration", func() { cfg.ClaimDuration = -time.Second }),
+		Entry("fails with a negative pump timeout", func() { cfg.PumpTimeout = -1 }),
+		Entry("fails with a negative message publish timeout", func() { cfg.MessagePublishTimeout = -1 }),
+		Entry("fails with a message publish timeout for a publisher that can't publish single messages", func() {
+			cfg.Publisher = struct{ outbox.Publisher }{cfg.Publisher}
+			cfg.MessagePublishTimeout = time.Second
+		}),
+		Entry("fails with a negative shutdown grace", func() { cfg.ShutdownGrace = -1 }),
+		Entry("fails with a negative cleanup timeout", func() { cfg.CleanupTimeout = -1 }),
+		Entry("fails with a negative wake debounce", func() { cfg.WakeDebounce = -1 }),
+		Entry("fails with a negative interval jitter", func() { cfg.IntervalJitter = -1 }),
+		Entry("fails with an interval jitter exceeding the process interval", func() {
+			cfg.ProcessInterval = time.Second
+			cfg.IntervalJitter = 2 * time.Second
+		}),
+		Entry("fails with duplicate namespaces", func() { cfg.Namespaces = []string{"first", "second", "first"} }),
+		Entry("fails with a negative health staleness", func() { cfg.HealthStaleness = -1 }),
+		Entry("fails to skip claims with a storage that can't get unclaimed entries", func() {
+			cfg.Storage = struct{ outbox.ProcessorStorage }{cfg.Storage}
+			cfg.SkipClaim = true
+		}),
+		Entry("fails with a negative shard count", func() { cfg.ShardCount = -1 }),
+		Entry("fails with a negative shard index", func() {
+			cfg.ShardCount = 2
+			cfg.ShardIndex = -1
+		}),
+		Entry("fails with a shard index beyond the shard count", func() {
+			cfg.ShardCount = 2
+			cfg.ShardIndex = 2
+		}),
+		Entry("fails with a shard index without a shard count", func() { cfg.ShardIndex = 1 }),
+		Entry("fails with a claim duration func returning a non-positive duration", func() {
+			cfg.ClaimDurationFunc = func(int) time.Duration { return 0 }
+		}),
+		Entry("fails to release failed entries with a storage that can't release entries", func() {
+			cfg.Storage = struct{ outbox.ProcessorStorage }{cfg.Storage}
+			cfg.ReleaseFailed = true
+		}),
+	)
+
+	DescribeTable(
+		"identifies the required field that is missing",
+		func(mutator func(), expected error) {
+			mutator()
+			Expect(errors.Is(cfg.DefaultAndValidate(), expected)).To(BeTrue())
+
+			_, err := outbox.New(cfg)
+			Expect(errors.Is(err, expected)).To(BeTrue())
+			Expect(err).To(MatchError("invalid config: " + expected.Error()))
+		},
+		Entry("without storage", func() { cfg.Storage = nil }, outbox.ErrNoStorage),
+		Entry("without a publisher", func() { cfg.Publisher = nil }, outbox.ErrNoPublisher),
+		Entry("without a processor ID", func() { cfg.ProcessorID = "" }, outbox.ErrNoProcessorID),
 	)
 
 	It("correctly sets defaults", func() {
 		Expect(cfg.DefaultAndValidate()).To(Succeed())
 
 		Expect(cfg.Clock).To(Equal(clockwork.NewRealClock()))
-		Expect(cfg.Logger).To(Equal(&logr.DiscardLogger{}))
+		Expect(cfg.Logger).To(Equal(logr.Discard()))
 		Expect(cfg.BatchSize).To(Equal(outbox.DefaultBatchSize))
+		Expect(cfg.DeleteChunkSize).To(Equal(outbox.DefaultDeleteChunkSize))
+		Expect(cfg.RoutineLogInterval).To(Equal(outbox.DefaultRoutineLogInterval))
+		Expect(cfg.CleanupTimeout).To(Equal(outbox.DefaultCleanupTimeout))
 		Expect(cfg.ClaimDuration).To(Equal(outbox.DefaultClaimDuration))
+		Expect(cfg.ClaimDurationFunc(cfg.BatchSize)).To(Equal(outbox.DefaultClaimDuration))
 		Expect(cfg.ProcessInterval).To(Equal(outbox.DefaultProcessInterval))
+		Expect(cfg.Metrics).ToNot(BeNil())
+		Expect(cfg.Concurrency).To(Equal(1))
+		Expect(cfg.MaxAttempts).To(BeZero())
+		Expect(cfg.MaxEntryAge).To(BeZero())
+		Expect(cfg.DeadLetterHandler).ToNot(BeNil())
+		Expect(cfg.RandSource).ToNot(BeNil())
+	})
+
+	It("preserves provided metrics", func() {
+		metrics := &fake.Metrics{}
+		cfg.Metrics = metrics
+
+		Expect(cfg.DefaultAndValidate()).To(Succeed())
+		Expect(cfg.Metrics).To(BeIdenticalTo(metrics))
+	})
+
+	It("doesn't interfere between configs validated concurrently", func() {
+		var wg sync.WaitGroup
+		configs := make([]outbox.Config, 16)
+		for idx := range configs {
+			configs[idx] = cfg
+			if idx%2 == 0 {
+				configs[idx].BatchSize = idx + 1
+				configs[idx].ProcessInterval = time.Duration(idx+1) * time.Second
+			}
+
+			wg.Add(1)
+			go func(cfg *outbox.Config) {
+				defer wg.Done()
+				defer GinkgoRecover()
+
+				Expect(cfg.DefaultAndValidate()).To(Succeed())
+			}(&configs[idx])
+		}
+		wg.Wait()
+
+		for idx, cfg := range configs {
+			if idx%2 == 0 {
+				Expect(cfg.BatchSize).To(Equal(idx + 1))
+				Expect(cfg.ProcessInterval).To(Equal(time.Duration(idx+1) * time.Second))
+			} else {
+				Expect(cfg.BatchSize).To(Equal(outbox.DefaultBatchSize))
+				Expect(cfg.ProcessInterval).To(Equal(outbox.DefaultProcessInterval))
+			}
+		}
+	})
+
+	Describe("Defaults", func() {
+		It("provides the same defaults as DefaultAndValidate", func() {
+			defaults := outbox.Defaults()
+			Expect(cfg.DefaultAndValidate()).To(Succeed())
+
+			Expect(defaults.Clock).To(Equal(cfg.Clock))
+			Expect(defaults.Logger).To(Equal(cfg.Logger))
+			Expect(defaults.BatchSize).To(Equal(cfg.BatchSize))
+			Expect(defaults.DeleteChunkSize).To(Equal(cfg.DeleteChunkSize))
+			Expect(defaults.Concurrency).To(Equal(cfg.Concurrency))
+			Expect(defaults.RoutineLogInterval).To(Equal(cfg.RoutineLogInterval))
+			Expect(defaults.CleanupTimeout).To(Equal(cfg.CleanupTimeout))
+			Expect(defaults.ClaimDuration).To(Equal(cfg.ClaimDuration))
+			Expect(defaults.ProcessInterval).To(Equal(cfg.ProcessInterval))
+			Expect(defaults.Metrics).To(Equal(cfg.Metrics))
+			Expect(defaults.Tracer).To(Equal(cfg.Tracer))
+			Expect(defaults.EventSink).To(Equal(cfg.EventSink))
+			Expect(defaults.RandSource).ToNot(BeNil())
+			Expect(defaults.BackoffFactory).ToNot(BeNil())
+		})
+
+		It("can be completed and validated", func() {
+			defaults := outbox.Defaults()
+			defaults.Storage = cfg.Storage
+			defaults.Publisher = cfg.Publisher
+			defaults.ProcessorID = cfg.ProcessorID
+			defaults.ClaimDuration = 6 * time.Second
+
+			Expect(defaults.DefaultAndValidate()).To(Succeed())
+			Expect(defaults.ClaimDurationFunc(defaults.BatchSize)).To(Equal(6 * time.Second))
+			Expect(defaults.ClaimRenewalInterval).To(Equal(2 * time.Second))
+		})
+
+		It("returns a distinct config every time", func() {
+			modified := outbox.Defaults()
+			modified.BatchSize = 1
+			modified.ProcessInterval = time.Hour
+
+			Expect(outbox.Defaults().BatchSize).To(Equal(outbox.DefaultBatchSize))
+			Expect(outbox.Defaults().ProcessInterval).To(Equal(outbox.DefaultProcessInterval))
+
+			Expect(cfg.DefaultAndValidate()).To(Succeed())
+			Expect(cfg.BatchSize).To(Equal(outbox.DefaultBatchSize))
+			Expect(cfg.ProcessInterval).To(Equal(outbox.DefaultProcessInterval))
+		})
+	})
+
+	Describe("claim duration", func() {
+		var lines []string
+
+		BeforeEach(func() {
+			lines = nil
+			cfg.Logger = funcr.New(func(prefix, args string) {
+				lines = append(lines, args)
+			}, funcr.Options{})
+		})
+
+		It("warns if claims can't be renewed at least twice before they expire", func() {
+			cfg.ClaimDuration = 2 * time.Second
+			cfg.ClaimRenewalInterval = 1500 * time.Millisecond
+			cfg.ProcessInterval = time.Second
+
+			Expect(cfg.DefaultAndValidate()).To(Succeed())
+			Expect(lines).To(ConsistOf(And(
+				ContainSubstring("claim duration leaves little time to renew claims"),
+				ContainSubstring(`"claimDuration"="2s" "claimRenewalInterval"="1.5s"`),
+			)))
+		})
+
+		It("doesn't warn about the default renewal interval", func() {
+			cfg.ClaimDuration = 2 * time.Second
+			cfg.ProcessInterval = time.Second
+
+			Expect(cfg.DefaultAndValidate()).To(Succeed())
+			Expect(lines).To(BeEmpty())
+		})
+
+		It("warns if the claim duration isn't longer than the process interval", func() {
+			cfg.ClaimDuration = time.Second
+			cfg.ProcessInterval = time.Minute
+
+			Expect(cfg.DefaultAndValidate()).To(Succeed())
+			Expect(lines).To(ConsistOf(And(
+				ContainSubstring("claim duration isn't longer than the process interval"),
+				ContainSubstring(`"claimDuration"="1s" "processInterval"="1m0s"`),
+			)))
+		})
+
+		It("warns if the claim duration func's duration isn't longer than the process interval", func() {
+			cfg.ClaimDurationFunc = func(int) time.Duration {
+				return time.Minute
+			}
+			cfg.ProcessInterval = time.Minute
+
+			Expect(cfg.DefaultAndValidate()).To(Succeed())
+			Expect(lines).To(ConsistOf(ContainSubstring("claim duration isn't longer than the process interval")))
+		})
+
+		It("doesn't warn about the default claim duration", func() {
+			cfg.ProcessInterval = time.Minute
+
+			Expect(cfg.DefaultAndValidate()).To(Succeed())
+			Expect(lines).To(BeEmpty())
+		})
+
+		It("doesn't warn about a claim duration if claims are skipped", func() {
+			cfg.ClaimDuration = time.Second
+			cfg.ProcessInterval = time.Minute
+			cfg.SkipClaim = true
+
+			Expect(cfg.DefaultAndValidate()).To(Succeed())
+			Expect(lines).To(BeEmpty())
+		})
 	})
 })
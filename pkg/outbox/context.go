@@ -2,13 +2,37 @@ package outbox
 
 import (
 	"context"
+	"sort"
+	"strings"
+	"time"
 )
 
+// ValueHeaderPrefix prefixes the headers that record the values set with WithValue on the context passed to
+// Outbox.Publish, e.g. "outboxen-value-topic". The processor removes them before publishing, setting the values on
+// the context passed to the Publisher instead.
+const ValueHeaderPrefix = "outboxen-value-"
+
 type settingsKey struct{}
 
 // ContextSettings are settings that can configure outbox behaviour through context
 type ContextSettings struct {
-	Namespace string
+	Namespace        string
+	Ordering         OrderingMode
+	MaxEntriesPerKey int
+	ClaimAffinity    time.Duration
+	// ShardIndex and ShardCount are set by WithShard
+	ShardIndex int
+	ShardCount int
+	// DedupWithinPublish is set by WithDedupWithinPublish
+	DedupWithinPublish bool
+	// SelectionOrder is set by WithSelectionOrder
+	SelectionOrder OrderMode
+	// FairnessKey is set by WithFairnessKey
+	FairnessKey func(msg Message) string
+	// Values are custom values set with WithValue, which are only ever copied, never modified
+	Values map[string]string
+	// namespaceSet distinguishes the empty namespace being set from no namespace being set
+	namespaceSet bool
 }
 
 // Clone clones context settings
@@ -51,10 +75,228 @@ func NamespaceFromContext(ctx context.Context) string {
 	return c.Namespace
 }
 
+// LookupNamespace reports the namespace set on the context by WithNamespace, if any. ProcessorStorage
+// implementations use it to restrict the entries they claim to that namespace, claiming entries in every namespace
+// if none was set.
+func LookupNamespace(ctx context.Context) (namespace string, ok bool) {
+	c := settingsFromContext(ctx)
+	if c == nil || !c.namespaceSet {
+		return "", false
+	}
+
+	return c.Namespace, true
+}
+
 // WithNamespace creates a context which configures published messages to be recorded to the outbox with the specified
-// namespace
+// namespace. When passed to Outbox.StartProcessing or Outbox.PumpOutbox it also restricts the processor to entries in
+// that namespace.
 func WithNamespace(ctx context.Context, namespace string) context.Context {
 	return augmentContextSettings(ctx, func(c *ContextSettings) {
 		c.Namespace = namespace
+		c.namespaceSet = true
+	})
+}
+
+// OrderingFromContext identifies what order ProcessorStorage.GetClaimedEntries should return entries in
+func OrderingFromContext(ctx context.Context) OrderingMode {
+	c := settingsFromContext(ctx)
+	if c == nil {
+		return OrderingNone
+	}
+
+	return c.Ordering
+}
+
+// WithOrdering creates a context which configures the order ProcessorStorage.GetClaimedEntries returns entries in
+func WithOrdering(ctx context.Context, ordering OrderingMode) context.Context {
+	return augmentContextSettings(ctx, func(c *ContextSettings) {
+		c.Ordering = ordering
+	})
+}
+
+// SelectionOrderFromContext identifies whether ProcessorStorage.GetClaimedEntries should return the oldest or newest
+// entries within each priority first, as set by WithSelectionOrder, defaulting to OldestFirst. It is an optional
+// hint, see ProcessorStorage.
+func SelectionOrderFromContext(ctx context.Context) OrderMode {
+	c := settingsFromContext(ctx)
+	if c == nil {
+		return OldestFirst
+	}
+
+	return c.SelectionOrder
+}
+
+// WithSelectionOrder creates a context which configures whether ProcessorStorage.GetClaimedEntries returns the
+// oldest or newest entries within each priority first
+func WithSelectionOrder(ctx context.Context, order OrderMode) context.Context {
+	return augmentContextSettings(ctx, func(c *ContextSettings) {
+		c.SelectionOrder = order
+	})
+}
+
+// MaxEntriesPerKeyFromContext identifies the most entries with the same non-empty ClaimedEntry.Key that
+// ProcessorStorage.GetClaimedEntries should return, or zero if it is unlimited. It is an optional hint, see
+// ProcessorStorage.
+func MaxEntriesPerKeyFromContext(ctx context.Context) int {
+	c := settingsFromContext(ctx)
+	if c == nil {
+		return 0
+	}
+
+	return c.MaxEntriesPerKey
+}
+
+// WithMaxEntriesPerKey creates a context which limits how many entries with the same non-empty key
+// ProcessorStorage.GetClaimedEntries returns, so that a busy key can't fill a batch and delay the other keys
+func WithMaxEntriesPerKey(ctx context.Context, maxEntriesPerKey int) context.Context {
+	return augmentContextSettings(ctx, func(c *ContextSettings) {
+		c.MaxEntriesPerKey = maxEntriesPerKey
+	})
+}
+
+// ClaimAffinityFromContext identifies how long ProcessorStorage.ClaimEntries should leave entries whose claim by
+// another processor has expired for that processor to claim again, before claiming them itself, or zero if entries
+// should be claimed as soon as their claim expires. It is an optional hint, see ProcessorStorage.
+func ClaimAffinityFromContext(ctx context.Context) time.Duration {
+	c := settingsFromContext(ctx)
+	if c == nil {
+		return 0
+	}
+
+	return c.ClaimAffinity
+}
+
+// WithClaimAffinity creates a context which asks ProcessorStorage.ClaimEntries to leave entries whose claim by
+// another processor has expired for that processor, until their claim has been expired for the grace period, so that
+// entries stay with a processor that is still active rather than bouncing between processors
+func WithClaimAffinity(ctx context.Context, grace time.Duration) context.Context {
+	return augmentContextSettings(ctx, func(c *ContextSettings) {
+		c.ClaimAffinity = grace
+	})
+}
+
+// ShardFromContext identifies the shard ProcessorStorage.ClaimEntries should restrict claimed entries to, as set by
+// WithShard, or a count of zero if entries shouldn't be restricted to a shard. It is an optional hint, see
+// ProcessorStorage.
+func ShardFromContext(ctx context.Context) (index, count int) {
+	c := settingsFromContext(ctx)
+	if c == nil {
+		return 0, 0
+	}
+
+	return c.ShardIndex, c.ShardCount
+}
+
+// WithShard creates a context which restricts ProcessorStorage.ClaimEntries to entries whose ClaimedEntry.Key
+// belongs to the shard with the index, out of count shards, as assigned by PartitionForKey
+func WithShard(ctx context.Context, index, count int) context.Context {
+	return augmentContextSettings(ctx, func(c *ContextSettings) {
+		c.ShardIndex = index
+		c.ShardCount = count
+	})
+}
+
+// DedupWithinPublishFromContext reports whether ProcessorStorage.Publish should skip messages with the same
+// ContentHash as a message earlier in the same call. It is an optional hint, see ProcessorStorage.
+func DedupWithinPublishFromContext(ctx context.Context) bool {
+	c := settingsFromContext(ctx)
+	if c == nil {
+		return false
+	}
+
+	return c.DedupWithinPublish
+}
+
+// WithDedupWithinPublish creates a context which asks ProcessorStorage.Publish to write only the first of any
+// messages with the same ContentHash in a single call, so that an event accidentally enqueued twice in one unit of
+// work is only published once
+func WithDedupWithinPublish(ctx context.Context) context.Context {
+	return augmentContextSettings(ctx, func(c *ContextSettings) {
+		c.DedupWithinPublish = true
+	})
+}
+
+// FairnessKeyFromContext identifies the function ProcessorStorage.GetClaimedEntries should group entries by, to
+// interleave the groups round-robin, as set by WithFairnessKey, or nil if entries shouldn't be interleaved. It is an
+// optional hint, see ProcessorStorage.
+func FairnessKeyFromContext(ctx context.Context) func(msg Message) string {
+	c := settingsFromContext(ctx)
+	if c == nil {
+		return nil
+	}
+
+	return c.FairnessKey
+}
+
+// WithFairnessKey creates a context which asks ProcessorStorage.GetClaimedEntries to group entries by the key the
+// function returns for their messages, e.g. a tenant, and to take entries from each group in turn, so that a group
+// with many entries can't fill a batch and delay the others
+func WithFairnessKey(ctx context.Context, fairnessKey func(msg Message) string) context.Context {
+	return augmentContextSettings(ctx, func(c *ContextSettings) {
+		c.FairnessKey = fairnessKey
 	})
 }
+
+// ValueFromContext reports the custom value set on the context by WithValue for the key, if any. Publisher
+// implementations use it to read hints recorded when the messages they are publishing were written to the outbox.
+func ValueFromContext(ctx context.Context, key string) (value string, ok bool) {
+	c := settingsFromContext(ctx)
+	if c == nil {
+		return "", false
+	}
+
+	value, ok = c.Values[key]
+	return value, ok
+}
+
+// ValuesFromContext returns a copy of every custom value set on the context by WithValue
+func ValuesFromContext(ctx context.Context) map[string]string {
+	c := settingsFromContext(ctx)
+	if c == nil || len(c.Values) == 0 {
+		return nil
+	}
+
+	values := make(map[string]string, len(c.Values))
+	for k, v := range c.Values {
+		values[k] = v
+	}
+	return values
+}
+
+// WithValue creates a context carrying a custom value, e.g. a hint for the Publisher such as a topic override. When
+// passed to Outbox.Publish the values are recorded alongside the messages, as headers prefixed with
+// ValueHeaderPrefix, and the processor sets them on the context it passes to the Publisher when publishing those
+// messages.
+func WithValue(ctx context.Context, key, value string) context.Context {
+	return augmentContextSettings(ctx, func(c *ContextSettings) {
+		values := make(map[string]string, len(c.Values)+1)
+		for k, v := range c.Values {
+			values[k] = v
+		}
+		values[key] = value
+		c.Values = values
+	})
+}
+
+// withValues creates a context carrying each of the custom values, as if set by WithValue
+func withValues(ctx context.Context, values map[string]string) context.Context {
+	for k, v := range values {
+		ctx = WithValue(ctx, k, v)
+	}
+	return ctx
+}
+
+// valuesKey canonically encodes the custom values, so that messages with equal values can be grouped together
+func valuesKey(values map[string]string) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString("\x00" + k + "=" + values[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package outbox
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+)
+
+// DeadLetterHandler receives entries that have exceeded Config.MaxAttempts, that the Publisher rejected with an
+// error wrapping ErrPermanent, or that exceeded Config.MaxEntryAge if Config.DeadLetterExpired is set, so that they
+// can be recorded somewhere for later inspection rather than being retried forever
+type DeadLetterHandler interface {
+	// DeadLetter is called with entries that will no longer be published. If it returns an error the
+	// entries are not deleted, and will be dead lettered again once they are next claimed.
+	DeadLetter(ctx context.Context, entries ...ClaimedEntry) error
+}
+
+// loggingDeadLetterHandler is the default DeadLetterHandler, which logs and discards dead lettered entries
+type loggingDeadLetterHandler struct {
+	logger logr.Logger
+}
+
+func (l *loggingDeadLetterHandler) DeadLetter(_ context.Context, entries ...ClaimedEntry) error {
+	for _, entry := range entries {
+		l.logger.Info("discarding dead lettered entry",
+			"id", entry.ID, "namespace", entry.Namespace, "attempts", entry.Attempts, "createdAt", entry.CreatedAt)
+	}
+
+	return nil
+}
@@ -0,0 +1,100 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("WakeDebounce", func() {
+	const debounce = 5 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var publisher *fake.Publisher
+	var pumped chan int
+	var ob *outbox.Outbox
+	var errChan chan error
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		pumped = make(chan int, 10)
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock: clock,
+			Storage: &fake.EntryStorage{
+				Clock: clock,
+			},
+			Publisher:       publisher,
+			ProcessInterval: time.Minute,
+			WakeDebounce:    debounce,
+			ProcessorID:     "test",
+			BatchSize:       100,
+			OnPumpComplete: func(processed int, err error) {
+				pumped <- processed
+			},
+		})
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func(ob *outbox.Outbox, ctx context.Context, errChan chan<- error) {
+			errChan <- ob.StartProcessing(ctx)
+		}(ob, ctx, errChan)
+		clock.BlockUntil(1)
+	})
+
+	AfterEach(func() {
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+	})
+
+	It("coalesces a burst of wakes into a single pump after the debounce", func() {
+		for i := 0; i < 10; i++ {
+			Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte(fmt.Sprint(i))})).To(Succeed())
+		}
+
+		// the debounce timer, alongside the process interval timer
+		clock.BlockUntil(2)
+		Consistently(pumped).ShouldNot(Receive())
+
+		clock.Advance(debounce)
+		Eventually(pumped).Should(Receive(Equal(10)))
+		Consistently(pumped).ShouldNot(Receive())
+		Expect(publisher.GetPublishedCount()).To(Equal(10))
+	})
+
+	It("starts a new debounce for wakes after the pump", func() {
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("first")})).To(Succeed())
+		clock.BlockUntil(2)
+		clock.Advance(debounce)
+		Eventually(pumped).Should(Receive(Equal(1)))
+
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("second")})).To(Succeed())
+		clock.BlockUntil(2)
+		Consistently(pumped).ShouldNot(Receive())
+
+		clock.Advance(debounce)
+		Eventually(pumped).Should(Receive(Equal(1)))
+	})
+
+	It("stops without pumping if shut down during the debounce", func() {
+		ob.WakeProcessor()
+		clock.BlockUntil(2)
+
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(pumped).NotTo(Receive())
+	})
+})
@@ -0,0 +1,96 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Dedup keys", func() {
+	const claimDuration = 5 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var failing bool
+	var attempted []string
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{}
+		failing = true
+		attempted = nil
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			// records the dedup key of every attempt, failing them all until told otherwise
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				for _, msg := range messages {
+					attempted = append(attempted, msg.DedupKey)
+				}
+				if failing {
+					return errors.New("publisher unavailable")
+				}
+				return publisher.Publish(ctx, messages...)
+			}),
+			ClaimDuration: claimDuration,
+			ProcessorID:   "test",
+		})
+		Expect(err).To(Succeed())
+	})
+
+	// publishAfterRetry fails to publish the outbox's messages once, then publishes them once they are reclaimed
+	publishAfterRetry := func() {
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).ToNot(Succeed())
+
+		failing = false
+		clock.Advance(claimDuration)
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+	}
+
+	It("keeps the provided dedup key across retries", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{DedupKey: "dedup-key"})).To(Succeed())
+
+		publishAfterRetry()
+
+		Expect(attempted).To(Equal([]string{"dedup-key", "dedup-key"}))
+		Expect(publisher.GetPublished()[0].DedupKey).To(Equal("dedup-key"))
+	})
+
+	It("defaults the dedup key to a stable identifier across retries", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{})).To(Succeed())
+
+		publishAfterRetry()
+
+		Expect(attempted).To(HaveLen(2))
+		Expect(attempted[0]).ToNot(BeEmpty())
+		Expect(attempted[1]).To(Equal(attempted[0]))
+		Expect(publisher.GetPublished()[0].DedupKey).To(Equal(attempted[0]))
+	})
+
+	It("gives every message a distinct default dedup key", func() {
+		failing = false
+		Expect(storage.Publish(ctx, nil, outbox.Message{}, outbox.Message{})).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+		Expect(attempted).To(HaveLen(2))
+		Expect(attempted[0]).ToNot(Equal(attempted[1]))
+	})
+})
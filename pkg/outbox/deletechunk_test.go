@@ -0,0 +1,111 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// chunkRecordingStorage records how many entries each call to DeleteEntries was asked to delete
+type chunkRecordingStorage struct {
+	*fake.EntryStorage
+
+	lock   sync.Mutex
+	chunks []int
+}
+
+func (c *chunkRecordingStorage) DeleteEntries(ctx context.Context, entryIDs ...string) error {
+	c.lock.Lock()
+	c.chunks = append(c.chunks, len(entryIDs))
+	c.lock.Unlock()
+
+	return c.EntryStorage.DeleteEntries(ctx, entryIDs...)
+}
+
+func (c *chunkRecordingStorage) getChunks() []int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return append([]int(nil), c.chunks...)
+}
+
+var _ = Describe("Delete chunking", func() {
+	var ctx context.Context
+	var storage *chunkRecordingStorage
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &chunkRecordingStorage{
+			EntryStorage: &fake.EntryStorage{
+				Clock: clock,
+			},
+		}
+
+		cfg = outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       &fake.Publisher{Logger: logr.Discard()},
+			ProcessorID:     "test",
+			BatchSize:       25,
+			DeleteChunkSize: 10,
+		}
+
+		for i := 0; i < 25; i++ {
+			Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte(fmt.Sprint(i))})).To(Succeed())
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("deletes the entries of a batch in chunks", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(25))
+
+		Expect(storage.getChunks()).To(Equal([]int{10, 10, 5}))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("carries on deleting the remaining chunks when one fails", func() {
+		storage.DeleteErr = fake.FailFirst(1, errors.New("connection reset"))
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("deleting entries")))
+
+		Expect(storage.getChunks()).To(Equal([]int{10, 10, 5}))
+		Expect(storage.CountEntries()).To(Equal(10))
+	})
+
+	When("the chunk size isn't configured", func() {
+		BeforeEach(func() {
+			cfg.DeleteChunkSize = 0
+		})
+
+		It("deletes batches smaller than the default chunk size at once", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(25))
+
+			Expect(storage.getChunks()).To(Equal([]int{25}))
+		})
+	})
+
+	It("doesn't delete anything when there is nothing to delete", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(25))
+		Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+
+		Expect(storage.getChunks()).To(Equal([]int{10, 10, 5}))
+	})
+})
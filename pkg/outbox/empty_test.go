@@ -0,0 +1,80 @@
+package outbox_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Empty outbox", func() {
+	var ctx context.Context
+	var storage *chunkRecordingStorage
+	var publishLock sync.Mutex
+	var publishes int
+	var cfg outbox.Config
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &chunkRecordingStorage{
+			EntryStorage: &fake.EntryStorage{
+				Clock: clock,
+			},
+		}
+		publishes = 0
+
+		cfg = outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			Publisher: &fake.Publisher{
+				Logger: logr.Discard(),
+				OnPublish: func(context.Context, ...outbox.Message) {
+					publishLock.Lock()
+					defer publishLock.Unlock()
+
+					publishes++
+				},
+			},
+			ProcessorID: "test",
+		}
+	})
+
+	DescribeTable("calls neither the publisher nor DeleteEntries",
+		func(configure func(cfg *outbox.Config)) {
+			configure(&cfg)
+			ob, err := outbox.New(cfg)
+			Expect(err).To(Succeed())
+
+			Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+			Expect(ob.ProcessOnce(ctx)).To(Equal(0))
+
+			Expect(publishes).To(BeZero())
+			Expect(storage.getChunks()).To(BeEmpty())
+		},
+		Entry("by default", func(*outbox.Config) {}),
+		Entry("publishing concurrently", func(cfg *outbox.Config) { cfg.Concurrency = 4 }),
+		Entry("ordering per key", func(cfg *outbox.Config) { cfg.Ordering = outbox.OrderingPerKey }),
+		Entry("limiting entries per key", func(cfg *outbox.Config) { cfg.MaxEntriesPerKeyPerBatch = 1 }),
+		Entry("halting on the first failure", func(cfg *outbox.Config) { cfg.FailureMode = outbox.HaltOnFirstFailure }),
+		Entry("restricted to namespaces", func(cfg *outbox.Config) { cfg.Namespaces = []string{"first", "second"} }),
+		Entry("publishing single messages", func(cfg *outbox.Config) { cfg.MessagePublishTimeout = time.Second }),
+	)
+
+	It("stops draining once there is nothing to process", func() {
+		ob, err := outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		Expect(ob.FlushNamespace(ctx, "orders")).To(Equal(0))
+		Expect(publishes).To(BeZero())
+		Expect(storage.getChunks()).To(BeEmpty())
+	})
+})
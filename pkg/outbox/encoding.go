@@ -0,0 +1,159 @@
+package outbox
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/multierr"
+)
+
+// encodeMessages records the custom values in headers prefixed with ValueHeaderPrefix, then compresses the payloads
+// of the messages with the Config.Compressor and encrypts them with the Config.Encryptor, marking each message with
+// the CompressionHeader or EncryptionHeader as appropriate. The provided messages are left unmodified.
+func (o *Outbox) encodeMessages(values map[string]string, messages []Message) ([]Message, error) {
+	if o.config.Compressor == nil && o.config.Encryptor == nil && len(values) == 0 {
+		return messages, nil
+	}
+
+	encoded := make([]Message, len(messages))
+	for idx, msg := range messages {
+		for k, v := range values {
+			msg.Headers = withHeader(msg.Headers, ValueHeaderPrefix+k, []byte(v))
+		}
+
+		if o.config.Compressor != nil && len(msg.Payload) > 0 && len(msg.Payload) >= o.config.CompressionThreshold {
+			payload, err := o.config.Compressor.Compress(msg.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("error compressing payload of message %d: %w", idx, err)
+			}
+
+			msg.Payload = payload
+			msg.Headers = withHeader(msg.Headers, CompressionHeader, []byte("true"))
+		}
+
+		if o.config.Encryptor != nil {
+			payload, err := o.config.Encryptor.Encrypt(msg.Payload)
+			if err != nil {
+				return nil, fmt.Errorf("error encrypting payload of message %d: %w", idx, err)
+			}
+
+			msg.Payload = payload
+			msg.Headers = withHeader(msg.Headers, EncryptionHeader, []byte("true"))
+		}
+
+		encoded[idx] = msg
+	}
+
+	return encoded, nil
+}
+
+// decodeEntries decodes each of the entries with decodeEntry, returning those that were decoded along with an
+// error describing any that could not be, which are left to be retried
+func (o *Outbox) decodeEntries(entries []ClaimedEntry) ([]ClaimedEntry, error) {
+	decoded := make([]ClaimedEntry, 0, len(entries))
+	var errs []error
+	for _, entry := range entries {
+		entry, err := o.decodeEntry(entry)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		decoded = append(decoded, entry)
+	}
+
+	return decoded, multierr.Combine(errs...)
+}
+
+// decodeEntry restores the payload of an entry encoded by encodeMessages, removing the EncryptionHeader and
+// CompressionHeader so that they aren't published
+func (o *Outbox) decodeEntry(entry ClaimedEntry) (ClaimedEntry, error) {
+	if _, ok := entry.Headers[EncryptionHeader]; ok {
+		if o.config.Encryptor == nil {
+			return entry, fmt.Errorf("entry %s is encrypted, but no encryptor is configured", entry.ID)
+		}
+
+		payload, err := o.config.Encryptor.Decrypt(entry.Payload)
+		if err != nil {
+			return entry, fmt.Errorf("error decrypting payload of entry %s: %w", entry.ID, err)
+		}
+
+		entry.Payload = payload
+		entry.Headers = withoutHeader(entry.Headers, EncryptionHeader)
+	}
+
+	if _, ok := entry.Headers[CompressionHeader]; !ok {
+		return entry, nil
+	}
+
+	if o.config.Compressor == nil {
+		return entry, fmt.Errorf("entry %s is compressed, but no compressor is configured", entry.ID)
+	}
+
+	payload, err := o.config.Compressor.Decompress(entry.Payload)
+	if err != nil {
+		return entry, fmt.Errorf("error decompressing payload of entry %s: %w", entry.ID, err)
+	}
+
+	entry.Payload = payload
+	entry.Headers = withoutHeader(entry.Headers, CompressionHeader)
+	return entry, nil
+}
+
+// withHeader returns a copy of the headers with the header added, leaving the provided headers unmodified
+func withHeader(headers map[string][]byte, key string, value []byte) map[string][]byte {
+	copied := make(map[string][]byte, len(headers)+1)
+	for k, v := range headers {
+		copied[k] = v
+	}
+	copied[key] = value
+
+	return copied
+}
+
+// withoutHeader returns a copy of the headers without the header, or nil if no headers remain, leaving the
+// provided headers unmodified
+func withoutHeader(headers map[string][]byte, key string) map[string][]byte {
+	copied := make(map[string][]byte, len(headers))
+	for k, v := range headers {
+		if k != key {
+			copied[k] = v
+		}
+	}
+
+	if len(copied) == 0 {
+		return nil
+	}
+	return copied
+}
+
+// splitValues separates the headers recording custom values, added by encodeMessages, from the rest of the headers.
+// The provided headers are returned as they are if none record values.
+func splitValues(headers map[string][]byte) (values map[string]string, rest map[string][]byte) {
+	for k, v := range headers {
+		if !strings.HasPrefix(k, ValueHeaderPrefix) {
+			continue
+		}
+
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[strings.TrimPrefix(k, ValueHeaderPrefix)] = string(v)
+	}
+
+	if values == nil {
+		return nil, headers
+	}
+
+	rest = make(map[string][]byte, len(headers)-len(values))
+	for k, v := range headers {
+		if !strings.HasPrefix(k, ValueHeaderPrefix) {
+			rest[k] = v
+		}
+	}
+	if len(rest) == 0 {
+		rest = nil
+	}
+
+	return values, rest
+}
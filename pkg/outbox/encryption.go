@@ -0,0 +1,13 @@
+package outbox
+
+// EncryptionHeader marks the entries whose Message.Payload was encrypted by the Config.Encryptor, so that entries
+// written without encryption can still be published. The processor removes it before publishing.
+const EncryptionHeader = "outboxen-encrypted"
+
+// Encryptor encrypts message payloads while they are stored in the outbox, e.g. to protect personal data
+type Encryptor interface {
+	// Encrypt encrypts a payload before it is written to the ProcessorStorage
+	Encrypt(plaintext []byte) ([]byte, error)
+	// Decrypt restores a payload encrypted by Encrypt before it is published
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
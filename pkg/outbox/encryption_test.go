@@ -0,0 +1,117 @@
+package outbox_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/compress/gzip"
+	"github.com/omaskery/outboxen/pkg/encrypt/aesgcm"
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Encryption", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	key := bytes.Repeat([]byte{1}, 32)
+	payload := []byte("personal data")
+
+	newEncryptor := func(key []byte) *aesgcm.Encryptor {
+		encryptor, err := aesgcm.New(aesgcm.Config{Keys: map[string][]byte{"key": key}, KeyID: "key"})
+		Expect(err).To(Succeed())
+		return encryptor
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{}
+
+		cfg = outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			Encryptor:     newEncryptor(key),
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("stores payloads encrypted", func() {
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: payload})).To(Succeed())
+
+		Expect(storage.ClaimEntries(ctx, "inspector", clock.Now().Add(time.Second))).To(Succeed())
+		entries, err := storage.GetClaimedEntries(ctx, "inspector", 10)
+		Expect(err).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Payload).ToNot(ContainSubstring(string(payload)))
+		Expect(entries[0].Headers).To(HaveKey(outbox.EncryptionHeader))
+	})
+
+	It("publishes the decrypted payload", func() {
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: payload})).To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+		published := publisher.GetPublished()
+		Expect(published).To(HaveLen(1))
+		Expect(published[0].Payload).To(Equal(payload))
+		Expect(published[0].Headers).To(BeNil())
+	})
+
+	When("payloads are also compressed", func() {
+		BeforeEach(func() {
+			compressor, err := gzip.New(gzip.Config{})
+			Expect(err).To(Succeed())
+			cfg.Compressor = compressor
+		})
+
+		It("publishes the original payload", func() {
+			large := bytes.Repeat(payload, 100)
+			Expect(ob.Publish(ctx, nil, outbox.Message{Payload: large})).To(Succeed())
+			Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+			published := publisher.GetPublished()
+			Expect(published).To(HaveLen(1))
+			Expect(published[0].Payload).To(Equal(large))
+			Expect(published[0].Headers).To(BeNil())
+		})
+	})
+
+	When("the processor has the wrong key", func() {
+		JustBeforeEach(func() {
+			Expect(ob.Publish(ctx, nil, outbox.Message{Payload: payload})).To(Succeed())
+
+			cfg.Encryptor = newEncryptor(bytes.Repeat([]byte{2}, 32))
+			var err error
+			ob, err = outbox.New(cfg)
+			Expect(err).To(Succeed())
+		})
+
+		It("fails to publish the entry, leaving it to be retried", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(MatchError(ContainSubstring("error decrypting payload")))
+
+			Expect(publisher.GetPublishedCount()).To(BeZero())
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,65 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("EnqueuedAt", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("carries when each message was written through to the publisher", func() {
+		first := clock.Now()
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("first")})).To(Succeed())
+		clock.Advance(time.Minute)
+		second := clock.Now()
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("second")})).To(Succeed())
+		clock.Advance(time.Minute)
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+		Expect(publisher.GetPublished()).To(ConsistOf(
+			HaveField("Message", And(HaveField("Payload", []byte("first")), HaveField("EnqueuedAt", first))),
+			HaveField("Message", And(HaveField("Payload", []byte("second")), HaveField("EnqueuedAt", second))),
+		))
+	})
+
+	It("is ignored when writing messages", func() {
+		written := clock.Now()
+		Expect(ob.Publish(ctx, nil, outbox.Message{EnqueuedAt: written.Add(-time.Hour)})).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		Expect(publisher.GetPublished()).To(ConsistOf(HaveField("Message.EnqueuedAt", written)))
+	})
+})
@@ -0,0 +1,103 @@
+package outbox
+
+import (
+	"errors"
+)
+
+// ErrPermanent marks errors that retrying cannot fix, such as a missing table or column, or a message a broker will
+// never accept. ProcessorStorage and Publisher implementations return errors wrapping it, e.g. with Permanent, so
+// that the processor stops retrying them.
+var ErrPermanent = errors.New("permanent error")
+
+// ErrNoStorage is returned by Config.DefaultAndValidate, and wrapped by New, if no Config.Storage is provided
+var ErrNoStorage = errors.New("no storage provided")
+
+// ErrNoPublisher is returned by Config.DefaultAndValidate, and wrapped by New, if no Config.Publisher is provided
+var ErrNoPublisher = errors.New("no publisher provided")
+
+// ErrNoProcessorID is returned by Config.DefaultAndValidate, and wrapped by New, if no Config.ProcessorID is provided
+var ErrNoProcessorID = errors.New("no processor ID provided")
+
+// ErrAlreadyProcessing is returned by StartProcessing, and StartProcessingWithTrigger, if the Outbox is already
+// processing, as only one processor may run on each Outbox at a time
+var ErrAlreadyProcessing = errors.New("outbox is already processing")
+
+// ErrShutdownGraceExceeded is returned by StartProcessing if a pump in progress when its context was cancelled didn't
+// return within the Config.ShutdownGrace
+var ErrShutdownGraceExceeded = errors.New("pump in progress did not stop within the shutdown grace")
+
+// ErrReplayUnsupported is returned by Replay if the ProcessorStorage doesn't implement EntryRepublisher
+var ErrReplayUnsupported = errors.New("storage does not support republishing entries")
+
+// ErrPeekUnsupported is returned by Peek if the ProcessorStorage doesn't implement EntryPeeker
+var ErrPeekUnsupported = errors.New("storage does not support peeking at entries")
+
+// ErrCountPendingUnsupported is returned by BacklogSize if the ProcessorStorage doesn't implement PendingCounter
+var ErrCountPendingUnsupported = errors.New("storage does not support counting pending entries")
+
+// Permanent wraps err so that it matches ErrPermanent with errors.Is, while still wrapping err itself. It returns nil
+// if err is nil.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &permanentError{err: err}
+}
+
+// permanentError is an error that matches ErrPermanent, as well as the error it wraps
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string {
+	return p.err.Error()
+}
+
+func (p *permanentError) Unwrap() error {
+	return p.err
+}
+
+func (p *permanentError) Is(target error) bool {
+	return target == ErrPermanent
+}
+
+// StorageError is returned, wrapped, by the processor when ProcessorStorage fails, so that storage failures can be
+// told apart from publishing failures, and transient failures from permanent ones
+type StorageError struct {
+	// Op describes the operation that failed, e.g. "claiming entries"
+	Op string
+	// Err is the error returned by the ProcessorStorage
+	Err error
+}
+
+// Error implements the error interface
+func (s *StorageError) Error() string {
+	return "error " + s.Op + ": " + s.Err.Error()
+}
+
+// Unwrap provides the error returned by the ProcessorStorage
+func (s *StorageError) Unwrap() error {
+	return s.Err
+}
+
+// Temporary reports whether retrying the operation may succeed, which it may unless the ProcessorStorage returned an
+// error wrapping ErrPermanent
+func (s *StorageError) Temporary() bool {
+	return !errors.Is(s.Err, ErrPermanent)
+}
+
+// storageError wraps a non-nil error returned by the ProcessorStorage while performing op in a StorageError
+func storageError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &StorageError{Op: op, Err: err}
+}
+
+// permanentStorageFailure reports whether err wraps a StorageError that retrying cannot fix
+func permanentStorageFailure(err error) bool {
+	var storageErr *StorageError
+	return errors.As(err, &storageErr) && !storageErr.Temporary()
+}
@@ -0,0 +1,190 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// failingStorage fails to claim entries with claimErr, counting each attempt
+type failingStorage struct {
+	*fake.EntryStorage
+	claimErr error
+
+	lock     sync.Mutex
+	attempts int
+}
+
+func (f *failingStorage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.attempts++
+	return f.claimErr
+}
+
+func (f *failingStorage) getAttempts() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.attempts
+}
+
+var _ = Describe("Errors", func() {
+	Describe("Permanent", func() {
+		It("matches both ErrPermanent and the wrapped error", func() {
+			cause := errors.New("no such table")
+			err := outbox.Permanent(cause)
+
+			Expect(err).To(MatchError(outbox.ErrPermanent))
+			Expect(err).To(MatchError(cause))
+			Expect(err.Error()).To(Equal("no such table"))
+		})
+
+		It("returns nil for a nil error", func() {
+			Expect(outbox.Permanent(nil)).To(BeNil())
+		})
+	})
+
+	Describe("StorageError", func() {
+		It("is temporary unless the storage error is permanent", func() {
+			Expect((&outbox.StorageError{Op: "claiming entries", Err: errors.New("connection reset")}).Temporary()).To(BeTrue())
+			Expect((&outbox.StorageError{Op: "claiming entries", Err: outbox.Permanent(errors.New("no such table"))}).Temporary()).To(BeFalse())
+		})
+	})
+
+	Describe("processing", func() {
+		const retryInterval = 3 * time.Second
+
+		var ctx context.Context
+		var clock clockwork.FakeClock
+		var storage *failingStorage
+		var cfg outbox.Config
+		var ob *outbox.Outbox
+		var errChan chan error
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			clock = clockwork.NewFakeClock()
+			storage = &failingStorage{
+				EntryStorage: &fake.EntryStorage{
+					Clock: clock,
+				},
+			}
+
+			cfg = outbox.Config{
+				Clock:           clock,
+				Storage:         storage,
+				Publisher:       &fake.Publisher{},
+				ProcessInterval: time.Minute,
+				ProcessorID:     "test",
+				BackoffFactory: func() backoff.BackOff {
+					return backoff.NewConstantBackOff(retryInterval)
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			var err error
+			ob, err = outbox.New(cfg)
+			Expect(err).To(Succeed())
+		})
+
+		It("reports storage failures as a StorageError", func() {
+			storage.claimErr = errors.New("connection reset")
+
+			_, err := ob.PumpOutbox(ctx)
+
+			var storageErr *outbox.StorageError
+			Expect(errors.As(err, &storageErr)).To(BeTrue())
+			Expect(storageErr.Op).To(Equal("claiming entries"))
+			Expect(storageErr.Temporary()).To(BeTrue())
+		})
+
+		When("processing continuously", func() {
+			JustBeforeEach(func() {
+				errChan = make(chan error, 1)
+				go func() {
+					errChan <- ob.StartProcessing(ctx)
+				}()
+				clock.BlockUntil(1)
+			})
+
+			When("the storage fails transiently", func() {
+				BeforeEach(func() {
+					storage.claimErr = errors.New("connection reset")
+				})
+
+				AfterEach(func() {
+					Expect(ob.Shutdown(ctx)).To(Succeed())
+					Expect(errChan).To(Receive(BeNil()))
+				})
+
+				It("retries", func() {
+					ob.WakeProcessor()
+					Eventually(storage.getAttempts).Should(Equal(1))
+
+					clock.BlockUntil(2)
+					clock.Advance(retryInterval)
+					Eventually(storage.getAttempts).Should(Equal(2))
+					Consistently(errChan).ShouldNot(Receive())
+				})
+			})
+
+			When("the storage fails permanently", func() {
+				BeforeEach(func() {
+					storage.claimErr = outbox.Permanent(errors.New("no such table"))
+				})
+
+				It("returns the error from StartProcessing without retrying", func() {
+					ob.WakeProcessor()
+
+					var err error
+					Eventually(errChan).Should(Receive(&err))
+					Expect(err).To(MatchError(outbox.ErrPermanent))
+					Expect(err).To(MatchError(ContainSubstring("no such table")))
+					Expect(storage.getAttempts()).To(Equal(1))
+				})
+
+				When("an OnFatalError callback is provided", func() {
+					var fatalErrors chan error
+
+					BeforeEach(func() {
+						fatalErrors = make(chan error, 10)
+						cfg.OnFatalError = func(err error) {
+							fatalErrors <- err
+						}
+					})
+
+					AfterEach(func() {
+						Expect(ob.Shutdown(ctx)).To(Succeed())
+						Expect(errChan).To(Receive(BeNil()))
+					})
+
+					It("reports the error without retrying and keeps processing", func() {
+						ob.WakeProcessor()
+
+						var err error
+						Eventually(fatalErrors).Should(Receive(&err))
+						Expect(err).To(MatchError(outbox.ErrPermanent))
+						Expect(storage.getAttempts()).To(Equal(1))
+						Consistently(errChan).ShouldNot(Receive())
+
+						ob.WakeProcessor()
+						Eventually(fatalErrors).Should(Receive())
+						Expect(storage.getAttempts()).To(Equal(2))
+					})
+				})
+			})
+		})
+	})
+})
@@ -0,0 +1,52 @@
+package outbox
+
+import (
+	"time"
+)
+
+// EventSink receives an event for each step the processor takes with each message, e.g. to build an audit log. It is
+// more granular than Metrics, and is called synchronously by the processor, so implementations should be quick.
+type EventSink interface {
+	// MessageClaimed is called for each entry the processor retrieves to publish
+	MessageClaimed(event MessageEvent)
+	// MessagePublished is called for each message the Publisher published successfully
+	MessagePublished(event MessageEvent)
+	// MessageFailed is called for each message the Publisher failed to publish, with the error it failed with
+	MessageFailed(event MessageEvent, err error)
+	// MessageDeleted is called for each entry deleted from the ProcessorStorage, having been published, dead
+	// lettered or expired
+	MessageDeleted(event MessageEvent)
+}
+
+// MessageEvent identifies the message an EventSink is notified about, and when the event happened
+type MessageEvent struct {
+	// EntryID is the ClaimedEntry.ID of the message's entry
+	EntryID string
+	// Namespace is the ClaimedEntry.Namespace of the message's entry
+	Namespace string
+	// Key is the Message.Key
+	Key []byte
+	// At is when the event happened, according to the Config.Clock
+	At time.Time
+}
+
+// newMessageEvent describes an event that happened to the entry at the given time
+func newMessageEvent(entry ClaimedEntry, at time.Time) MessageEvent {
+	return MessageEvent{
+		EntryID:   entry.ID,
+		Namespace: entry.Namespace,
+		Key:       entry.Key,
+		At:        at,
+	}
+}
+
+// noopEventSink is the default EventSink implementation, which discards all events
+type noopEventSink struct{}
+
+func (noopEventSink) MessageClaimed(MessageEvent) {}
+
+func (noopEventSink) MessagePublished(MessageEvent) {}
+
+func (noopEventSink) MessageFailed(MessageEvent, error) {}
+
+func (noopEventSink) MessageDeleted(MessageEvent) {}
@@ -0,0 +1,78 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("EventSink", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var events *fake.EventSink
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+			IDGenerator: func(msg outbox.Message) string {
+				return string(msg.Payload)
+			},
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+			FailFunc: func(msg outbox.Message) error {
+				if string(msg.Payload) == "bad" {
+					return errors.New("broker unavailable")
+				}
+				return nil
+			},
+		}
+		events = &fake.EventSink{}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			EventSink:   events,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("reports a published message being claimed, published and then deleted", func() {
+		Expect(ob.Publish(ctx, nil, outbox.Message{Key: []byte("key"), Payload: []byte("good")})).To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+		Expect(events.GetEventTypes("good")).To(Equal([]fake.EventType{
+			fake.EventClaimed, fake.EventPublished, fake.EventDeleted,
+		}))
+		for _, event := range events.GetEvents() {
+			Expect(event.Key).To(Equal([]byte("key")))
+			Expect(event.At).To(Equal(clock.Now()))
+			Expect(event.Err).To(BeNil())
+		}
+	})
+
+	It("reports a failed message being claimed and failing, without being deleted", func() {
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("bad")})).To(Succeed())
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(HaveOccurred())
+
+		Expect(events.GetEventTypes("bad")).To(Equal([]fake.EventType{fake.EventClaimed, fake.EventFailed}))
+		Expect(events.GetEvents()[1].Err).To(MatchError("broker unavailable"))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+})
@@ -0,0 +1,126 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Expiry", func() {
+	const maxEntryAge = time.Minute
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var metrics *fake.Metrics
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		metrics = &fake.Metrics{}
+
+		cfg = outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			Metrics:       metrics,
+			MaxEntryAge:   maxEntryAge,
+		}
+
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("stale")})).To(Succeed())
+		clock.Advance(maxEntryAge / 2)
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("fresh")})).To(Succeed())
+		clock.Advance(maxEntryAge / 2)
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("publishes entries that are exactly the max entry age", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+		Expect(publishedPayloads(publisher)).To(Equal([]string{"stale", "fresh"}))
+		Expect(metrics.GetExpiredCount()).To(BeZero())
+	})
+
+	When("an entry is older than the max entry age", func() {
+		BeforeEach(func() {
+			clock.Advance(time.Second)
+		})
+
+		It("deletes only the stale entry, without publishing it", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+			Expect(publishedPayloads(publisher)).To(Equal([]string{"fresh"}))
+			Expect(storage.CountEntries()).To(Equal(0))
+			Expect(metrics.GetExpiredCount()).To(Equal(1))
+			Expect(metrics.GetPublishedCount()).To(Equal(1))
+		})
+
+		When("no max entry age is configured", func() {
+			BeforeEach(func() {
+				cfg.MaxEntryAge = 0
+			})
+
+			It("publishes every entry", func() {
+				Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+				Expect(publishedPayloads(publisher)).To(Equal([]string{"stale", "fresh"}))
+				Expect(metrics.GetExpiredCount()).To(BeZero())
+			})
+		})
+
+		When("expired entries are dead lettered", func() {
+			var deadLetters *fake.DeadLetterHandler
+
+			BeforeEach(func() {
+				deadLetters = &fake.DeadLetterHandler{}
+				cfg.DeadLetterHandler = deadLetters
+				cfg.DeadLetterExpired = true
+			})
+
+			It("passes the stale entry to the dead letter handler before deleting it", func() {
+				Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+				deadLettered := deadLetters.GetDeadLettered()
+				Expect(deadLettered).To(HaveLen(1))
+				Expect(deadLettered[0].Payload).To(Equal([]byte("stale")))
+				Expect(publishedPayloads(publisher)).To(Equal([]string{"fresh"}))
+				Expect(storage.CountEntries()).To(Equal(0))
+				Expect(metrics.GetExpiredCount()).To(Equal(1))
+			})
+
+			It("keeps the stale entry if dead lettering fails", func() {
+				deadLetters.Err = errors.New("dead letter queue unavailable")
+
+				_, err := ob.PumpOutbox(ctx)
+				Expect(err).To(MatchError(ContainSubstring("dead letter queue unavailable")))
+
+				Expect(publishedPayloads(publisher)).To(Equal([]string{"fresh"}))
+				Expect(storage.CountEntries()).To(Equal(1))
+			})
+		})
+	})
+})
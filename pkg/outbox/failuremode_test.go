@@ -0,0 +1,116 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Failure modes", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var failing bool
+	var attempted []string
+	var published []string
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		failing = true
+		attempted = nil
+		published = nil
+
+		cfg = outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			// fails the "second" message until told otherwise
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				publishErr := &outbox.PublishError{Errors: make([]error, len(messages))}
+				for idx, msg := range messages {
+					attempted = append(attempted, string(msg.Payload))
+					if failing && string(msg.Payload) == "second" {
+						publishErr.Errors[idx] = errors.New("publisher unavailable")
+						continue
+					}
+					published = append(published, string(msg.Payload))
+				}
+
+				if publishErr.ErrorCount() > 0 {
+					return publishErr
+				}
+				return nil
+			}),
+			ProcessorID: "test",
+		}
+
+		Expect(storage.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("first")},
+			outbox.Message{Payload: []byte("second")},
+			outbox.Message{Payload: []byte("third")},
+		)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("continues publishing the batch after a failure by default", func() {
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).ToNot(Succeed())
+
+		Expect(published).To(Equal([]string{"first", "third"}))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+
+	When("halting on the first failure", func() {
+		BeforeEach(func() {
+			cfg.FailureMode = outbox.HaltOnFirstFailure
+		})
+
+		It("does not publish anything after the failed message", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(MatchError(ContainSubstring("failed to publish 1/1 messages")))
+
+			Expect(attempted).To(Equal([]string{"first", "second"}))
+			Expect(published).To(Equal([]string{"first"}))
+			Expect(storage.CountEntries()).To(Equal(2))
+		})
+
+		It("publishes the remaining entries in order on the next pump", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).ToNot(Succeed())
+
+			failing = false
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			Expect(published).To(Equal([]string{"first", "second", "third"}))
+			Expect(storage.CountEntries()).To(BeZero())
+		})
+
+		It("stops processing later batches", func() {
+			cfg.BatchSize = 1
+
+			unbatched, err := outbox.New(cfg)
+			Expect(err).To(Succeed())
+
+			_, err = unbatched.PumpOutbox(ctx)
+			Expect(err).ToNot(Succeed())
+
+			Expect(attempted).To(Equal([]string{"first", "second"}))
+			Expect(storage.CountEntries()).To(Equal(2))
+		})
+	})
+})
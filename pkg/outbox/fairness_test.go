@@ -0,0 +1,199 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Fairness", func() {
+	const hotEntries = 10
+	const batchSize = 4
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	publishedKeys := func() []string {
+		var keys []string
+		for _, msg := range publisher.GetPublished() {
+			keys = append(keys, string(msg.Key))
+		}
+		return keys
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		cfg = outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			BatchSize:     batchSize,
+		}
+
+		for i := 0; i < hotEntries; i++ {
+			Expect(storage.Publish(ctx, nil, outbox.Message{
+				Key:     []byte("hot"),
+				Payload: []byte(fmt.Sprintf("hot-%d", i)),
+			})).To(Succeed())
+			clock.Advance(time.Second)
+		}
+		for _, key := range []string{"cold-a", "cold-b", "cold-c"} {
+			Expect(storage.Publish(ctx, nil, outbox.Message{Key: []byte(key)})).To(Succeed())
+			clock.Advance(time.Second)
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("fills early batches with the hot key when entries per key are unlimited", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(hotEntries + 3))
+
+		Expect(publishedKeys()[:batchSize]).To(Equal([]string{"hot", "hot", "hot", "hot"}))
+	})
+
+	When("entries per key are limited", func() {
+		BeforeEach(func() {
+			cfg.MaxEntriesPerKeyPerBatch = 2
+		})
+
+		It("represents the cold keys in the first batches", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(hotEntries + 3))
+
+			keys := publishedKeys()
+			Expect(keys[:batchSize]).To(Equal([]string{"hot", "hot", "cold-a", "cold-b"}))
+			Expect(keys[batchSize : batchSize+3]).To(Equal([]string{"hot", "hot", "cold-c"}))
+		})
+
+		It("publishes each key's entries in the order they were written", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(hotEntries + 3))
+
+			var hot []string
+			for _, msg := range publisher.GetPublished() {
+				if string(msg.Key) == "hot" {
+					hot = append(hot, string(msg.Payload))
+				}
+			}
+			Expect(hot).To(HaveLen(hotEntries))
+			for i, payload := range hot {
+				Expect(payload).To(Equal(fmt.Sprintf("hot-%d", i)))
+			}
+		})
+
+		It("does not limit entries without a key", func() {
+			for i := 0; i < batchSize; i++ {
+				Expect(storage.Publish(ctx, nil, outbox.Message{})).To(Succeed())
+			}
+			Expect(storage.ClaimEntries(ctx, "test", clock.Now().Add(time.Minute))).To(Succeed())
+
+			entries, err := storage.GetClaimedEntries(outbox.WithMaxEntriesPerKey(ctx, 1), "test", 100)
+			Expect(err).To(Succeed())
+			Expect(entries).To(HaveLen(batchSize + 4))
+		})
+	})
+})
+
+var _ = Describe("Fairness key", func() {
+	const batchSize = 3
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var batches [][]string
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		batches = nil
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			ProcessorID: "test",
+			BatchSize:   batchSize,
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				var tenants []string
+				for _, msg := range messages {
+					tenants = append(tenants, string(msg.Headers["tenant"]))
+				}
+				batches = append(batches, tenants)
+				return publisher.Publish(ctx, messages...)
+			}),
+			FairnessKey: func(msg outbox.Message) string {
+				return string(msg.Headers["tenant"])
+			},
+		})
+		Expect(err).To(Succeed())
+
+		counts := map[string]int{"a": 8, "b": 4, "c": 2}
+		for _, tenant := range []string{"a", "b", "c"} {
+			for i := 0; i < counts[tenant]; i++ {
+				Expect(storage.Publish(ctx, nil, outbox.NewMessage(
+					[]byte(fmt.Sprintf("%s-%d", tenant, i)),
+					outbox.WithHeader("tenant", []byte(tenant)),
+				))).To(Succeed())
+				clock.Advance(time.Second)
+			}
+		}
+	})
+
+	It("shares each batch between the tenants", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(14))
+
+		Expect(batches).To(HaveLen(5))
+		Expect(batches[0]).To(ConsistOf("a", "b", "c"))
+		Expect(batches[1]).To(ConsistOf("a", "b", "c"))
+		Expect(batches[2]).To(ConsistOf("a", "a", "b"))
+		Expect(batches[3]).To(ConsistOf("a", "a", "b"))
+		Expect(batches[4]).To(ConsistOf("a", "a"))
+	})
+
+	It("publishes each tenant's entries in the order they were written", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(14))
+
+		byTenant := map[string][]string{}
+		for _, msg := range publisher.GetPublished() {
+			tenant := string(msg.Headers["tenant"])
+			byTenant[tenant] = append(byTenant[tenant], string(msg.Payload))
+		}
+		for tenant, payloads := range byTenant {
+			for i, payload := range payloads {
+				Expect(payload).To(Equal(fmt.Sprintf("%s-%d", tenant, i)))
+			}
+		}
+	})
+})
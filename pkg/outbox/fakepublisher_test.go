@@ -0,0 +1,195 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Fake publisher", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var ob *outbox.Outbox
+
+	// failSecond fails the message with the "#2" payload
+	failSecond := func(msg outbox.Message) error {
+		if string(msg.Payload) == "#2" {
+			return errors.New("broker unavailable")
+		}
+		return nil
+	}
+
+	messages := []outbox.Message{
+		{Payload: []byte("#1")},
+		{Payload: []byte("#2")},
+		{Payload: []byte("#3")},
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:                clock,
+			Storage:              storage,
+			Publisher:            publisher,
+			ClaimDuration:        5 * time.Second,
+			ClaimRenewalInterval: time.Second,
+			ProcessorID:          "test",
+		})
+		Expect(err).To(Succeed())
+
+		for _, msg := range messages {
+			Expect(storage.Publish(ctx, nil, msg)).To(Succeed())
+			clock.Advance(time.Second)
+		}
+	})
+
+	It("publishes every message by default", func() {
+		Expect(publisher.Publish(ctx, messages...)).To(Succeed())
+		Expect(publisher.GetPublishedCount()).To(Equal(3))
+	})
+
+	It("returns a PublishError describing the messages FailFunc failed", func() {
+		publisher.FailFunc = failSecond
+
+		err := publisher.Publish(ctx, messages...)
+
+		var publishErr *outbox.PublishError
+		Expect(errors.As(err, &publishErr)).To(BeTrue())
+		Expect(publishErr.Errors).To(HaveLen(3))
+		Expect(publishErr.FailedIndices()).To(Equal([]int{1}))
+		Expect(publishErr.Errors[1]).To(MatchError("broker unavailable"))
+	})
+
+	It("only records the messages that didn't fail as published", func() {
+		publisher.FailFunc = failSecond
+
+		Expect(publisher.Publish(ctx, messages...)).NotTo(Succeed())
+
+		Expect(publishedPayloads(publisher)).To(Equal([]string{"#1", "#3"}))
+	})
+
+	It("leaves the failed message in storage while deleting the others", func() {
+		publisher.FailFunc = failSecond
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("failed to publish")))
+
+		entries, err := storage.GetClaimedEntries(ctx, "test", 10)
+		Expect(err).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(string(entries[0].Payload)).To(Equal("#2"))
+	})
+
+	It("publishes the failed message once FailFunc stops failing it", func() {
+		publisher.FailFunc = failSecond
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).NotTo(Succeed())
+
+		publisher.FailFunc = nil
+		clock.Advance(5 * time.Second)
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+		Expect(publisher.GetPublishedCount()).To(Equal(3))
+	})
+
+	When("publishing is delayed", func() {
+		const delay = 10 * time.Second
+
+		BeforeEach(func() {
+			publisher.Delay = delay
+			publisher.Clock = clock
+		})
+
+		It("blocks until the delay has elapsed before publishing", func() {
+			publishErr := make(chan error, 1)
+			go func() {
+				publishErr <- publisher.Publish(ctx, messages...)
+			}()
+
+			clock.BlockUntil(1)
+			Expect(publisher.GetPublishedCount()).To(Equal(0))
+
+			clock.Advance(delay)
+			Eventually(publishErr).Should(Receive(BeNil()))
+			Expect(publisher.GetPublishedCount()).To(Equal(3))
+		})
+
+		It("stops waiting and publishes nothing if the context is cancelled mid-publish", func() {
+			publishCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			publishErr := make(chan error, 1)
+			go func() {
+				publishErr <- publisher.Publish(publishCtx, messages...)
+			}()
+
+			clock.BlockUntil(1)
+			cancel()
+
+			Eventually(publishErr).Should(Receive(MatchError(context.Canceled)))
+			Expect(publisher.GetPublishedCount()).To(Equal(0))
+		})
+
+		It("leaves the entries in storage if the pump is cancelled mid-publish", func() {
+			pumpCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+
+			pumpErr := make(chan error, 1)
+			go func() {
+				_, err := ob.PumpOutbox(pumpCtx)
+				pumpErr <- err
+			}()
+
+			// the publisher's delay and the claim renewal timers
+			clock.BlockUntil(2)
+			cancel()
+
+			Eventually(pumpErr).Should(Receive(MatchError(context.Canceled)))
+			Expect(publisher.GetPublishedCount()).To(Equal(0))
+			Expect(storage.CountEntries()).To(Equal(3))
+		})
+
+		It("keeps the entries claimed while the delay outlasts the claim duration", func() {
+			pumpErr := make(chan error, 1)
+			go func() {
+				_, err := ob.PumpOutbox(ctx)
+				pumpErr <- err
+			}()
+
+			for elapsed := time.Second; elapsed < delay; elapsed += time.Second {
+				clock.BlockUntil(2)
+				clock.Advance(time.Second)
+			}
+
+			Expect(storage.ClaimEntries(ctx, "other", clock.Now().Add(5*time.Second))).To(Succeed())
+			Expect(storage.GetClaimedEntries(ctx, "other", 10)).To(BeEmpty())
+
+			clock.BlockUntil(2)
+			clock.Advance(time.Second)
+			Eventually(pumpErr).Should(Receive(BeNil()))
+			Expect(publisher.GetPublishedCount()).To(Equal(3))
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+	})
+})
@@ -0,0 +1,43 @@
+package outbox_test
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Fakes", func() {
+	It("are usable as the Outbox's storage and publisher", func() {
+		ctx := context.Background()
+		clock := clockwork.NewFakeClock()
+
+		var storage outbox.ProcessorStorage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		var publisher outbox.Publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		ob, err := outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		txn := storage.(*fake.EntryStorage).Begin()
+		Expect(ob.Publish(ctx, txn, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+		Expect(txn.Commit()).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		Expect(publisher.(*fake.Publisher).GetPublished()).To(ConsistOf(HaveField("Message.Payload", []byte("hello"))))
+		Expect(storage.(*fake.EntryStorage).CountEntries()).To(Equal(0))
+	})
+})
@@ -0,0 +1,159 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Fatal errors", func() {
+	const retryInterval = 3 * time.Second
+	const maxElapsed = 5 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var attemptsLock sync.Mutex
+	var attempts int
+	var failedPumps chan struct{}
+	var ob *outbox.Outbox
+	var errChan chan error
+
+	getAttempts := func() int {
+		attemptsLock.Lock()
+		defer attemptsLock.Unlock()
+
+		return attempts
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		failedPumps = make(chan struct{}, 10)
+		attempts = 0
+
+		cfg = outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			// counts each attempt, all of which fail
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				attemptsLock.Lock()
+				defer attemptsLock.Unlock()
+
+				attempts++
+				return errors.New("publisher unavailable")
+			}),
+			ProcessInterval: time.Minute,
+			ClaimDuration:   time.Second,
+			ProcessorID:     "test",
+			OnPumpComplete: func(_ int, err error) {
+				if err != nil {
+					failedPumps <- struct{}{}
+				}
+			},
+			BackoffFactory: func() backoff.BackOff {
+				return backoff.NewConstantBackOff(retryInterval)
+			},
+			MaxProcessingRetryElapsed: maxElapsed,
+		}
+
+		Expect(storage.Publish(ctx, nil, outbox.Message{})).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+		clock.BlockUntil(1)
+	})
+
+	// failUntilExhausted wakes the processor and retries until the pumps have failed for longer than maxElapsed
+	failUntilExhausted := func() {
+		ob.WakeProcessor()
+		for retry := 1; retry <= 2; retry++ {
+			Eventually(getAttempts).Should(Equal(retry))
+			Eventually(failedPumps).Should(Receive())
+			clock.BlockUntil(2)
+			clock.Advance(retryInterval)
+		}
+		Eventually(getAttempts).Should(Equal(3))
+	}
+
+	It("returns the error from StartProcessing once retries exceed the maximum", func() {
+		failUntilExhausted()
+
+		var err error
+		Eventually(errChan).Should(Receive(&err))
+		Expect(err).To(MatchError(ContainSubstring("publisher unavailable")))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+
+	When("an OnFatalError callback is provided", func() {
+		var fatalErrors chan error
+
+		BeforeEach(func() {
+			fatalErrors = make(chan error, 10)
+			cfg.OnFatalError = func(err error) {
+				fatalErrors <- err
+			}
+		})
+
+		AfterEach(func() {
+			Expect(ob.Shutdown(ctx)).To(Succeed())
+			Expect(errChan).To(Receive(BeNil()))
+		})
+
+		It("reports the error and keeps processing", func() {
+			failUntilExhausted()
+
+			var err error
+			Eventually(fatalErrors).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("publisher unavailable")))
+			Consistently(errChan).ShouldNot(Receive())
+
+			// reporting the error restarts the time allowed for failing pumps
+			ob.WakeProcessor()
+			Eventually(getAttempts).Should(Equal(4))
+			Consistently(fatalErrors).ShouldNot(Receive())
+		})
+	})
+
+	When("no maximum is configured", func() {
+		BeforeEach(func() {
+			cfg.MaxProcessingRetryElapsed = 0
+		})
+
+		AfterEach(func() {
+			Expect(ob.Shutdown(ctx)).To(Succeed())
+			Expect(errChan).To(Receive(BeNil()))
+		})
+
+		It("retries indefinitely", func() {
+			failUntilExhausted()
+
+			Eventually(failedPumps).Should(Receive())
+			clock.BlockUntil(2)
+			clock.Advance(retryInterval)
+			Eventually(getAttempts).Should(Equal(4))
+			Consistently(errChan).ShouldNot(Receive())
+		})
+	})
+})
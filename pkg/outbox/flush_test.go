@@ -0,0 +1,77 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("FlushNamespace", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		cfg = outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+			BatchSize:   2,
+		}
+
+		for _, namespace := range []string{"orders", "users"} {
+			for i := 0; i < 5; i++ {
+				msg := outbox.Message{Payload: []byte(fmt.Sprintf("%s-%d", namespace, i))}
+				Expect(storage.Publish(outbox.WithNamespace(ctx, namespace), nil, msg)).To(Succeed())
+			}
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("drains only the targeted namespace, across every batch", func() {
+		Expect(ob.FlushNamespace(ctx, "orders")).To(Equal(5))
+
+		Expect(publisher.GetPublished()).To(HaveLen(5))
+		for _, msg := range publisher.GetPublished() {
+			Expect(msg.Namespace).To(Equal("orders"))
+		}
+		Expect(storage.CountEntries()).To(Equal(5))
+
+		Expect(ob.FlushNamespace(ctx, "orders")).To(Equal(0))
+	})
+
+	When("the processor is restricted to other namespaces", func() {
+		BeforeEach(func() {
+			cfg.Namespace = "users"
+		})
+
+		It("still drains the targeted namespace", func() {
+			Expect(ob.FlushNamespace(ctx, "orders")).To(Equal(5))
+			Expect(storage.CountEntries()).To(Equal(5))
+		})
+	})
+})
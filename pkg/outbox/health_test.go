@@ -0,0 +1,103 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Healthy", func() {
+	const staleness = time.Minute
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+
+		cfg = outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       &fake.Publisher{Logger: logr.Discard()},
+			ProcessorID:     "test",
+			HealthStaleness: staleness,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("is healthy until the staleness has passed since it was created", func() {
+		Expect(ob.Healthy(ctx)).To(Succeed())
+
+		clock.Advance(staleness)
+		Expect(ob.Healthy(ctx)).To(Succeed())
+
+		clock.Advance(time.Second)
+		Expect(ob.Healthy(ctx)).To(MatchError(ContainSubstring("no successful pump")))
+	})
+
+	It("is healthy again once a pump succeeds", func() {
+		clock.Advance(staleness + time.Second)
+		Expect(ob.Healthy(ctx)).NotTo(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+		Expect(ob.Healthy(ctx)).To(Succeed())
+
+		clock.Advance(staleness + time.Second)
+		Expect(ob.Healthy(ctx)).NotTo(Succeed())
+	})
+
+	It("becomes unhealthy when pumps keep failing past the staleness", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+
+		storage.ClaimErr = fake.FailAlways(errors.New("connection reset"))
+		for elapsed := time.Duration(0); elapsed <= staleness; elapsed += 10 * time.Second {
+			clock.Advance(10 * time.Second)
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).NotTo(Succeed())
+		}
+
+		Expect(ob.Healthy(ctx)).To(MatchError(ContainSubstring("no successful pump")))
+	})
+
+	It("is unhealthy if the storage is unreachable", func() {
+		storage.PingErr = fake.FailAlways(errors.New("connection refused"))
+
+		err := ob.Healthy(ctx)
+		Expect(err).To(MatchError(ContainSubstring("storage unreachable")))
+		Expect(err).To(MatchError(ContainSubstring("connection refused")))
+	})
+
+	When("no staleness is configured", func() {
+		BeforeEach(func() {
+			cfg.HealthStaleness = 0
+		})
+
+		It("only checks the storage is reachable", func() {
+			clock.Advance(24 * time.Hour)
+			Expect(ob.Healthy(ctx)).To(Succeed())
+
+			storage.PingErr = fake.FailAlways(errors.New("connection refused"))
+			Expect(ob.Healthy(ctx)).NotTo(Succeed())
+		})
+	})
+})
@@ -0,0 +1,146 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("WaitForIdle", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+	var errChan chan error
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		cfg = outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       publisher,
+			ProcessInterval: time.Minute,
+			ProcessorID:     "test",
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+		clock.BlockUntil(1)
+	})
+
+	AfterEach(func() {
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+	})
+
+	It("returns once the processor has published the messages it was woken for", func() {
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+		Expect(ob.WaitForIdle(ctx)).To(Succeed())
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("returns immediately if the processor is already idle", func() {
+		Expect(ob.WaitForIdle(ctx)).To(Succeed())
+	})
+
+	When("publishing is slow", func() {
+		const delay = 10 * time.Second
+
+		BeforeEach(func() {
+			publisher.Delay = delay
+			publisher.Clock = clock
+		})
+
+		It("waits for the pump in progress to finish", func() {
+			Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+			// the publisher's delay, alongside the process interval timer
+			clock.BlockUntil(2)
+
+			idle := make(chan error, 1)
+			go func() {
+				idle <- ob.WaitForIdle(ctx)
+			}()
+			Consistently(idle).ShouldNot(Receive())
+
+			clock.Advance(delay)
+			Eventually(idle).Should(Receive(BeNil()))
+			Expect(publisher.GetPublishedCount()).To(Equal(1))
+		})
+
+		It("returns the context's error if the pump doesn't finish first", func() {
+			Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+			clock.BlockUntil(2)
+
+			waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+
+			Expect(ob.WaitForIdle(waitCtx)).To(MatchError(context.DeadlineExceeded))
+
+			clock.Advance(delay)
+			Expect(ob.WaitForIdle(ctx)).To(Succeed())
+		})
+	})
+
+	When("the pump fails", func() {
+		BeforeEach(func() {
+			publisher.FailFunc = func(outbox.Message) error {
+				return errors.New("broker unavailable")
+			}
+			cfg.BackoffFactory = func() backoff.BackOff {
+				return backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), 1)
+			}
+		})
+
+		It("returns once the processor has given up retrying", func() {
+			Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+			idle := make(chan error, 1)
+			go func() {
+				idle <- ob.WaitForIdle(ctx)
+			}()
+
+			// the backoff timer waiting to retry, alongside the process interval timer
+			clock.BlockUntil(2)
+			Consistently(idle).ShouldNot(Receive())
+			clock.Advance(time.Second)
+
+			Eventually(idle).Should(Receive(BeNil()))
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+	})
+
+	It("returns an error once the processor has stopped", func() {
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(ob.WaitForIdle(ctx)).To(MatchError("outbox processor is not running"))
+	})
+})
@@ -0,0 +1,89 @@
+package outbox
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"hash/fnv"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator generates the ClaimedEntry.ID of the entry a message is written to. ProcessorStorage implementations
+// that generate their own IDs accept an IDGenerator in their configuration, defaulting to UUIDGenerator, so that
+// tests can generate predictable IDs or applications can derive IDs from message contents.
+type IDGenerator func(msg Message) string
+
+// UUIDGenerator is the default IDGenerator, which generates a random UUID for every message
+func UUIDGenerator(Message) string {
+	return uuid.NewString()
+}
+
+// ContentIDGenerator is an IDGenerator that derives the ID from a hash of the message's Key, Payload, Headers and
+// DedupKey, so that writing the same message twice fails with storages that require unique IDs, e.g. as a primary
+// key, rather than publishing it twice. Only use it if messages with identical contents are always duplicates. The
+// hash covers the message as written to storage, after any compression or encryption by the Outbox, so it can't be
+// used with an Encryptor that encrypts identical payloads differently each time.
+func ContentIDGenerator(msg Message) string {
+	h := sha256.New()
+	writeField(h, msg.Key)
+	writeField(h, msg.Payload)
+
+	names := make([]string, 0, len(msg.Headers))
+	for name := range msg.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeLength(h, len(names))
+	for _, name := range names {
+		writeField(h, []byte(name))
+		writeField(h, msg.Headers[name])
+	}
+
+	writeField(h, []byte(msg.DedupKey))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ContentHash hashes the message's Key and Payload, so that messages with the same contents can be recognised, e.g.
+// by ProcessorStorage implementations supporting DedupWithinPublishFromContext
+func ContentHash(msg Message) string {
+	h := sha256.New()
+	writeField(h, msg.Key)
+	writeField(h, msg.Payload)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// PartitionForKey assigns the key to one of the partitions as the 32-bit FNV-1a hash of the key, as computed by
+// hash/fnv.New32a, modulo the number of partitions. The algorithm won't change, so that applications and consumers
+// can compute the same assignment as the Outbox, which uses it to divide entries between shards for
+// Config.ShardCount, or between their own partitions, e.g. workers. Keys that are nil or empty are all assigned to
+// the same partition, and every key is assigned to partition zero if there are fewer than two partitions. It isn't
+// the partitioner brokers such as Kafka use to assign records to their partitions.
+func PartitionForKey(key []byte, partitions int) int {
+	if partitions < 2 {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// writeField writes the field to the hash prefixed by its length, so that adjacent fields can't be confused
+func writeField(h hash.Hash, field []byte) {
+	writeLength(h, len(field))
+	h.Write(field)
+}
+
+// writeLength writes the length to the hash as a fixed size integer
+func writeLength(h hash.Hash, length int) {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], uint64(length))
+	h.Write(encoded[:])
+}
@@ -0,0 +1,147 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("IDGenerator", func() {
+	It("lets storages generate predictable IDs", func() {
+		ctx := context.Background()
+		clock := clockwork.NewFakeClock()
+
+		generated := 0
+		storage := &fake.EntryStorage{
+			Clock: clock,
+			IDGenerator: func(outbox.Message) string {
+				generated++
+				return fmt.Sprintf("entry-%d", generated)
+			},
+		}
+
+		Expect(storage.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("first")},
+			outbox.Message{Payload: []byte("second")},
+		)).To(Succeed())
+
+		Expect(storage.ClaimEntries(ctx, "test", clock.Now().Add(time.Minute))).To(Succeed())
+		entries, err := storage.GetClaimedEntries(ctx, "test", 10)
+		Expect(err).To(Succeed())
+		Expect(entries).To(ConsistOf(
+			HaveField("ID", "entry-1"),
+			HaveField("ID", "entry-2"),
+		))
+	})
+
+	Describe("UUIDGenerator", func() {
+		It("generates a different ID for every message", func() {
+			msg := outbox.Message{Payload: []byte("hello")}
+			Expect(outbox.UUIDGenerator(msg)).NotTo(Equal(outbox.UUIDGenerator(msg)))
+		})
+	})
+
+	Describe("ContentIDGenerator", func() {
+		var msg outbox.Message
+
+		BeforeEach(func() {
+			msg = outbox.Message{
+				Key:      []byte("key"),
+				Payload:  []byte("payload"),
+				DedupKey: "dedup",
+				Headers: map[string][]byte{
+					"a": []byte("1"),
+					"b": []byte("2"),
+				},
+			}
+		})
+
+		It("generates the same ID for identical messages", func() {
+			other := outbox.Message{
+				Key:      []byte("key"),
+				Payload:  []byte("payload"),
+				DedupKey: "dedup",
+				Headers: map[string][]byte{
+					"b": []byte("2"),
+					"a": []byte("1"),
+				},
+			}
+			Expect(outbox.ContentIDGenerator(msg)).To(Equal(outbox.ContentIDGenerator(other)))
+		})
+
+		It("generates different IDs for different messages", func() {
+			id := outbox.ContentIDGenerator(msg)
+
+			changedPayload := msg
+			changedPayload.Payload = []byte("other")
+			Expect(outbox.ContentIDGenerator(changedPayload)).NotTo(Equal(id))
+
+			changedHeader := msg
+			changedHeader.Headers = map[string][]byte{"a": []byte("1"), "b": []byte("3")}
+			Expect(outbox.ContentIDGenerator(changedHeader)).NotTo(Equal(id))
+		})
+
+		It("doesn't confuse adjacent fields", func() {
+			first := outbox.Message{Key: []byte("ab"), Payload: []byte("c")}
+			second := outbox.Message{Key: []byte("a"), Payload: []byte("bc")}
+			Expect(outbox.ContentIDGenerator(first)).NotTo(Equal(outbox.ContentIDGenerator(second)))
+		})
+	})
+
+	Describe("ContentHash", func() {
+		It("only covers the key and payload", func() {
+			msg := outbox.Message{Key: []byte("key"), Payload: []byte("payload")}
+			other := msg
+			other.DedupKey = "dedup"
+			other.Headers = map[string][]byte{"a": []byte("1")}
+			Expect(outbox.ContentHash(other)).To(Equal(outbox.ContentHash(msg)))
+
+			other.Payload = []byte("other")
+			Expect(outbox.ContentHash(other)).NotTo(Equal(outbox.ContentHash(msg)))
+		})
+	})
+
+	Describe("PartitionForKey", func() {
+		It("assigns known keys to known partitions", func() {
+			Expect(outbox.PartitionForKey(nil, 8)).To(Equal(5))
+			Expect(outbox.PartitionForKey([]byte("order-1"), 8)).To(Equal(5))
+			Expect(outbox.PartitionForKey([]byte("order-2"), 8)).To(Equal(4))
+			Expect(outbox.PartitionForKey([]byte("customer-42"), 12)).To(Equal(10))
+		})
+
+		It("assigns every key to the only partition", func() {
+			Expect(outbox.PartitionForKey(nil, 1)).To(Equal(0))
+			Expect(outbox.PartitionForKey([]byte("order-1"), 1)).To(Equal(0))
+			Expect(outbox.PartitionForKey([]byte("order-2"), 1)).To(Equal(0))
+		})
+
+		It("assigns every key to partition zero without any partitions", func() {
+			Expect(outbox.PartitionForKey([]byte("order-1"), 0)).To(Equal(0))
+			Expect(outbox.PartitionForKey([]byte("order-2"), 0)).To(Equal(0))
+			Expect(outbox.PartitionForKey([]byte("order-1"), -3)).To(Equal(0))
+		})
+
+		It("spreads keys evenly across the partitions", func() {
+			const partitions = 8
+			const keys = 80000
+
+			counts := make([]int, partitions)
+			for i := 0; i < keys; i++ {
+				partition := outbox.PartitionForKey([]byte(fmt.Sprintf("key-%d", i)), partitions)
+				Expect(partition).To(And(BeNumerically(">=", 0), BeNumerically("<", partitions)))
+				counts[partition]++
+			}
+
+			for _, count := range counts {
+				Expect(count).To(BeNumerically("~", keys/partitions, keys/partitions/10))
+			}
+		})
+	})
+})
@@ -4,15 +4,20 @@ import (
 	"context"
 	"fmt"
 	"time"
+
+	"github.com/jonboulle/clockwork"
 )
 
-// Clock abstracts interactions with the time package to facilitate testing
+// Clock abstracts interactions with the time package to facilitate testing, and is implemented by the
+// clocks provided by github.com/jonboulle/clockwork
 type Clock interface {
 	Now() time.Time
-	After(c time.Duration) <-chan time.Time
+	NewTimer(d time.Duration) clockwork.Timer
 }
 
-// ClaimedEntry is an entry in the Outbox
+// ClaimedEntry is an entry in the Outbox, as returned by a ProcessorStorage once it has been claimed. It is the only
+// view of an entry that the Outbox uses, so it omits the bookkeeping a ProcessorStorage records to manage claims,
+// such as which processor holds the claim and until when, which implementations keep in their own records instead.
 type ClaimedEntry struct {
 	// Namespace is an identifier used to group outbox entries, e.g. for choosing what topics to route entries to
 	Namespace string
@@ -22,21 +27,125 @@ type ClaimedEntry struct {
 	Key []byte
 	// Payload to be included in the published Message
 	Payload []byte
+	// Headers to be included in the published Message
+	Headers map[string][]byte
+	// NotBefore is the earliest time the entry may be published, if any
+	NotBefore *time.Time
+	// Attempts counts how many times the entry has been claimed for publishing, including the current claim
+	Attempts int
+	// TraceContext to be included in the published Message
+	TraceContext map[string]string
+	// DedupKey to be included in the published Message, if one was provided when it was written
+	DedupKey string
+	// Priority to be included in the published Message, higher priority entries are published first
+	Priority int
+	// CreatedAt is when the entry was written to the outbox, used to enforce Config.MaxEntryAge and included in the
+	// published Message as its EnqueuedAt. It is zero if the ProcessorStorage doesn't record it, in which case the
+	// entry never expires.
+	CreatedAt time.Time
 }
 
-// ProcessorStorage is the Outbox's interaction with persistence, typically a database
+// ProcessorStorage is the Outbox's interaction with persistence, typically a database. Errors are assumed to be
+// transient and retried, unless they wrap ErrPermanent, e.g. by using Permanent, for failures that retrying can't fix.
+// Claims belong to the processorID alone, which identifies a processor across every namespace, so implementations
+// must key claims by it whether or not they also record the namespace, rather than by the processorID and namespace
+// together. The Outbox passes Config.EffectiveProcessorID, which is distinct for processors restricted to different
+// namespaces.
+//
+// The ClaimAffinityFromContext, ShardFromContext, SelectionOrderFromContext, MaxEntriesPerKeyFromContext,
+// FairnessKeyFromContext and DedupWithinPublishFromContext settings are optional hints, which implementations that
+// don't support them may ignore, so the Config fields that set them only take effect with a ProcessorStorage that
+// supports them, such as fake.EntryStorage.
 type ProcessorStorage interface {
-	// ClaimEntries attempts to update all claimable entries as belonging to the calling processor
+	// ClaimEntries attempts to update all claimable entries as belonging to the calling processor.
+	// Entries whose ClaimedEntry.NotBefore is after the current time must not be claimed, e.g. for a
+	// SQL database: WHERE (not_before IS NULL OR not_before <= NOW()).
+	// Each claimed entry's ClaimedEntry.Attempts must be incremented, e.g. SET attempts = attempts + 1.
+	// If the context has a namespace, as reported by LookupNamespace, only entries in that namespace may be claimed.
+	// If ClaimAffinityFromContext is positive, entries whose claim by another processor expired less than that long
+	// ago should be left for that processor.
+	// If ShardFromContext returns a positive count, only entries for which PartitionForKey(entry.Key, count) == index
+	// should be claimed.
 	ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error
-	// GetClaimedEntries returns a batch of entries currently belonging to the calling processor
+	// GetClaimedEntries returns a batch of entries currently belonging to the calling processor.
+	// As with ClaimEntries, entries whose ClaimedEntry.NotBefore is after the current time must be excluded, as must
+	// entries outside of the context's namespace, if it has one.
+	// Entries must be returned highest ClaimedEntry.Priority first, and oldest first within each priority, e.g.
+	// ORDER BY priority DESC, created_at. If OrderingFromContext is OrderingPerKey they must instead be grouped by
+	// ClaimedEntry.Key and oldest first within each key, regardless of priority, e.g. ORDER BY key, created_at.
+	// If SelectionOrderFromContext is NewestFirst, entries should instead be returned newest first within each
+	// priority, e.g. ORDER BY priority DESC, created_at DESC.
+	// If MaxEntriesPerKeyFromContext is positive, entries beyond that many with the same non-empty key should be
+	// skipped in favour of entries with other keys.
+	// If FairnessKeyFromContext is set, entries should be grouped by the key it returns for their messages, as they
+	// were written, and taken from each group in turn, in the order each group's first entry would otherwise be
+	// returned, keeping each group's entries in order.
 	GetClaimedEntries(ctx context.Context, processorID string, batchSize int) ([]ClaimedEntry, error)
+	// RenewClaim extends the claim deadline of every entry currently belonging to the calling processor, so
+	// that entries taking longer than expected to publish are not claimed by another processor
+	RenewClaim(ctx context.Context, processorID string, claimDeadline time.Time) error
 	// DeleteEntries deletes the entries as specified by their ClaimedEntry.ID
 	DeleteEntries(ctx context.Context, entryIDs ...string) error
-	// Publish creates new outbox entries containing the provided messages, to be published as soon as possible
+	// Publish creates new outbox entries containing the provided messages, to be published as soon as possible.
+	// If txn is the application's ongoing transaction, the entries must only be recorded if it commits. If txn is
+	// nil, implementations should record the entries in a transaction of their own that has committed by the time
+	// Publish returns, for applications without a transaction to hand.
+	// If DedupWithinPublishFromContext is set, messages with the same ContentHash as a message earlier in the same
+	// call should be skipped.
 	// Note: implementations should consult the context for additional ContextSettings, e.g. namespace
 	Publish(ctx context.Context, txn interface{}, messages ...Message) error
 }
 
+// UnclaimedEntryGetter may be implemented by a ProcessorStorage to support Config.SkipClaim
+type UnclaimedEntryGetter interface {
+	// GetUnclaimedEntries returns a batch of entries regardless of which processor, if any, has claimed them,
+	// excluding entries that aren't yet due or are outside of the context's namespace, if it has one, and ordered as
+	// ProcessorStorage.GetClaimedEntries orders them. Like ProcessorStorage.ClaimEntries, entries outside the shard
+	// from ShardFromContext should be excluded, if the implementation supports it.
+	GetUnclaimedEntries(ctx context.Context, batchSize int) ([]ClaimedEntry, error)
+}
+
+// EntryReleaser may be implemented by a ProcessorStorage to support Config.ReleaseFailed, so that entries that failed
+// to publish can be retried straight away, rather than once their claim has expired
+type EntryReleaser interface {
+	// ReleaseEntries clears the claim on the entries, as specified by their ClaimedEntry.ID, so that they can be
+	// claimed again by any processor. Entries no longer claimed by the calling processor must be left alone.
+	ReleaseEntries(ctx context.Context, processorID string, entryIDs ...string) error
+}
+
+// EntryRepublisher may be implemented by a ProcessorStorage to support Outbox.Replay
+type EntryRepublisher interface {
+	// Republish creates new outbox entries from entries that were previously published, e.g. as dead lettered, to be
+	// published again as soon as possible. Each new entry must have a fresh ClaimedEntry.ID and
+	// ClaimedEntry.CreatedAt, and no claim or Attempts, while keeping the entry's Namespace, Key, Payload, Headers,
+	// TraceContext, DedupKey and Priority as they are. The entry's NotBefore is ignored.
+	Republish(ctx context.Context, entries ...ClaimedEntry) error
+}
+
+// EntryPeeker may be implemented by a ProcessorStorage to support Outbox.Peek
+type EntryPeeker interface {
+	// PeekEntries returns at most limit of the entries waiting to be published without claiming or otherwise
+	// modifying them, e.g. for showing the backlog in an admin UI. It returns entries regardless of which processor,
+	// if any, has claimed them and whether or not they are due yet, excluding only those outside of the context's
+	// namespace, if it has one. Entries are ordered highest ClaimedEntry.Priority first, and oldest first within each
+	// priority.
+	PeekEntries(ctx context.Context, limit int) ([]ClaimedEntry, error)
+}
+
+// PendingCounter may be implemented by a ProcessorStorage to support Outbox.BacklogSize
+type PendingCounter interface {
+	// CountPending counts the entries waiting to be published, regardless of which processor, if any, has claimed
+	// them and whether or not they are due yet, excluding only those outside of the context's namespace, if it has
+	// one, e.g. for a SQL database: SELECT COUNT(*) FROM outbox_entries
+	CountPending(ctx context.Context) (int, error)
+}
+
+// HealthChecker may be implemented by a ProcessorStorage so that Outbox.Healthy can check it is reachable
+type HealthChecker interface {
+	// Ping cheaply checks that the storage is reachable, e.g. by pinging the database, returning an error if not
+	Ping(ctx context.Context) error
+}
+
 // Message is what will be published over some pubsub/streaming system
 type Message struct {
 	// Key is an optional value primarily used in streaming systems that partition
@@ -44,6 +153,29 @@ type Message struct {
 	Key []byte
 	// Payload is the actual message contents that should be published
 	Payload []byte
+	// Headers are optional attributes published alongside the Payload, for pubsub/streaming systems
+	// that support them (e.g. Kafka record headers, SQS message attributes)
+	Headers map[string][]byte
+	// NotBefore optionally delays publishing the message until the specified time, e.g. for scheduling
+	// reminders. If nil, the message is published as soon as possible.
+	NotBefore *time.Time
+	// TraceContext optionally carries distributed tracing metadata (e.g. a W3C traceparent) from the
+	// code that wrote the message to the outbox, so that publishing can be linked to the original trace
+	TraceContext map[string]string
+	// DedupKey identifies the message across every attempt to publish it, so that publishers can forward it
+	// to brokers that discard duplicates (e.g. as a JetStream Nats-Msg-Id or SQS deduplication ID), or so
+	// that consumers can recognise redeliveries. If not provided when writing the message, the Outbox uses
+	// the ID of its entry in storage.
+	DedupKey string
+	// Priority orders the message relative to others waiting in the outbox, messages with a higher priority are
+	// published before those with a lower one, and messages with equal priorities are published oldest first.
+	// Defaults to zero, and may be negative to publish a message after the default. Priority does not reorder
+	// messages with the same Key when using OrderingPerKey.
+	Priority int
+	// EnqueuedAt is when the message was written to the outbox, so that publishers can measure how long it waited
+	// before being published. It is set by the Outbox from the ClaimedEntry.CreatedAt of the message's entry, and is
+	// zero if the ProcessorStorage doesn't record it. It is ignored when writing messages to the outbox.
+	EnqueuedAt time.Time
 }
 
 // Publisher is something that can take a batch of Message objects and attempt to publish them.
@@ -53,15 +185,27 @@ type Message struct {
 //     will write the given Message objects to the underlying ProcessorStorage for later publishing
 type Publisher interface {
 	// Publish attempts to write the given messages to a destination. It may return a PublishError
-	// to indicate which messages were published successfully.
+	// to indicate which messages were published successfully, or an error wrapping ErrPermanent if none of the
+	// messages can ever be published.
 	// Note: implementations should consult the context for additional ContextSettings, e.g. namespace
 	Publish(ctx context.Context, messages ...Message) error
 }
 
+// SingleMessagePublisher may be implemented by a Publisher that can publish messages one at a time, to support
+// Config.MessagePublishTimeout, so that one slow message can't hold up the rest of its batch
+type SingleMessagePublisher interface {
+	// PublishMessage attempts to write the given message to a destination, returning an error wrapping ErrPermanent
+	// if it can never be published.
+	// Note: implementations should consult the context for additional ContextSettings, e.g. namespace
+	PublishMessage(ctx context.Context, msg Message) error
+}
+
 // PublishError allows callers to understand which Message objects, if any, were sent successfully
 type PublishError struct {
 	// Errors correlates one-to-one with the Message values passed to Publisher.Publish - if a message
-	// was sent successfully it will have a nil entry, otherwise it will be an error value
+	// was sent successfully it will have a nil entry, otherwise it will be an error value. Errors wrapping
+	// ErrPermanent, e.g. by using Permanent, mark messages that can never be published, which the Outbox dead letters
+	// rather than retries.
 	Errors []error
 }
 
@@ -79,3 +223,44 @@ func (p *PublishError) ErrorCount() (count int) {
 func (p *PublishError) Error() string {
 	return fmt.Sprintf("failed to publish %v/%v messages", p.ErrorCount(), len(p.Errors))
 }
+
+// FailedIndices lists the indexes of the messages that failed to publish
+func (p *PublishError) FailedIndices() []int {
+	var indices []int
+	for idx, err := range p.Errors {
+		if err != nil {
+			indices = append(indices, idx)
+		}
+	}
+	return indices
+}
+
+// SucceededMessages selects the messages that were published from the input passed to Publisher.Publish. If the
+// input doesn't correlate one-to-one with Errors, none of the messages can be known to have been published.
+func (p *PublishError) SucceededMessages(input []Message) []Message {
+	return p.selectMessages(input, false)
+}
+
+// FailedMessages selects the messages that failed to publish from the input passed to Publisher.Publish. If the
+// input doesn't correlate one-to-one with Errors, all of the messages are considered to have failed.
+func (p *PublishError) FailedMessages(input []Message) []Message {
+	return p.selectMessages(input, true)
+}
+
+// selectMessages selects either the failed or the published messages from the input
+func (p *PublishError) selectMessages(input []Message, failed bool) []Message {
+	if len(input) != len(p.Errors) {
+		if failed {
+			return append([]Message(nil), input...)
+		}
+		return nil
+	}
+
+	var selected []Message
+	for idx, msg := range input {
+		if (p.Errors[idx] != nil) == failed {
+			selected = append(selected, msg)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,97 @@
+package outbox_test
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Interval jitter", func() {
+	const processInterval = 8 * time.Second
+	const jitter = 2 * time.Second
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var clock clockwork.FakeClock
+	var cfg outbox.Config
+	var pumps chan struct{}
+	var errChan chan error
+
+	// expectPumpAfter checks that the processor stays idle until the wait has elapsed, then pumps the outbox
+	expectPumpAfter := func(wait time.Duration) {
+		clock.BlockUntil(1)
+		clock.Advance(wait - 1)
+		Consistently(pumps, 20*time.Millisecond).ShouldNot(Receive())
+
+		clock.Advance(1)
+		Eventually(pumps).Should(Receive())
+	}
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		clock = clockwork.NewFakeClock()
+		pumps = make(chan struct{}, 10)
+
+		cfg = outbox.Config{
+			Clock:           clock,
+			Storage:         &fake.EntryStorage{Clock: clock},
+			Publisher:       &fake.Publisher{},
+			ProcessInterval: processInterval,
+			IntervalJitter:  jitter,
+			ClaimDuration:   5 * time.Second,
+			ProcessorID:     "test",
+			OnPumpComplete: func(processed int, err error) {
+				pumps <- struct{}{}
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		ob, err := outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(errChan, time.Second).Should(Receive(BeNil()))
+	})
+
+	It("waits within the jitter of the process interval between pumps", func() {
+		for cycle := 0; cycle < 5; cycle++ {
+			clock.BlockUntil(1)
+			clock.Advance(processInterval - jitter - time.Millisecond)
+			Consistently(pumps, 20*time.Millisecond).ShouldNot(Receive())
+
+			clock.Advance(2*jitter + time.Millisecond)
+			Eventually(pumps).Should(Receive())
+		}
+	})
+
+	When("a fixed seed random source is provided", func() {
+		const seed = 42
+
+		BeforeEach(func() {
+			cfg.RandSource = rand.NewSource(seed)
+		})
+
+		It("waits exactly the jittered intervals drawn from the source", func() {
+			expected := rand.New(rand.NewSource(seed))
+			for cycle := 0; cycle < 5; cycle++ {
+				wait := processInterval - jitter + time.Duration(expected.Int63n(2*int64(jitter)+1))
+				expectPumpAfter(wait)
+			}
+		})
+	})
+})
@@ -0,0 +1,119 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("LastError", func() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+	var pumps chan error
+	var errChan chan error
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		pumps = make(chan error, 10)
+
+		cfg = outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       &fake.Publisher{Logger: logr.Discard()},
+			ProcessInterval: time.Minute,
+			ProcessorID:     "test",
+			BackoffFactory: func() backoff.BackOff {
+				return &backoff.StopBackOff{}
+			},
+			OnPumpComplete: func(processed int, err error) {
+				pumps <- err
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func(ob *outbox.Outbox, ctx context.Context, errChan chan<- error) {
+			errChan <- ob.StartProcessing(ctx)
+		}(ob, ctx, errChan)
+		Eventually(ob.Stats).Should(HaveField("Processing", BeTrue()))
+	})
+
+	AfterEach(func() {
+		cancel()
+		Eventually(errChan).Should(Receive())
+	})
+
+	// pumpOnce wakes the processor and waits for the loop to finish handling the pump
+	pumpOnce := func() {
+		ob.WakeProcessor()
+		Eventually(pumps).Should(Receive())
+		Expect(ob.WaitForIdle(ctx)).To(Succeed())
+	}
+
+	It("is nil before any pump has failed", func() {
+		Expect(ob.LastError()).To(BeNil())
+
+		pumpOnce()
+		Expect(ob.LastError()).To(BeNil())
+	})
+
+	It("reports the error the processor gave up on, until a pump succeeds", func() {
+		storage.ClaimErr = fake.FailFirst(1, errors.New("connection reset"))
+
+		pumpOnce()
+		Expect(ob.LastError()).To(MatchError(ContainSubstring("error pumping outbox")))
+		Expect(ob.LastError()).To(MatchError(ContainSubstring("connection reset")))
+
+		pumpOnce()
+		Expect(ob.LastError()).To(BeNil())
+	})
+
+	It("isn't affected by direct calls to PumpOutbox", func() {
+		storage.ClaimErr = fake.FailFirst(1, errors.New("connection reset"))
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(HaveOccurred())
+		Expect(ob.LastError()).To(BeNil())
+		Expect(ob.Stats().LastPumpErr).To(HaveOccurred())
+	})
+
+	When("the processor stops with a fatal error", func() {
+		BeforeEach(func() {
+			storage.ClaimErr = fake.FailAlways(outbox.Permanent(errors.New("no such table")))
+		})
+
+		It("reports the fatal error", func() {
+			ob.WakeProcessor()
+
+			var err error
+			Eventually(errChan).Should(Receive(&err))
+			Expect(err).To(MatchError(outbox.ErrPermanent))
+			Expect(ob.LastError()).To(MatchError(err))
+
+			// the processor has already stopped
+			errChan <- nil
+		})
+	})
+})
@@ -0,0 +1,178 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Lifecycle hooks", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var eventsLock sync.Mutex
+	var events []string
+	var ob *outbox.Outbox
+
+	record := func(event string) {
+		eventsLock.Lock()
+		defer eventsLock.Unlock()
+
+		events = append(events, event)
+	}
+
+	getEvents := func() []string {
+		eventsLock.Lock()
+		defer eventsLock.Unlock()
+
+		return append([]string(nil), events...)
+	}
+
+	// startProcessing runs the processor in the background, returning a channel that receives its result
+	startProcessing := func(ctx context.Context) chan error {
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+		return errChan
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		events = nil
+
+		cfg = outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     &fake.Publisher{},
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			OnStart: func() {
+				record("start")
+			},
+			OnStop: func() {
+				record("stop")
+			},
+			OnPumpComplete: func(processed int, err error) {
+				record(fmt.Sprintf("pump %d %v", processed, err))
+			},
+		}
+
+		Expect(storage.Publish(ctx, nil, outbox.Message{}, outbox.Message{})).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("reports how many entries each pump processed", func() {
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+		Expect(getEvents()).To(Equal([]string{"pump 2 <nil>", "pump 0 <nil>"}))
+	})
+
+	When("publishing fails", func() {
+		BeforeEach(func() {
+			cfg.Publisher = outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				return errors.New("publisher unavailable")
+			})
+		})
+
+		It("reports the entries and the error", func() {
+			processed, err := ob.PumpOutbox(ctx)
+			Expect(err).ToNot(Succeed())
+			Expect(processed).To(Equal(2))
+
+			Expect(getEvents()).To(Equal([]string{fmt.Sprintf("pump 2 %v", err)}))
+		})
+	})
+
+	It("fires the hooks in order when shut down", func() {
+		errChan := startProcessing(ctx)
+		clock.BlockUntil(1)
+
+		ob.WakeProcessor()
+		Eventually(getEvents).Should(ContainElement("pump 2 <nil>"))
+
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+		Expect(getEvents()).To(Equal([]string{"start", "pump 2 <nil>", "stop"}))
+	})
+
+	It("fires the hooks in order when the context is cancelled", func() {
+		processCtx, cancel := context.WithCancel(ctx)
+		errChan := startProcessing(processCtx)
+		clock.BlockUntil(1)
+
+		ob.WakeProcessor()
+		Eventually(getEvents).Should(ContainElement("pump 2 <nil>"))
+
+		cancel()
+		Eventually(errChan, time.Second).Should(Receive(BeNil()))
+		Expect(getEvents()).To(Equal([]string{"start", "pump 2 <nil>", "stop"}))
+	})
+
+	It("does not fire the start and stop hooks when a concurrent start is rejected", func() {
+		errChan := startProcessing(ctx)
+		clock.BlockUntil(1)
+
+		Expect(ob.StartProcessing(ctx)).To(MatchError(outbox.ErrAlreadyProcessing))
+		Expect(getEvents()).To(Equal([]string{"start"}))
+
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+		Expect(getEvents()).To(Equal([]string{"start", "stop"}))
+	})
+
+	It("runs exactly one processor when started concurrently", func() {
+		const starts = 10
+
+		errChans := make([]chan error, starts)
+		for i := range errChans {
+			errChans[i] = startProcessing(ctx)
+		}
+
+		// every start but one is rejected, while the other keeps running until shutdown
+		Eventually(func() int {
+			rejected := 0
+			for _, errChan := range errChans {
+				if len(errChan) > 0 {
+					rejected++
+				}
+			}
+			return rejected
+		}).Should(Equal(starts - 1))
+
+		var running chan error
+		for _, errChan := range errChans {
+			if len(errChan) == 0 {
+				running = errChan
+				continue
+			}
+			Expect(<-errChan).To(MatchError(outbox.ErrAlreadyProcessing))
+		}
+		Expect(running).NotTo(BeNil())
+		Expect(getEvents()).To(Equal([]string{"start"}))
+
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(running).To(Receive(BeNil()))
+		Expect(getEvents()).To(Equal([]string{"start", "stop"}))
+	})
+})
@@ -0,0 +1,35 @@
+package outbox
+
+import (
+	"sync"
+	"time"
+)
+
+// logSuppressor limits a routine log line to being logged at most once per interval, counting the lines suppressed
+// in between
+type logSuppressor struct {
+	lock       sync.Mutex
+	last       time.Time
+	suppressed int
+}
+
+// allow reports whether the line may be logged at the given time, and if so how many lines were suppressed since it
+// was last logged. Every line is allowed if the interval isn't positive.
+func (l *logSuppressor) allow(now time.Time, interval time.Duration) (bool, int) {
+	if interval <= 0 {
+		return true, 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.last.IsZero() && now.Sub(l.last) < interval {
+		l.suppressed++
+		return false, 0
+	}
+
+	suppressed := l.suppressed
+	l.last = now
+	l.suppressed = 0
+	return true, suppressed
+}
@@ -0,0 +1,93 @@
+package outbox_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr/funcr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Routine logging", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+	var linesLock sync.Mutex
+	var lines []string
+
+	// pumpLines returns the logged lines about pumping the outbox
+	pumpLines := func() []string {
+		linesLock.Lock()
+		defer linesLock.Unlock()
+
+		var pumps []string
+		for _, line := range lines {
+			if strings.Contains(line, "pumping outbox") {
+				pumps = append(pumps, line)
+			}
+		}
+		return pumps
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		lines = nil
+
+		cfg = outbox.Config{
+			Clock: clock,
+			Storage: &fake.EntryStorage{
+				Clock: clock,
+			},
+			Publisher:          &fake.Publisher{},
+			ProcessorID:        "test",
+			RoutineLogInterval: time.Minute,
+			Logger: funcr.New(func(prefix, args string) {
+				linesLock.Lock()
+				defer linesLock.Unlock()
+
+				lines = append(lines, args)
+			}, funcr.Options{Verbosity: 1}),
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("logs each pump at most once per interval", func() {
+		for i := 0; i < 5; i++ {
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+			clock.Advance(10 * time.Second)
+		}
+		Expect(pumpLines()).To(HaveLen(1))
+
+		clock.Advance(10 * time.Second)
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+		Expect(pumpLines()).To(HaveLen(2))
+		Expect(pumpLines()[1]).To(ContainSubstring(`"suppressed"=4`))
+	})
+
+	When("routine logs aren't suppressed", func() {
+		BeforeEach(func() {
+			cfg.RoutineLogInterval = -1
+		})
+
+		It("logs every pump", func() {
+			for i := 0; i < 5; i++ {
+				Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+			}
+			Expect(pumpLines()).To(HaveLen(5))
+		})
+	})
+})
@@ -0,0 +1,84 @@
+package outbox
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPayloadTooLarge is returned, wrapped, when a Message.Payload exceeds the maximum payload size
+var ErrPayloadTooLarge = errors.New("payload too large")
+
+// CheckPayloadSize returns an error wrapping ErrPayloadTooLarge if any of the messages has a Message.Payload larger
+// than maxPayloadSize bytes, or nil if they all fit. A maxPayloadSize of zero allows payloads of any size. It is
+// used by Outbox.Publish when Config.MaxPayloadSize is set, and may be called beforehand to fail even earlier.
+func CheckPayloadSize(maxPayloadSize int, messages ...Message) error {
+	if maxPayloadSize == 0 {
+		return nil
+	}
+
+	for idx, msg := range messages {
+		if len(msg.Payload) > maxPayloadSize {
+			return fmt.Errorf("message %d has a payload of %d bytes, over the limit of %d: %w",
+				idx, len(msg.Payload), maxPayloadSize, ErrPayloadTooLarge)
+		}
+	}
+
+	return nil
+}
+
+// MessageOption configures a Message constructed with NewMessage
+type MessageOption func(msg *Message)
+
+// NewMessage constructs a Message with the provided payload, configured by any options
+func NewMessage(payload []byte, opts ...MessageOption) Message {
+	msg := Message{
+		Payload: payload,
+	}
+
+	for _, opt := range opts {
+		opt(&msg)
+	}
+
+	return msg
+}
+
+// WithKey sets the Message.Key
+func WithKey(key []byte) MessageOption {
+	return func(msg *Message) {
+		msg.Key = key
+	}
+}
+
+// WithHeader adds a header to the Message.Headers, replacing any existing header with the same key
+func WithHeader(key string, value []byte) MessageOption {
+	return func(msg *Message) {
+		if msg.Headers == nil {
+			msg.Headers = map[string][]byte{}
+		}
+		msg.Headers[key] = value
+	}
+}
+
+// WithDedupKey sets the Message.DedupKey
+func WithDedupKey(dedupKey string) MessageOption {
+	return func(msg *Message) {
+		msg.DedupKey = dedupKey
+	}
+}
+
+// WithNotBefore sets the Message.NotBefore, delaying publishing until the specified time
+func WithNotBefore(notBefore time.Time) MessageOption {
+	return func(msg *Message) {
+		msg.NotBefore = &notBefore
+	}
+}
+
+// WithDelay delays publishing until the specified duration after the Message is constructed, according to the
+// real clock. Use WithNotBefore if the Outbox is configured with a different Config.Clock.
+func WithDelay(delay time.Duration) MessageOption {
+	return func(msg *Message) {
+		notBefore := time.Now().Add(delay)
+		msg.NotBefore = &notBefore
+	}
+}
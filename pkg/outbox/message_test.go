@@ -0,0 +1,72 @@
+package outbox_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("NewMessage", func() {
+	It("only sets the payload without options", func() {
+		Expect(outbox.NewMessage([]byte("payload"))).To(Equal(outbox.Message{Payload: []byte("payload")}))
+	})
+
+	It("sets the key", func() {
+		msg := outbox.NewMessage(nil, outbox.WithKey([]byte("key")))
+		Expect(msg.Key).To(Equal([]byte("key")))
+	})
+
+	It("accumulates headers, replacing repeated keys", func() {
+		msg := outbox.NewMessage(nil,
+			outbox.WithHeader("first", []byte("1")),
+			outbox.WithHeader("second", []byte("2")),
+			outbox.WithHeader("first", []byte("replaced")),
+		)
+		Expect(msg.Headers).To(Equal(map[string][]byte{
+			"first":  []byte("replaced"),
+			"second": []byte("2"),
+		}))
+	})
+
+	It("sets the dedup key", func() {
+		msg := outbox.NewMessage(nil, outbox.WithDedupKey("dedup-key"))
+		Expect(msg.DedupKey).To(Equal("dedup-key"))
+	})
+
+	It("sets the not before time", func() {
+		notBefore := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		msg := outbox.NewMessage(nil, outbox.WithNotBefore(notBefore))
+		Expect(msg.NotBefore).To(Equal(&notBefore))
+	})
+
+	It("delays the message from now", func() {
+		before := time.Now()
+		msg := outbox.NewMessage(nil, outbox.WithDelay(time.Minute))
+		after := time.Now()
+
+		Expect(msg.NotBefore).ToNot(BeNil())
+		Expect(*msg.NotBefore).To(BeTemporally(">=", before.Add(time.Minute)))
+		Expect(*msg.NotBefore).To(BeTemporally("<=", after.Add(time.Minute)))
+	})
+
+	It("combines options", func() {
+		notBefore := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		msg := outbox.NewMessage([]byte("payload"),
+			outbox.WithKey([]byte("key")),
+			outbox.WithHeader("header", []byte("value")),
+			outbox.WithDedupKey("dedup-key"),
+			outbox.WithNotBefore(notBefore),
+		)
+
+		Expect(msg).To(Equal(outbox.Message{
+			Key:       []byte("key"),
+			Payload:   []byte("payload"),
+			Headers:   map[string][]byte{"header": []byte("value")},
+			NotBefore: &notBefore,
+			DedupKey:  "dedup-key",
+		}))
+	})
+})
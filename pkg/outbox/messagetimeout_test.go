@@ -0,0 +1,129 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("MessagePublishTimeout", func() {
+	const timeout = 5 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var events *fake.EventSink
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+			Clock:  clock,
+			MessageDelay: func(msg outbox.Message) time.Duration {
+				if string(msg.Payload) == "slow" {
+					return time.Minute
+				}
+				return time.Second
+			},
+		}
+
+		events = &fake.EventSink{}
+
+		cfg = outbox.Config{
+			EventSink:             events,
+			Clock:                 clock,
+			Storage:               storage,
+			Publisher:             publisher,
+			ProcessorID:           "test",
+			ClaimDuration:         time.Hour,
+			MessagePublishTimeout: timeout,
+		}
+
+		Expect(storage.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("first")},
+			outbox.Message{Payload: []byte("slow")},
+			outbox.Message{Payload: []byte("last")},
+		)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	// pump pumps the outbox in the background, returning a channel receiving its error
+	pump := func() <-chan error {
+		errChan := make(chan error, 1)
+		go func(ob *outbox.Outbox, errChan chan<- error) {
+			_, err := ob.PumpOutbox(ctx)
+			errChan <- err
+		}(ob, errChan)
+		return errChan
+	}
+
+	// advance waits for the claim renewal, message timeout and message delay timers, then advances the clock
+	advance := func(d time.Duration) {
+		clock.BlockUntil(3)
+		clock.Advance(d)
+	}
+
+	It("fails messages that time out without holding up the rest of the batch", func() {
+		errChan := pump()
+
+		advance(time.Second)
+		advance(timeout)
+		advance(time.Second)
+
+		var err error
+		Eventually(errChan).Should(Receive(&err))
+		Expect(err).To(MatchError(ContainSubstring("failed to publish 1/3 messages")))
+
+		Expect(publishedPayloads(publisher)).To(Equal([]string{"first", "last"}))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+
+	It("reports the timeout as the message's error", func() {
+		errChan := pump()
+
+		advance(time.Second)
+		advance(timeout)
+		advance(time.Second)
+		Eventually(errChan).Should(Receive())
+
+		var failed []fake.Event
+		for _, event := range events.GetEvents() {
+			if event.Type == fake.EventFailed {
+				failed = append(failed, event)
+			}
+		}
+		Expect(failed).To(HaveLen(1))
+		Expect(failed[0].Err).To(MatchError(ContainSubstring("message publish timed out after 5s")))
+		Expect(failed[0].Err).To(MatchError(context.Canceled))
+	})
+
+	When("no message publish timeout is configured", func() {
+		BeforeEach(func() {
+			cfg.MessagePublishTimeout = 0
+		})
+
+		It("publishes the batch in one call", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+			Expect(publisher.GetPublishedCount()).To(Equal(3))
+		})
+	})
+})
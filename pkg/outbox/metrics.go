@@ -0,0 +1,41 @@
+package outbox
+
+import (
+	"time"
+)
+
+// Metrics receives measurements from the Outbox processor, so they can be forwarded to whatever
+// metrics system the application uses (e.g. Prometheus, StatsD)
+type Metrics interface {
+	// RecordClaimDuration records how long it took to claim entries in ProcessorStorage
+	RecordClaimDuration(d time.Duration)
+	// RecordBatchProcessed records how many entries were in a processed batch and how long it took
+	// to publish and delete them, it is not called for empty batches
+	RecordBatchProcessed(size int, duration time.Duration)
+	// RecordPublished records how many messages were published successfully
+	RecordPublished(count int)
+	// RecordPublishFailure records how many messages failed to publish
+	RecordPublishFailure(count int)
+	// RecordExpired records how many entries exceeded Config.MaxEntryAge, and so were not published
+	RecordExpired(count int)
+}
+
+// BacklogMetrics may be implemented by Metrics to receive the size of the backlog each time Outbox.BacklogSize
+// counts it, e.g. to alert on the backlog growing
+type BacklogMetrics interface {
+	// RecordBacklogSize records how many entries were waiting to be published
+	RecordBacklogSize(size int)
+}
+
+// noopMetrics is the default Metrics implementation, which discards all measurements
+type noopMetrics struct{}
+
+func (noopMetrics) RecordClaimDuration(time.Duration) {}
+
+func (noopMetrics) RecordBatchProcessed(int, time.Duration) {}
+
+func (noopMetrics) RecordPublished(int) {}
+
+func (noopMetrics) RecordPublishFailure(int) {}
+
+func (noopMetrics) RecordExpired(int) {}
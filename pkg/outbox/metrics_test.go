@@ -0,0 +1,93 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Metrics", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher outbox.Publisher
+	var metrics *fake.Metrics
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		metrics = &fake.Metrics{}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			BatchSize:     2,
+			Metrics:       metrics,
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("records a claim but no batch when the outbox is empty", func() {
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+		Expect(metrics.GetClaimDurations()).To(HaveLen(1))
+		Expect(metrics.GetBatchesProcessed()).To(BeEmpty())
+		Expect(metrics.GetPublishedCount()).To(BeZero())
+		Expect(metrics.GetPublishFailureCount()).To(BeZero())
+	})
+
+	When("the outbox contains messages", func() {
+		BeforeEach(func() {
+			Expect(storage.Publish(ctx, nil, outbox.Message{}, outbox.Message{}, outbox.Message{})).To(Succeed())
+		})
+
+		It("records each batch and the published messages", func() {
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			Expect(metrics.GetBatchesProcessed()).To(ConsistOf(
+				fake.BatchProcessed{Size: 2},
+				fake.BatchProcessed{Size: 1},
+			))
+			Expect(metrics.GetPublishedCount()).To(Equal(3))
+			Expect(metrics.GetPublishFailureCount()).To(BeZero())
+		})
+
+		When("publishing fails", func() {
+			BeforeEach(func() {
+				publisher = outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+					return errors.New("publisher unavailable")
+				})
+			})
+
+			It("records the publish failures", func() {
+				_, err := ob.PumpOutbox(ctx)
+				Expect(err).ToNot(Succeed())
+
+				Expect(metrics.GetBatchesProcessed()).To(ConsistOf(fake.BatchProcessed{Size: 2}))
+				Expect(metrics.GetPublishedCount()).To(BeZero())
+				Expect(metrics.GetPublishFailureCount()).To(Equal(2))
+			})
+		})
+	})
+})
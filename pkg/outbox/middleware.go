@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+)
+
+// DefaultRetryAttempts is how many times the backoff used by RetryMiddleware retries failed messages by default
+const DefaultRetryAttempts = 3
+
+// PublisherFunc adapts an ordinary function to the Publisher interface, e.g. for implementing a PublisherMiddleware
+type PublisherFunc func(ctx context.Context, messages ...Message) error
+
+// Publish implements the Publisher interface by calling the function
+func (f PublisherFunc) Publish(ctx context.Context, messages ...Message) error {
+	return f(ctx, messages...)
+}
+
+// PublisherMiddleware wraps a Publisher to add behaviour around it, such as logging, metrics or retries, without
+// modifying the Publisher itself
+type PublisherMiddleware func(next Publisher) Publisher
+
+// Chain wraps the base Publisher in each of the middleware, such that the first middleware is the outermost: it is
+// the first to see each call to Publish, and the last to see its outcome
+func Chain(base Publisher, middleware ...PublisherMiddleware) Publisher {
+	publisher := base
+	for idx := len(middleware) - 1; idx >= 0; idx-- {
+		publisher = middleware[idx](publisher)
+	}
+
+	return publisher
+}
+
+// LoggingMiddleware logs each batch of messages published through the Publisher it wraps, along with any messages
+// that failed to publish
+func LoggingMiddleware(logger logr.Logger) PublisherMiddleware {
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(ctx context.Context, messages ...Message) error {
+			logger := logger.WithValues("namespace", NamespaceFromContext(ctx), "count", len(messages))
+
+			logger.V(1).Info("publishing messages")
+			err := next.Publish(ctx, messages...)
+			if err != nil {
+				failed := len(messages)
+				var publishErr *PublishError
+				if errors.As(err, &publishErr) && len(publishErr.Errors) == len(messages) {
+					failed = publishErr.ErrorCount()
+				}
+
+				logger.Error(err, "failed to publish messages", "failed", failed)
+				return err
+			}
+
+			logger.V(1).Info("published messages")
+			return nil
+		})
+	}
+}
+
+// RetryMiddleware retries the messages the Publisher it wraps fails to publish, without publishing again those that
+// were published, waiting between attempts as directed by a fresh backoff from backoffFactory on each call to
+// Publish. Messages rejected with an error wrapping ErrPermanent aren't retried. The clock defaults to a real clock,
+// and the backoffFactory to an exponential backoff retrying up to DefaultRetryAttempts times.
+//
+// The Outbox already retries failed messages on its next pump, so this suits publishers prone to brief failures,
+// where retrying promptly avoids waiting for the next pump and reclaiming the messages' entries.
+func RetryMiddleware(clock Clock, backoffFactory func() backoff.BackOff) PublisherMiddleware {
+	if clock == nil {
+		clock = clockwork.NewRealClock()
+	}
+	if backoffFactory == nil {
+		backoffFactory = func() backoff.BackOff {
+			return backoff.WithMaxRetries(backoff.NewExponentialBackOff(), DefaultRetryAttempts)
+		}
+	}
+
+	return func(next Publisher) Publisher {
+		return PublisherFunc(func(ctx context.Context, messages ...Message) error {
+			errs := make([]error, len(messages))
+			pending := make([]int, len(messages))
+			for idx := range messages {
+				pending[idx] = idx
+			}
+
+			op := func() error {
+				batch := make([]Message, len(pending))
+				for idx, msgIdx := range pending {
+					batch[idx] = messages[msgIdx]
+				}
+
+				var retrying []int
+				for idx, err := range messageErrors(len(batch), next.Publish(ctx, batch...)) {
+					msgIdx := pending[idx]
+					errs[msgIdx] = err
+					if err != nil && !errors.Is(err, ErrPermanent) {
+						retrying = append(retrying, msgIdx)
+					}
+				}
+
+				pending = retrying
+				if len(pending) == 0 {
+					return nil
+				}
+				return errors.New("messages failed to publish")
+			}
+
+			_ = retry(ctx, clock, op, backoffFactory(), func(error, time.Duration) {})
+
+			publishErr := &PublishError{Errors: errs}
+			if publishErr.ErrorCount() > 0 {
+				return publishErr
+			}
+			return nil
+		})
+	}
+}
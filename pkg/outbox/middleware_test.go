@@ -0,0 +1,179 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Publisher middleware", func() {
+	var ctx context.Context
+	var publisher *fake.Publisher
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+	})
+
+	It("chains middleware with the first outermost", func() {
+		var calls []string
+		record := func(name string) outbox.PublisherMiddleware {
+			return func(next outbox.Publisher) outbox.Publisher {
+				return outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+					calls = append(calls, name+" before")
+					err := next.Publish(ctx, messages...)
+					calls = append(calls, name+" after")
+					return err
+				})
+			}
+		}
+
+		chained := outbox.Chain(publisher, record("first"), record("second"))
+		Expect(chained.Publish(ctx, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+		Expect(calls).To(Equal([]string{"first before", "second before", "second after", "first after"}))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+	})
+
+	It("returns the base publisher without middleware", func() {
+		Expect(outbox.Chain(publisher)).To(BeIdenticalTo(publisher))
+	})
+
+	Describe("LoggingMiddleware", func() {
+		var lines []string
+
+		BeforeEach(func() {
+			lines = nil
+		})
+
+		It("logs published and failed messages", func() {
+			logger := funcr.New(func(prefix, args string) {
+				lines = append(lines, args)
+			}, funcr.Options{Verbosity: 1})
+			publisher.FailFunc = func(msg outbox.Message) error {
+				if string(msg.Payload) == "bad" {
+					return errors.New("broker unavailable")
+				}
+				return nil
+			}
+			chained := outbox.Chain(publisher, outbox.LoggingMiddleware(logger))
+
+			Expect(chained.Publish(ctx, outbox.Message{Payload: []byte("good")})).To(Succeed())
+			Expect(chained.Publish(ctx, outbox.Message{Payload: []byte("good")}, outbox.Message{Payload: []byte("bad")})).NotTo(Succeed())
+
+			Expect(strings.Join(lines, "\n")).To(And(
+				ContainSubstring(`"msg"="published messages" "namespace"="" "count"=1`),
+				ContainSubstring(`"msg"="failed to publish messages" "error"="failed to publish 1/2 messages" "namespace"="" "count"=2 "failed"=1`),
+			))
+		})
+	})
+
+	Describe("RetryMiddleware", func() {
+		var clock clockwork.FakeClock
+		var attempts map[string]int
+		var attemptsLock sync.Mutex
+		var chained outbox.Publisher
+
+		BeforeEach(func() {
+			clock = clockwork.NewFakeClock()
+			attempts = map[string]int{}
+			publisher.FailFunc = func(msg outbox.Message) error {
+				attemptsLock.Lock()
+				defer attemptsLock.Unlock()
+
+				payload := string(msg.Payload)
+				attempts[payload]++
+				switch {
+				case payload == "flaky" && attempts[payload] < 3:
+					return errors.New("broker unavailable")
+				case payload == "broken":
+					return errors.New("broker unavailable")
+				case payload == "invalid":
+					return outbox.Permanent(errors.New("message too large"))
+				}
+				return nil
+			}
+
+			chained = outbox.Chain(publisher, outbox.RetryMiddleware(clock, func() backoff.BackOff {
+				return backoff.WithMaxRetries(backoff.NewConstantBackOff(time.Second), 3)
+			}))
+		})
+
+		// publish publishes the messages through the chain in the background, advancing the clock past each backoff
+		// until it returns
+		publish := func(messages ...outbox.Message) error {
+			errChan := make(chan error, 1)
+			go func(chained outbox.Publisher, errChan chan<- error) {
+				errChan <- chained.Publish(ctx, messages...)
+			}(chained, errChan)
+
+			for {
+				select {
+				case err := <-errChan:
+					return err
+				case <-time.After(10 * time.Millisecond):
+					clock.Advance(time.Second)
+				}
+			}
+		}
+
+		// getAttempts returns how many times the message with the payload was published
+		getAttempts := func(payload string) int {
+			attemptsLock.Lock()
+			defer attemptsLock.Unlock()
+
+			return attempts[payload]
+		}
+
+		It("retries transient failures without republishing the messages that succeeded", func() {
+			Expect(publish(
+				outbox.Message{Payload: []byte("steady")},
+				outbox.Message{Payload: []byte("flaky")},
+			)).To(Succeed())
+
+			Expect(getAttempts("steady")).To(Equal(1))
+			Expect(getAttempts("flaky")).To(Equal(3))
+			Expect(publisher.GetPublishedCount()).To(Equal(2))
+		})
+
+		It("gives up once the backoff stops", func() {
+			err := publish(
+				outbox.Message{Payload: []byte("steady")},
+				outbox.Message{Payload: []byte("broken")},
+			)
+
+			var publishErr *outbox.PublishError
+			Expect(errors.As(err, &publishErr)).To(BeTrue())
+			Expect(publishErr.Errors[0]).To(BeNil())
+			Expect(publishErr.Errors[1]).To(MatchError("broker unavailable"))
+			Expect(getAttempts("broken")).To(Equal(4))
+		})
+
+		It("doesn't retry permanent failures", func() {
+			err := publish(
+				outbox.Message{Payload: []byte("flaky")},
+				outbox.Message{Payload: []byte("invalid")},
+			)
+
+			var publishErr *outbox.PublishError
+			Expect(errors.As(err, &publishErr)).To(BeTrue())
+			Expect(publishErr.Errors[0]).To(BeNil())
+			Expect(publishErr.Errors[1]).To(MatchError(outbox.ErrPermanent))
+			Expect(getAttempts("invalid")).To(Equal(1))
+		})
+	})
+})
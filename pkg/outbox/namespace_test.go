@@ -0,0 +1,231 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Namespaces", func() {
+	const processorID = "test"
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var metrics *fake.Metrics
+	var published map[string][]string
+	var ob *outbox.Outbox
+
+	remaining := func() []string {
+		entries, err := storage.GetClaimedEntries(ctx, processorID, 10)
+		Expect(err).To(Succeed())
+
+		payloads := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			payloads = append(payloads, string(entry.Payload))
+		}
+		return payloads
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		metrics = &fake.Metrics{}
+		published = map[string][]string{}
+
+		// the publisher fails any message with a "poison" payload, and every message in the "broken" namespace
+		publisher := outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+			namespace := outbox.NamespaceFromContext(ctx)
+			if namespace == "broken" {
+				return errors.New("namespace unavailable")
+			}
+
+			publishErr := &outbox.PublishError{Errors: make([]error, len(messages))}
+			for idx, msg := range messages {
+				if string(msg.Payload) == "poison" {
+					publishErr.Errors[idx] = errors.New("poison message")
+					continue
+				}
+				published[namespace] = append(published[namespace], string(msg.Payload))
+			}
+
+			if publishErr.ErrorCount() > 0 {
+				return publishErr
+			}
+			return nil
+		})
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   processorID,
+			Metrics:       metrics,
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("only retries the messages that failed in a partially failed namespace", func() {
+		Expect(storage.Publish(outbox.WithNamespace(ctx, "first"), nil,
+			outbox.Message{Payload: []byte("first-1")},
+			outbox.Message{Payload: []byte("first-2")},
+		)).To(Succeed())
+		Expect(storage.Publish(outbox.WithNamespace(ctx, "second"), nil,
+			outbox.Message{Payload: []byte("poison")},
+			outbox.Message{Payload: []byte("second-1")},
+		)).To(Succeed())
+
+		_, err := ob.PumpOutbox(ctx)
+
+		Expect(err).To(MatchError(ContainSubstring("failed to publish 1/2 messages")))
+
+		Expect(published).To(Equal(map[string][]string{
+			"first":  {"first-1", "first-2"},
+			"second": {"second-1"},
+		}))
+		Expect(remaining()).To(ConsistOf("poison"))
+		Expect(metrics.GetPublishedCount()).To(Equal(3))
+		Expect(metrics.GetPublishFailureCount()).To(Equal(1))
+	})
+
+	When("the processor is restricted to a namespace", func() {
+		var publisher *fake.Publisher
+
+		BeforeEach(func() {
+			publisher = &fake.Publisher{}
+
+			var err error
+			ob, err = outbox.New(outbox.Config{
+				Clock:         clock,
+				Storage:       storage,
+				Publisher:     publisher,
+				ClaimDuration: 5 * time.Second,
+				Namespace:     "first",
+				ProcessorID:   processorID,
+			})
+			Expect(err).To(Succeed())
+
+			Expect(storage.Publish(outbox.WithNamespace(ctx, "first"), nil, outbox.Message{Payload: []byte("first-1")})).To(Succeed())
+			Expect(storage.Publish(outbox.WithNamespace(ctx, "second"), nil, outbox.Message{Payload: []byte("second-1")})).To(Succeed())
+			Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("default-1")})).To(Succeed())
+		})
+
+		It("only publishes entries in that namespace", func() {
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			Expect(publisher.GetPublished()).To(HaveLen(1))
+			Expect(publisher.GetPublished()[0].Payload).To(Equal([]byte("first-1")))
+			Expect(storage.CountEntries()).To(Equal(2))
+		})
+
+		It("leaves entries in other namespaces for other processors", func() {
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			other, err := outbox.New(outbox.Config{
+				Clock:       clock,
+				Storage:     storage,
+				Publisher:   publisher,
+				ProcessorID: "other",
+			})
+			Expect(err).To(Succeed())
+
+			Expect(other.PumpOutbox(outbox.WithNamespace(ctx, ""))).Error().To(Succeed())
+			Expect(publisher.GetPublished()).To(HaveLen(2))
+			Expect(publisher.GetPublished()[1].Payload).To(Equal([]byte("default-1")))
+
+			Expect(other.PumpOutbox(ctx)).Error().To(Succeed())
+			Expect(publisher.GetPublished()).To(HaveLen(3))
+			Expect(storage.CountEntries()).To(BeZero())
+		})
+	})
+
+	When("the processor handles several namespaces", func() {
+		var publisher *fake.Publisher
+
+		BeforeEach(func() {
+			publisher = &fake.Publisher{}
+
+			var err error
+			ob, err = outbox.New(outbox.Config{
+				Clock:         clock,
+				Storage:       storage,
+				Publisher:     publisher,
+				ClaimDuration: 5 * time.Second,
+				BatchSize:     1,
+				Namespaces:    []string{"busy", "quiet"},
+				ProcessorID:   processorID,
+			})
+			Expect(err).To(Succeed())
+
+			for _, payload := range []string{"busy-1", "busy-2", "busy-3"} {
+				Expect(storage.Publish(outbox.WithNamespace(ctx, "busy"), nil, outbox.Message{Payload: []byte(payload)})).To(Succeed())
+			}
+			for _, payload := range []string{"quiet-1", "quiet-2"} {
+				Expect(storage.Publish(outbox.WithNamespace(ctx, "quiet"), nil, outbox.Message{Payload: []byte(payload)})).To(Succeed())
+			}
+			Expect(storage.Publish(outbox.WithNamespace(ctx, "ignored"), nil, outbox.Message{Payload: []byte("ignored-1")})).To(Succeed())
+		})
+
+		It("drains every namespace within a pump, taking turns between them", func() {
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			Expect(publishedPayloads(publisher)).To(Equal([]string{"busy-1", "quiet-1", "busy-2", "quiet-2", "busy-3"}))
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+
+		It("keeps draining the other namespaces when one fails", func() {
+			failing, err := outbox.New(outbox.Config{
+				Clock:   clock,
+				Storage: storage,
+				Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+					if outbox.NamespaceFromContext(ctx) == "busy" {
+						return errors.New("namespace unavailable")
+					}
+					return publisher.Publish(ctx, messages...)
+				}),
+				ClaimDuration: 5 * time.Second,
+				BatchSize:     1,
+				Namespaces:    []string{"busy", "quiet"},
+				ProcessorID:   processorID,
+			})
+			Expect(err).To(Succeed())
+
+			_, err = failing.PumpOutbox(ctx)
+
+			Expect(err).To(MatchError(ContainSubstring("namespace unavailable")))
+
+			Expect(publishedPayloads(publisher)).To(Equal([]string{"quiet-1", "quiet-2"}))
+			Expect(storage.CountEntries()).To(Equal(4))
+		})
+	})
+
+	It("publishes the other namespaces when one fails entirely", func() {
+		Expect(storage.Publish(outbox.WithNamespace(ctx, "broken"), nil,
+			outbox.Message{Payload: []byte("broken-1")},
+		)).To(Succeed())
+		Expect(storage.Publish(outbox.WithNamespace(ctx, "working"), nil,
+			outbox.Message{Payload: []byte("working-1")},
+		)).To(Succeed())
+
+		_, err := ob.PumpOutbox(ctx)
+
+		Expect(err).To(MatchError(ContainSubstring("namespace unavailable")))
+
+		Expect(published).To(Equal(map[string][]string{
+			"working": {"working-1"},
+		}))
+		Expect(remaining()).To(ConsistOf("broken-1"))
+	})
+})
@@ -0,0 +1,97 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Option configures the Config of an Outbox constructed with NewOutbox. Options for fields without a dedicated
+// Option can be written as an ordinary function modifying the Config.
+type Option func(cfg *Config)
+
+// NewOutbox attempts to construct an Outbox from the storage, publisher and processor ID it requires, configured by
+// any options, if the resulting Config is valid. It is equivalent to calling New with a Config populated the same way.
+func NewOutbox(storage ProcessorStorage, publisher Publisher, processorID string, opts ...Option) (*Outbox, error) {
+	cfg := Config{
+		Storage:     storage,
+		Publisher:   publisher,
+		ProcessorID: processorID,
+	}
+
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return New(cfg)
+}
+
+// WithClock sets the Config.Clock
+func WithClock(clock Clock) Option {
+	return func(cfg *Config) {
+		cfg.Clock = clock
+	}
+}
+
+// WithBatchSize sets the Config.BatchSize
+func WithBatchSize(batchSize int) Option {
+	return func(cfg *Config) {
+		cfg.BatchSize = batchSize
+	}
+}
+
+// WithProcessInterval sets the Config.ProcessInterval
+func WithProcessInterval(interval time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ProcessInterval = interval
+	}
+}
+
+// WithClaimDuration sets the Config.ClaimDuration
+func WithClaimDuration(duration time.Duration) Option {
+	return func(cfg *Config) {
+		cfg.ClaimDuration = duration
+	}
+}
+
+// WithConcurrency sets the Config.Concurrency
+func WithConcurrency(concurrency int) Option {
+	return func(cfg *Config) {
+		cfg.Concurrency = concurrency
+	}
+}
+
+// WithLogger sets the Config.Logger
+func WithLogger(logger logr.Logger) Option {
+	return func(cfg *Config) {
+		cfg.Logger = logger
+	}
+}
+
+// WithMetrics sets the Config.Metrics
+func WithMetrics(metrics Metrics) Option {
+	return func(cfg *Config) {
+		cfg.Metrics = metrics
+	}
+}
+
+// WithTracer sets the Config.Tracer
+func WithTracer(tracer Tracer) Option {
+	return func(cfg *Config) {
+		cfg.Tracer = tracer
+	}
+}
+
+// WithEventSink sets the Config.EventSink
+func WithEventSink(sink EventSink) Option {
+	return func(cfg *Config) {
+		cfg.EventSink = sink
+	}
+}
+
+// WithCodec sets the Config.Codec
+func WithCodec(codec Codec) Option {
+	return func(cfg *Config) {
+		cfg.Codec = codec
+	}
+}
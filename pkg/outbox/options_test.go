@@ -0,0 +1,120 @@
+package outbox_test
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/funcr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("NewOutbox", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var batches []int
+
+	// batchRecorder records the size of each batch published, before publishing it to the fake publisher
+	batchRecorder := func() outbox.Publisher {
+		return outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+			batches = append(batches, len(messages))
+			return publisher.Publish(ctx, messages...)
+		})
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		batches = nil
+	})
+
+	It("constructs an outbox from the required fields alone", func() {
+		ob, err := outbox.NewOutbox(storage, publisher, "test")
+		Expect(err).To(Succeed())
+
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+	})
+
+	DescribeTable(
+		"required fields are missing",
+		func(build func() (*outbox.Outbox, error), expected string) {
+			_, err := build()
+			Expect(err).To(MatchError("invalid config: " + expected))
+		},
+		Entry("fails without storage", func() (*outbox.Outbox, error) {
+			return outbox.NewOutbox(nil, publisher, "test")
+		}, "no storage provided"),
+		Entry("fails without a publisher", func() (*outbox.Outbox, error) {
+			return outbox.NewOutbox(storage, nil, "test")
+		}, "no publisher provided"),
+		Entry("fails without a processor ID", func() (*outbox.Outbox, error) {
+			return outbox.NewOutbox(storage, publisher, "")
+		}, "no processor ID provided"),
+	)
+
+	It("validates the options", func() {
+		_, err := outbox.NewOutbox(storage, publisher, "test", outbox.WithProcessInterval(-time.Second))
+		Expect(err).To(MatchError(ContainSubstring("invalid config")))
+	})
+
+	It("applies the options", func() {
+		var lines []string
+		logger := funcr.New(func(prefix, args string) {
+			lines = append(lines, args)
+		}, funcr.Options{Verbosity: 1})
+		events := &fake.EventSink{}
+
+		ob, err := outbox.NewOutbox(storage, batchRecorder(), "test",
+			outbox.WithClock(clock),
+			outbox.WithBatchSize(2),
+			outbox.WithLogger(logger),
+			outbox.WithEventSink(events),
+		)
+		Expect(err).To(Succeed())
+
+		for _, payload := range []string{"first", "second", "third"} {
+			Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte(payload)})).To(Succeed())
+		}
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(batches).To(Equal([]int{2, 1}))
+
+		Expect(strings.Join(lines, "\n")).To(ContainSubstring("pumping outbox"))
+		Expect(events.GetEvents()).NotTo(BeEmpty())
+		for _, event := range events.GetEvents() {
+			Expect(event.At).To(Equal(clock.Now()))
+		}
+	})
+
+	It("applies the options in order, so later options take precedence", func() {
+		ob, err := outbox.NewOutbox(storage, batchRecorder(), "test",
+			outbox.WithBatchSize(1),
+			func(cfg *outbox.Config) {
+				cfg.BatchSize = 3
+			},
+		)
+		Expect(err).To(Succeed())
+
+		for _, payload := range []string{"first", "second", "third"} {
+			Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte(payload)})).To(Succeed())
+		}
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(batches).To(Equal([]int{3}))
+	})
+})
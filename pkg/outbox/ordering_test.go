@@ -0,0 +1,120 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Per key ordering", func() {
+	const entriesPerKey = 4
+
+	keys := []string{"a", "b", "c", "d"}
+
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var publishedLock sync.Mutex
+	var published map[string][]string
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		published = map[string][]string{}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			// records the payloads published for each key, in the order they were published
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				publishedLock.Lock()
+				defer publishedLock.Unlock()
+
+				for _, msg := range messages {
+					published[string(msg.Key)] = append(published[string(msg.Key)], string(msg.Payload))
+				}
+				return nil
+			}),
+			ProcessorID: "test",
+			BatchSize:   2,
+			Concurrency: 3,
+			Ordering:    outbox.OrderingPerKey,
+		})
+		Expect(err).To(Succeed())
+
+		// interleave the keys, so that ordering by creation alone would split every key across batches
+		for i := 0; i < entriesPerKey; i++ {
+			for _, key := range keys {
+				msg := outbox.Message{Key: []byte(key), Payload: []byte(fmt.Sprintf("%s-%d", key, i))}
+				Expect(storage.Publish(ctx, nil, msg)).To(Succeed())
+			}
+		}
+	})
+
+	It("publishes each key's entries in the order they were written", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(len(keys) * entriesPerKey))
+
+		for _, key := range keys {
+			var expected []string
+			for i := 0; i < entriesPerKey; i++ {
+				expected = append(expected, fmt.Sprintf("%s-%d", key, i))
+			}
+			Expect(published[key]).To(Equal(expected), "key %s", key)
+		}
+		Expect(storage.CountEntries()).To(BeZero())
+	})
+})
+
+var _ = Describe("Per key ordering with custom values", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var published []string
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		published = nil
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				for _, msg := range messages {
+					published = append(published, string(msg.Payload))
+				}
+				return nil
+			}),
+			ProcessorID: "test",
+			Ordering:    outbox.OrderingPerKey,
+		})
+		Expect(err).To(Succeed())
+
+		// the messages alternate between having values and not, so they can't all be published in one call
+		Expect(ob.Publish(outbox.WithValue(ctx, "topic", "x"), nil, outbox.Message{Key: []byte("key"), Payload: []byte("1")})).To(Succeed())
+		Expect(ob.Publish(ctx, nil, outbox.Message{Key: []byte("key"), Payload: []byte("2")})).To(Succeed())
+		Expect(ob.Publish(outbox.WithValue(ctx, "topic", "x"), nil, outbox.Message{Key: []byte("key"), Payload: []byte("3")})).To(Succeed())
+	})
+
+	It("publishes the key's entries in the order they were written", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+
+		Expect(published).To(Equal([]string{"1", "2", "3"}))
+		Expect(storage.CountEntries()).To(BeZero())
+	})
+})
@@ -4,18 +4,57 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/jonboulle/clockwork"
 	"go.uber.org/multierr"
 )
 
 // Outbox is the primary object in the package that implements the transactional outbox pattern.
 type Outbox struct {
-	config      Config
-	wakeSignal  chan struct{}
+	config Config
+	// processorID is the Config.EffectiveProcessorID the processor claims entries with
+	processorID string
+	// wakeSignal is created each time the processor starts, and is nil while the processor isn't running
+	wakeSignal chan struct{}
+	// stoppedLock guards the processor's lifecycle, serialising StartProcessing claiming processingDone and
+	// wakeSignal with Shutdown and with other calls to StartProcessing, so that only one processor runs at a time
 	stoppedLock sync.RWMutex
+
+	// shutdownSignal is closed by Shutdown to ask the processor to stop once its current pump is complete
+	shutdownSignal chan struct{}
+	shutdownOnce   sync.Once
+	// processingDone is closed when StartProcessing returns, and is nil while the processor isn't running
+	processingDone chan struct{}
+
+	idleLock sync.Mutex
+	// idleSignal is closed, and replaced, each time the processor finishes a pump and waits to pump again
+	idleSignal chan struct{}
+	// wakes counts the calls to WakeProcessor, and handled is how many of them preceded the latest pump
+	wakes, handled uint64
+	pumping        bool
+
+	// stats records the outcome of each pump for Stats
+	stats statsRecorder
+	// pendingDeletes holds the entries that were handled but failed to be deleted, to retry deleting on later pumps
+	pendingDeletes pendingDeletes
+	// pumpLog limits how often each pump is logged to the Config.RoutineLogInterval
+	pumpLog logSuppressor
+	// createdAt is when New created the Outbox, which Healthy measures staleness from until a pump succeeds
+	createdAt time.Time
+
+	// rescheduleSignal interrupts the processor's idle wait so it can account for a newly scheduled message
+	rescheduleSignal chan struct{}
+	scheduleLock     sync.Mutex
+	// scheduled holds the distinct due times of messages published with a NotBefore time, earliest first
+	scheduled []time.Time
+
+	// rand draws from the Config.RandSource, and is only used by the processor
+	rand *rand.Rand
 }
 
 // New attempts to construct an Outbox from the provided Config, if the Config is valid
@@ -25,9 +64,14 @@ func New(cfg Config) (*Outbox, error) {
 	}
 
 	o := &Outbox{
-		config:      cfg,
-		wakeSignal:  make(chan struct{}, 1),
-		stoppedLock: sync.RWMutex{},
+		config:           cfg,
+		processorID:      cfg.EffectiveProcessorID(),
+		stoppedLock:      sync.RWMutex{},
+		shutdownSignal:   make(chan struct{}),
+		rescheduleSignal: make(chan struct{}, 1),
+		idleSignal:       make(chan struct{}),
+		createdAt:        cfg.Clock.Now(),
+		rand:             rand.New(cfg.RandSource),
 	}
 
 	return o, nil
@@ -37,7 +81,9 @@ func New(cfg Config) (*Outbox, error) {
 // to the outbox and it should wake up and process them, rather than wait for the
 // Config.ProcessInterval. For batch write operations, try to only call this once so the
 // processor is likely to wake up fewer times and process them as a batch. This function
-// does not block.
+// does not block: if the processor hasn't yet woken for a previous call, the calls are coalesced into one wake,
+// which is counted by Stats.DroppedWakes. It does nothing if the processor isn't running, as a processor that is
+// started later processes the outbox after its first Config.ProcessInterval.
 func (o *Outbox) WakeProcessor() {
 	o.stoppedLock.RLock()
 	defer o.stoppedLock.RUnlock()
@@ -46,133 +92,1466 @@ func (o *Outbox) WakeProcessor() {
 		return
 	}
 
+	o.idleLock.Lock()
+	o.wakes++
+	o.idleLock.Unlock()
+
 	select {
 	case o.wakeSignal <- struct{}{}:
 	default:
+		o.stats.wakeDropped()
+	}
+}
+
+// WakeProcessorCtx behaves like WakeProcessor, except that rather than coalescing with a wake the processor hasn't
+// yet woken for, it blocks until the processor has taken that wake and accepted this one, guaranteeing that the
+// processor pumps again after WakeProcessorCtx returns. It returns the context's error if the context is done first.
+// Like WakeProcessor it does nothing if the processor isn't running, and it returns if the processor stops while
+// it is waiting.
+func (o *Outbox) WakeProcessorCtx(ctx context.Context) error {
+	o.stoppedLock.RLock()
+	wakeSignal := o.wakeSignal
+	done := o.processingDone
+	o.stoppedLock.RUnlock()
+
+	if wakeSignal == nil {
+		return nil
+	}
+
+	o.idleLock.Lock()
+	o.wakes++
+	o.idleLock.Unlock()
+
+	select {
+	case wakeSignal <- struct{}{}:
+		return nil
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
 // Publish publishes the provided messages to the outbox, and will be forwarded to the configured Publisher during
-// one of the subsequent PumpOutbox calls
+// one of the subsequent PumpOutbox calls. If any messages have a Message.NotBefore time, the processor will
+// wake up as each of them becomes due, rather than waiting for the Config.ProcessInterval. No messages are
+// published if any exceeds the Config.MaxPayloadSize. Payloads are compressed by the Config.Compressor and
+// encrypted by the Config.Encryptor, if any. Values set on the context with WithValue are recorded alongside the
+// messages, and set on the context passed to the Publisher when they are published. The txn is passed to the
+// ProcessorStorage, to record the messages within the application's transaction, or nil for the ProcessorStorage to
+// record them in a transaction of its own.
 func (o *Outbox) Publish(ctx context.Context, txn interface{}, messages ...Message) error {
-	return o.config.Storage.Publish(ctx, txn, messages...)
+	if err := CheckPayloadSize(o.config.MaxPayloadSize, messages...); err != nil {
+		return err
+	}
+
+	encoded, err := o.encodeMessages(ValuesFromContext(ctx), messages)
+	if err != nil {
+		return err
+	}
+
+	if o.config.DedupWithinPublish {
+		ctx = WithDedupWithinPublish(ctx)
+	}
+
+	if err := o.config.Storage.Publish(ctx, txn, encoded...); err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+		if msg.NotBefore != nil {
+			o.scheduleWake(*msg.NotBefore)
+		}
+	}
+
+	return nil
+}
+
+// Replay writes entries that were previously published back to the outbox as new entries, so that they are published
+// again, e.g. to replay a window of events after fixing a bug in a consumer, or to retry dead lettered entries once
+// whatever rejected them is fixed. Entries are written as they were stored, so their payloads aren't compressed or
+// encrypted again, but they are given new IDs, so entries without a ClaimedEntry.DedupKey are published with a new
+// Message.DedupKey. It requires a ProcessorStorage that implements EntryRepublisher, returning ErrReplayUnsupported
+// otherwise.
+func (o *Outbox) Replay(ctx context.Context, entries ...ClaimedEntry) error {
+	republisher, ok := o.config.Storage.(EntryRepublisher)
+	if !ok {
+		return ErrReplayUnsupported
+	}
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return republisher.Republish(ctx, entries...)
+}
+
+// Peek returns at most limit of the entries waiting in the outbox, without claiming them or otherwise affecting their
+// processing, e.g. for showing the backlog in a dashboard. Entries are returned whether or not they are claimed or
+// due yet, and are restricted to the namespace of the context, if it has one, rather than to the Config.Namespaces.
+// Payloads are decompressed and decrypted as they would be for publishing, so it fails if any of the entries can't
+// be. It requires a ProcessorStorage that implements EntryPeeker, returning ErrPeekUnsupported otherwise.
+func (o *Outbox) Peek(ctx context.Context, limit int) ([]ClaimedEntry, error) {
+	peeker, ok := o.config.Storage.(EntryPeeker)
+	if !ok {
+		return nil, ErrPeekUnsupported
+	}
+
+	if limit < 1 {
+		return nil, fmt.Errorf("peek limit must be positive, not %d", limit)
+	}
+
+	entries, err := peeker.PeekEntries(ctx, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err = o.decodeEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
 }
 
-// StartProcessing blocks, processing the outbox until its context is cancelled.
+// BacklogSize counts the entries waiting in the outbox, whether or not they are claimed or due yet, restricted to the
+// namespace of the context, if it has one, rather than to the Config.Namespaces. If the Config.Metrics implement
+// BacklogMetrics the count is recorded with them too, so calling it periodically, e.g. before metrics are scraped,
+// lets operators alert on the backlog growing. It requires a ProcessorStorage that implements PendingCounter,
+// returning ErrCountPendingUnsupported otherwise.
+func (o *Outbox) BacklogSize(ctx context.Context) (int, error) {
+	counter, ok := o.config.Storage.(PendingCounter)
+	if !ok {
+		return 0, ErrCountPendingUnsupported
+	}
+
+	size, err := counter.CountPending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if metrics, ok := o.config.Metrics.(BacklogMetrics); ok {
+		metrics.RecordBacklogSize(size)
+	}
+
+	return size, nil
+}
+
+// scheduleWake records that the processor should wake up at the specified time, interrupting the processor's
+// idle wait if this is now the earliest scheduled wake up
+func (o *Outbox) scheduleWake(at time.Time) {
+	o.scheduleLock.Lock()
+	defer o.scheduleLock.Unlock()
+
+	idx := sort.Search(len(o.scheduled), func(i int) bool {
+		return !o.scheduled[i].Before(at)
+	})
+	if idx < len(o.scheduled) && o.scheduled[idx].Equal(at) {
+		return
+	}
+
+	o.scheduled = append(o.scheduled, time.Time{})
+	copy(o.scheduled[idx+1:], o.scheduled[idx:])
+	o.scheduled[idx] = at
+
+	if idx > 0 {
+		return
+	}
+
+	select {
+	case o.rescheduleSignal <- struct{}{}:
+	default:
+	}
+}
+
+// idleDuration determines how long the processor should wait before pumping the outbox again, which is
+// the provided interval unless a scheduled message becomes due sooner
+func (o *Outbox) idleDuration(interval time.Duration) time.Duration {
+	o.scheduleLock.Lock()
+	defer o.scheduleLock.Unlock()
+
+	idle := interval
+	if len(o.scheduled) > 0 {
+		if untilDue := o.scheduled[0].Sub(o.config.Clock.Now()); untilDue < idle {
+			idle = untilDue
+		}
+	}
+
+	return idle
+}
+
+// clearScheduledWakes forgets any scheduled wake ups that are due by the specified time, as the processor
+// is about to pump the outbox and publish them
+func (o *Outbox) clearScheduledWakes(now time.Time) {
+	o.scheduleLock.Lock()
+	defer o.scheduleLock.Unlock()
+
+	due := 0
+	for due < len(o.scheduled) && !now.Before(o.scheduled[due]) {
+		due++
+	}
+	o.scheduled = o.scheduled[due:]
+}
+
+// resetTimer stops the timer, discarding any expiry that hasn't been received, and restarts it with
+// the provided duration
+func resetTimer(timer clockwork.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.Chan():
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// PublishThenWake publishes the provided messages to the outbox like Publish, then wakes the processor so that they
+// are published without waiting for the Config.ProcessInterval. The processor is not woken if publishing fails.
+// If txn is a transaction that has not yet committed, the processor may wake before the messages are visible to it,
+// in which case they are published when it next wakes, so prefer calling WakeProcessor once the transaction commits.
+func (o *Outbox) PublishThenWake(ctx context.Context, txn interface{}, messages ...Message) error {
+	if err := o.Publish(ctx, txn, messages...); err != nil {
+		return err
+	}
+
+	o.WakeProcessor()
+	return nil
+}
+
+// StartProcessing blocks, processing the outbox until its context is cancelled or Shutdown is called.
 // It wakes up to process regularly based on the Config.ProcessInterval and can be woken
-// manually using WakeProcessor.
+// manually using WakeProcessor. Messages published with a Message.NotBefore time through
+// Publish also cause it to wake up as they become due. Only one call to StartProcessing may run at
+// a time, any other call returns ErrAlreadyProcessing immediately, but once StartProcessing has returned because its
+// context was cancelled it may be called again to restart processing. If its context is cancelled while a pump is in
+// progress, it waits for the pump to return, for up to the Config.ShutdownGrace, and returns the pump's error if it
+// failed for any reason other than the cancellation. Failed pumps are retried, unless the ProcessorStorage failed
+// with an error wrapping ErrPermanent, which is treated as fatal without retrying.
 func (o *Outbox) StartProcessing(ctx context.Context) error {
+	return o.process(ctx, nil)
+}
+
+// StartProcessingWithTrigger behaves like StartProcessing, except that the processor only wakes up to process when
+// it receives from the trigger, or is woken using WakeProcessor, rather than based on the Config.ProcessInterval or
+// messages becoming due. This lets an external scheduler, e.g. a distributed cron, drive how often the outbox is
+// processed. Each receive from the trigger causes one pump, and it returns once the trigger is closed.
+func (o *Outbox) StartProcessingWithTrigger(ctx context.Context, trigger <-chan struct{}) error {
+	if trigger == nil {
+		return errors.New("no trigger provided")
+	}
+
+	return o.process(ctx, trigger)
+}
+
+// process implements StartProcessing and StartProcessingWithTrigger, waking up to process using an internal timer
+// unless a trigger is provided
+func (o *Outbox) process(ctx context.Context, trigger <-chan struct{}) error {
 	logger := o.config.Logger.WithName("processor")
 	logger.Info("outbox processor starting")
 	defer logger.Info("outbox processor exiting")
 
+	// shutdown and starting are serialised by stoppedLock, so either Shutdown waits for this processor or
+	// the processor sees that shutdown has already been requested
+	done := make(chan struct{})
+	o.stoppedLock.Lock()
+	select {
+	case <-o.shutdownSignal:
+		o.stoppedLock.Unlock()
+		logger.Info("shutdown requested")
+		return nil
+	default:
+	}
+	if o.processingDone != nil {
+		o.stoppedLock.Unlock()
+		return ErrAlreadyProcessing
+	}
+	wakeSignal := make(chan struct{}, 1)
+	o.processingDone = done
+	o.wakeSignal = wakeSignal
+	o.stoppedLock.Unlock()
+	defer func() {
+		o.stoppedLock.Lock()
+		o.processingDone = nil
+		o.wakeSignal = nil
+		o.stoppedLock.Unlock()
+		close(done)
+	}()
+
+	if o.config.OnStart != nil {
+		o.config.OnStart()
+	}
+	if o.config.OnStop != nil {
+		defer o.config.OnStop()
+	}
+
+	// retryCtx stops any retries once shutdown is requested, without interrupting a pump that is in progress
+	retryCtx, cancelRetries := context.WithCancel(ctx)
+	defer cancelRetries()
+	go func() {
+		select {
+		case <-o.shutdownSignal:
+			cancelRetries()
+		case <-retryCtx.Done():
+		}
+	}()
+
+	// interval is how long to wait between pumps, which only changes from the Config.ProcessInterval with
+	// Config.AdaptiveInterval
+	interval := o.config.ProcessInterval
+
+	// wait is the interval with jitter applied, which is chosen again after each pump
+	wait := o.jitter(interval)
+
+	// a single timer is used for idle waits, which is reset whenever the time to wait changes, unless the processor
+	// is driven by a trigger instead
+	var timer clockwork.Timer
+	var timerChan <-chan time.Time
+	if trigger == nil {
+		timer = o.config.Clock.NewTimer(o.idleDuration(wait))
+		defer timer.Stop()
+		timerChan = timer.Chan()
+	}
+
+	// failingSince is when pumps started failing, and is zero while they are succeeding
+	var failingSince time.Time
+	retriesExhausted := func() bool {
+		return o.config.MaxProcessingRetryElapsed > 0 && !failingSince.IsZero() &&
+			o.config.Clock.Now().Sub(failingSince) >= o.config.MaxProcessingRetryElapsed
+	}
+
 	for {
+		select {
+		case <-o.shutdownSignal:
+			logger.Info("shutdown requested")
+			return nil
+		default:
+		}
+
 		select {
 		case <-ctx.Done():
 			logger.Info("context cancelled", "reason", ctx.Err())
 			return nil
-		case _, more := <-o.wakeSignal:
+		case <-o.shutdownSignal:
+			logger.Info("shutdown requested")
+			return nil
+		case <-wakeSignal:
 			logger.V(1).Info("wake signal received")
+			if !o.debounceWakes(ctx, wakeSignal) {
+				continue
+			}
+		case <-o.rescheduleSignal:
+			logger.V(1).Info("message scheduled, recalculating wake up time")
+			if timer != nil {
+				resetTimer(timer, o.idleDuration(wait))
+			}
+			continue
+		case <-timerChan:
+			logger.V(1).Info("woken by timer")
+		case _, more := <-trigger:
 			if !more {
+				logger.Info("trigger closed")
 				return nil
 			}
-		case <-o.config.Clock.After(o.config.ProcessInterval):
-			logger.V(1).Info("woken by processing interval")
+			logger.V(1).Info("woken by trigger")
 		}
 
+		o.clearScheduledWakes(o.config.Clock.Now())
+		o.markPumping()
+
+		var processed int
+		var permanent bool
 		op := func() error {
-			if err := o.PumpOutbox(ctx); err != nil {
-				return fmt.Errorf("error pumping outbox: %w", err)
+			var err error
+			processed, err = o.ProcessOnce(ctx)
+			if err != nil {
+				if failingSince.IsZero() {
+					failingSince = o.config.Clock.Now()
+				}
+
+				err = fmt.Errorf("error pumping outbox: %w", err)
+				permanent = permanentStorageFailure(err)
+				if permanent || retriesExhausted() {
+					return backoff.Permanent(err)
+				}
+				return err
 			}
+
+			failingSince = time.Time{}
 			return nil
 		}
 		notify := func(err error, duration time.Duration) {
 			logger.Error(err, "transient error, will retry", "backoff", duration)
 		}
-		bo := backoff.WithContext(backoff.NewExponentialBackOff(), ctx)
-		if err := backoff.RetryNotify(op, bo, notify); err != nil {
-			logger.Error(err, "error, giving up for now")
+		err := o.awaitPump(ctx, func() error {
+			return o.retry(retryCtx, op, o.config.BackoffFactory(), notify)
+		})
+		if errors.Is(err, ErrShutdownGraceExceeded) {
+			logger.Error(err, "abandoning pump in progress")
+			o.stats.setLastError(err)
+			return err
+		}
+		if err != nil && ctx.Err() != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			// the pump failed for some other reason while stopping, which would otherwise go unreported
+			err = fmt.Errorf("pump in progress failed while stopping: %w", err)
+			o.stats.setLastError(err)
+			return err
+		}
+		if err != nil {
+			var fatalErr error
+			switch {
+			case permanent:
+				fatalErr = fmt.Errorf("permanent storage failure: %w", err)
+			case retriesExhausted():
+				fatalErr = fmt.Errorf("failed to pump outbox for over %v: %w", o.config.MaxProcessingRetryElapsed, err)
+			default:
+				logger.Error(err, "error, giving up for now")
+			}
+
+			if ctx.Err() == nil {
+				if fatalErr != nil {
+					o.stats.setLastError(fatalErr)
+				} else {
+					o.stats.setLastError(err)
+				}
+			}
+
+			if fatalErr != nil {
+				if o.config.OnFatalError == nil {
+					logger.Error(fatalErr, "fatal error, stopping")
+					return fatalErr
+				}
+
+				logger.Error(fatalErr, "fatal error")
+				o.config.OnFatalError(fatalErr)
+				failingSince = time.Time{}
+			}
+		} else {
+			o.stats.setLastError(nil)
+			if o.config.AdaptiveInterval {
+				interval = o.adaptInterval(interval, processed)
+			}
+		}
+
+		if timer != nil {
+			wait = o.jitter(interval)
+			resetTimer(timer, o.idleDuration(wait))
+		}
+		o.notifyIdle()
+	}
+}
+
+// debounceWakes waits for the Config.WakeDebounce after the processor is woken, coalescing any other wakes in the
+// meantime. It returns false if the processor should stop rather than pump.
+func (o *Outbox) debounceWakes(ctx context.Context, wakeSignal <-chan struct{}) bool {
+	if o.config.WakeDebounce == 0 {
+		return true
+	}
+
+	timer := o.config.Clock.NewTimer(o.config.WakeDebounce)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-o.shutdownSignal:
+			return false
+		case <-wakeSignal:
+		case <-timer.Chan():
+			return true
+		}
+	}
+}
+
+// awaitPump runs the pump on its own goroutine and waits for it to return, unless the context is cancelled and it
+// doesn't return within the Config.ShutdownGrace, in which case it returns ErrShutdownGraceExceeded
+func (o *Outbox) awaitPump(ctx context.Context, pump func() error) error {
+	pumpDone := make(chan error, 1)
+	go func() {
+		pumpDone <- pump()
+	}()
+
+	select {
+	case err := <-pumpDone:
+		return err
+	case <-ctx.Done():
+	}
+
+	if o.config.ShutdownGrace == 0 {
+		return <-pumpDone
+	}
+
+	timer := o.config.Clock.NewTimer(o.config.ShutdownGrace)
+	defer timer.Stop()
+
+	select {
+	case err := <-pumpDone:
+		return err
+	case <-timer.Chan():
+		return fmt.Errorf("%w of %v", ErrShutdownGraceExceeded, o.config.ShutdownGrace)
+	}
+}
+
+// markPumping records that the processor is starting a pump, which handles every WakeProcessor call so far
+func (o *Outbox) markPumping() {
+	o.idleLock.Lock()
+	defer o.idleLock.Unlock()
+
+	o.pumping = true
+	o.handled = o.wakes
+}
+
+// notifyIdle records that the processor has finished a pump, releasing any callers of WaitForIdle
+func (o *Outbox) notifyIdle() {
+	o.idleLock.Lock()
+	defer o.idleLock.Unlock()
+
+	o.pumping = false
+	close(o.idleSignal)
+	o.idleSignal = make(chan struct{})
+}
+
+// WaitForIdle blocks until the processor started by StartProcessing is waiting to be woken, having finished any
+// pump in progress and a pump for every call to WakeProcessor made before WaitForIdle was called, whether or not
+// they succeeded. Tests can call WakeProcessor, or PublishThenWake, followed by WaitForIdle to deterministically
+// observe the outcome of the pump. It returns an error if the processor isn't running or stops first, or the
+// context's error if the context is done first.
+func (o *Outbox) WaitForIdle(ctx context.Context) error {
+	o.stoppedLock.RLock()
+	done := o.processingDone
+	o.stoppedLock.RUnlock()
+
+	if done == nil {
+		return errors.New("outbox processor is not running")
+	}
+
+	o.idleLock.Lock()
+	target := o.wakes
+	o.idleLock.Unlock()
+
+	for {
+		o.idleLock.Lock()
+		idle := !o.pumping && o.handled >= target
+		signal := o.idleSignal
+		o.idleLock.Unlock()
+
+		if idle {
+			return nil
+		}
+
+		select {
+		case <-signal:
+		case <-done:
+			return errors.New("outbox processor is not running")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// jitter randomises the interval to within plus or minus the Config.IntervalJitter, without going below zero
+func (o *Outbox) jitter(interval time.Duration) time.Duration {
+	if o.config.IntervalJitter == 0 {
+		return interval
+	}
+
+	jittered := interval - o.config.IntervalJitter + time.Duration(o.rand.Int63n(2*int64(o.config.IntervalJitter)+1))
+	if jittered < 0 {
+		return 0
+	}
+
+	return jittered
+}
+
+// minAdaptiveInterval is the shortest non-zero wait between pumps with Config.AdaptiveInterval, shorter waits are
+// rounded down to zero, and it is the first step when growing the wait from zero
+const minAdaptiveInterval = time.Millisecond
+
+// adaptInterval adjusts the wait between pumps for Config.AdaptiveInterval given how many entries the last pump
+// processed. The wait is halved after a pump that processed a full batch for every worker, as more entries are
+// likely waiting, and doubled, up to the Config.ProcessInterval, after a pump that found the outbox empty.
+func (o *Outbox) adaptInterval(interval time.Duration, processed int) time.Duration {
+	switch {
+	case processed >= o.config.BatchSize*o.config.Concurrency:
+		interval /= 2
+		if interval < minAdaptiveInterval {
+			interval = 0
+		}
+	case processed == 0:
+		interval *= 2
+		if interval < minAdaptiveInterval {
+			interval = minAdaptiveInterval
+		}
+		if interval > o.config.ProcessInterval {
+			interval = o.config.ProcessInterval
 		}
 	}
+
+	return interval
+}
+
+// ProcessOnce processes the outbox once, claiming entries and publishing every batch of them until none remain, and
+// returns the total number of entries processed. It suits short lived invocations, e.g. serverless functions or cron
+// jobs, where StartProcessing can't run indefinitely. The pump is limited by the Config.PumpTimeout, as with
+// StartProcessing, but a failed pump is not retried, its error is returned for the caller to handle instead.
+func (o *Outbox) ProcessOnce(ctx context.Context) (processed int, err error) {
+	pumpCtx, cancelPump := o.withPumpTimeout(ctx)
+	defer cancelPump()
+
+	processed, err = o.PumpOutbox(pumpCtx)
+	if err != nil && pumpCtx.Err() != nil && ctx.Err() == nil {
+		err = fmt.Errorf("pump timed out after %v: %w", o.config.PumpTimeout, err)
+	}
+
+	return processed, err
+}
+
+// withPumpTimeout derives a context for a single pump that is cancelled once the Config.PumpTimeout elapses,
+// according to the Config.Clock so that timeouts can be tested. The returned function must be called once the
+// pump completes.
+func (o *Outbox) withPumpTimeout(ctx context.Context) (context.Context, func()) {
+	return o.withTimeout(ctx, o.config.PumpTimeout)
+}
+
+// withTimeout derives a context that is cancelled once the timeout elapses, according to the Config.Clock, or that
+// is only cancelled with its parent if the timeout is zero. The returned function must be called once the context is
+// no longer needed.
+func (o *Outbox) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, func()) {
+	if timeout == 0 {
+		return ctx, func() {}
+	}
+
+	timeoutCtx, cancel := context.WithCancel(ctx)
+	timer := o.config.Clock.NewTimer(timeout)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		select {
+		case <-timer.Chan():
+			cancel()
+		case <-timeoutCtx.Done():
+		}
+	}()
+
+	return timeoutCtx, func() {
+		cancel()
+		timer.Stop()
+		<-done
+	}
+}
+
+// cleanupContext provides the context to delete handled entries with, which is the provided context unless it is
+// already done, in which case it is a context detached from it that is cancelled after the Config.CleanupTimeout.
+// The returned function must be called once the context is no longer needed.
+func (o *Outbox) cleanupContext(ctx context.Context) (context.Context, func()) {
+	if ctx.Err() == nil {
+		return ctx, func() {}
+	}
+
+	return o.withTimeout(detachedContext{parent: ctx}, o.config.CleanupTimeout)
+}
+
+// detachedContext carries the values of its parent without being cancelled along with it, as context.WithoutCancel
+// does in later versions of Go
+type detachedContext struct {
+	parent context.Context
+}
+
+// Deadline implements context.Context, a detachedContext has no deadline
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// Done implements context.Context, a detachedContext is never done
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+// Err implements context.Context, a detachedContext is never done
+func (detachedContext) Err() error {
+	return nil
+}
+
+// Value implements context.Context, returning the parent's values
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
+
+// retry calls op until it succeeds, returns a *backoff.PermanentError, the backoff stops or the context is done,
+// returning the last error. Between attempts it calls notify and waits for the backoff's delay, using the
+// Config.Clock so that retries can be tested.
+func (o *Outbox) retry(ctx context.Context, op func() error, bo backoff.BackOff, notify func(error, time.Duration)) error {
+	return retry(ctx, o.config.Clock, op, bo, notify)
+}
+
+// retry calls op until it succeeds, returns a *backoff.PermanentError, the backoff stops or the context is done,
+// returning the last error. Between attempts it calls notify and waits for the backoff's delay on the clock.
+func retry(ctx context.Context, clock Clock, op func() error, bo backoff.BackOff, notify func(error, time.Duration)) error {
+	bo.Reset()
+
+	for {
+		err := op()
+		if err == nil {
+			return nil
+		}
+		if permanent, ok := err.(*backoff.PermanentError); ok {
+			return permanent.Err
+		}
+
+		next := bo.NextBackOff()
+		if next == backoff.Stop || ctx.Err() != nil {
+			return err
+		}
+		notify(err, next)
+
+		timer := clock.NewTimer(next)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.Chan():
+		}
+	}
+}
+
+// Shutdown gracefully stops StartProcessing: any pump in progress is allowed to finish, but no new work is
+// claimed and transient errors are not retried. It blocks until StartProcessing has returned or the provided
+// context is done, in which case the context's error is returned. Once Shutdown has been called, subsequent
+// calls to StartProcessing return immediately.
+func (o *Outbox) Shutdown(ctx context.Context) error {
+	o.stoppedLock.Lock()
+	o.shutdownOnce.Do(func() {
+		close(o.shutdownSignal)
+	})
+	done := o.processingDone
+	o.stoppedLock.Unlock()
+
+	if done == nil {
+		return nil
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // PumpOutbox causes the Outbox to process entries immediately. This is typically not called directly,
 // instead called from StartProcessing. However, this is exposed partially for ease of testing, but
 // also to facilitate customising the processing logic if the provided StartProcessing function isn't
-// suitable for your application.
-func (o *Outbox) PumpOutbox(ctx context.Context) (err error) {
-	o.config.Logger.V(1).Info("pumping outbox")
+// suitable for your application. It returns how many entries were processed, whether or not they were
+// published successfully, so that callers can tell whether the outbox was empty.
+//
+// The contexts passed to the Config.Storage and Config.Publisher are derived from ctx, so request scoped values set
+// on it, such as a tenant ID or credentials, reach them unchanged. The Outbox only adds to them: the ContextSettings
+// it needs, such as the namespace being processed and the custom values of the entries being published, which take
+// precedence over custom values with the same names set on ctx, and whatever the Config.Tracer adds to the context
+// it is given.
+func (o *Outbox) PumpOutbox(ctx context.Context) (processed int, err error) {
+	return o.recordPump(ctx, o.namespaceScopes(ctx))
+}
+
+// FlushNamespace immediately claims and publishes every pending entry in the namespace, whether or not the processor
+// is restricted to other namespaces, returning once the namespace has been drained. Like PumpOutbox, it only flushes
+// the entries in the processor's shard if Config.ShardCount is set. Request handlers can use it to
+// have the messages they have just written published before they respond. It returns how many entries were
+// processed, whether or not they were published successfully. Like PumpOutbox it claims entries as the
+// Config.ProcessorID, so entries the processor has already claimed are included.
+func (o *Outbox) FlushNamespace(ctx context.Context, namespace string) (processed int, err error) {
+	return o.recordPump(ctx, []context.Context{WithNamespace(o.shardScope(ctx), namespace)})
+}
+
+// recordPump pumps the outbox for PumpOutbox and FlushNamespace, recording the outcome in the Stats and reporting it
+// to the Config.OnPumpComplete callback
+func (o *Outbox) recordPump(ctx context.Context, scopes []context.Context) (processed int, err error) {
+	o.stats.pumpStarted()
+	processed, err = o.pump(ctx, scopes)
+	o.stats.pumpFinished(o.config.Clock.Now(), processed, err)
 
-	deadline := o.config.Clock.Now().Add(o.config.ClaimDuration)
-	if err := o.config.Storage.ClaimEntries(ctx, o.config.ProcessorID, deadline); err != nil {
-		return fmt.Errorf("error claiming entries: %w", err)
+	if o.config.OnPumpComplete != nil {
+		o.config.OnPumpComplete(processed, err)
 	}
 
+	return processed, err
+}
+
+// pump claims, unless Config.SkipClaim is set, and processes entries in each of the namespace scopes, returning how
+// many entries were processed
+func (o *Outbox) pump(ctx context.Context, scopes []context.Context) (processed int, err error) {
+	if log, suppressed := o.pumpLog.allow(o.config.Clock.Now(), o.config.RoutineLogInterval); log {
+		o.config.Logger.V(1).Info("pumping outbox", "suppressed", suppressed)
+	}
+
+	// entries that failed to be deleted are deleted before they could be published again
+	if err := o.retryPendingDeletes(ctx); err != nil {
+		return 0, err
+	}
+
+	if o.config.SkipClaim {
+		return o.drain(scopes)
+	}
+
+	claimStart := o.config.Clock.Now()
+	deadline := claimStart.Add(o.claimDuration())
+	for _, scope := range scopes {
+		if o.config.ClaimAffinity {
+			scope = WithClaimAffinity(scope, o.config.ProcessInterval)
+		}
+		if err := o.config.Storage.ClaimEntries(scope, o.processorID, deadline); err != nil {
+			return 0, storageError("claiming entries", err)
+		}
+	}
+	o.config.Metrics.RecordClaimDuration(o.config.Clock.Now().Sub(claimStart))
+
+	renewCtx, stopRenewing := context.WithCancel(ctx)
+	renewDone := make(chan struct{})
+	go func() {
+		defer close(renewDone)
+		o.renewClaims(renewCtx)
+	}()
+	defer func() {
+		stopRenewing()
+		<-renewDone
+	}()
+
+	return o.drain(scopes)
+}
+
+// drain takes turns processing batches from each namespace, so that a busy namespace can't starve the others, until
+// every namespace is drained or has failed, returning how many entries were processed
+func (o *Outbox) drain(scopes []context.Context) (processed int, err error) {
+	var errs []error
+	for len(scopes) > 0 {
+		var pending []context.Context
+		for _, scope := range scopes {
+			count, more, err := o.processBatches(scope)
+			processed += count
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error processing batch of outbox entries: %w", err))
+				if o.config.FailureMode == HaltOnFirstFailure {
+					return processed, multierr.Combine(errs...)
+				}
+				continue
+			}
+
+			if more {
+				pending = append(pending, scope)
+			}
+		}
+		scopes = pending
+	}
+
+	return processed, multierr.Combine(errs...)
+}
+
+// namespaceScopes provides a context for claiming and processing the entries of each of the configured
+// Config.Namespaces, or just the provided context if there are none, restricted to the Config.ShardIndex if entries
+// are sharded
+func (o *Outbox) namespaceScopes(ctx context.Context) []context.Context {
+	ctx = o.shardScope(ctx)
+
+	if len(o.config.Namespaces) == 0 {
+		return []context.Context{ctx}
+	}
+
+	scopes := make([]context.Context, 0, len(o.config.Namespaces))
+	for _, namespace := range o.config.Namespaces {
+		scopes = append(scopes, WithNamespace(ctx, namespace))
+	}
+
+	return scopes
+}
+
+// shardScope restricts the context to the Config.ShardIndex if entries are sharded
+func (o *Outbox) shardScope(ctx context.Context) context.Context {
+	if o.config.ShardCount > 0 {
+		return WithShard(ctx, o.config.ShardIndex, o.config.ShardCount)
+	}
+
+	return ctx
+}
+
+// claimDuration computes how long to claim entries for with Config.ClaimDurationFunc, given how many entries each
+// pump expects to process at once
+func (o *Outbox) claimDuration() time.Duration {
+	return o.config.ClaimDurationFunc(o.config.BatchSize * o.config.Concurrency)
+}
+
+// renewClaims periodically extends the processor's claim on its entries until the context is done, so that
+// entries taking longer than the claim duration to publish are not claimed by another processor
+func (o *Outbox) renewClaims(ctx context.Context) {
+	timer := o.config.Clock.NewTimer(o.config.ClaimRenewalInterval)
+	defer timer.Stop()
+
 	for {
-		more, err := o.processBatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.Chan():
+		}
+
+		deadline := o.config.Clock.Now().Add(o.claimDuration())
+		if err := o.config.Storage.RenewClaim(ctx, o.processorID, deadline); err != nil && ctx.Err() == nil {
+			o.config.Logger.Error(err, "error renewing claim on outbox entries")
+		}
+
+		timer.Reset(o.config.ClaimRenewalInterval)
+	}
+}
+
+// processBatches retrieves up to Config.Concurrency batches worth of claimed entries, and processes each
+// batch concurrently. Each batch is a distinct subset of the retrieved entries, so no entry is published twice.
+// It returns how many entries were retrieved, and whether there may be more to retrieve.
+func (o *Outbox) processBatches(ctx context.Context) (processed int, more bool, err error) {
+	limit := o.config.BatchSize * o.config.Concurrency
+
+	query := WithMaxEntriesPerKey(WithOrdering(ctx, o.config.Ordering), o.config.MaxEntriesPerKeyPerBatch)
+	if o.config.SelectionOrder != OldestFirst {
+		query = WithSelectionOrder(query, o.config.SelectionOrder)
+	}
+	if o.config.FairnessKey != nil {
+		query = WithFairnessKey(query, o.config.FairnessKey)
+	}
+	var entries []ClaimedEntry
+	if o.config.SkipClaim {
+		entries, err = o.config.Storage.(UnclaimedEntryGetter).GetUnclaimedEntries(query, limit)
 		if err != nil {
-			return fmt.Errorf("error processing batch of outbox entries: %w", err)
+			return 0, false, storageError("getting unclaimed entries", err)
 		}
+	} else {
+		entries, err = o.config.Storage.GetClaimedEntries(query, o.processorID, limit)
+		if err != nil {
+			return 0, false, storageError("getting claimed entries", err)
+		}
+	}
+
+	// there is nothing to publish or delete, so neither the publisher nor the storage are called again
+	if len(entries) == 0 {
+		return 0, false, nil
+	}
+
+	processed = len(entries)
+	more = processed >= limit
+	if o.config.MaxEntriesPerKeyPerBatch > 0 {
+		// entries skipped to limit each key may remain even though fewer than the limit were retrieved
+		more = processed > 0
+	}
 
-		if !more {
-			break
+	workloads := o.splitWorkloads(entries)
+	if len(workloads) == 1 {
+		return processed, more, o.processWorkload(ctx, workloads[0])
+	}
+
+	errs := make([]error, len(workloads))
+	var wg sync.WaitGroup
+	for idx, workload := range workloads {
+		wg.Add(1)
+		go func(idx int, workload []ClaimedEntry) {
+			defer wg.Done()
+
+			if err := ctx.Err(); err != nil {
+				errs[idx] = err
+				return
+			}
+
+			errs[idx] = o.processWorkload(ctx, workload)
+		}(idx, workload)
+	}
+	wg.Wait()
+
+	return processed, more, multierr.Combine(errs...)
+}
+
+// splitWorkloads divides the entries between at most Config.Concurrency workers. Without ordering each worker is
+// given a single batch, whereas with OrderingPerKey all of a key's entries are given to the same worker, so that
+// they are published in order, with each key given to whichever worker has the fewest entries so far.
+func (o *Outbox) splitWorkloads(entries []ClaimedEntry) [][]ClaimedEntry {
+	if o.config.Ordering != OrderingPerKey || len(entries) == 0 {
+		return splitBatches(entries, o.config.BatchSize)
+	}
+
+	workloads := make([][]ClaimedEntry, 0, o.config.Concurrency)
+	workers := map[string]int{}
+	for _, entry := range entries {
+		worker, ok := workers[string(entry.Key)]
+		if !ok {
+			worker = len(workloads)
+			if worker < o.config.Concurrency {
+				workloads = append(workloads, nil)
+			} else {
+				worker = 0
+				for idx := range workloads {
+					if len(workloads[idx]) < len(workloads[worker]) {
+						worker = idx
+					}
+				}
+			}
+			workers[string(entry.Key)] = worker
 		}
+
+		workloads[worker] = append(workloads[worker], entry)
 	}
 
-	return nil
+	return workloads
 }
 
-func (o *Outbox) processBatch(ctx context.Context) (more bool, err error) {
-	entries, err := o.config.Storage.GetClaimedEntries(ctx, o.config.ProcessorID, o.config.BatchSize)
-	if err != nil {
-		return false, fmt.Errorf("error getting claimed entries: %w", err)
+// processWorkload processes a worker's entries one batch at a time, in order
+func (o *Outbox) processWorkload(ctx context.Context, entries []ClaimedEntry) error {
+	var errs []error
+	for idx, batch := range splitBatches(entries, o.config.BatchSize) {
+		if idx > 0 {
+			if err := ctx.Err(); err != nil {
+				return multierr.Combine(append(errs, err)...)
+			}
+		}
+
+		if err := o.processBatch(ctx, batch); err != nil {
+			errs = append(errs, err)
+			if o.config.FailureMode == HaltOnFirstFailure {
+				break
+			}
+		}
+	}
+
+	return multierr.Combine(errs...)
+}
+
+// splitBatches divides entries into consecutive batches of at most batchSize entries, always returning
+// at least one (possibly empty) batch
+func splitBatches(entries []ClaimedEntry, batchSize int) [][]ClaimedEntry {
+	batches := make([][]ClaimedEntry, 0, (len(entries)+batchSize-1)/batchSize)
+	for len(entries) > batchSize {
+		batches = append(batches, entries[:batchSize])
+		entries = entries[batchSize:]
+	}
+
+	return append(batches, entries)
+}
+
+// processBatch drops any entries that have exceeded Config.MaxEntryAge, dead letters any that have exceeded
+// Config.MaxAttempts and publishes the rest, deleting those that were handled successfully. Entries the Publisher
+// rejects permanently are dead lettered too, while those whose messages the Config.Transform drops are deleted
+// without being published. Failing to dead letter entries does not prevent the others being published.
+func (o *Outbox) processBatch(ctx context.Context, entries []ClaimedEntry) (err error) {
+	batchStart := o.config.Clock.Now()
+	batchSize := len(entries)
+
+	claimed := make(map[string]ClaimedEntry, len(entries))
+	for _, entry := range entries {
+		claimed[entry.ID] = entry
+		o.config.EventSink.MessageClaimed(newMessageEvent(entry, batchStart))
+	}
+
+	entries, pendingIDs := o.partitionPendingDeletes(entries)
+	entries, expired := o.partitionExpired(entries, batchStart)
+	entries, deadLetters := o.partitionDeadLetters(entries)
+
+	var expiredIDs []string
+	if o.config.DeadLetterExpired {
+		deadLetters = append(deadLetters, expired...)
+	} else {
+		for _, entry := range expired {
+			expiredIDs = append(expiredIDs, entry.ID)
+		}
+	}
+	if len(expired) > 0 {
+		o.config.Metrics.RecordExpired(len(expired))
 	}
 
-	more = len(entries) >= o.config.BatchSize
+	// entries that can't be decoded are left to be retried, while dead letters are passed on even if they can't be
+	entries, decodeErr := o.decodeEntries(entries)
+	for idx, entry := range deadLetters {
+		if decoded, err := o.decodeEntry(entry); err == nil {
+			deadLetters[idx] = decoded
+		}
+	}
 
-	entryIDs := make([]string, 0, len(entries))
-	namespaced := make(map[string][]Message)
+	messages := make([]Message, 0, len(entries))
+	byID := make(map[string]ClaimedEntry, len(entries))
 	for _, entry := range entries {
-		entryIDs = append(entryIDs, entry.ID)
+		byID[entry.ID] = entry
 
+		_, headers := splitValues(entry.Headers)
 		msg := Message{
-			Key:     entry.Key,
-			Payload: entry.Payload,
+			Key:          entry.Key,
+			Payload:      entry.Payload,
+			Headers:      headers,
+			TraceContext: entry.TraceContext,
+			DedupKey:     entry.DedupKey,
+			Priority:     entry.Priority,
+			EnqueuedAt:   entry.CreatedAt,
+		}
+		if msg.DedupKey == "" {
+			msg.DedupKey = entry.ID
 		}
 
-		namespaced[entry.Namespace] = append(namespaced[entry.Namespace], msg)
+		messages = append(messages, msg)
 	}
 
-	defer func() {
-		deletableIDs := entryIDs
+	// entries whose messages are dropped by the transform are deleted without being published
+	publishable, messages, droppedIDs, transformErr := o.transformMessages(ctx, entries, messages)
 
-		if err != nil {
-			deletableIDs = make([]string, 0, len(entries))
+	// consecutive messages are published together only if they share a namespace and values, as they share a context,
+	// so that messages are still published in the order they were retrieved
+	var namespaced []*namespaceBatch
+	var lastGroup string
+	for idx, entry := range publishable {
+		msg := messages[idx]
+		values, _ := splitValues(entry.Headers)
 
-			var publishErr *PublishError
-			if errors.As(err, &publishErr) {
-				for idx, err := range publishErr.Errors {
-					if err != nil {
-						continue
-					}
+		group := entry.Namespace + valuesKey(values)
+		if len(namespaced) == 0 || group != lastGroup {
+			namespaced = append(namespaced, &namespaceBatch{
+				namespace: entry.Namespace,
+				values:    values,
+			})
+			lastGroup = group
+		}
+		batch := namespaced[len(namespaced)-1]
+		batch.entryIDs = append(batch.entryIDs, entry.ID)
+		batch.messages = append(batch.messages, msg)
+	}
+
+	// only trace batches with work to do, so idle pumps don't produce empty spans
+	if len(messages) > 0 || len(deadLetters) > 0 {
+		var endSpan func(err error)
+		ctx, endSpan = o.config.Tracer.StartBatch(ctx, messages)
+		defer func() {
+			endSpan(err)
+		}()
+	}
+
+	deadLetteredIDs, deadLetterErr := o.deadLetter(ctx, deadLetters)
+
+	publishedIDs, rejectedIDs, attempted, publishErr := o.publish(ctx, namespaced)
+
+	// entries that failed to publish, or weren't attempted, may be released to be retried without waiting for their
+	// claims to expire
+	handled := make(map[string]bool, len(publishedIDs)+len(rejectedIDs))
+	for _, id := range append(append([]string(nil), publishedIDs...), rejectedIDs...) {
+		handled[id] = true
+	}
+	for _, id := range droppedIDs {
+		handled[id] = true
+	}
+	var failedIDs []string
+	for _, entry := range entries {
+		if !handled[entry.ID] {
+			failedIDs = append(failedIDs, entry.ID)
+		}
+	}
+
+	// messages the publisher will never accept are dead lettered rather than retried
+	rejected := make([]ClaimedEntry, 0, len(rejectedIDs))
+	for _, id := range rejectedIDs {
+		rejected = append(rejected, byID[id])
+	}
+	rejectedIDs, rejectErr := o.deadLetter(ctx, rejected)
+
+	if failed := attempted - len(publishedIDs); failed > 0 {
+		o.config.Metrics.RecordPublishFailure(failed)
+	}
+	if published := len(publishedIDs); published > 0 {
+		o.config.Metrics.RecordPublished(published)
+	}
+
+	deletable := append(append(append(append(append(publishedIDs, deadLetteredIDs...), expiredIDs...), rejectedIDs...), pendingIDs...), droppedIDs...)
+	// entries that were published must be deleted even if the pump was cancelled while publishing them
+	cleanupCtx, cancelCleanup := o.cleanupContext(ctx)
+	deleted, deleteErr := o.deleteEntries(cleanupCtx, deletable)
+	cancelCleanup()
+
+	// entries that were handled but not deleted are only deleted on later pumps, rather than handled again
+	o.pendingDeletes.remove(deleted...)
+	undeleted := make([]ClaimedEntry, 0, len(deletable)-len(deleted))
+	for _, id := range excludeIDs(deletable, deleted) {
+		undeleted = append(undeleted, claimed[id])
+	}
+	o.pendingDeletes.add(undeleted...)
+	deletedAt := o.config.Clock.Now()
+	for _, id := range deleted {
+		o.config.EventSink.MessageDeleted(newMessageEvent(claimed[id], deletedAt))
+	}
+
+	releaseErr := o.releaseEntries(ctx, failedIDs)
+
+	if batchSize > 0 {
+		o.config.Metrics.RecordBatchProcessed(batchSize, o.config.Clock.Now().Sub(batchStart))
+	}
+
+	return multierr.Combine(decodeErr, transformErr, deadLetterErr, publishErr, rejectErr, deleteErr, releaseErr)
+}
+
+// releaseEntries releases the claims on the entries if Config.ReleaseFailed is set, so that they can be retried
+// straight away. There are no claims to release if Config.SkipClaim is set.
+func (o *Outbox) releaseEntries(ctx context.Context, entryIDs []string) error {
+	if !o.config.ReleaseFailed || o.config.SkipClaim || len(entryIDs) == 0 {
+		return nil
+	}
+
+	releaser := o.config.Storage.(EntryReleaser)
+	return storageError("releasing entries", releaser.ReleaseEntries(ctx, o.processorID, entryIDs...))
+}
+
+// deleteEntries deletes the entries in chunks of at most Config.DeleteChunkSize, carrying on with the remaining
+// chunks if any fail so that as few entries as possible are published again. It returns the IDs of the entries in
+// the chunks that were deleted successfully.
+func (o *Outbox) deleteEntries(ctx context.Context, entryIDs []string) (deleted []string, err error) {
+	if len(entryIDs) == 0 {
+		return nil, nil
+	}
+
+	var errs []error
+	for _, chunk := range splitIDs(entryIDs, o.config.DeleteChunkSize) {
+		if err := o.config.Storage.DeleteEntries(ctx, chunk...); err != nil {
+			errs = append(errs, storageError("deleting entries", err))
+			continue
+		}
+		deleted = append(deleted, chunk...)
+	}
+
+	return deleted, multierr.Combine(errs...)
+}
+
+// splitIDs divides the IDs into chunks of at most size IDs
+func splitIDs(ids []string, size int) [][]string {
+	chunks := make([][]string, 0, (len(ids)+size-1)/size)
+	for len(ids) > size {
+		chunks = append(chunks, ids[:size])
+		ids = ids[size:]
+	}
+
+	return append(chunks, ids)
+}
+
+// deadLetter passes the entries to the Config.DeadLetterHandler, returning the IDs of the entries that may be
+// deleted as a result
+func (o *Outbox) deadLetter(ctx context.Context, entries []ClaimedEntry) ([]string, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	if err := o.config.DeadLetterHandler.DeadLetter(ctx, entries...); err != nil {
+		return nil, fmt.Errorf("error dead lettering entries: %w", err)
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+
+	return ids, nil
+}
+
+// namespaceBatch holds a run of consecutive messages of a batch that belong to one namespace and have the same custom
+// values, alongside the IDs of the entries they were read from
+type namespaceBatch struct {
+	namespace string
+	values    map[string]string
+	entryIDs  []string
+	messages  []Message
+}
 
-					deletableIDs = append(deletableIDs, entryIDs[idx])
+// publish publishes each run of messages sharing a namespace and set of custom values to the Config.Publisher, in
+// order, returning the IDs of the entries that were published successfully, the IDs of those that were rejected
+// permanently, and how many messages were attempted. A run failing to publish does not prevent the others being
+// published, unless the Config.FailureMode is HaltOnFirstFailure. Permanent rejections are not treated as failures to
+// publish, as retrying them can't succeed.
+func (o *Outbox) publish(ctx context.Context, namespaced []*namespaceBatch) (published, rejected []string, attempted int, err error) {
+	var errs []error
+	for _, batch := range namespaced {
+		namespace := batch.namespace
+		publishCtx := withValues(WithNamespace(ctx, namespace), batch.values)
+
+		if o.config.FailureMode == HaltOnFirstFailure {
+			// publish one message at a time, so that nothing is published after the first failure
+			for idx := range batch.messages {
+				ids, rejectedIDs, err := o.publishNamespace(publishCtx, namespace, batch.entryIDs[idx:idx+1], batch.messages[idx:idx+1])
+				published = append(published, ids...)
+				rejected = append(rejected, rejectedIDs...)
+				attempted++
+				if err != nil {
+					return published, rejected, attempted, err
 				}
 			}
+			continue
+		}
+
+		ids, rejectedIDs, err := o.publishNamespace(publishCtx, namespace, batch.entryIDs, batch.messages)
+		published = append(published, ids...)
+		rejected = append(rejected, rejectedIDs...)
+		attempted += len(batch.messages)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return published, rejected, attempted, multierr.Combine(errs...)
+}
+
+// publisherFor selects the Publisher for the namespace with the Config.PublisherResolver, if any, falling back to the
+// Config.Publisher
+func (o *Outbox) publisherFor(namespace string) Publisher {
+	if o.config.PublisherResolver != nil {
+		if publisher := o.config.PublisherResolver(namespace); publisher != nil {
+			return publisher
+		}
+	}
+
+	return o.config.Publisher
+}
+
+// publishNamespace publishes messages from a single namespace in one call to the namespace's Publisher, returning
+// the IDs of the entries that were published successfully and of those that failed with an error wrapping
+// ErrPermanent. It only returns an error if some messages failed transiently.
+func (o *Outbox) publishNamespace(ctx context.Context, namespace string, entryIDs []string, messages []Message) (published, rejected []string, err error) {
+	publisher := o.publisherFor(namespace)
+	if o.config.MessagePublishTimeout > 0 {
+		err = o.publishEach(ctx, publisher, messages)
+	} else {
+		err = publisher.Publish(ctx, messages...)
+	}
+	var publishErr *PublishError
+	if errors.As(err, &publishErr) && len(publishErr.Errors) != len(messages) {
+		err = fmt.Errorf("publisher reported outcomes for %v of %v messages, treating all as failed: %w",
+			len(publishErr.Errors), len(messages), err)
+		o.config.Logger.Error(err, "publisher returned a malformed publish error", "namespace", namespace)
+	}
+
+	transient := false
+	publishedAt := o.config.Clock.Now()
+	for idx, msgErr := range messageErrors(len(messages), err) {
+		event := MessageEvent{
+			EntryID:   entryIDs[idx],
+			Namespace: namespace,
+			Key:       messages[idx].Key,
+			At:        publishedAt,
+		}
+		if msgErr == nil {
+			o.config.EventSink.MessagePublished(event)
+		} else {
+			o.config.EventSink.MessageFailed(event, msgErr)
 		}
 
-		if deleteErr := o.config.Storage.DeleteEntries(ctx, deletableIDs...); deleteErr != nil {
-			err = multierr.Combine(err, deleteErr)
+		switch {
+		case msgErr == nil:
+			published = append(published, entryIDs[idx])
+		case errors.Is(msgErr, ErrPermanent):
+			rejected = append(rejected, entryIDs[idx])
+		default:
+			transient = true
+		}
+		o.onPublish(ctx, messages[idx], msgErr)
+	}
+
+	if transient {
+		return published, rejected, fmt.Errorf("error publishing to namespace %q: %w", namespace, err)
+	}
+
+	return published, rejected, nil
+}
+
+// publishEach publishes the messages one at a time with SingleMessagePublisher.PublishMessage, limiting each to the
+// Config.MessagePublishTimeout, and returns a PublishError describing any that failed. It fails every message if the
+// publisher, e.g. one selected by the Config.PublisherResolver, can't publish single messages.
+func (o *Outbox) publishEach(ctx context.Context, next Publisher, messages []Message) error {
+	publisher, ok := next.(SingleMessagePublisher)
+	if !ok {
+		return errors.New("publisher does not support publishing single messages")
+	}
+
+	publishErr := &PublishError{Errors: make([]error, len(messages))}
+	for idx, msg := range messages {
+		msgCtx, cancel := o.withTimeout(ctx, o.config.MessagePublishTimeout)
+		err := publisher.PublishMessage(msgCtx, msg)
+		if err != nil && msgCtx.Err() != nil && ctx.Err() == nil {
+			err = fmt.Errorf("message publish timed out after %v: %w", o.config.MessagePublishTimeout, err)
+		}
+		cancel()
+
+		publishErr.Errors[idx] = err
+	}
+
+	if publishErr.ErrorCount() > 0 {
+		return publishErr
+	}
+	return nil
+}
+
+// messageErrors determines the outcome of publishing each of count messages, based on the error returned when
+// publishing them. A PublishError that doesn't describe every message is treated as a total failure.
+func messageErrors(count int, err error) []error {
+	errs := make([]error, count)
+	if err == nil {
+		return errs
+	}
+
+	var publishErr *PublishError
+	if errors.As(err, &publishErr) && len(publishErr.Errors) == count {
+		copy(errs, publishErr.Errors)
+		return errs
+	}
+
+	for idx := range errs {
+		errs[idx] = err
+	}
+
+	return errs
+}
+
+// onPublish passes the outcome of publishing a message to the Config.OnPublish callback, if any, recovering
+// from any panic so that it cannot disrupt the processor
+func (o *Outbox) onPublish(ctx context.Context, msg Message, err error) {
+	if o.config.OnPublish == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			o.config.Logger.Error(fmt.Errorf("%v", r), "publish callback panicked")
 		}
 	}()
 
-	for namespace, messages := range namespaced {
-		publishCtx := WithNamespace(ctx, namespace)
+	o.config.OnPublish(ctx, msg, err)
+}
+
+// partitionExpired separates entries that were created more than Config.MaxEntryAge before now from those that
+// should be published. Entries without a ClaimedEntry.CreatedAt never expire.
+func (o *Outbox) partitionExpired(entries []ClaimedEntry, now time.Time) (publishable, expired []ClaimedEntry) {
+	if o.config.MaxEntryAge == 0 {
+		return entries, nil
+	}
+
+	cutoff := now.Add(-o.config.MaxEntryAge)
+	publishable = make([]ClaimedEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.CreatedAt.IsZero() && entry.CreatedAt.Before(cutoff) {
+			expired = append(expired, entry)
+		} else {
+			publishable = append(publishable, entry)
+		}
+	}
+
+	return publishable, expired
+}
 
-		if err := o.config.Publisher.Publish(publishCtx, messages...); err != nil {
-			return more, fmt.Errorf("error publishing: %w", err)
+// partitionDeadLetters separates entries that have exceeded Config.MaxAttempts from those that should be published
+func (o *Outbox) partitionDeadLetters(entries []ClaimedEntry) (publishable, deadLetters []ClaimedEntry) {
+	if o.config.MaxAttempts < 1 {
+		return entries, nil
+	}
+
+	publishable = make([]ClaimedEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Attempts > o.config.MaxAttempts {
+			deadLetters = append(deadLetters, entry)
+		} else {
+			publishable = append(publishable, entry)
 		}
 	}
 
-	return more, nil
+	return publishable, deadLetters
 }
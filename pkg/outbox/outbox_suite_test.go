@@ -5,9 +5,20 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
 )
 
 func TestOutbox(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Outbox Suite")
 }
+
+// publishedPayloads returns the payloads of the messages the publisher has published, in the order it published them
+func publishedPayloads(publisher *fake.Publisher) []string {
+	var payloads []string
+	for _, msg := range publisher.GetPublished() {
+		payloads = append(payloads, string(msg.Payload))
+	}
+	return payloads
+}
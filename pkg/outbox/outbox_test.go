@@ -2,6 +2,7 @@ package outbox_test
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -100,10 +101,21 @@ var _ = Describe("Outbox", func() {
 			Eventually(errChan, 1*time.Second).Should(Receive(nil))
 		})
 
+		It("counts the entries processed by a manual pump", func() {
+			for i := 0; i < 7; i++ {
+				Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte(fmt.Sprintf("message-%d", i))})).To(Succeed())
+			}
+
+			Expect(ob.PumpOutbox(ctx)).To(Equal(7))
+			Expect(publisher.GetPublishedCount()).To(Equal(7))
+
+			Expect(ob.PumpOutbox(ctx)).To(BeZero())
+		})
+
 		When("the outbox is pumped manually", func() {
 			JustBeforeEach(func() {
 				logger.Info("manually pumping outbox")
-				Expect(ob.PumpOutbox(ctx)).To(Succeed())
+				Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
 			})
 
 			When("the outbox was empty", func() {
@@ -117,8 +129,9 @@ var _ = Describe("Outbox", func() {
 
 				BeforeEach(func() {
 					testMessage = outbox.Message{
-						Key:     []byte("test-key"),
-						Payload: []byte("test-payload"),
+						Key:      []byte("test-key"),
+						Payload:  []byte("test-payload"),
+						DedupKey: "test-dedup-key",
 					}
 
 					ctx = outbox.WithNamespace(ctx, testNamespace)
@@ -128,8 +141,10 @@ var _ = Describe("Outbox", func() {
 				})
 
 				It("publishes the message", func() {
+					expected := testMessage
+					expected.EnqueuedAt = clock.Now()
 					Expect(publisher.GetPublished()).To(ConsistOf(fake.PublishedMessage{
-						Message:   testMessage,
+						Message:   expected,
 						Namespace: testNamespace,
 					}))
 				})
@@ -137,6 +152,61 @@ var _ = Describe("Outbox", func() {
 				It("clears the outbox", func() {
 					Expect(storage.CountEntries()).To(BeNumerically("==", 0))
 				})
+
+				It("publishes without headers", func() {
+					Expect(publisher.GetPublished()[0].Headers).To(BeNil())
+				})
+			})
+
+			When("the outbox contained a scheduled message", func() {
+				var notBefore time.Time
+
+				BeforeEach(func() {
+					notBefore = clock.Now().Add(time.Minute)
+
+					logger.Info("storing a scheduled message in the outbox")
+					Expect(storage.Publish(ctx, nil, outbox.Message{
+						Payload:   []byte("scheduled"),
+						NotBefore: &notBefore,
+					})).To(Succeed())
+				})
+
+				It("does not publish the message before it is due", func() {
+					Expect(publisher.GetPublishedCount()).To(BeNumerically("==", 0))
+					Expect(storage.CountEntries()).To(BeNumerically("==", 1))
+				})
+
+				It("publishes the message once it is due", func() {
+					clock.Advance(time.Minute)
+					Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+					Expect(publisher.GetPublishedCount()).To(BeNumerically("==", 1))
+					Expect(storage.CountEntries()).To(BeNumerically("==", 0))
+				})
+			})
+
+			When("the outbox contained a message with headers", func() {
+				var testMessage outbox.Message
+
+				BeforeEach(func() {
+					testMessage = outbox.Message{
+						Key:     []byte("test-key"),
+						Payload: []byte("test-payload"),
+						Headers: map[string][]byte{
+							"content-type": []byte("application/json"),
+							"source":       []byte("test"),
+						},
+					}
+
+					logger.Info("storing a message with headers in the outbox")
+					Expect(storage.Publish(ctx, nil, testMessage)).To(Succeed())
+				})
+
+				It("publishes the message with its headers", func() {
+					published := publisher.GetPublished()
+					Expect(published).To(HaveLen(1))
+					Expect(published[0].Headers).To(Equal(testMessage.Headers))
+				})
 			})
 		})
 
@@ -167,15 +237,71 @@ var _ = Describe("Outbox", func() {
 				Eventually(errChan, 1*time.Second).Should(Receive(nil))
 			})
 
+			// the processor computes its wait from the current time, so these specs hold whether or not the
+			// processor has reset its timer before the clock is advanced
+			When("a scheduled message is published", func() {
+				var delay time.Duration
+
+				JustBeforeEach(func() {
+					delay = cfg.ProcessInterval / 4
+					notBefore := clock.Now().Add(delay)
+
+					logger.Info("publishing a scheduled message")
+					Expect(ob.Publish(ctx, nil, outbox.Message{NotBefore: &notBefore})).To(Succeed())
+				})
+
+				It("publishes when the message is due, before the processing interval", func() {
+					clock.Advance(delay / 2)
+					Consistently(func() int {
+						return publisher.GetPublishedCount()
+					}, 100*time.Millisecond).Should(BeNumerically("==", 0))
+
+					clock.Advance(delay / 2)
+					Eventually(func() int {
+						return publisher.GetPublishedCount()
+					}).Should(BeNumerically("==", 1))
+				})
+			})
+
+			When("several scheduled messages are published", func() {
+				var delay time.Duration
+
+				JustBeforeEach(func() {
+					delay = cfg.ProcessInterval / 4
+					first := clock.Now().Add(delay)
+					second := clock.Now().Add(2 * delay)
+
+					logger.Info("publishing scheduled messages")
+					Expect(ob.Publish(ctx, nil,
+						outbox.Message{Payload: []byte("second"), NotBefore: &second},
+						outbox.Message{Payload: []byte("first"), NotBefore: &first},
+					)).To(Succeed())
+				})
+
+				It("wakes up as each message becomes due", func() {
+					clock.Advance(delay)
+					Eventually(func() int {
+						return publisher.GetPublishedCount()
+					}).Should(BeNumerically("==", 1))
+					Expect(publisher.GetPublished()[0].Payload).To(Equal([]byte("first")))
+
+					clock.Advance(delay)
+					Eventually(func() int {
+						return publisher.GetPublishedCount()
+					}).Should(BeNumerically("==", 2))
+				})
+			})
+
 			When("a message is published", func() {
 				JustBeforeEach(func() {
 					ctx = outbox.WithNamespace(ctx, testNamespace)
 
 					logger.Info("publishing a message")
-					Expect(ob.Publish(ctx, nil, outbox.Message{})).To(Succeed())
+					Expect(ob.Publish(ctx, nil, outbox.Message{DedupKey: "test-dedup-key"})).To(Succeed())
 				})
 
 				It("publishes after the processing interval", func() {
+					enqueuedAt := clock.Now()
 					initialDelay := cfg.ProcessInterval / 2
 					logger.Info("advancing time", "delay", initialDelay)
 					clock.Advance(initialDelay)
@@ -191,7 +317,7 @@ var _ = Describe("Outbox", func() {
 
 					Expect(publisher.GetPublished()[0]).To(Equal(
 						fake.PublishedMessage{
-							Message:   outbox.Message{},
+							Message:   outbox.Message{DedupKey: "test-dedup-key", EnqueuedAt: enqueuedAt},
 							Namespace: testNamespace,
 						}),
 					)
@@ -0,0 +1,91 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Payload size limit", func() {
+	const maxPayloadSize = 4
+
+	var ctx context.Context
+	var storage *fake.EntryStorage
+
+	underLimit := outbox.Message{Payload: []byte("four")}
+	overLimit := outbox.Message{Payload: []byte("five!")}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		storage = &fake.EntryStorage{
+			Clock: clockwork.NewFakeClock(),
+		}
+	})
+
+	Describe("CheckPayloadSize", func() {
+		It("accepts payloads within the limit", func() {
+			Expect(outbox.CheckPayloadSize(maxPayloadSize, underLimit, outbox.Message{})).To(Succeed())
+		})
+
+		It("rejects payloads over the limit", func() {
+			err := outbox.CheckPayloadSize(maxPayloadSize, underLimit, overLimit)
+			Expect(errors.Is(err, outbox.ErrPayloadTooLarge)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("message 1")))
+		})
+
+		It("accepts payloads of any size without a limit", func() {
+			Expect(outbox.CheckPayloadSize(0, overLimit)).To(Succeed())
+		})
+	})
+
+	Describe("Outbox.Publish", func() {
+		var ob *outbox.Outbox
+
+		BeforeEach(func() {
+			var err error
+			ob, err = outbox.New(outbox.Config{
+				Storage:        storage,
+				Publisher:      &fake.Publisher{},
+				ClaimDuration:  5 * time.Second,
+				ProcessorID:    "test",
+				MaxPayloadSize: maxPayloadSize,
+			})
+			Expect(err).To(Succeed())
+		})
+
+		It("publishes payloads within the limit", func() {
+			Expect(ob.Publish(ctx, nil, underLimit)).To(Succeed())
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+
+		It("rejects every message if any payload is over the limit", func() {
+			err := ob.Publish(ctx, nil, underLimit, overLimit)
+			Expect(errors.Is(err, outbox.ErrPayloadTooLarge)).To(BeTrue())
+			Expect(storage.CountEntries()).To(BeZero())
+		})
+	})
+
+	Describe("fake.EntryStorage.Publish", func() {
+		BeforeEach(func() {
+			storage.MaxPayloadSize = maxPayloadSize
+		})
+
+		It("records payloads within the limit", func() {
+			Expect(storage.Publish(ctx, nil, underLimit)).To(Succeed())
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+
+		It("rejects payloads over the limit", func() {
+			err := storage.Publish(ctx, nil, overLimit)
+			Expect(errors.Is(err, outbox.ErrPayloadTooLarge)).To(BeTrue())
+			Expect(storage.CountEntries()).To(BeZero())
+		})
+	})
+})
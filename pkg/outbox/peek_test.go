@@ -0,0 +1,166 @@
+package outbox_test
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/compress/gzip"
+	"github.com/omaskery/outboxen/pkg/encrypt/aesgcm"
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Peek", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+			IDGenerator: func(msg outbox.Message) string {
+				return string(msg.Payload)
+			},
+		}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   &fake.Publisher{Logger: logr.Discard()},
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+	})
+
+	// peekedIDs peeks at up to limit entries, returning their IDs
+	peekedIDs := func(ctx context.Context, limit int) []string {
+		entries, err := ob.Peek(ctx, limit)
+		Expect(err).To(Succeed())
+
+		ids := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			ids = append(ids, entry.ID)
+		}
+		return ids
+	}
+
+	It("returns the waiting entries, highest priority first, up to the limit", func() {
+		Expect(ob.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("first")},
+			outbox.Message{Payload: []byte("urgent"), Priority: 1},
+			outbox.Message{Payload: []byte("second")},
+		)).To(Succeed())
+
+		Expect(peekedIDs(ctx, 10)).To(Equal([]string{"urgent", "first", "second"}))
+		Expect(peekedIDs(ctx, 2)).To(Equal([]string{"urgent", "first"}))
+	})
+
+	It("doesn't alter the claims on the entries", func() {
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+		entries, err := ob.Peek(ctx, 10)
+		Expect(err).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Attempts).To(BeZero())
+
+		Expect(storage.ClaimEntries(ctx, "test", clock.Now().Add(time.Minute))).To(Succeed())
+		claimed, err := storage.GetClaimedEntries(ctx, "test", 10)
+		Expect(err).To(Succeed())
+		Expect(claimed).To(HaveLen(1))
+		Expect(claimed[0].Attempts).To(Equal(1))
+		Expect(entries[0].Attempts).To(BeZero())
+	})
+
+	It("returns entries regardless of which processor has claimed them, or whether they are due", func() {
+		Expect(ob.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("claimed")},
+		)).To(Succeed())
+		Expect(storage.ClaimEntries(ctx, "other", clock.Now().Add(time.Minute))).To(Succeed())
+		Expect(ob.Publish(ctx, nil,
+			outbox.NewMessage([]byte("scheduled"), outbox.WithNotBefore(clock.Now().Add(time.Hour))),
+		)).To(Succeed())
+
+		Expect(peekedIDs(ctx, 10)).To(Equal([]string{"claimed", "scheduled"}))
+		Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+		Expect(storage.CountEntries()).To(Equal(2))
+	})
+
+	It("only returns entries in the namespace of the context", func() {
+		Expect(ob.Publish(outbox.WithNamespace(ctx, "orders"), nil, outbox.Message{Payload: []byte("order")})).To(Succeed())
+		Expect(ob.Publish(outbox.WithNamespace(ctx, "payments"), nil, outbox.Message{Payload: []byte("payment")})).To(Succeed())
+
+		Expect(peekedIDs(outbox.WithNamespace(ctx, "orders"), 10)).To(Equal([]string{"order"}))
+		Expect(peekedIDs(ctx, 10)).To(ConsistOf("order", "payment"))
+	})
+
+	When("payloads are compressed and encrypted", func() {
+		var encoded *outbox.Outbox
+
+		BeforeEach(func() {
+			compressor, err := gzip.New(gzip.Config{})
+			Expect(err).To(Succeed())
+			encryptor, err := aesgcm.New(aesgcm.Config{
+				Keys:  map[string][]byte{"key": bytes.Repeat([]byte{1}, 32)},
+				KeyID: "key",
+			})
+			Expect(err).To(Succeed())
+
+			encoded, err = outbox.New(outbox.Config{
+				Clock:                clock,
+				Storage:              storage,
+				Publisher:            &fake.Publisher{Logger: logr.Discard()},
+				ProcessorID:          "test",
+				Compressor:           compressor,
+				CompressionThreshold: 1,
+				Encryptor:            encryptor,
+			})
+			Expect(err).To(Succeed())
+
+			Expect(encoded.Publish(ctx, nil, outbox.Message{
+				Payload: []byte("personal data"),
+				Headers: map[string][]byte{"type": []byte("order")},
+			})).To(Succeed())
+		})
+
+		It("returns the decoded payloads, without the encoding headers", func() {
+			entries, err := encoded.Peek(ctx, 10)
+			Expect(err).To(Succeed())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Payload).To(Equal([]byte("personal data")))
+			Expect(entries[0].Headers).To(Equal(map[string][]byte{"type": []byte("order")}))
+		})
+
+		It("fails if the entries can't be decoded", func() {
+			_, err := ob.Peek(ctx, 10)
+			Expect(err).To(MatchError(ContainSubstring("no encryptor is configured")))
+		})
+	})
+
+	It("fails with a limit that isn't positive", func() {
+		_, err := ob.Peek(ctx, 0)
+		Expect(err).To(MatchError("peek limit must be positive, not 0"))
+	})
+
+	It("fails with a storage that can't peek at entries", func() {
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Storage:     struct{ outbox.ProcessorStorage }{storage},
+			Publisher:   &fake.Publisher{Logger: logr.Discard()},
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		_, err = ob.Peek(ctx, 10)
+		Expect(err).To(MatchError(outbox.ErrPeekUnsupported))
+	})
+})
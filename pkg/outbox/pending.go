@@ -0,0 +1,114 @@
+package outbox
+
+import (
+	"context"
+	"sync"
+)
+
+// pendingDeletes tracks the entries that were published, or otherwise handled, but that failed to be deleted, so that
+// the processor retries deleting them rather than publishing them again. It is kept in memory, so entries whose claim
+// expires before they are deleted may still be published again by another processor.
+type pendingDeletes struct {
+	lock    sync.Mutex
+	entries map[string]ClaimedEntry
+}
+
+// add records that the entries are waiting to be deleted
+func (p *pendingDeletes) add(entries ...ClaimedEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.entries == nil {
+		p.entries = make(map[string]ClaimedEntry, len(entries))
+	}
+	for _, entry := range entries {
+		p.entries[entry.ID] = entry
+	}
+}
+
+// remove forgets the entries with the IDs, once they have been deleted
+func (p *pendingDeletes) remove(entryIDs ...string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, id := range entryIDs {
+		delete(p.entries, id)
+	}
+}
+
+// has reports whether the entry with the ID is waiting to be deleted
+func (p *pendingDeletes) has(entryID string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	_, ok := p.entries[entryID]
+	return ok
+}
+
+// list returns the IDs of the entries waiting to be deleted, along with the entries themselves
+func (p *pendingDeletes) list() (entryIDs []string, entries map[string]ClaimedEntry) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	entries = make(map[string]ClaimedEntry, len(p.entries))
+	for id, entry := range p.entries {
+		entryIDs = append(entryIDs, id)
+		entries[id] = entry
+	}
+	return entryIDs, entries
+}
+
+// retryPendingDeletes retries deleting the entries that were handled by an earlier pump but failed to be deleted,
+// without publishing them again
+func (o *Outbox) retryPendingDeletes(ctx context.Context) error {
+	entryIDs, entries := o.pendingDeletes.list()
+	if len(entryIDs) == 0 {
+		return nil
+	}
+
+	deleted, err := o.deleteEntries(ctx, entryIDs)
+	o.pendingDeletes.remove(deleted...)
+
+	deletedAt := o.config.Clock.Now()
+	for _, id := range deleted {
+		o.config.EventSink.MessageDeleted(newMessageEvent(entries[id], deletedAt))
+	}
+
+	return err
+}
+
+// partitionPendingDeletes separates the entries that were already handled by an earlier pump, but failed to be
+// deleted, from those that should be processed
+func (o *Outbox) partitionPendingDeletes(entries []ClaimedEntry) (processable []ClaimedEntry, pendingIDs []string) {
+	processable = make([]ClaimedEntry, 0, len(entries))
+	for _, entry := range entries {
+		if o.pendingDeletes.has(entry.ID) {
+			pendingIDs = append(pendingIDs, entry.ID)
+			continue
+		}
+
+		processable = append(processable, entry)
+	}
+
+	return processable, pendingIDs
+}
+
+// excludeIDs returns the IDs that aren't excluded, in their original order
+func excludeIDs(ids []string, excluded []string) []string {
+	skip := make(map[string]bool, len(excluded))
+	for _, id := range excluded {
+		skip[id] = true
+	}
+
+	var remaining []string
+	for _, id := range ids {
+		if !skip[id] {
+			remaining = append(remaining, id)
+		}
+	}
+	return remaining
+}
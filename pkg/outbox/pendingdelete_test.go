@@ -0,0 +1,93 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Retrying failed deletes", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var events *fake.EventSink
+	var ob *outbox.Outbox
+
+	connectionReset := errors.New("connection reset")
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+			IDGenerator: func(msg outbox.Message) string {
+				return string(msg.Payload)
+			},
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		events = &fake.EventSink{}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			EventSink:   events,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+	})
+
+	It("deletes the published entries on the next pump without publishing them again", func() {
+		storage.DeleteErr = fake.FailFirst(1, connectionReset)
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("deleting entries")))
+		Expect(storage.CountEntries()).To(Equal(1))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+		Expect(events.GetEventTypes("hello")).To(Equal([]fake.EventType{
+			fake.EventClaimed, fake.EventPublished, fake.EventDeleted,
+		}))
+	})
+
+	It("keeps retrying the delete without publishing again while it fails", func() {
+		storage.DeleteErr = fake.FailFirst(3, connectionReset)
+
+		for i := 0; i < 3; i++ {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(MatchError(ContainSubstring("deleting entries")))
+		}
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(1))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("publishes new entries once the failed delete is retried", func() {
+		storage.DeleteErr = fake.FailFirst(1, connectionReset)
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(HaveOccurred())
+
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("world")})).To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		Expect(publisher.GetPublished()).To(HaveLen(2))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+})
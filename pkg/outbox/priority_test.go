@@ -0,0 +1,107 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Priority", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		cfg = outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+		}
+
+		for _, msg := range []outbox.Message{
+			{Payload: []byte("bulk-1")},
+			{Payload: []byte("urgent-1"), Priority: 10},
+			{Payload: []byte("backfill"), Priority: -1},
+			{Payload: []byte("bulk-2")},
+			{Payload: []byte("urgent-2"), Priority: 10},
+		} {
+			Expect(storage.Publish(ctx, nil, msg)).To(Succeed())
+			clock.Advance(time.Second)
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("publishes higher priority messages first within a batch", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(5))
+
+		Expect(publishedPayloads(publisher)).To(Equal([]string{"urgent-1", "urgent-2", "bulk-1", "bulk-2", "backfill"}))
+	})
+
+	It("includes the priority in published messages", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(5))
+
+		published := publisher.GetPublished()
+		Expect(published).To(HaveLen(5))
+		Expect(published[0].Priority).To(Equal(10))
+		Expect(published[4].Priority).To(Equal(-1))
+	})
+
+	When("the batch mixes namespaces and custom values", func() {
+		BeforeEach(func() {
+			Expect(storage.Publish(outbox.WithNamespace(ctx, "alpha"), nil, outbox.Message{
+				Payload: []byte("bulk-3"),
+			})).To(Succeed())
+			clock.Advance(time.Second)
+			Expect(storage.Publish(outbox.WithValue(outbox.WithNamespace(ctx, "zeta"), "topic", "alerts"), nil, outbox.Message{
+				Payload:  []byte("urgent-3"),
+				Priority: 10,
+			})).To(Succeed())
+		})
+
+		It("still publishes higher priority messages first", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(7))
+
+			Expect(publishedPayloads(publisher)).To(Equal([]string{
+				"urgent-1", "urgent-2", "urgent-3", "bulk-1", "bulk-2", "bulk-3", "backfill",
+			}))
+		})
+	})
+
+	When("the batch is smaller than the backlog", func() {
+		BeforeEach(func() {
+			cfg.BatchSize = 2
+		})
+
+		It("publishes the highest priority messages in the first batch", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(5))
+
+			Expect(publishedPayloads(publisher)[:2]).To(Equal([]string{"urgent-1", "urgent-2"}))
+		})
+	})
+})
@@ -0,0 +1,107 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("ProcessOnce", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		cfg = outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+			BatchSize:   10,
+		}
+
+		for i := 0; i < 25; i++ {
+			Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte(fmt.Sprint(i))})).To(Succeed())
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("drains every batch in one call, returning the total processed", func() {
+		Expect(ob.ProcessOnce(ctx)).To(Equal(25))
+
+		Expect(publisher.GetPublishedCount()).To(Equal(25))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("processes nothing once the outbox is empty", func() {
+		Expect(ob.ProcessOnce(ctx)).To(Equal(25))
+		Expect(ob.ProcessOnce(ctx)).To(Equal(0))
+	})
+
+	It("returns errors without retrying", func() {
+		claims := 0
+		storage.ClaimErr = func() error {
+			claims++
+			return errors.New("connection reset")
+		}
+
+		_, err := ob.ProcessOnce(ctx)
+		Expect(err).To(MatchError(ContainSubstring("connection reset")))
+		Expect(claims).To(Equal(1))
+		Expect(publisher.GetPublishedCount()).To(Equal(0))
+	})
+
+	When("a pump timeout is configured", func() {
+		const pumpTimeout = 5 * time.Second
+
+		BeforeEach(func() {
+			cfg.PumpTimeout = pumpTimeout
+			publisher.Delay = time.Minute
+			publisher.Clock = clock
+		})
+
+		It("stops the pump once it times out", func() {
+			errChan := make(chan error, 1)
+			go func() {
+				_, err := ob.ProcessOnce(ctx)
+				errChan <- err
+			}()
+
+			// the pump timeout, the claim renewal and the publisher's delay timers
+			clock.BlockUntil(3)
+			clock.Advance(pumpTimeout)
+
+			var err error
+			Eventually(errChan).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("pump timed out")))
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(storage.CountEntries()).To(Equal(25))
+		})
+	})
+})
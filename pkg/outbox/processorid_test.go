@@ -0,0 +1,123 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("EffectiveProcessorID", func() {
+	DescribeTable("composes the processor ID with the namespaces it is restricted to",
+		func(cfg outbox.Config, expected string) {
+			cfg.ProcessorID = "worker"
+			Expect(cfg.EffectiveProcessorID()).To(Equal(expected))
+		},
+		Entry("unrestricted", outbox.Config{}, "worker"),
+		Entry("a namespace", outbox.Config{Namespace: "orders"}, `worker["orders"]`),
+		Entry("namespaces", outbox.Config{Namespaces: []string{"payments", "orders"}}, `worker["orders","payments"]`),
+		Entry("the empty namespace", outbox.Config{Namespaces: []string{""}}, `worker[""]`),
+		Entry("namespaces that need quoting", outbox.Config{Namespaces: []string{`a","b`}}, `worker["a\",\"b"]`),
+	)
+
+	When("processors for different namespaces share a processor ID", func() {
+		const claimDuration = 3 * time.Second
+		const renewalInterval = time.Second
+
+		var ctx context.Context
+		var clock clockwork.FakeClock
+		var storage *fake.EntryStorage
+		var cfg outbox.Config
+
+		// processor creates a processor restricted to the namespace
+		processor := func(namespace string, publisher outbox.Publisher) *outbox.Outbox {
+			nsCfg := cfg
+			nsCfg.Namespace = namespace
+			nsCfg.Publisher = publisher
+
+			ob, err := outbox.New(nsCfg)
+			Expect(err).To(Succeed())
+			return ob
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			clock = clockwork.NewFakeClock()
+			storage = &fake.EntryStorage{
+				Clock: clock,
+			}
+
+			cfg = outbox.Config{
+				Clock:                clock,
+				Storage:              storage,
+				ProcessorID:          "worker",
+				ClaimDuration:        claimDuration,
+				ClaimRenewalInterval: renewalInterval,
+			}
+
+			Expect(storage.Publish(outbox.WithNamespace(ctx, "orders"), nil, outbox.Message{Payload: []byte("order")})).To(Succeed())
+			Expect(storage.Publish(outbox.WithNamespace(ctx, "payments"), nil, outbox.Message{Payload: []byte("payment")})).To(Succeed())
+		})
+
+		It("claims entries with distinct identities", func() {
+			Expect(processor("orders", &fake.Publisher{Logger: logr.Discard()}).PumpOutbox(ctx)).To(Equal(1))
+
+			Expect(storage.GetClaimedEntries(ctx, `worker["payments"]`, 10)).To(BeEmpty())
+			Expect(storage.GetUnclaimedEntries(outbox.WithNamespace(ctx, "payments"), 10)).To(HaveLen(1))
+		})
+
+		It("doesn't keep the other namespace's entries claimed", func() {
+			// the payments processor claims its entry and fails to publish it, then stops
+			failing := &fake.Publisher{
+				Logger: logr.Discard(),
+				FailFunc: func(outbox.Message) error {
+					return errors.New("broker unavailable")
+				},
+			}
+			_, err := processor("payments", failing).PumpOutbox(ctx)
+			Expect(err).To(HaveOccurred())
+
+			// the orders processor renews its claims while it publishes slowly
+			publishing := make(chan struct{}, 1)
+			release := make(chan struct{})
+			slow := outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				publishing <- struct{}{}
+				<-release
+				return nil
+			})
+			pumpErr := make(chan error, 1)
+			go func(ob *outbox.Outbox, pumpErr chan<- error) {
+				_, err := ob.PumpOutbox(ctx)
+				pumpErr <- err
+			}(processor("orders", slow), pumpErr)
+			Eventually(publishing).Should(Receive())
+
+			for elapsed := time.Duration(0); elapsed <= claimDuration; elapsed += renewalInterval {
+				clock.BlockUntil(1)
+				clock.Advance(renewalInterval)
+			}
+
+			// the payment's claim expired, as the orders processor didn't renew it, so another can publish it
+			publisher := &fake.Publisher{Logger: logr.Discard()}
+			other := cfg
+			other.ProcessorID = "other"
+			other.Namespace = "payments"
+			other.Publisher = publisher
+			otherOb, err := outbox.New(other)
+			Expect(err).To(Succeed())
+			Expect(otherOb.PumpOutbox(ctx)).To(Equal(1))
+			Expect(publisher.GetPublishedCount()).To(Equal(1))
+
+			close(release)
+			Eventually(pumpErr).Should(Receive(BeNil()))
+		})
+	})
+})
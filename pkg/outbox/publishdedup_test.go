@@ -0,0 +1,90 @@
+package outbox_test
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("DedupWithinPublish", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+
+		cfg = outbox.Config{
+			Clock:              clock,
+			Storage:            storage,
+			Publisher:          &fake.Publisher{Logger: logr.Discard()},
+			ProcessorID:        "test",
+			DedupWithinPublish: true,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("writes duplicate messages in one call to Publish only once", func() {
+		Expect(ob.Publish(ctx, nil,
+			outbox.Message{Key: []byte("order-1"), Payload: []byte("created")},
+			outbox.Message{Key: []byte("order-1"), Payload: []byte("created")},
+			outbox.Message{Key: []byte("order-1"), Payload: []byte("shipped")},
+			outbox.Message{Key: []byte("order-2"), Payload: []byte("created")},
+		)).To(Succeed())
+
+		Expect(storage.CountEntries()).To(Equal(3))
+	})
+
+	It("keeps duplicates across separate calls to Publish", func() {
+		msg := outbox.Message{Key: []byte("order-1"), Payload: []byte("created")}
+		Expect(ob.Publish(ctx, nil, msg)).To(Succeed())
+		Expect(ob.Publish(ctx, nil, msg)).To(Succeed())
+
+		Expect(storage.CountEntries()).To(Equal(2))
+	})
+
+	It("deduplicates within a transaction", func() {
+		txn := storage.Begin()
+		msg := outbox.Message{Key: []byte("order-1"), Payload: []byte("created")}
+		Expect(ob.Publish(ctx, txn, msg, msg)).To(Succeed())
+		Expect(txn.Commit()).To(Succeed())
+
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+
+	When("it is disabled", func() {
+		BeforeEach(func() {
+			cfg.DedupWithinPublish = false
+		})
+
+		It("writes every message", func() {
+			msg := outbox.Message{Key: []byte("order-1"), Payload: []byte("created")}
+			Expect(ob.Publish(ctx, nil, msg, msg)).To(Succeed())
+
+			Expect(storage.CountEntries()).To(Equal(2))
+		})
+	})
+
+	It("lets storages be asked to deduplicate directly", func() {
+		msg := outbox.Message{Payload: []byte("created")}
+		Expect(storage.Publish(outbox.WithDedupWithinPublish(ctx), nil, msg, msg)).To(Succeed())
+
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+})
@@ -0,0 +1,102 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("PublisherResolver", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var fallback *fake.Publisher
+	var orders *fake.Publisher
+	var payments *fake.Publisher
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		storage = &fake.EntryStorage{
+			Clock: clockwork.NewFakeClock(),
+		}
+		fallback = &fake.Publisher{Logger: logr.Discard()}
+		orders = &fake.Publisher{Logger: logr.Discard()}
+		payments = &fake.Publisher{Logger: logr.Discard()}
+
+		publishers := map[string]outbox.Publisher{
+			"orders":   orders,
+			"payments": payments,
+		}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Storage:     storage,
+			Publisher:   fallback,
+			ProcessorID: "test",
+			PublisherResolver: func(namespace string) outbox.Publisher {
+				return publishers[namespace]
+			},
+		})
+		Expect(err).To(Succeed())
+
+		for _, namespace := range []string{"orders", "payments", "orders", "audit"} {
+			Expect(ob.Publish(outbox.WithNamespace(ctx, namespace), nil, outbox.Message{
+				Payload: []byte(namespace),
+			})).To(Succeed())
+		}
+	})
+
+	It("publishes each namespace's entries to its publisher", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(4))
+
+		Expect(publishedPayloads(orders)).To(Equal([]string{"orders", "orders"}))
+		Expect(publishedPayloads(payments)).To(Equal([]string{"payments"}))
+		Expect(publishedPayloads(fallback)).To(Equal([]string{"audit"}))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("publishes to the other namespaces when one namespace's publisher fails", func() {
+		payments.FailFunc = func(outbox.Message) error {
+			return errors.New("broker unavailable")
+		}
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring(`error publishing to namespace "payments"`)))
+
+		Expect(publishedPayloads(orders)).To(HaveLen(2))
+		Expect(publishedPayloads(fallback)).To(HaveLen(1))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+
+	It("fails the namespaces whose publisher can't publish single messages with a message publish timeout", func() {
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Storage:               storage,
+			Publisher:             fallback,
+			ProcessorID:           "test",
+			MessagePublishTimeout: time.Second,
+			PublisherResolver: func(namespace string) outbox.Publisher {
+				if namespace == "payments" {
+					return outbox.PublisherFunc(payments.Publish)
+				}
+				return nil
+			},
+		})
+		Expect(err).To(Succeed())
+
+		_, err = ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("publisher does not support publishing single messages")))
+
+		Expect(publishedPayloads(payments)).To(BeEmpty())
+		Expect(publishedPayloads(fallback)).To(HaveLen(3))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+})
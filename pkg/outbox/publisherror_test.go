@@ -0,0 +1,103 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("PublishError", func() {
+	var input []outbox.Message
+
+	BeforeEach(func() {
+		input = []outbox.Message{
+			{Payload: []byte("first")},
+			{Payload: []byte("second")},
+			{Payload: []byte("third")},
+		}
+	})
+
+	It("separates the succeeded and failed messages", func() {
+		publishErr := &outbox.PublishError{
+			Errors: []error{nil, errors.New("failed"), nil},
+		}
+
+		Expect(publishErr.FailedIndices()).To(Equal([]int{1}))
+		Expect(publishErr.SucceededMessages(input)).To(Equal([]outbox.Message{input[0], input[2]}))
+		Expect(publishErr.FailedMessages(input)).To(Equal([]outbox.Message{input[1]}))
+	})
+
+	It("handles every message succeeding", func() {
+		publishErr := &outbox.PublishError{
+			Errors: make([]error, len(input)),
+		}
+
+		Expect(publishErr.FailedIndices()).To(BeEmpty())
+		Expect(publishErr.SucceededMessages(input)).To(Equal(input))
+		Expect(publishErr.FailedMessages(input)).To(BeEmpty())
+	})
+
+	It("handles every message failing", func() {
+		err := errors.New("failed")
+		publishErr := &outbox.PublishError{
+			Errors: []error{err, err, err},
+		}
+
+		Expect(publishErr.FailedIndices()).To(Equal([]int{0, 1, 2}))
+		Expect(publishErr.SucceededMessages(input)).To(BeEmpty())
+		Expect(publishErr.FailedMessages(input)).To(Equal(input))
+	})
+
+	It("considers every message failed when the input doesn't match the errors", func() {
+		publishErr := &outbox.PublishError{
+			Errors: []error{nil, errors.New("failed")},
+		}
+
+		Expect(publishErr.FailedIndices()).To(Equal([]int{1}))
+		Expect(publishErr.SucceededMessages(input)).To(BeEmpty())
+		Expect(publishErr.FailedMessages(input)).To(Equal(input))
+	})
+})
+
+var _ = Describe("Publishing with a malformed PublishError", func() {
+	DescribeTable("treats the whole batch as failed",
+		func(errs []error) {
+			ctx := context.Background()
+			clock := clockwork.NewFakeClock()
+			storage := &fake.EntryStorage{
+				Clock: clock,
+			}
+			metrics := &fake.Metrics{}
+
+			ob, err := outbox.New(outbox.Config{
+				Clock:   clock,
+				Storage: storage,
+				Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+					return &outbox.PublishError{Errors: errs}
+				}),
+				ProcessorID: "test",
+				Metrics:     metrics,
+			})
+			Expect(err).To(Succeed())
+
+			Expect(storage.Publish(ctx, nil, outbox.Message{}, outbox.Message{})).To(Succeed())
+
+			var pumpErr error
+			Expect(func() {
+				_, pumpErr = ob.PumpOutbox(ctx)
+			}).ToNot(Panic())
+			Expect(pumpErr).To(MatchError(ContainSubstring("treating all as failed")))
+			Expect(storage.CountEntries()).To(Equal(2))
+			Expect(metrics.GetPublishFailureCount()).To(Equal(2))
+		},
+		Entry("with too few errors", []error{nil}),
+		Entry("with too many errors", []error{nil, nil, errors.New("failed")}),
+	)
+})
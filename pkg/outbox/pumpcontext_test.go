@@ -0,0 +1,116 @@
+package outbox_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// tenantKey is a request scoped context key belonging to the application rather than the outbox
+type tenantKey struct{}
+
+// contextRecordingStorage records the tenant of the context passed to each storage operation
+type contextRecordingStorage struct {
+	*fake.EntryStorage
+	lock    sync.Mutex
+	tenants map[string][]interface{}
+}
+
+func (c *contextRecordingStorage) record(op string, ctx context.Context) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.tenants[op] = append(c.tenants[op], ctx.Value(tenantKey{}))
+}
+
+func (c *contextRecordingStorage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	c.record("claim", ctx)
+	return c.EntryStorage.ClaimEntries(ctx, processorID, claimDeadline)
+}
+
+func (c *contextRecordingStorage) GetClaimedEntries(ctx context.Context, processorID string, batchSize int) ([]outbox.ClaimedEntry, error) {
+	c.record("get", ctx)
+	return c.EntryStorage.GetClaimedEntries(ctx, processorID, batchSize)
+}
+
+func (c *contextRecordingStorage) DeleteEntries(ctx context.Context, entryIDs ...string) error {
+	c.record("delete", ctx)
+	return c.EntryStorage.DeleteEntries(ctx, entryIDs...)
+}
+
+var _ = Describe("PumpOutbox context", func() {
+	var ctx context.Context
+	var storage *contextRecordingStorage
+	var publishedCtx context.Context
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		clock := clockwork.NewFakeClock()
+		storage = &contextRecordingStorage{
+			EntryStorage: &fake.EntryStorage{
+				Clock: clock,
+			},
+			tenants: map[string][]interface{}{},
+		}
+		publisher := &fake.Publisher{
+			Logger: logr.Discard(),
+			OnPublish: func(ctx context.Context, messages ...outbox.Message) {
+				publishedCtx = ctx
+			},
+		}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		writeCtx := outbox.WithValue(outbox.WithNamespace(context.Background(), "orders"), "topic", "orders")
+		Expect(ob.Publish(writeCtx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+		ctx = context.WithValue(context.Background(), tenantKey{}, "acme")
+		ctx = outbox.WithValue(ctx, "region", "eu")
+		ctx = outbox.WithValue(ctx, "topic", "default")
+	})
+
+	It("passes values on the context through to the publisher", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+		Expect(publishedCtx).NotTo(BeNil())
+		Expect(publishedCtx.Value(tenantKey{})).To(Equal("acme"))
+	})
+
+	It("adds the namespace and custom values of the entries being published", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+		Expect(outbox.NamespaceFromContext(publishedCtx)).To(Equal("orders"))
+		Expect(outbox.ValuesFromContext(publishedCtx)).To(Equal(map[string]string{
+			"region": "eu",
+			"topic":  "orders",
+		}))
+	})
+
+	It("passes values on the context through to the storage", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+		Expect(storage.tenants).To(HaveKeyWithValue("claim", ConsistOf("acme")))
+		Expect(storage.tenants).To(HaveKeyWithValue("get", ContainElement("acme")))
+		Expect(storage.tenants).To(HaveKeyWithValue("delete", ConsistOf("acme")))
+		for op, tenants := range storage.tenants {
+			for _, tenant := range tenants {
+				Expect(tenant).To(Equal("acme"), "storage operation %q", op)
+			}
+		}
+	})
+})
@@ -0,0 +1,170 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Rejection", func() {
+	const claimDuration = 5 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var deadLetters *fake.DeadLetterHandler
+	var metrics *fake.Metrics
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	// rejectingPublisher permanently rejects "malformed" payloads and transiently fails "flaky" ones
+	rejectingPublisher := outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+		publishErr := &outbox.PublishError{Errors: make([]error, len(messages))}
+		for idx, msg := range messages {
+			switch string(msg.Payload) {
+			case "malformed":
+				publishErr.Errors[idx] = outbox.Permanent(errors.New("message rejected by broker"))
+			case "flaky":
+				publishErr.Errors[idx] = errors.New("broker unavailable")
+			}
+		}
+
+		if publishErr.ErrorCount() > 0 {
+			return publishErr
+		}
+		return nil
+	})
+
+	remainingPayloads := func() []string {
+		entries, err := storage.GetClaimedEntries(ctx, "test", 10)
+		Expect(err).To(Succeed())
+
+		var payloads []string
+		for _, entry := range entries {
+			payloads = append(payloads, string(entry.Payload))
+		}
+		return payloads
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		deadLetters = &fake.DeadLetterHandler{}
+		metrics = &fake.Metrics{}
+
+		cfg = outbox.Config{
+			Clock:             clock,
+			Storage:           storage,
+			Publisher:         rejectingPublisher,
+			ClaimDuration:     claimDuration,
+			ProcessorID:       "test",
+			DeadLetterHandler: deadLetters,
+			Metrics:           metrics,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	When("a batch mixes permanent and transient failures", func() {
+		BeforeEach(func() {
+			Expect(storage.Publish(ctx, nil,
+				outbox.Message{Payload: []byte("healthy")},
+				outbox.Message{Payload: []byte("malformed")},
+				outbox.Message{Payload: []byte("flaky")},
+			)).To(Succeed())
+		})
+
+		It("dead letters the permanent failures and retries the transient ones", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(MatchError(ContainSubstring("failed to publish")))
+
+			deadLettered := deadLetters.GetDeadLettered()
+			Expect(deadLettered).To(HaveLen(1))
+			Expect(deadLettered[0].Payload).To(Equal([]byte("malformed")))
+			Expect(remainingPayloads()).To(Equal([]string{"flaky"}))
+			Expect(metrics.GetPublishedCount()).To(Equal(1))
+			Expect(metrics.GetPublishFailureCount()).To(Equal(2))
+		})
+
+		When("dead lettering fails", func() {
+			BeforeEach(func() {
+				deadLetters.Err = errors.New("dead letter queue unavailable")
+			})
+
+			It("keeps the rejected entry to try again", func() {
+				_, err := ob.PumpOutbox(ctx)
+				Expect(err).To(MatchError(ContainSubstring("dead letter queue unavailable")))
+
+				Expect(remainingPayloads()).To(ConsistOf("malformed", "flaky"))
+			})
+		})
+	})
+
+	When("every failure is permanent", func() {
+		BeforeEach(func() {
+			Expect(storage.Publish(ctx, nil,
+				outbox.Message{Payload: []byte("healthy")},
+				outbox.Message{Payload: []byte("malformed")},
+			)).To(Succeed())
+		})
+
+		It("succeeds, as there is nothing to retry", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+			Expect(deadLetters.GetDeadLettered()).To(HaveLen(1))
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+	})
+
+	When("the publisher rejects the whole batch permanently", func() {
+		BeforeEach(func() {
+			cfg.Publisher = outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				return outbox.Permanent(errors.New("topic does not exist"))
+			})
+
+			Expect(storage.Publish(ctx, nil, outbox.Message{}, outbox.Message{})).To(Succeed())
+		})
+
+		It("dead letters every entry", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+
+			Expect(deadLetters.GetDeadLettered()).To(HaveLen(2))
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+	})
+
+	When("halting on the first failure", func() {
+		BeforeEach(func() {
+			cfg.FailureMode = outbox.HaltOnFirstFailure
+
+			Expect(storage.Publish(ctx, nil,
+				outbox.Message{Payload: []byte("malformed")},
+				outbox.Message{Payload: []byte("healthy")},
+				outbox.Message{Payload: []byte("flaky")},
+				outbox.Message{Payload: []byte("later")},
+			)).To(Succeed())
+		})
+
+		It("continues past permanent failures, halting only on transient ones", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(MatchError(ContainSubstring("failed to publish")))
+
+			Expect(deadLetters.GetDeadLettered()).To(HaveLen(1))
+			Expect(remainingPayloads()).To(Equal([]string{"flaky", "later"}))
+		})
+	})
+})
@@ -0,0 +1,126 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("ReleaseFailed", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var brokerDown bool
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		brokerDown = true
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+			FailFunc: func(msg outbox.Message) error {
+				if brokerDown && string(msg.Payload) == "flaky" {
+					return errors.New("broker unavailable")
+				}
+				return nil
+			},
+		}
+
+		cfg = outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			ProcessorID:   "test",
+			ReleaseFailed: true,
+		}
+
+		Expect(storage.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("flaky")},
+			outbox.Message{Payload: []byte("steady")},
+		)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	// otherProcessor returns another processor sharing the storage
+	otherProcessor := func() *outbox.Outbox {
+		other := cfg
+		other.ProcessorID = "other"
+		otherOb, err := outbox.New(other)
+		Expect(err).To(Succeed())
+		return otherOb
+	}
+
+	It("lets other processors retry failed entries straight away", func() {
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("failed to publish 1/2 messages")))
+		Expect(storage.CountEntries()).To(Equal(1))
+
+		brokerDown = false
+		Expect(otherProcessor().PumpOutbox(ctx)).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+		Expect(publisher.GetPublishedCount()).To(Equal(2))
+	})
+
+	It("counts each retry as another attempt", func() {
+		for i := 0; i < 3; i++ {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(HaveOccurred())
+		}
+
+		entries, err := storage.GetUnclaimedEntries(ctx, 10)
+		Expect(err).To(Succeed())
+		Expect(entries).To(ConsistOf(HaveField("Attempts", 3)))
+	})
+
+	It("reports failing to release entries", func() {
+		storage.ReleaseErr = fake.FailAlways(errors.New("connection reset"))
+
+		_, err := ob.PumpOutbox(ctx)
+		var storageErr *outbox.StorageError
+		Expect(errors.As(err, &storageErr)).To(BeTrue())
+		Expect(storageErr.Op).To(Equal("releasing entries"))
+
+		// the entry is still claimed, so other processors wait for its claim to expire
+		brokerDown = false
+		other := otherProcessor()
+		Expect(other.PumpOutbox(ctx)).To(Equal(0))
+		clock.Advance(outbox.DefaultClaimDuration)
+		Expect(other.PumpOutbox(ctx)).To(Equal(1))
+	})
+
+	When("failed entries aren't released", func() {
+		BeforeEach(func() {
+			cfg.ReleaseFailed = false
+		})
+
+		It("leaves other processors to wait for their claims to expire", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).To(HaveOccurred())
+
+			brokerDown = false
+			other := otherProcessor()
+			Expect(other.PumpOutbox(ctx)).To(Equal(0))
+
+			clock.Advance(outbox.DefaultClaimDuration)
+			Expect(other.PumpOutbox(ctx)).To(Equal(1))
+		})
+	})
+})
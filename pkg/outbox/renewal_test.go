@@ -0,0 +1,101 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Claim renewal", func() {
+	const claimDuration = 3 * time.Second
+	const renewalInterval = time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var publishing chan struct{}
+	var release chan struct{}
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{}
+		publishing = make(chan struct{}, 1)
+		release = make(chan struct{})
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			// blocks until released, so that publishing takes as long as the test needs
+			Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				publishing <- struct{}{}
+				<-release
+				return publisher.Publish(ctx, messages...)
+			}),
+			ClaimDuration:        claimDuration,
+			ClaimRenewalInterval: renewalInterval,
+			ProcessorID:          "test",
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("defaults to a fraction of the claim duration", func() {
+		cfg := outbox.Config{
+			Storage:       storage,
+			Publisher:     publisher,
+			ProcessorID:   "test",
+			ClaimDuration: claimDuration,
+		}
+		Expect(cfg.DefaultAndValidate()).To(Succeed())
+		Expect(cfg.ClaimRenewalInterval).To(BeNumerically(">", 0))
+		Expect(cfg.ClaimRenewalInterval).To(BeNumerically("<", claimDuration))
+	})
+
+	It("rejects an interval that is not shorter than the claim duration", func() {
+		cfg := outbox.Config{
+			Storage:              storage,
+			Publisher:            publisher,
+			ProcessorID:          "test",
+			ClaimDuration:        claimDuration,
+			ClaimRenewalInterval: claimDuration,
+		}
+		Expect(cfg.DefaultAndValidate()).ToNot(Succeed())
+	})
+
+	It("keeps entries claimed while publishing outlasts the claim duration", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("slow")})).To(Succeed())
+
+		pumpErr := make(chan error, 1)
+		go func() {
+			_, err := ob.PumpOutbox(ctx)
+			pumpErr <- err
+		}()
+		Eventually(publishing).Should(Receive())
+
+		// the renewal timer is the only one waiting while the pump is in progress
+		for elapsed := time.Duration(0); elapsed <= claimDuration; elapsed += renewalInterval {
+			clock.BlockUntil(1)
+			clock.Advance(renewalInterval)
+		}
+
+		Expect(storage.ClaimEntries(ctx, "other", clock.Now().Add(claimDuration))).To(Succeed())
+		Expect(storage.GetClaimedEntries(ctx, "other", 10)).To(BeEmpty())
+
+		close(release)
+		Eventually(pumpErr).Should(Receive(BeNil()))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+})
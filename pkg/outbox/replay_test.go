@@ -0,0 +1,117 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Replay", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var deadLetters *fake.DeadLetterHandler
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+			FailFunc: func(msg outbox.Message) error {
+				if string(msg.Payload) == "rejected" {
+					return outbox.Permanent(errors.New("consumer bug"))
+				}
+				return nil
+			},
+		}
+		deadLetters = &fake.DeadLetterHandler{}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:             clock,
+			Storage:           storage,
+			Publisher:         publisher,
+			DeadLetterHandler: deadLetters,
+			ProcessorID:       "test",
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("publishes entries again with fresh IDs and timestamps", func() {
+		Expect(ob.Publish(outbox.WithNamespace(ctx, "orders"), nil, outbox.Message{
+			Key:      []byte("order-1"),
+			Payload:  []byte("rejected"),
+			Headers:  map[string][]byte{"type": []byte("created")},
+			Priority: 3,
+		})).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		deadLettered := deadLetters.GetDeadLettered()
+		Expect(deadLettered).To(HaveLen(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+
+		publisher.FailFunc = nil
+		clock.Advance(time.Hour)
+		replayedAt := clock.Now()
+		Expect(ob.Replay(ctx, deadLettered...)).To(Succeed())
+		Expect(storage.CountEntries()).To(Equal(1))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		published := publisher.GetPublished()
+		Expect(published).To(HaveLen(1))
+		Expect(published[0].Namespace).To(Equal("orders"))
+		Expect(published[0].Message).To(And(
+			HaveField("Key", []byte("order-1")),
+			HaveField("Payload", []byte("rejected")),
+			HaveField("Headers", HaveKeyWithValue("type", []byte("created"))),
+			HaveField("Priority", 3),
+			HaveField("EnqueuedAt", replayedAt),
+		))
+		Expect(published[0].DedupKey).NotTo(Equal(deadLettered[0].ID))
+	})
+
+	It("publishes entries again as many times as they are replayed", func() {
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("rejected")})).To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+		publisher.FailFunc = nil
+		deadLettered := deadLetters.GetDeadLettered()
+		Expect(ob.Replay(ctx, deadLettered...)).To(Succeed())
+		Expect(ob.Replay(ctx, deadLettered...)).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(2))
+		Expect(publisher.GetPublishedCount()).To(Equal(2))
+	})
+
+	It("returns storage errors", func() {
+		storage.PublishErr = fake.FailAlways(errors.New("connection reset"))
+
+		Expect(ob.Replay(ctx, outbox.ClaimedEntry{ID: "entry"})).To(MatchError("connection reset"))
+	})
+
+	It("fails with a storage that can't republish entries", func() {
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     struct{ outbox.ProcessorStorage }{storage},
+			Publisher:   publisher,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		Expect(ob.Replay(ctx, outbox.ClaimedEntry{ID: "entry"})).To(MatchError(outbox.ErrReplayUnsupported))
+	})
+})
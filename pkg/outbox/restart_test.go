@@ -0,0 +1,108 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Restarting processing", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var publisher *fake.Publisher
+	var pumped chan int
+	var ob *outbox.Outbox
+
+	// startProcessing runs the processor in the background until the returned context is cancelled, returning a
+	// channel that receives its result
+	startProcessing := func() (context.CancelFunc, chan error) {
+		processCtx, cancel := context.WithCancel(ctx)
+		errChan := make(chan error, 1)
+		go func(ob *outbox.Outbox, ctx context.Context, errChan chan<- error) {
+			errChan <- ob.StartProcessing(ctx)
+		}(ob, processCtx, errChan)
+		Eventually(ob.Stats).Should(HaveField("Processing", BeTrue()))
+		return cancel, errChan
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		pumped = make(chan int, 10)
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock: clock,
+			Storage: &fake.EntryStorage{
+				Clock: clock,
+			},
+			Publisher:       publisher,
+			ProcessInterval: time.Minute,
+			ProcessorID:     "test",
+			OnPumpComplete: func(processed int, err error) {
+				pumped <- processed
+			},
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("processes again once restarted after its context was cancelled", func() {
+		cancel, errChan := startProcessing()
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("first")})).To(Succeed())
+		Eventually(pumped).Should(Receive(Equal(1)))
+
+		cancel()
+		Eventually(errChan).Should(Receive(BeNil()))
+		Expect(ob.Stats().Processing).To(BeFalse())
+
+		// waking the stopped processor does nothing, the message waits for the restarted processor
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("stopped")})).To(Succeed())
+		Expect(ob.WakeProcessorCtx(ctx)).To(Succeed())
+		Consistently(pumped).ShouldNot(Receive())
+
+		cancel, errChan = startProcessing()
+		defer cancel()
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("restarted")})).To(Succeed())
+		Eventually(pumped).Should(Receive(Equal(2)))
+		Expect(publisher.GetPublishedCount()).To(Equal(3))
+
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+	})
+
+	It("processes on the process interval once restarted", func() {
+		cancel, errChan := startProcessing()
+		cancel()
+		Eventually(errChan).Should(Receive(BeNil()))
+
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("stopped")})).To(Succeed())
+
+		cancel, errChan = startProcessing()
+		defer cancel()
+		clock.BlockUntil(1)
+		clock.Advance(time.Minute)
+		Eventually(pumped).Should(Receive(Equal(1)))
+
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+	})
+
+	It("can't be restarted once shut down", func() {
+		_, errChan := startProcessing()
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+
+		Expect(ob.StartProcessing(ctx)).To(Succeed())
+		Expect(ob.Stats().Processing).To(BeFalse())
+	})
+})
@@ -0,0 +1,80 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Selection order", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		cfg = outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+			BatchSize:   2,
+		}
+
+		for i := 0; i < 4; i++ {
+			Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte(fmt.Sprintf("entry-%d", i))})).To(Succeed())
+			clock.Advance(time.Second)
+		}
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("urgent"), Priority: 1})).To(Succeed())
+	})
+
+	// pumpedPayloads pumps the outbox once, returning the payloads it published in order
+	pumpedPayloads := func() []string {
+		ob, err := outbox.New(cfg)
+		Expect(err).To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).To(Equal(5))
+
+		return publishedPayloads(publisher)
+	}
+
+	It("publishes the oldest entries within each priority first by default", func() {
+		Expect(pumpedPayloads()).To(Equal([]string{"urgent", "entry-0", "entry-1", "entry-2", "entry-3"}))
+	})
+
+	It("publishes the newest entries within each priority first when configured", func() {
+		cfg.SelectionOrder = outbox.NewestFirst
+
+		Expect(pumpedPayloads()).To(Equal([]string{"urgent", "entry-3", "entry-2", "entry-1", "entry-0"}))
+	})
+
+	It("retrieves the newest entries first from storage", func() {
+		Expect(storage.ClaimEntries(ctx, "test", clock.Now().Add(time.Minute))).To(Succeed())
+
+		entries, err := storage.GetClaimedEntries(outbox.WithSelectionOrder(ctx, outbox.NewestFirst), "test", 3)
+		Expect(err).To(Succeed())
+
+		var payloads []string
+		for _, entry := range entries {
+			payloads = append(payloads, string(entry.Payload))
+		}
+		Expect(payloads).To(Equal([]string{"urgent", "entry-3", "entry-2"}))
+	})
+})
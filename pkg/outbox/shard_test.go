@@ -0,0 +1,130 @@
+package outbox_test
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Sharding", func() {
+	const shardCount = 2
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publishers []*fake.Publisher
+	var outboxes []*outbox.Outbox
+	var skipClaim bool
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		skipClaim = false
+
+		for i := 0; i < 20; i++ {
+			Expect(storage.Publish(ctx, nil, outbox.Message{
+				Key:     []byte(fmt.Sprintf("key-%d", i%10)),
+				Payload: []byte(fmt.Sprint(i)),
+			})).To(Succeed())
+		}
+	})
+
+	JustBeforeEach(func() {
+		publishers = nil
+		outboxes = nil
+		for shard := 0; shard < shardCount; shard++ {
+			publisher := &fake.Publisher{
+				Logger: logr.Discard(),
+			}
+			ob, err := outbox.New(outbox.Config{
+				Clock:       clock,
+				Storage:     storage,
+				Publisher:   publisher,
+				ProcessorID: fmt.Sprintf("processor-%d", shard),
+				ShardCount:  shardCount,
+				ShardIndex:  shard,
+				SkipClaim:   skipClaim,
+			})
+			Expect(err).To(Succeed())
+
+			publishers = append(publishers, publisher)
+			outboxes = append(outboxes, ob)
+		}
+	})
+
+	// expectDisjointShards pumps each processor in turn, expecting each to publish only the entries in its shard,
+	// and between them to publish every entry exactly once
+	expectDisjointShards := func() {
+		payloads := map[string]int{}
+		for shard, ob := range outboxes {
+			processed, err := ob.PumpOutbox(ctx)
+			Expect(err).To(Succeed())
+			Expect(processed).To(BeNumerically(">", 0))
+
+			for _, published := range publishers[shard].GetPublished() {
+				Expect(outbox.PartitionForKey(published.Key, shardCount)).To(Equal(shard))
+				payloads[string(published.Payload)]++
+			}
+		}
+
+		Expect(payloads).To(HaveLen(20))
+		for payload, count := range payloads {
+			Expect(count).To(Equal(1), "payload %s", payload)
+		}
+		Expect(storage.CountEntries()).To(Equal(0))
+	}
+
+	It("claims a disjoint subset of the entries for each shard", func() {
+		expectDisjointShards()
+	})
+
+	It("leaves the entries of other shards unclaimed", func() {
+		Expect(outboxes[0].PumpOutbox(ctx)).To(BeNumerically("<", 20))
+		Expect(storage.CountEntries()).To(BeNumerically(">", 0))
+
+		Expect(outboxes[1].PumpOutbox(ctx)).To(BeNumerically(">", 0))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("only flushes the entries of its shard in the namespace", func() {
+		namespaced := outbox.WithNamespace(ctx, "orders")
+		for i := 0; i < 10; i++ {
+			Expect(storage.Publish(namespaced, nil, outbox.Message{
+				Key:     []byte(fmt.Sprintf("key-%d", i)),
+				Payload: []byte(fmt.Sprintf("order-%d", i)),
+			})).To(Succeed())
+		}
+
+		processed, err := outboxes[0].FlushNamespace(ctx, "orders")
+		Expect(err).To(Succeed())
+		Expect(processed).To(BeNumerically("<", 10))
+
+		Expect(publishers[0].GetPublished()).To(HaveLen(processed))
+		for _, published := range publishers[0].GetPublished() {
+			Expect(outbox.PartitionForKey(published.Key, shardCount)).To(Equal(0))
+		}
+
+		Expect(outboxes[1].FlushNamespace(ctx, "orders")).To(Equal(10 - processed))
+		Expect(storage.CountEntries()).To(Equal(20))
+	})
+
+	When("skipping claims", func() {
+		BeforeEach(func() {
+			skipClaim = true
+		})
+
+		It("gets a disjoint subset of the entries for each shard", func() {
+			expectDisjointShards()
+		})
+	})
+})
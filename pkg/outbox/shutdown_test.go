@@ -0,0 +1,163 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Shutdown", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publishing chan struct{}
+	var release chan struct{}
+	var published chan outbox.Message
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publishing = make(chan struct{}, 1)
+		release = make(chan struct{})
+		published = make(chan outbox.Message, 10)
+
+		// publisher blocks until released, so tests can shut down while a pump is in progress
+		publisher := outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+			publishing <- struct{}{}
+			<-release
+			for _, msg := range messages {
+				published <- msg
+			}
+			return nil
+		})
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       publisher,
+			ProcessInterval: 10 * time.Second,
+			ClaimDuration:   5 * time.Second,
+			ProcessorID:     "test",
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("returns immediately when the processor isn't running", func() {
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+	})
+
+	It("prevents the processor starting after shutdown", func() {
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+
+		errChan := make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+		Eventually(errChan, time.Second).Should(Receive(BeNil()))
+	})
+
+	It("waits for a processor that was started immediately before", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("unprocessed")})).To(Succeed())
+
+		errChan := make(chan error, 1)
+		go func(ob *outbox.Outbox, ctx context.Context) {
+			errChan <- ob.StartProcessing(ctx)
+		}(ob, ctx)
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+
+		Eventually(errChan, time.Second).Should(Receive(BeNil()))
+		Expect(publishing).ToNot(Receive())
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+
+	When("the processor is running", func() {
+		var processingErr chan error
+
+		BeforeEach(func() {
+			errChan := make(chan error, 1)
+			processingErr = errChan
+			go func(ob *outbox.Outbox, ctx context.Context) {
+				errChan <- ob.StartProcessing(ctx)
+			}(ob, ctx)
+			clock.BlockUntil(1)
+		})
+
+		AfterEach(func() {
+			// never leave the publisher blocked
+			select {
+			case <-release:
+			default:
+				close(release)
+			}
+		})
+
+		It("rejects a concurrent start", func() {
+			Expect(ob.StartProcessing(ctx)).To(MatchError(outbox.ErrAlreadyProcessing))
+
+			Expect(ob.Shutdown(ctx)).To(Succeed())
+			Expect(processingErr).To(Receive(BeNil()))
+		})
+
+		It("stops the idle processor", func() {
+			Expect(ob.Shutdown(ctx)).To(Succeed())
+			Expect(processingErr).To(Receive(BeNil()))
+		})
+
+		When("a pump is in progress", func() {
+			BeforeEach(func() {
+				Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("in-flight"), DedupKey: "in-flight"})).To(Succeed())
+				ob.WakeProcessor()
+				Eventually(publishing).Should(Receive())
+			})
+
+			It("waits for the in-flight entries to complete", func() {
+				shutdownErr := make(chan error, 1)
+				go func() {
+					shutdownErr <- ob.Shutdown(ctx)
+				}()
+				Consistently(shutdownErr, 100*time.Millisecond).ShouldNot(Receive())
+
+				close(release)
+				Eventually(shutdownErr, time.Second).Should(Receive(BeNil()))
+				Expect(processingErr).To(Receive(BeNil()))
+				Expect(published).To(Receive(Equal(outbox.Message{
+					Payload:    []byte("in-flight"),
+					DedupKey:   "in-flight",
+					EnqueuedAt: clock.Now(),
+				})))
+				Expect(storage.CountEntries()).To(Equal(0))
+			})
+
+			It("stops processing afterwards", func() {
+				close(release)
+				Expect(ob.Shutdown(ctx)).To(Succeed())
+
+				Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("too-late")})).To(Succeed())
+				ob.WakeProcessor()
+				clock.Advance(time.Minute)
+				Consistently(publishing, 100*time.Millisecond).ShouldNot(Receive())
+			})
+
+			It("gives up waiting when the shutdown context is done", func() {
+				shutdownCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+				defer cancel()
+
+				Expect(ob.Shutdown(shutdownCtx)).To(MatchError(context.DeadlineExceeded))
+
+				close(release)
+				Eventually(processingErr, time.Second).Should(Receive(BeNil()))
+			})
+		})
+	})
+})
@@ -0,0 +1,144 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// stubbornStorage blocks claims until released, returning the result of onCancel if the context is cancelled first,
+// unless ignoreCancel is set
+type stubbornStorage struct {
+	*fake.EntryStorage
+	claiming     chan struct{}
+	release      chan struct{}
+	ignoreCancel bool
+	onCancel     func(ctx context.Context) error
+}
+
+func (b *stubbornStorage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	b.claiming <- struct{}{}
+
+	done := ctx.Done()
+	if b.ignoreCancel {
+		done = nil
+	}
+
+	select {
+	case <-b.release:
+		return b.EntryStorage.ClaimEntries(ctx, processorID, claimDeadline)
+	case <-done:
+		return b.onCancel(ctx)
+	}
+}
+
+var _ = Describe("ShutdownGrace", func() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var clock clockwork.FakeClock
+	var storage *stubbornStorage
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+	var errChan chan error
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		clock = clockwork.NewFakeClock()
+		storage = &stubbornStorage{
+			EntryStorage: &fake.EntryStorage{
+				Clock: clock,
+			},
+			claiming: make(chan struct{}, 1),
+			release:  make(chan struct{}),
+			onCancel: func(ctx context.Context) error {
+				return ctx.Err()
+			},
+		}
+
+		cfg = outbox.Config{
+			Clock:   clock,
+			Storage: storage,
+			Publisher: &fake.Publisher{
+				Logger: logr.Discard(),
+			},
+			ProcessInterval: time.Minute,
+			ProcessorID:     "test",
+			ShutdownGrace:   5 * time.Second,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func(ob *outbox.Outbox, ctx context.Context, errChan chan<- error) {
+			errChan <- ob.StartProcessing(ctx)
+		}(ob, ctx, errChan)
+		clock.BlockUntil(1)
+
+		ob.WakeProcessor()
+		Eventually(storage.claiming).Should(Receive())
+	})
+
+	AfterEach(func() {
+		cancel()
+		close(storage.release)
+	})
+
+	It("returns once the pump in progress observes the cancellation", func() {
+		cancel()
+		Eventually(errChan).Should(Receive(BeNil()))
+	})
+
+	When("the pump in progress fails for another reason while stopping", func() {
+		BeforeEach(func() {
+			storage.onCancel = func(context.Context) error {
+				return errors.New("connection reset")
+			}
+		})
+
+		It("returns the pump's error", func() {
+			cancel()
+
+			var err error
+			Eventually(errChan).Should(Receive(&err))
+			Expect(err).To(MatchError(ContainSubstring("connection reset")))
+		})
+	})
+
+	When("the pump in progress ignores the cancellation", func() {
+		BeforeEach(func() {
+			storage.ignoreCancel = true
+		})
+
+		It("waits for the shutdown grace before giving up on the pump", func() {
+			cancel()
+
+			// the shutdown grace timer, alongside the process interval timer
+			clock.BlockUntil(2)
+			Consistently(errChan).ShouldNot(Receive())
+
+			clock.Advance(cfg.ShutdownGrace)
+			Eventually(errChan).Should(Receive(MatchError(outbox.ErrShutdownGraceExceeded)))
+			Expect(ob.Stats().Processing).To(BeFalse())
+		})
+
+		It("returns as soon as the pump does within the shutdown grace", func() {
+			cancel()
+			clock.BlockUntil(2)
+
+			storage.release <- struct{}{}
+			Eventually(errChan).Should(Receive(BeNil()))
+		})
+	})
+})
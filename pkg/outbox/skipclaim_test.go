@@ -0,0 +1,100 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("SkipClaim", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+			// claiming would fail, so any pump that succeeds must have skipped it
+			ClaimErr: fake.FailAlways(errors.New("claims should be skipped")),
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		cfg = outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+			BatchSize:   2,
+			SkipClaim:   true,
+		}
+
+		for _, payload := range []string{"a", "b", "c"} {
+			Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte(payload)})).To(Succeed())
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("publishes every entry without claiming them", func() {
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+
+		Expect(publisher.GetPublishedCount()).To(Equal(3))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("doesn't count attempts", func() {
+		publisher.FailFunc = func(outbox.Message) error {
+			return errors.New("broker unavailable")
+		}
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).NotTo(Succeed())
+
+		entries, err := storage.GetUnclaimedEntries(ctx, 10)
+		Expect(err).To(Succeed())
+		Expect(entries).To(HaveLen(3))
+		for _, entry := range entries {
+			Expect(entry.Attempts).To(Equal(0))
+		}
+	})
+
+	It("reports failures to get the entries", func() {
+		storage.GetErr = fake.FailFirst(1, errors.New("connection reset"))
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("getting unclaimed entries")))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+	})
+
+	It("still only publishes entries once they are due", func() {
+		notBefore := clock.Now().Add(time.Minute)
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("later"), NotBefore: &notBefore})).To(Succeed())
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(storage.CountEntries()).To(Equal(1))
+
+		clock.Advance(time.Minute)
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+})
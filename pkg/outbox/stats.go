@@ -0,0 +1,142 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of the state of an Outbox processor, e.g. for serving from a debug endpoint
+type Stats struct {
+	// Processing is whether StartProcessing is running
+	Processing bool
+	// Pumping is whether a pump is in progress, otherwise the processor is idle
+	Pumping bool
+	// LastPumpAt is when the latest pump finished, or zero if no pump has finished yet
+	LastPumpAt time.Time
+	// LastPumpProcessed is how many entries the latest pump processed, whether or not it succeeded
+	LastPumpProcessed int
+	// LastPumpErr is the error the latest pump failed with, or nil if it succeeded
+	LastPumpErr error
+	// LastSuccessAt is when the latest successful pump finished, or zero if no pump has succeeded yet
+	LastSuccessAt time.Time
+	// ConsecutiveFailures is how many pumps in a row have failed, or zero if the latest pump succeeded
+	ConsecutiveFailures int
+	// DroppedWakes counts the calls to WakeProcessor that were coalesced with an earlier wake the processor hadn't
+	// yet woken for
+	DroppedWakes int
+}
+
+// statsRecorder maintains the Stats of an Outbox as pumps start and finish
+type statsRecorder struct {
+	lock  sync.Mutex
+	stats Stats
+	// pumps counts the pumps in progress, as PumpOutbox may be called concurrently with StartProcessing
+	pumps int
+	// lastErr is the error the processing loop last gave up on, reported by Outbox.LastError
+	lastErr error
+}
+
+// pumpStarted records that a pump has started
+func (s *statsRecorder) pumpStarted() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pumps++
+}
+
+// pumpFinished records the outcome of a pump that has finished
+func (s *statsRecorder) pumpFinished(at time.Time, processed int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.pumps--
+	s.stats.LastPumpAt = at
+	s.stats.LastPumpProcessed = processed
+	s.stats.LastPumpErr = err
+	if err != nil {
+		s.stats.ConsecutiveFailures++
+	} else {
+		s.stats.ConsecutiveFailures = 0
+		s.stats.LastSuccessAt = at
+	}
+}
+
+// wakeDropped records that a call to WakeProcessor was coalesced with an earlier wake
+func (s *statsRecorder) wakeDropped() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.stats.DroppedWakes++
+}
+
+// setLastError records the outcome of the processing loop's latest pump, including its retries
+func (s *statsRecorder) setLastError(err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.lastErr = err
+}
+
+// lastError returns the outcome of the processing loop's latest pump
+func (s *statsRecorder) lastError() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lastErr
+}
+
+// snapshot copies the current Stats
+func (s *statsRecorder) snapshot() Stats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := s.stats
+	stats.Pumping = s.pumps > 0
+	return stats
+}
+
+// Stats returns a snapshot of the state of the processor, covering pumps run by StartProcessing and direct calls
+// to PumpOutbox alike
+func (o *Outbox) Stats() Stats {
+	stats := o.stats.snapshot()
+
+	o.stoppedLock.RLock()
+	stats.Processing = o.processingDone != nil
+	o.stoppedLock.RUnlock()
+
+	return stats
+}
+
+// LastError returns the error StartProcessing last gave up on, once retrying it failed, or the fatal error it
+// stopped with, so that a supervisor can react to sustained failures. It returns nil if StartProcessing hasn't failed
+// to pump the outbox, or once a later pump has succeeded. Unlike Stats.LastPumpErr, it isn't affected by individual
+// attempts that are retried, or by direct calls to PumpOutbox.
+func (o *Outbox) LastError() error {
+	return o.stats.lastError()
+}
+
+// Healthy returns an error if the processor is unhealthy, e.g. for serving from a readiness probe. It is unhealthy if
+// no pump has succeeded within the Config.HealthStaleness, if one is configured, or if the ProcessorStorage
+// implements HealthChecker and isn't reachable.
+func (o *Outbox) Healthy(ctx context.Context) error {
+	if o.config.HealthStaleness > 0 {
+		lastSuccess := o.Stats().LastSuccessAt
+		if lastSuccess.IsZero() {
+			lastSuccess = o.createdAt
+		}
+
+		if stale := o.config.Clock.Now().Sub(lastSuccess); stale > o.config.HealthStaleness {
+			return fmt.Errorf("no successful pump for %v, exceeding the health staleness of %v", stale, o.config.HealthStaleness)
+		}
+	}
+
+	if checker, ok := o.config.Storage.(HealthChecker); ok {
+		if err := checker.Ping(ctx); err != nil {
+			return fmt.Errorf("storage unreachable: %w", err)
+		}
+	}
+
+	return nil
+}
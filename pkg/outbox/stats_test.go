@@ -0,0 +1,128 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Stats", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       publisher,
+			ProcessInterval: time.Minute,
+			ProcessorID:     "test",
+		})
+		Expect(err).To(Succeed())
+
+		for _, payload := range []string{"a", "b", "c"} {
+			Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte(payload)})).To(Succeed())
+		}
+	})
+
+	It("starts idle without any pumps", func() {
+		Expect(ob.Stats()).To(Equal(outbox.Stats{}))
+	})
+
+	It("reflects a manual pump", func() {
+		clock.Advance(time.Second)
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+
+		stats := ob.Stats()
+		Expect(stats.Processing).To(BeFalse())
+		Expect(stats.Pumping).To(BeFalse())
+		Expect(stats.LastPumpAt).To(Equal(clock.Now()))
+		Expect(stats.LastPumpProcessed).To(Equal(3))
+		Expect(stats.LastPumpErr).To(BeNil())
+		Expect(stats.ConsecutiveFailures).To(Equal(0))
+	})
+
+	It("counts consecutive failures until a pump succeeds", func() {
+		storage.ClaimErr = fake.FailFirst(2, errors.New("connection reset"))
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).NotTo(Succeed())
+		Expect(ob.Stats().ConsecutiveFailures).To(Equal(1))
+
+		clock.Advance(time.Second)
+		_, err = ob.PumpOutbox(ctx)
+		Expect(err).NotTo(Succeed())
+
+		stats := ob.Stats()
+		Expect(stats.ConsecutiveFailures).To(Equal(2))
+		Expect(stats.LastPumpErr).To(MatchError(ContainSubstring("connection reset")))
+		Expect(stats.LastPumpAt).To(Equal(clock.Now()))
+		Expect(stats.LastPumpProcessed).To(Equal(0))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+
+		stats = ob.Stats()
+		Expect(stats.ConsecutiveFailures).To(Equal(0))
+		Expect(stats.LastPumpErr).To(BeNil())
+		Expect(stats.LastPumpProcessed).To(Equal(3))
+	})
+
+	When("processing", func() {
+		var errChan chan error
+
+		BeforeEach(func() {
+			publisher.Delay = 10 * time.Second
+			publisher.Clock = clock
+
+			errChan = make(chan error, 1)
+			go func() {
+				errChan <- ob.StartProcessing(ctx)
+			}()
+			clock.BlockUntil(1)
+		})
+
+		AfterEach(func() {
+			Expect(ob.Shutdown(ctx)).To(Succeed())
+			Expect(errChan).To(Receive(BeNil()))
+			Expect(ob.Stats().Processing).To(BeFalse())
+		})
+
+		It("reports whether a pump is in progress", func() {
+			Expect(ob.Stats().Processing).To(BeTrue())
+			Expect(ob.Stats().Pumping).To(BeFalse())
+
+			ob.WakeProcessor()
+			// the publisher's delay, alongside the process interval timer
+			clock.BlockUntil(2)
+			Expect(ob.Stats().Pumping).To(BeTrue())
+
+			clock.Advance(10 * time.Second)
+			Expect(ob.WaitForIdle(ctx)).To(Succeed())
+
+			stats := ob.Stats()
+			Expect(stats.Pumping).To(BeFalse())
+			Expect(stats.LastPumpProcessed).To(Equal(3))
+		})
+	})
+})
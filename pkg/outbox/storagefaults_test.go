@@ -0,0 +1,134 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Storage faults", func() {
+	const retryInterval = 3 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var cfg outbox.Config
+	var ob *outbox.Outbox
+
+	connectionReset := errors.New("connection reset")
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		cfg = outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       publisher,
+			ProcessInterval: time.Minute,
+			ProcessorID:     "test",
+			BackoffFactory: func() backoff.BackOff {
+				return backoff.NewConstantBackOff(retryInterval)
+			},
+		}
+
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	Describe("FailFirst", func() {
+		It("fails the first n calls and then succeeds", func() {
+			fail := fake.FailFirst(2, connectionReset)
+
+			Expect(fail()).To(MatchError(connectionReset))
+			Expect(fail()).To(MatchError(connectionReset))
+			Expect(fail()).To(Succeed())
+			Expect(fail()).To(Succeed())
+		})
+	})
+
+	It("fails publishing to the outbox without recording the messages", func() {
+		storage.PublishErr = fake.FailAlways(connectionReset)
+
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("lost")})).To(MatchError(connectionReset))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+
+	It("leaves the entries in storage if getting the claimed entries fails", func() {
+		storage.GetErr = fake.FailFirst(1, connectionReset)
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(connectionReset))
+		Expect(err).To(MatchError(ContainSubstring("getting claimed entries")))
+		Expect(publisher.GetPublishedCount()).To(Equal(0))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+
+	It("deletes the published entries without publishing them again once deleting succeeds", func() {
+		storage.DeleteErr = fake.FailFirst(1, connectionReset)
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("deleting entries")))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(1))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(0))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	When("processing continuously", func() {
+		var errChan chan error
+
+		BeforeEach(func() {
+			storage.ClaimErr = fake.FailFirst(1, connectionReset)
+		})
+
+		JustBeforeEach(func() {
+			errChan = make(chan error, 1)
+			go func() {
+				errChan <- ob.StartProcessing(ctx)
+			}()
+			clock.BlockUntil(1)
+		})
+
+		AfterEach(func() {
+			Expect(ob.Shutdown(ctx)).To(Succeed())
+			Expect(errChan).To(Receive(BeNil()))
+		})
+
+		It("retries a failed claim and eventually publishes the batch", func() {
+			ob.WakeProcessor()
+
+			// the backoff timer waiting to retry, alongside the process interval timer
+			clock.BlockUntil(2)
+			Expect(publisher.GetPublishedCount()).To(Equal(0))
+
+			clock.Advance(retryInterval)
+			Eventually(publisher.GetPublishedCount).Should(Equal(1))
+			Eventually(storage.CountEntries).Should(Equal(0))
+			Consistently(errChan).ShouldNot(Receive())
+		})
+	})
+})
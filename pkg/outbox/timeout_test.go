@@ -0,0 +1,117 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// blockingStorage blocks every claim until its context is done
+type blockingStorage struct {
+	*fake.EntryStorage
+	claiming chan struct{}
+}
+
+func (b *blockingStorage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	b.claiming <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+var _ = Describe("Pump timeouts", func() {
+	const pumpTimeout = 5 * time.Second
+	const retryInterval = 3 * time.Second
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var clock clockwork.FakeClock
+	var storage *blockingStorage
+	var cfg outbox.Config
+	var pumpErrs chan error
+	var ob *outbox.Outbox
+	var errChan chan error
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		clock = clockwork.NewFakeClock()
+		storage = &blockingStorage{
+			EntryStorage: &fake.EntryStorage{
+				Clock: clock,
+			},
+			claiming: make(chan struct{}, 10),
+		}
+		pumpErrs = make(chan error, 10)
+
+		cfg = outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       &fake.Publisher{},
+			ProcessInterval: time.Hour,
+			ProcessorID:     "test",
+			PumpTimeout:     pumpTimeout,
+			OnPumpComplete: func(_ int, err error) {
+				pumpErrs <- err
+			},
+			BackoffFactory: func() backoff.BackOff {
+				return backoff.NewConstantBackOff(retryInterval)
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(cfg)
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+		clock.BlockUntil(1)
+	})
+
+	AfterEach(func() {
+		// the blocked storage only returns once the context is cancelled
+		cancel()
+		Eventually(errChan).Should(Receive(BeNil()))
+	})
+
+	It("cancels a pump that takes too long, then retries it", func() {
+		ob.WakeProcessor()
+		Eventually(storage.claiming).Should(Receive())
+
+		// the processor waits on both its idle timer and the pump timeout
+		clock.BlockUntil(2)
+		clock.Advance(pumpTimeout)
+
+		var err error
+		Eventually(pumpErrs).Should(Receive(&err))
+		Expect(err).To(MatchError(context.Canceled))
+
+		// the processor waits on both its idle timer and the backoff
+		clock.BlockUntil(2)
+		clock.Advance(retryInterval)
+		Eventually(storage.claiming).Should(Receive())
+	})
+
+	When("no pump timeout is configured", func() {
+		BeforeEach(func() {
+			cfg.PumpTimeout = 0
+		})
+
+		It("waits for the pump however long it takes", func() {
+			ob.WakeProcessor()
+			Eventually(storage.claiming).Should(Receive())
+
+			clock.Advance(time.Minute)
+			Consistently(pumpErrs).ShouldNot(Receive())
+		})
+	})
+})
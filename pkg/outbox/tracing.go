@@ -0,0 +1,21 @@
+package outbox
+
+import (
+	"context"
+)
+
+// Tracer allows the Outbox processor to participate in distributed tracing, without the outbox package
+// depending on any particular tracing library
+type Tracer interface {
+	// StartBatch is called before a batch of entries is processed, but not when there are no entries to
+	// process. The returned context is used when publishing the batch, and the returned function is
+	// called with the outcome once processing the batch has finished.
+	StartBatch(ctx context.Context, messages []Message) (context.Context, func(err error))
+}
+
+// noopTracer is the default Tracer implementation, which does not trace anything
+type noopTracer struct{}
+
+func (noopTracer) StartBatch(ctx context.Context, _ []Message) (context.Context, func(err error)) {
+	return ctx, func(error) {}
+}
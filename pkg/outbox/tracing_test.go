@@ -0,0 +1,130 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+type tracedBatch struct {
+	messages []outbox.Message
+	err      error
+	ended    bool
+}
+
+type batchSpanKey struct{}
+
+// recordingTracer is a test outbox.Tracer that records each batch it is asked to trace
+type recordingTracer struct {
+	lock    sync.Mutex
+	batches []*tracedBatch
+}
+
+func (r *recordingTracer) StartBatch(ctx context.Context, messages []outbox.Message) (context.Context, func(err error)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	batch := &tracedBatch{messages: messages}
+	r.batches = append(r.batches, batch)
+
+	return context.WithValue(ctx, batchSpanKey{}, batch), func(err error) {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+
+		batch.err = err
+		batch.ended = true
+	}
+}
+
+var _ = Describe("Tracing", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var publisher outbox.Publisher
+	var tracer *recordingTracer
+	var ob *outbox.Outbox
+
+	traceContext := map[string]string{
+		"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		tracer = &recordingTracer{}
+
+		Expect(storage.Publish(ctx, nil,
+			outbox.Message{Payload: []byte("traced"), TraceContext: traceContext},
+			outbox.Message{Payload: []byte("untraced")},
+		)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Storage:       storage,
+			Publisher:     publisher,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			Tracer:        tracer,
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("carries the trace context through to the publisher", func() {
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+		published := publisher.(*fake.Publisher).GetPublished()
+		Expect(published).To(HaveLen(2))
+		Expect(published[0].TraceContext).To(Equal(traceContext))
+		Expect(published[1].TraceContext).To(BeNil())
+	})
+
+	It("traces each batch", func() {
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+		Expect(tracer.batches).To(HaveLen(1))
+		Expect(tracer.batches[0].messages).To(HaveLen(2))
+		Expect(tracer.batches[0].ended).To(BeTrue())
+		Expect(tracer.batches[0].err).To(Succeed())
+	})
+
+	It("does not trace pumps that find no entries", func() {
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+		Expect(tracer.batches).To(HaveLen(1))
+	})
+
+	When("publishing fails", func() {
+		BeforeEach(func() {
+			publisher = outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+				Expect(ctx.Value(batchSpanKey{})).ToNot(BeNil(), "publishing should use the traced context")
+				return errors.New("publisher unavailable")
+			})
+		})
+
+		It("ends the batch trace with the error", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).ToNot(Succeed())
+
+			Expect(tracer.batches).To(HaveLen(1))
+			Expect(tracer.batches[0].ended).To(BeTrue())
+			Expect(tracer.batches[0].err).To(MatchError(ContainSubstring("publisher unavailable")))
+		})
+	})
+})
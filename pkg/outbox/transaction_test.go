@@ -0,0 +1,86 @@
+package outbox_test
+
+import (
+	"context"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Publishing transactions", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		storage = &fake.EntryStorage{
+			Clock: clockwork.NewFakeClock(),
+		}
+	})
+
+	When("no transaction is provided", func() {
+		It("records the messages immediately", func() {
+			Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+			Expect(storage.CountEntries()).To(Equal(1))
+		})
+	})
+
+	When("the caller's transaction is provided", func() {
+		var txn *fake.Txn
+
+		BeforeEach(func() {
+			txn = storage.Begin()
+			Expect(storage.Publish(ctx, txn, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+			Expect(storage.Publish(ctx, txn, outbox.Message{Payload: []byte("world")})).To(Succeed())
+		})
+
+		It("records the messages once it commits", func() {
+			Expect(storage.CountEntries()).To(Equal(0))
+
+			Expect(txn.Commit()).To(Succeed())
+			Expect(storage.CountEntries()).To(Equal(2))
+		})
+
+		It("discards the messages if it rolls back", func() {
+			Expect(txn.Rollback()).To(Succeed())
+			Expect(storage.CountEntries()).To(Equal(0))
+		})
+
+		It("can't be used once it has finished", func() {
+			Expect(txn.Commit()).To(Succeed())
+
+			Expect(storage.Publish(ctx, txn, outbox.Message{})).To(MatchError(fake.ErrTxnDone))
+			Expect(txn.Commit()).To(MatchError(fake.ErrTxnDone))
+			Expect(txn.Rollback()).To(MatchError(fake.ErrTxnDone))
+			Expect(storage.CountEntries()).To(Equal(2))
+		})
+	})
+
+	It("rejects other transactions", func() {
+		other := &fake.EntryStorage{}
+
+		Expect(storage.Publish(ctx, "not a transaction", outbox.Message{})).NotTo(Succeed())
+		Expect(storage.Publish(ctx, other.Begin(), outbox.Message{})).NotTo(Succeed())
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("passes the transaction through the Outbox", func() {
+		ob, err := outbox.New(outbox.Config{
+			Storage:     storage,
+			Publisher:   &fake.Publisher{},
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		txn := storage.Begin()
+		Expect(ob.Publish(ctx, txn, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+		Expect(storage.CountEntries()).To(Equal(0))
+
+		Expect(txn.Commit()).To(Succeed())
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+})
@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+)
+
+// transformMessages passes the messages of the entries to the Config.Transform, if any, returning the entries whose
+// messages are to be published alongside their transformed messages, and the IDs of the entries whose messages were
+// dropped. If the transform fails, or its result can't be matched with the entries, nothing is published or dropped.
+func (o *Outbox) transformMessages(ctx context.Context, entries []ClaimedEntry, messages []Message) (publishable []ClaimedEntry, transformed []Message, droppedIDs []string, err error) {
+	if o.config.Transform == nil || len(messages) == 0 {
+		return entries, messages, nil, nil
+	}
+
+	transformed, err = o.config.Transform(ctx, messages)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("transforming messages: %w", err)
+	}
+
+	switch {
+	case len(transformed) > len(messages):
+		return nil, nil, nil, fmt.Errorf("transforming messages: returned %d messages for a batch of %d", len(transformed), len(messages))
+	case len(transformed) == len(messages):
+		// every message was kept, so they are matched with their entries by position, even if their DedupKey changed
+		return entries, transformed, nil, nil
+	}
+
+	// the messages that were kept are matched with the next entry with the same DedupKey, the rest were dropped
+	next := 0
+	publishable = make([]ClaimedEntry, 0, len(transformed))
+	for _, msg := range transformed {
+		for next < len(messages) && messages[next].DedupKey != msg.DedupKey {
+			droppedIDs = append(droppedIDs, entries[next].ID)
+			next++
+		}
+		if next == len(messages) {
+			return nil, nil, nil, fmt.Errorf("transforming messages: returned a message with dedup key %q that isn't in the batch, or is out of order", msg.DedupKey)
+		}
+
+		publishable = append(publishable, entries[next])
+		next++
+	}
+	for ; next < len(messages); next++ {
+		droppedIDs = append(droppedIDs, entries[next].ID)
+	}
+
+	return publishable, transformed, droppedIDs, nil
+}
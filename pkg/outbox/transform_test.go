@@ -0,0 +1,142 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Transform", func() {
+	var ctx context.Context
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var events *fake.EventSink
+	var transform func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error)
+	var ob *outbox.Outbox
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock := clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+			IDGenerator: func(msg outbox.Message) string {
+				return string(msg.Payload)
+			},
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		events = &fake.EventSink{}
+		transform = nil
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			EventSink:   events,
+			ProcessorID: "test",
+			Transform: func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error) {
+				return transform(ctx, messages)
+			},
+		})
+		Expect(err).To(Succeed())
+
+		for _, payload := range []string{"first", "second", "third"} {
+			Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte(payload)})).To(Succeed())
+		}
+	})
+
+	It("deletes the messages it drops without publishing them", func() {
+		transform = func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error) {
+			return []outbox.Message{messages[0], messages[2]}, nil
+		}
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(publishedPayloads(publisher)).To(Equal([]string{"first", "third"}))
+		Expect(storage.CountEntries()).To(Equal(0))
+		Expect(events.GetEventTypes("second")).To(Equal([]fake.EventType{fake.EventClaimed, fake.EventDeleted}))
+	})
+
+	It("publishes the messages it rewrites", func() {
+		transform = func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error) {
+			rewritten := make([]outbox.Message, len(messages))
+			for idx, msg := range messages {
+				msg.Payload = append([]byte("redacted "), msg.Payload...)
+				msg.DedupKey = ""
+				rewritten[idx] = msg
+			}
+			return rewritten, nil
+		}
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(publishedPayloads(publisher)).To(Equal([]string{"redacted first", "redacted second", "redacted third"}))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("matches the messages it rewrites with their entries when dropping others", func() {
+		transform = func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error) {
+			first := messages[0]
+			first.Payload = []byte("rewritten")
+			return []outbox.Message{first}, nil
+		}
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(publishedPayloads(publisher)).To(Equal([]string{"rewritten"}))
+		Expect(events.GetEventTypes("first")).To(Equal([]fake.EventType{
+			fake.EventClaimed, fake.EventPublished, fake.EventDeleted,
+		}))
+		Expect(events.GetEventTypes("third")).To(Equal([]fake.EventType{fake.EventClaimed, fake.EventDeleted}))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("deletes every message when it drops them all", func() {
+		transform = func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error) {
+			return nil, nil
+		}
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(publisher.GetPublishedCount()).To(Equal(0))
+		Expect(storage.CountEntries()).To(Equal(0))
+	})
+
+	It("publishes nothing if it fails", func() {
+		transform = func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error) {
+			return nil, errors.New("feature flags unavailable")
+		}
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("transforming messages: feature flags unavailable")))
+		Expect(publisher.GetPublishedCount()).To(Equal(0))
+		Expect(storage.CountEntries()).To(Equal(3))
+	})
+
+	It("publishes nothing if the messages it returns can't be matched with their entries", func() {
+		transform = func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error) {
+			return []outbox.Message{messages[2], messages[0]}, nil
+		}
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring(`returned a message with dedup key "first" that isn't in the batch`)))
+		Expect(publisher.GetPublishedCount()).To(Equal(0))
+		Expect(storage.CountEntries()).To(Equal(3))
+	})
+
+	It("publishes nothing if it returns more messages than it was given", func() {
+		transform = func(ctx context.Context, messages []outbox.Message) ([]outbox.Message, error) {
+			return append(messages, outbox.Message{Payload: []byte("extra")}), nil
+		}
+
+		_, err := ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("returned 4 messages for a batch of 3")))
+		Expect(publisher.GetPublishedCount()).To(Equal(0))
+		Expect(storage.CountEntries()).To(Equal(3))
+	})
+})
@@ -0,0 +1,114 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("StartProcessingWithTrigger", func() {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var ob *outbox.Outbox
+	var pumps chan int
+	var trigger chan struct{}
+	var errChan chan error
+
+	BeforeEach(func() {
+		ctx, cancel = context.WithCancel(context.Background())
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		pumps = make(chan int, 10)
+		trigger = make(chan struct{})
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       publisher,
+			ProcessInterval: time.Second,
+			ProcessorID:     "test",
+			OnPumpComplete: func(processed int, err error) {
+				pumps <- processed
+			},
+		})
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func(ob *outbox.Outbox, ctx context.Context, trigger <-chan struct{}, errChan chan<- error) {
+			errChan <- ob.StartProcessingWithTrigger(ctx, trigger)
+		}(ob, ctx, trigger, errChan)
+	})
+
+	AfterEach(func() {
+		cancel()
+		Expect(ob.Shutdown(context.Background())).To(Succeed())
+	})
+
+	It("pumps once per trigger", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("first")})).To(Succeed())
+		trigger <- struct{}{}
+		Eventually(pumps).Should(Receive(Equal(1)))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("second")})).To(Succeed())
+		trigger <- struct{}{}
+		Eventually(pumps).Should(Receive(Equal(1)))
+		Expect(publisher.GetPublishedCount()).To(Equal(2))
+
+		Consistently(pumps).ShouldNot(Receive())
+	})
+
+	It("ignores the process interval", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+		clock.Advance(time.Minute)
+		Consistently(pumps).ShouldNot(Receive())
+		Expect(publisher.GetPublishedCount()).To(Equal(0))
+	})
+
+	It("can still be woken by WakeProcessor", func() {
+		Eventually(ob.Stats).Should(HaveField("Processing", BeTrue()))
+
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+		Eventually(pumps).Should(Receive(Equal(1)))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+	})
+
+	It("stops when the context is cancelled", func() {
+		cancel()
+		Eventually(errChan).Should(Receive(BeNil()))
+	})
+
+	It("stops when the trigger is closed", func() {
+		close(trigger)
+		Eventually(errChan).Should(Receive(BeNil()))
+		Expect(pumps).NotTo(Receive())
+	})
+
+	It("requires a trigger", func() {
+		other, err := outbox.New(outbox.Config{
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "other",
+		})
+		Expect(err).To(Succeed())
+
+		Expect(other.StartProcessingWithTrigger(ctx, nil)).To(MatchError("no trigger provided"))
+	})
+})
@@ -0,0 +1,126 @@
+package outbox_test
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Values", func() {
+	It("reports values set on the context", func() {
+		ctx := outbox.WithValue(context.Background(), "topic", "orders")
+		ctx = outbox.WithValue(ctx, "region", "eu")
+
+		value, ok := outbox.ValueFromContext(ctx, "topic")
+		Expect(ok).To(BeTrue())
+		Expect(value).To(Equal("orders"))
+		Expect(outbox.ValuesFromContext(ctx)).To(Equal(map[string]string{"topic": "orders", "region": "eu"}))
+
+		_, ok = outbox.ValueFromContext(ctx, "missing")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("doesn't modify the values of the parent context", func() {
+		parent := outbox.WithValue(context.Background(), "topic", "orders")
+		outbox.WithValue(parent, "topic", "payments")
+
+		Expect(outbox.ValuesFromContext(parent)).To(Equal(map[string]string{"topic": "orders"}))
+	})
+
+	It("preserves the namespace", func() {
+		ctx := outbox.WithValue(outbox.WithNamespace(context.Background(), "namespace"), "topic", "orders")
+
+		Expect(outbox.NamespaceFromContext(ctx)).To(Equal("namespace"))
+	})
+
+	Describe("publishing", func() {
+		type published struct {
+			payload string
+			values  map[string]string
+			headers map[string][]byte
+		}
+
+		var ctx context.Context
+		var storage *fake.EntryStorage
+		var lock sync.Mutex
+		var calls [][]published
+		var ob *outbox.Outbox
+
+		getCalls := func() [][]published {
+			lock.Lock()
+			defer lock.Unlock()
+
+			return append([][]published(nil), calls...)
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			clock := clockwork.NewFakeClock()
+			storage = &fake.EntryStorage{
+				Clock: clock,
+			}
+			calls = nil
+
+			var err error
+			ob, err = outbox.New(outbox.Config{
+				Clock:   clock,
+				Storage: storage,
+				// records the values of the context each message was published with
+				Publisher: outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+					lock.Lock()
+					defer lock.Unlock()
+
+					var call []published
+					for _, msg := range messages {
+						call = append(call, published{
+							payload: string(msg.Payload),
+							values:  outbox.ValuesFromContext(ctx),
+							headers: msg.Headers,
+						})
+					}
+					calls = append(calls, call)
+					return nil
+				}),
+				ClaimDuration: 5 * time.Second,
+				ProcessorID:   "test",
+			})
+			Expect(err).To(Succeed())
+		})
+
+		It("passes values from the context messages were written with to the publisher", func() {
+			Expect(ob.Publish(outbox.WithValue(ctx, "topic", "orders"), nil, outbox.Message{
+				Payload: []byte("order"),
+				Headers: map[string][]byte{"content-type": []byte("application/json")},
+			})).To(Succeed())
+
+			Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+
+			Expect(getCalls()).To(Equal([][]published{{{
+				payload: "order",
+				values:  map[string]string{"topic": "orders"},
+				headers: map[string][]byte{"content-type": []byte("application/json")},
+			}}}))
+		})
+
+		It("publishes messages with different values separately", func() {
+			Expect(ob.Publish(outbox.WithValue(ctx, "topic", "orders"), nil, outbox.Message{Payload: []byte("order")})).To(Succeed())
+			Expect(ob.Publish(outbox.WithValue(ctx, "topic", "payments"), nil, outbox.Message{Payload: []byte("payment")})).To(Succeed())
+			Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("plain")})).To(Succeed())
+
+			Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+
+			Expect(getCalls()).To(ConsistOf(
+				[]published{{payload: "order", values: map[string]string{"topic": "orders"}}},
+				[]published{{payload: "payment", values: map[string]string{"topic": "payments"}}},
+				[]published{{payload: "plain"}},
+			))
+		})
+	})
+})
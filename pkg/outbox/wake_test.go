@@ -0,0 +1,88 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// unreliableStorage fails to record messages while failing is set
+type unreliableStorage struct {
+	*fake.EntryStorage
+	failing bool
+}
+
+func (u *unreliableStorage) Publish(ctx context.Context, txn interface{}, messages ...outbox.Message) error {
+	if u.failing {
+		return errors.New("storage unavailable")
+	}
+	return u.EntryStorage.Publish(ctx, txn, messages...)
+}
+
+var _ = Describe("PublishThenWake", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *unreliableStorage
+	var publisher *fake.Publisher
+	var pumped chan int
+	var ob *outbox.Outbox
+	var errChan chan error
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &unreliableStorage{
+			EntryStorage: &fake.EntryStorage{
+				Clock: clock,
+			},
+		}
+		publisher = &fake.Publisher{}
+		pumped = make(chan int, 10)
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       publisher,
+			ProcessInterval: time.Minute,
+			ProcessorID:     "test",
+			OnPumpComplete: func(processed int, err error) {
+				pumped <- processed
+			},
+		})
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func() {
+			errChan <- ob.StartProcessing(ctx)
+		}()
+		clock.BlockUntil(1)
+	})
+
+	AfterEach(func() {
+		Expect(ob.Shutdown(ctx)).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+	})
+
+	It("wakes the processor to publish the messages", func() {
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("urgent")})).To(Succeed())
+
+		Eventually(pumped).Should(Receive(Equal(1)))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+	})
+
+	It("does not wake the processor when publishing fails", func() {
+		storage.failing = true
+
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("lost")})).ToNot(Succeed())
+
+		Consistently(pumped).ShouldNot(Receive())
+	})
+})
@@ -0,0 +1,113 @@
+package outbox_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+var _ = Describe("Waking during a pump", func() {
+	const delay = 10 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var storage *fake.EntryStorage
+	var publisher *fake.Publisher
+	var pumped chan int
+	var ob *outbox.Outbox
+	var errChan chan error
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+			Delay:  delay,
+			Clock:  clock,
+		}
+		pumped = make(chan int, 10)
+
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:           clock,
+			Storage:         storage,
+			Publisher:       publisher,
+			ProcessInterval: time.Minute,
+			ProcessorID:     "test",
+			OnPumpComplete: func(processed int, err error) {
+				pumped <- processed
+			},
+		})
+		Expect(err).To(Succeed())
+
+		errChan = make(chan error, 1)
+		go func(ob *outbox.Outbox, ctx context.Context, errChan chan<- error) {
+			errChan <- ob.StartProcessing(ctx)
+		}(ob, ctx, errChan)
+		clock.BlockUntil(1)
+
+		// the processor is blocked publishing the first message, with the publisher's delay alongside the process
+		// interval timer
+		Expect(ob.PublishThenWake(ctx, nil, outbox.Message{Payload: []byte("first")})).To(Succeed())
+		clock.BlockUntil(2)
+	})
+
+	AfterEach(func() {
+		clock.Advance(delay)
+		Expect(ob.Shutdown(context.Background())).To(Succeed())
+		Expect(errChan).To(Receive(BeNil()))
+	})
+
+	It("coalesces a burst of wakes into one more pump, counting those dropped", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("second")})).To(Succeed())
+		for i := 0; i < 10; i++ {
+			ob.WakeProcessor()
+		}
+		Expect(ob.Stats().DroppedWakes).To(Equal(9))
+
+		clock.Advance(delay)
+		Eventually(pumped).Should(Receive(Equal(1)))
+
+		clock.BlockUntil(2)
+		clock.Advance(delay)
+		Eventually(pumped).Should(Receive(Equal(1)))
+		Expect(publisher.GetPublishedCount()).To(Equal(2))
+		Consistently(pumped).ShouldNot(Receive())
+	})
+
+	It("blocks WakeProcessorCtx until the pending wake is taken", func() {
+		ob.WakeProcessor()
+
+		woken := make(chan error, 1)
+		go func(ob *outbox.Outbox, ctx context.Context, woken chan<- error) {
+			woken <- ob.WakeProcessorCtx(ctx)
+		}(ob, ctx, woken)
+		Consistently(woken).ShouldNot(Receive())
+
+		clock.Advance(delay)
+		Eventually(woken).Should(Receive(BeNil()))
+		Expect(ob.Stats().DroppedWakes).To(Equal(0))
+	})
+
+	It("returns the context's error from WakeProcessorCtx if the wake isn't taken in time", func() {
+		ob.WakeProcessor()
+
+		wakeCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		Expect(ob.WakeProcessorCtx(wakeCtx)).To(MatchError(context.DeadlineExceeded))
+	})
+
+	It("doesn't block WakeProcessorCtx if no wake is pending", func() {
+		Expect(ob.WakeProcessorCtx(ctx)).To(Succeed())
+	})
+})
@@ -0,0 +1,115 @@
+// Package prometheus provides an outbox.Metrics implementation that exports measurements
+// of the outbox processor as Prometheus metrics.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+const namespace = "outboxen"
+
+// Collector implements outbox.Metrics by updating Prometheus metrics
+type Collector struct {
+	published       prometheus.Counter
+	publishFailures prometheus.Counter
+	expiredEntries  prometheus.Counter
+	claimedEntries  prometheus.Counter
+	batchDuration   prometheus.Histogram
+	claimDuration   prometheus.Histogram
+	backlogSize     prometheus.Gauge
+}
+
+// NewCollector creates a Collector and registers its metrics with the provided prometheus.Registerer.
+// Like prometheus.Registerer.MustRegister, it panics if the metrics cannot be registered, e.g. because
+// another Collector has already been registered with the same Registerer.
+func NewCollector(reg prometheus.Registerer) outbox.Metrics {
+	c := &Collector{
+		published: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "published_messages_total",
+			Help:      "Total number of outbox messages published successfully.",
+		}),
+		publishFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "publish_failures_total",
+			Help:      "Total number of outbox messages that failed to publish.",
+		}),
+		expiredEntries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "expired_entries_total",
+			Help:      "Total number of outbox entries that expired before they could be published.",
+		}),
+		claimedEntries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "claimed_entries_total",
+			Help:      "Total number of outbox entries retrieved for processing.",
+		}),
+		batchDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "batch_processing_duration_seconds",
+			Help:      "Time taken to publish and delete a batch of outbox entries.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		claimDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "claim_duration_seconds",
+			Help:      "Time taken to claim outbox entries in storage.",
+			Buckets:   prometheus.DefBuckets,
+		}),
+		backlogSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "backlog_entries",
+			Help:      "Number of outbox entries waiting to be published, as of the latest call to Outbox.BacklogSize.",
+		}),
+	}
+
+	reg.MustRegister(
+		c.published,
+		c.publishFailures,
+		c.expiredEntries,
+		c.claimedEntries,
+		c.batchDuration,
+		c.claimDuration,
+		c.backlogSize,
+	)
+
+	return c
+}
+
+// RecordClaimDuration implements the outbox.Metrics interface
+func (c *Collector) RecordClaimDuration(d time.Duration) {
+	c.claimDuration.Observe(d.Seconds())
+}
+
+// RecordBatchProcessed implements the outbox.Metrics interface
+func (c *Collector) RecordBatchProcessed(size int, duration time.Duration) {
+	c.claimedEntries.Add(float64(size))
+	c.batchDuration.Observe(duration.Seconds())
+}
+
+// RecordPublished implements the outbox.Metrics interface
+func (c *Collector) RecordPublished(count int) {
+	c.published.Add(float64(count))
+}
+
+// RecordPublishFailure implements the outbox.Metrics interface
+func (c *Collector) RecordPublishFailure(count int) {
+	c.publishFailures.Add(float64(count))
+}
+
+// RecordExpired implements the outbox.Metrics interface
+func (c *Collector) RecordExpired(count int) {
+	c.expiredEntries.Add(float64(count))
+}
+
+// RecordBacklogSize implements the outbox.BacklogMetrics interface
+func (c *Collector) RecordBacklogSize(size int) {
+	c.backlogSize.Set(float64(size))
+}
+
+var _ outbox.Metrics = (*Collector)(nil)
+var _ outbox.BacklogMetrics = (*Collector)(nil)
@@ -0,0 +1,142 @@
+package prometheus_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+	outboxprometheus "github.com/omaskery/outboxen/pkg/prometheus"
+)
+
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(context.Context, ...outbox.Message) error {
+	return errors.New("publisher unavailable")
+}
+
+var _ = Describe("Collector", func() {
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var reg *prometheus.Registry
+	var storage *fake.EntryStorage
+	var publisher outbox.Publisher
+	var maxEntryAge time.Duration
+	var ob *outbox.Outbox
+
+	gather := func() map[string]*dto.MetricFamily {
+		families, err := reg.Gather()
+		Expect(err).To(Succeed())
+
+		byName := make(map[string]*dto.MetricFamily, len(families))
+		for _, family := range families {
+			byName[family.GetName()] = family
+		}
+		return byName
+	}
+
+	counterValue := func(name string) float64 {
+		family, ok := gather()[name]
+		Expect(ok).To(BeTrue(), "metric %q not registered", name)
+		return family.GetMetric()[0].GetCounter().GetValue()
+	}
+
+	histogramCount := func(name string) uint64 {
+		family, ok := gather()[name]
+		Expect(ok).To(BeTrue(), "metric %q not registered", name)
+		return family.GetMetric()[0].GetHistogram().GetSampleCount()
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		reg = prometheus.NewRegistry()
+
+		clock = clockwork.NewFakeClock()
+		maxEntryAge = 0
+		storage = &fake.EntryStorage{
+			Clock: clock,
+		}
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+
+		Expect(storage.Publish(ctx, nil, outbox.Message{}, outbox.Message{}, outbox.Message{})).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		ob, err = outbox.New(outbox.Config{
+			Clock:         clock,
+			Storage:       storage,
+			Publisher:     publisher,
+			MaxEntryAge:   maxEntryAge,
+			ClaimDuration: 5 * time.Second,
+			ProcessorID:   "test",
+			BatchSize:     2,
+			Metrics:       outboxprometheus.NewCollector(reg),
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("panics when registered twice with the same registerer", func() {
+		Expect(func() { outboxprometheus.NewCollector(reg) }).To(Panic())
+	})
+
+	It("records a successful pump", func() {
+		Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+		Expect(counterValue("outboxen_published_messages_total")).To(BeNumerically("==", 3))
+		Expect(counterValue("outboxen_claimed_entries_total")).To(BeNumerically("==", 3))
+		Expect(counterValue("outboxen_publish_failures_total")).To(BeNumerically("==", 0))
+		Expect(counterValue("outboxen_expired_entries_total")).To(BeNumerically("==", 0))
+		Expect(histogramCount("outboxen_batch_processing_duration_seconds")).To(BeNumerically("==", 2))
+		Expect(histogramCount("outboxen_claim_duration_seconds")).To(BeNumerically("==", 1))
+	})
+
+	It("records the backlog size", func() {
+		Expect(ob.BacklogSize(ctx)).To(Equal(3))
+		Expect(gather()["outboxen_backlog_entries"].GetMetric()[0].GetGauge().GetValue()).To(BeNumerically("==", 3))
+
+		Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+		Expect(ob.BacklogSize(ctx)).To(Equal(0))
+		Expect(gather()["outboxen_backlog_entries"].GetMetric()[0].GetGauge().GetValue()).To(BeNumerically("==", 0))
+	})
+
+	When("publishing fails", func() {
+		BeforeEach(func() {
+			publisher = failingPublisher{}
+		})
+
+		It("records the failures", func() {
+			_, err := ob.PumpOutbox(ctx)
+			Expect(err).ToNot(Succeed())
+
+			Expect(counterValue("outboxen_published_messages_total")).To(BeNumerically("==", 0))
+			Expect(counterValue("outboxen_claimed_entries_total")).To(BeNumerically("==", 2))
+			Expect(counterValue("outboxen_publish_failures_total")).To(BeNumerically("==", 2))
+			Expect(histogramCount("outboxen_batch_processing_duration_seconds")).To(BeNumerically("==", 1))
+		})
+	})
+
+	When("entries expire", func() {
+		BeforeEach(func() {
+			maxEntryAge = time.Minute
+			clock.Advance(2 * time.Minute)
+		})
+
+		It("records the expired entries", func() {
+			Expect(ob.PumpOutbox(ctx)).To(Equal(3))
+
+			Expect(counterValue("outboxen_expired_entries_total")).To(BeNumerically("==", 3))
+			Expect(counterValue("outboxen_published_messages_total")).To(BeNumerically("==", 0))
+		})
+	})
+})
@@ -0,0 +1,228 @@
+// Package circuitbreaker implements a circuit breaker for outbox.Publisher implementations, so that while the
+// destination is down the Outbox fails fast rather than attempting to publish every batch to it.
+//
+// The breaker starts closed, passing every call through. Once FailureThreshold calls in a row have failed it opens,
+// failing every call with ErrOpen without publishing until the Cooldown has passed. It then half-opens, letting a
+// single call through to probe the destination: if that call succeeds the breaker closes again, otherwise it opens
+// for another Cooldown.
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+const (
+	// DefaultFailureThreshold is how many calls in a row must fail to open the breaker by default
+	DefaultFailureThreshold = 5
+	// DefaultCooldown is how long the breaker stays open before probing the destination by default
+	DefaultCooldown = 30 * time.Second
+)
+
+// ErrOpen is returned, without publishing, by publishers wrapped by a Breaker while it is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a Breaker
+type State int
+
+const (
+	// StateClosed passes every call through to the publisher
+	StateClosed State = iota
+	// StateOpen fails every call with ErrOpen
+	StateOpen
+	// StateHalfOpen passes a single call through to probe whether the publisher has recovered
+	StateHalfOpen
+)
+
+// String provides a human readable name for the state
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Config configures the behaviour of the Breaker
+type Config struct {
+	// FailureThreshold is how many calls in a row must fail to open the breaker, defaults to DefaultFailureThreshold
+	FailureThreshold int
+	// Cooldown is how long the breaker stays open before probing the publisher, defaults to DefaultCooldown
+	Cooldown time.Duration
+	// Clock abstracts interactions with the time package, defaults to a real clock implementation
+	Clock outbox.Clock
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.FailureThreshold < 0 {
+		return errors.New("failure threshold must not be negative")
+	}
+	if c.FailureThreshold == 0 {
+		c.FailureThreshold = DefaultFailureThreshold
+	}
+
+	if c.Cooldown < 0 {
+		return errors.New("cooldown must not be negative")
+	}
+	if c.Cooldown == 0 {
+		c.Cooldown = DefaultCooldown
+	}
+
+	if c.Clock == nil {
+		c.Clock = clockwork.NewRealClock()
+	}
+
+	return nil
+}
+
+// Breaker tracks whether calls to a publisher are failing, opening to fail further calls fast. Publishers are wrapped
+// by passing Middleware to outbox.Chain; every publisher wrapped by the same Breaker shares its state.
+//
+// A call only counts as failing if it published none of its messages, and not every message was rejected with an
+// error wrapping outbox.ErrPermanent, as the destination is clearly reachable if it published or rejected some of
+// them. Calls abandoned because their context was done don't count either way.
+type Breaker struct {
+	config Config
+
+	lock     sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// New attempts to construct a Breaker from the provided Config, if the Config is valid
+func New(cfg Config) (*Breaker, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Breaker{
+		config: cfg,
+	}, nil
+}
+
+// Middleware implements outbox.PublisherMiddleware, wrapping the publisher in the Breaker
+func (b *Breaker) Middleware(next outbox.Publisher) outbox.Publisher {
+	return outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+		if !b.allow() {
+			return ErrOpen
+		}
+
+		err := next.Publish(ctx, messages...)
+		if ctx.Err() != nil {
+			b.abandon()
+		} else {
+			b.record(failed(len(messages), err))
+		}
+
+		return err
+	})
+}
+
+// State reports the current state of the breaker, which is half-open once an open breaker's cooldown has passed even
+// if no call has yet probed the publisher
+func (b *Breaker) State() State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == StateOpen && b.cooledDown() {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// allow reports whether a call may be passed through to the publisher, making it the probe if the breaker is
+// half-open
+func (b *Breaker) allow() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state == StateOpen && b.cooledDown() {
+		b.state = StateHalfOpen
+	}
+
+	switch b.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+
+	return true
+}
+
+// record updates the state of the breaker with the outcome of a call that was passed through to the publisher
+func (b *Breaker) record(failure bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+
+	if !failure {
+		b.state = StateClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || b.failures >= b.config.FailureThreshold {
+		b.state = StateOpen
+		b.openedAt = b.config.Clock.Now()
+	}
+}
+
+// abandon releases the probe, if the call was probing, so that another call may probe the publisher instead
+func (b *Breaker) abandon() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.probing = false
+}
+
+// cooledDown reports whether the open breaker's cooldown has passed
+func (b *Breaker) cooledDown() bool {
+	return b.config.Clock.Now().Sub(b.openedAt) >= b.config.Cooldown
+}
+
+// failed reports whether a call publishing count messages, which returned err, failed to reach the publisher's
+// destination
+func failed(count int, err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var publishErr *outbox.PublishError
+	if !errors.As(err, &publishErr) || len(publishErr.Errors) != count {
+		return !errors.Is(err, outbox.ErrPermanent)
+	}
+
+	transient := false
+	for _, err := range publishErr.Errors {
+		if err == nil {
+			return false
+		}
+		if !errors.Is(err, outbox.ErrPermanent) {
+			transient = true
+		}
+	}
+
+	return transient
+}
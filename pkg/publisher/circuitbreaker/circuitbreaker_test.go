@@ -0,0 +1,178 @@
+package circuitbreaker_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/publisher/circuitbreaker"
+)
+
+var _ = Describe("Breaker", func() {
+	const cooldown = 30 * time.Second
+
+	var ctx context.Context
+	var clock clockwork.FakeClock
+	var brokerDown bool
+	var publisher *fake.Publisher
+	var breaker *circuitbreaker.Breaker
+	var chained outbox.Publisher
+
+	message := outbox.Message{Payload: []byte("hello")}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+		brokerDown = false
+		publisher = &fake.Publisher{
+			Logger: logr.Discard(),
+			FailFunc: func(msg outbox.Message) error {
+				if brokerDown {
+					return errors.New("broker unavailable")
+				}
+				if string(msg.Payload) == "invalid" {
+					return outbox.Permanent(errors.New("message too large"))
+				}
+				return nil
+			},
+		}
+
+		var err error
+		breaker, err = circuitbreaker.New(circuitbreaker.Config{
+			FailureThreshold: 3,
+			Cooldown:         cooldown,
+			Clock:            clock,
+		})
+		Expect(err).To(Succeed())
+
+		chained = outbox.Chain(publisher, breaker.Middleware)
+	})
+
+	It("validates its config", func() {
+		_, err := circuitbreaker.New(circuitbreaker.Config{FailureThreshold: -1})
+		Expect(err).To(MatchError(ContainSubstring("failure threshold must not be negative")))
+
+		_, err = circuitbreaker.New(circuitbreaker.Config{Cooldown: -time.Second})
+		Expect(err).To(MatchError(ContainSubstring("cooldown must not be negative")))
+	})
+
+	It("opens after consecutive failures, then probes and closes once the publisher recovers", func() {
+		Expect(breaker.State()).To(Equal(circuitbreaker.StateClosed))
+
+		brokerDown = true
+		for i := 0; i < 3; i++ {
+			Expect(breaker.State()).To(Equal(circuitbreaker.StateClosed))
+			Expect(chained.Publish(ctx, message)).To(MatchError(ContainSubstring("failed to publish 1/1 messages")))
+		}
+		Expect(breaker.State()).To(Equal(circuitbreaker.StateOpen))
+
+		// while open, calls fail fast without reaching the publisher
+		brokerDown = false
+		Expect(chained.Publish(ctx, message)).To(MatchError(circuitbreaker.ErrOpen))
+		Expect(publisher.GetPublishedCount()).To(Equal(0))
+
+		clock.Advance(cooldown)
+		Expect(breaker.State()).To(Equal(circuitbreaker.StateHalfOpen))
+
+		Expect(chained.Publish(ctx, message)).To(Succeed())
+		Expect(breaker.State()).To(Equal(circuitbreaker.StateClosed))
+		Expect(publisher.GetPublishedCount()).To(Equal(1))
+	})
+
+	It("opens again if the probe fails", func() {
+		brokerDown = true
+		for i := 0; i < 3; i++ {
+			Expect(chained.Publish(ctx, message)).NotTo(Succeed())
+		}
+
+		clock.Advance(cooldown)
+		Expect(chained.Publish(ctx, message)).To(MatchError(ContainSubstring("failed to publish 1/1 messages")))
+		Expect(breaker.State()).To(Equal(circuitbreaker.StateOpen))
+
+		clock.Advance(cooldown / 2)
+		Expect(chained.Publish(ctx, message)).To(MatchError(circuitbreaker.ErrOpen))
+	})
+
+	It("only lets one call probe the publisher", func() {
+		probing := make(chan struct{})
+		release := make(chan struct{})
+		slow := outbox.PublisherFunc(func(ctx context.Context, messages ...outbox.Message) error {
+			close(probing)
+			<-release
+			return nil
+		})
+
+		brokerDown = true
+		for i := 0; i < 3; i++ {
+			Expect(chained.Publish(ctx, message)).NotTo(Succeed())
+		}
+		clock.Advance(cooldown)
+
+		// publishers wrapped by the same breaker share its state
+		slowChained := outbox.Chain(slow, breaker.Middleware)
+		errChan := make(chan error, 1)
+		go func(slowChained outbox.Publisher, errChan chan<- error) {
+			errChan <- slowChained.Publish(ctx, message)
+		}(slowChained, errChan)
+
+		Eventually(probing).Should(BeClosed())
+		Expect(chained.Publish(ctx, message)).To(MatchError(circuitbreaker.ErrOpen))
+
+		close(release)
+		Eventually(errChan).Should(Receive(BeNil()))
+		Expect(breaker.State()).To(Equal(circuitbreaker.StateClosed))
+	})
+
+	It("resets the failure count after a success", func() {
+		brokerDown = true
+		Expect(chained.Publish(ctx, message)).NotTo(Succeed())
+		Expect(chained.Publish(ctx, message)).NotTo(Succeed())
+
+		brokerDown = false
+		Expect(chained.Publish(ctx, message)).To(Succeed())
+
+		brokerDown = true
+		Expect(chained.Publish(ctx, message)).NotTo(Succeed())
+		Expect(chained.Publish(ctx, message)).NotTo(Succeed())
+		Expect(breaker.State()).To(Equal(circuitbreaker.StateClosed))
+	})
+
+	It("doesn't count messages rejected permanently as failures", func() {
+		for i := 0; i < 5; i++ {
+			Expect(chained.Publish(ctx, outbox.Message{Payload: []byte("invalid")})).To(MatchError(ContainSubstring("failed to publish 1/1 messages")))
+		}
+		Expect(breaker.State()).To(Equal(circuitbreaker.StateClosed))
+	})
+
+	It("fails pumps fast while open", func() {
+		storage := &fake.EntryStorage{
+			Clock: clock,
+		}
+		ob, err := outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   chained,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+		Expect(storage.Publish(ctx, nil, message)).To(Succeed())
+
+		brokerDown = true
+		for i := 0; i < 3; i++ {
+			_, err = ob.PumpOutbox(ctx)
+			Expect(err).NotTo(Succeed())
+			clock.Advance(outbox.DefaultClaimDuration)
+		}
+
+		_, err = ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(circuitbreaker.ErrOpen))
+		Expect(storage.CountEntries()).To(Equal(1))
+	})
+})
@@ -0,0 +1,13 @@
+package gcppubsub_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestGCPPubSub(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "GCP Pub/Sub Suite")
+}
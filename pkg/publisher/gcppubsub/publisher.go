@@ -0,0 +1,174 @@
+// Package gcppubsub implements outbox.Publisher on top of Google Cloud Pub/Sub, using the official client
+package gcppubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"cloud.google.com/go/pubsub"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// DefaultKeyAttribute is the attribute the message Key is published in if Config.KeyAttribute is not provided
+const DefaultKeyAttribute = "outboxen-key"
+
+// TopicFunc derives the ID of the topic to publish to from the namespace the messages were published in
+type TopicFunc func(namespace string) string
+
+// Config configures the behaviour of the Publisher
+type Config struct {
+	// Client is used to publish messages to topics in its project
+	Client *pubsub.Client
+	// TopicID is the ID of the topic all messages are published to, unless TopicFunc is provided
+	TopicID string
+	// TopicFunc derives the topic ID from the namespace of the messages being published, taking precedence
+	// over TopicID. If neither is provided the namespace is used as the topic ID.
+	TopicFunc TopicFunc
+	// KeyAttribute is the attribute the message Key is published in, defaults to DefaultKeyAttribute
+	KeyAttribute string
+	// EnableOrdering uses the message Key as the Pub/Sub ordering key, so that subscribers with message
+	// ordering enabled receive messages with the same key in the order they were published
+	EnableOrdering bool
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.Client == nil {
+		return errors.New("no client provided")
+	}
+
+	if c.TopicFunc == nil {
+		topicID := c.TopicID
+		c.TopicFunc = func(namespace string) string {
+			if topicID != "" {
+				return topicID
+			}
+			return namespace
+		}
+	}
+
+	if c.KeyAttribute == "" {
+		c.KeyAttribute = DefaultKeyAttribute
+	}
+
+	return nil
+}
+
+// Publisher implements outbox.Publisher by publishing each message to a Pub/Sub topic, waiting for the
+// server to acknowledge every message before returning. Stop must be called once it is no longer needed.
+type Publisher struct {
+	config Config
+
+	topicsLock sync.Mutex
+	topics     map[string]*pubsub.Topic
+}
+
+// New attempts to construct a Publisher from the provided Config, if the Config is valid
+func New(cfg Config) (*Publisher, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Publisher{
+		config: cfg,
+		topics: map[string]*pubsub.Topic{},
+	}, nil
+}
+
+// Publish implements the outbox.Publisher interface. The messages are published concurrently, and if only
+// some of them are not acknowledged the returned error is an *outbox.PublishError aligned with the messages.
+func (p *Publisher) Publish(ctx context.Context, messages ...outbox.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	namespace := outbox.NamespaceFromContext(ctx)
+	topicID := p.config.TopicFunc(namespace)
+	if topicID == "" {
+		return fmt.Errorf("no topic for namespace %q", namespace)
+	}
+	topic := p.topic(topicID)
+
+	results := make([]*pubsub.PublishResult, 0, len(messages))
+	for _, msg := range messages {
+		results = append(results, topic.Publish(ctx, p.pubsubMessage(msg)))
+	}
+
+	errs := make([]error, len(messages))
+	failed := false
+	for i, result := range results {
+		if _, err := result.Get(ctx); err != nil {
+			errs[i] = err
+			failed = true
+
+			// a failure pauses publishing for the ordering key, so resume it for the outbox to retry
+			if p.config.EnableOrdering && len(messages[i].Key) > 0 {
+				topic.ResumePublish(string(messages[i].Key))
+			}
+		}
+	}
+
+	if failed {
+		return &outbox.PublishError{
+			Errors: errs,
+		}
+	}
+
+	return nil
+}
+
+// Stop flushes and stops every topic the Publisher has published to
+func (p *Publisher) Stop() {
+	p.topicsLock.Lock()
+	defer p.topicsLock.Unlock()
+
+	for id, topic := range p.topics {
+		topic.Stop()
+		delete(p.topics, id)
+	}
+}
+
+// topic returns the topic with the given ID, which is reused between batches so that its publishing
+// goroutines and ordering state persist
+func (p *Publisher) topic(id string) *pubsub.Topic {
+	p.topicsLock.Lock()
+	defer p.topicsLock.Unlock()
+
+	topic, ok := p.topics[id]
+	if !ok {
+		topic = p.config.Client.Topic(id)
+		topic.EnableMessageOrdering = p.config.EnableOrdering
+		p.topics[id] = topic
+	}
+
+	return topic
+}
+
+// pubsubMessage converts a message to the Pub/Sub message to publish
+func (p *Publisher) pubsubMessage(msg outbox.Message) *pubsub.Message {
+	attributes := make(map[string]string, len(msg.Headers)+1)
+	for key, value := range msg.Headers {
+		attributes[key] = string(value)
+	}
+
+	result := &pubsub.Message{
+		Data: msg.Payload,
+	}
+	if len(msg.Key) > 0 {
+		attributes[p.config.KeyAttribute] = string(msg.Key)
+		if p.config.EnableOrdering {
+			result.OrderingKey = string(msg.Key)
+		}
+	}
+	if len(attributes) > 0 {
+		result.Attributes = attributes
+	}
+
+	return result
+}
+
+var _ outbox.Publisher = (*Publisher)(nil)
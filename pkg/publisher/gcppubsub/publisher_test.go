@@ -0,0 +1,140 @@
+package gcppubsub_test
+
+import (
+	"bytes"
+	"context"
+
+	"cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/publisher/gcppubsub"
+)
+
+var _ = Describe("Publisher", func() {
+	const projectID = "project"
+
+	var ctx context.Context
+	var srv *pstest.Server
+	var conn *grpc.ClientConn
+	var client *pubsub.Client
+	var cfg gcppubsub.Config
+	var publisher *gcppubsub.Publisher
+
+	BeforeEach(func() {
+		ctx = outbox.WithNamespace(context.Background(), "orders")
+
+		srv = pstest.NewServer()
+
+		var err error
+		conn, err = grpc.NewClient(srv.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		Expect(err).To(Succeed())
+		client, err = pubsub.NewClient(ctx, projectID, option.WithGRPCConn(conn))
+		Expect(err).To(Succeed())
+
+		_, err = client.CreateTopic(ctx, "orders")
+		Expect(err).To(Succeed())
+
+		cfg = gcppubsub.Config{
+			Client: client,
+		}
+	})
+
+	AfterEach(func() {
+		publisher.Stop()
+		// closing the client also closes the connection it was given
+		Expect(client.Close()).To(Succeed())
+		Expect(srv.Close()).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		publisher, err = gcppubsub.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("fails to construct without a client", func() {
+		_, err := gcppubsub.New(gcppubsub.Config{})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("publishes messages to the namespace topic", func() {
+		Expect(publisher.Publish(ctx, outbox.Message{
+			Key:     []byte("key"),
+			Payload: []byte("payload"),
+			Headers: map[string][]byte{"header": []byte("value")},
+		})).To(Succeed())
+
+		msgs := srv.Messages()
+		Expect(msgs).To(HaveLen(1))
+		Expect(msgs[0].Data).To(Equal([]byte("payload")))
+		Expect(msgs[0].Attributes).To(Equal(map[string]string{
+			"header":                      "value",
+			gcppubsub.DefaultKeyAttribute: "key",
+		}))
+		Expect(msgs[0].OrderingKey).To(BeEmpty())
+	})
+
+	It("fails to publish to a topic that doesn't exist", func() {
+		Expect(publisher.Publish(outbox.WithNamespace(ctx, "missing"), outbox.Message{
+			Payload: []byte("payload"),
+		})).ToNot(Succeed())
+	})
+
+	It("only fails the messages that are not acknowledged", func() {
+		err := publisher.Publish(ctx,
+			outbox.Message{Payload: []byte("1")},
+			outbox.Message{Payload: bytes.Repeat([]byte("x"), pubsub.MaxPublishRequestBytes)},
+			outbox.Message{Payload: []byte("3")},
+		)
+
+		var publishErr *outbox.PublishError
+		Expect(err).To(BeAssignableToTypeOf(publishErr))
+		publishErr = err.(*outbox.PublishError)
+		Expect(publishErr.Errors).To(HaveLen(3))
+		Expect(publishErr.Errors[0]).To(Succeed())
+		Expect(publishErr.Errors[1]).ToNot(Succeed())
+		Expect(publishErr.Errors[2]).To(Succeed())
+
+		Expect(srv.Messages()).To(HaveLen(2))
+	})
+
+	When("a topic is configured", func() {
+		BeforeEach(func() {
+			cfg.TopicID = "orders"
+			ctx = context.Background()
+		})
+
+		It("publishes to it regardless of namespace", func() {
+			Expect(publisher.Publish(ctx, outbox.Message{Payload: []byte("payload")})).To(Succeed())
+			Expect(srv.Messages()).To(HaveLen(1))
+		})
+	})
+
+	When("ordering is enabled", func() {
+		BeforeEach(func() {
+			cfg.EnableOrdering = true
+		})
+
+		It("uses the key as the ordering key", func() {
+			Expect(publisher.Publish(ctx, outbox.Message{Key: []byte("key"), Payload: []byte("payload")})).To(Succeed())
+
+			msgs := srv.Messages()
+			Expect(msgs).To(HaveLen(1))
+			Expect(msgs[0].OrderingKey).To(Equal("key"))
+		})
+
+		It("resumes publishing a key once it has failed", func() {
+			oversized := outbox.Message{Key: []byte("key"), Payload: bytes.Repeat([]byte("x"), pubsub.MaxPublishRequestBytes)}
+			Expect(publisher.Publish(ctx, oversized)).ToNot(Succeed())
+
+			Expect(publisher.Publish(ctx, outbox.Message{Key: []byte("key"), Payload: []byte("payload")})).To(Succeed())
+			Expect(srv.Messages()).To(HaveLen(1))
+		})
+	})
+})
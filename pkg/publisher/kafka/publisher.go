@@ -0,0 +1,142 @@
+// Package kafka implements outbox.Publisher on top of the franz-go Kafka client
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// Producer synchronously produces records to Kafka, and is implemented by *kgo.Client
+type Producer interface {
+	ProduceSync(ctx context.Context, rs ...*kgo.Record) kgo.ProduceResults
+}
+
+// TopicFunc derives the topic to produce to from the namespace the messages were published in
+type TopicFunc func(namespace string) string
+
+// Config configures the behaviour of the Publisher
+type Config struct {
+	// Producer is used to produce records to Kafka, typically a *kgo.Client
+	Producer Producer
+	// Topic is the topic all messages are produced to, unless TopicFunc is provided
+	Topic string
+	// TopicFunc derives the topic from the namespace of the messages being published, taking precedence
+	// over Topic. If neither is provided the namespace is used as the topic.
+	TopicFunc TopicFunc
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.Producer == nil {
+		return errors.New("no producer provided")
+	}
+
+	if c.TopicFunc == nil {
+		topic := c.Topic
+		c.TopicFunc = func(namespace string) string {
+			if topic != "" {
+				return topic
+			}
+			return namespace
+		}
+	}
+
+	return nil
+}
+
+// Publisher implements outbox.Publisher by producing each message as a Kafka record, using the message
+// Key as the record key so that messages with the same key land in the same partition
+type Publisher struct {
+	config Config
+}
+
+// New attempts to construct a Publisher from the provided Config, if the Config is valid
+func New(cfg Config) (*Publisher, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Publisher{
+		config: cfg,
+	}, nil
+}
+
+// Publish implements the outbox.Publisher interface. If only some of the records fail to produce, the
+// returned error is an *outbox.PublishError aligned with the provided messages.
+func (p *Publisher) Publish(ctx context.Context, messages ...outbox.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	namespace := outbox.NamespaceFromContext(ctx)
+	topic := p.config.TopicFunc(namespace)
+	if topic == "" {
+		return fmt.Errorf("no topic for namespace %q", namespace)
+	}
+
+	records := make([]*kgo.Record, 0, len(messages))
+	indexes := make(map[*kgo.Record]int, len(messages))
+	for i, msg := range messages {
+		record := &kgo.Record{
+			Topic:   topic,
+			Key:     msg.Key,
+			Value:   msg.Payload,
+			Headers: recordHeaders(msg.Headers),
+		}
+		records = append(records, record)
+		indexes[record] = i
+	}
+
+	// results are not necessarily in the order the records were produced, so align them by record
+	errs := make([]error, len(messages))
+	failed := false
+	for _, result := range p.config.Producer.ProduceSync(ctx, records...) {
+		if result.Err == nil {
+			continue
+		}
+
+		i, ok := indexes[result.Record]
+		if !ok {
+			return fmt.Errorf("error producing records: %w", result.Err)
+		}
+		errs[i] = result.Err
+		failed = true
+	}
+
+	if failed {
+		return &outbox.PublishError{
+			Errors: errs,
+		}
+	}
+
+	return nil
+}
+
+// recordHeaders converts message headers to record headers, sorted by key so records are deterministic
+func recordHeaders(headers map[string][]byte) []kgo.RecordHeader {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	result := make([]kgo.RecordHeader, 0, len(headers))
+	for key, value := range headers {
+		result = append(result, kgo.RecordHeader{
+			Key:   key,
+			Value: value,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+
+	return result
+}
+
+var _ outbox.Publisher = (*Publisher)(nil)
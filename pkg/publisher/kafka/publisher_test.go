@@ -0,0 +1,134 @@
+package kafka_test
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/publisher/kafka"
+)
+
+// mockProducer records produced records, failing those whose value matches a configured error.
+// Results are returned in reverse order, as a real client makes no ordering guarantees.
+type mockProducer struct {
+	produced []*kgo.Record
+	failures map[string]error
+}
+
+func (m *mockProducer) ProduceSync(_ context.Context, rs ...*kgo.Record) kgo.ProduceResults {
+	results := make(kgo.ProduceResults, 0, len(rs))
+	for i := len(rs) - 1; i >= 0; i-- {
+		m.produced = append(m.produced, rs[i])
+		results = append(results, kgo.ProduceResult{
+			Record: rs[i],
+			Err:    m.failures[string(rs[i].Value)],
+		})
+	}
+	return results
+}
+
+var _ = Describe("Publisher", func() {
+	var ctx context.Context
+	var producer *mockProducer
+	var cfg kafka.Config
+	var publisher *kafka.Publisher
+
+	BeforeEach(func() {
+		ctx = outbox.WithNamespace(context.Background(), "orders")
+		producer = &mockProducer{
+			failures: map[string]error{},
+		}
+		cfg = kafka.Config{
+			Producer: producer,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		publisher, err = kafka.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("fails to construct without a producer", func() {
+		_, err := kafka.New(kafka.Config{})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("maps messages onto records", func() {
+		Expect(publisher.Publish(ctx, outbox.Message{
+			Key:     []byte("key"),
+			Payload: []byte("payload"),
+			Headers: map[string][]byte{
+				"b": []byte("2"),
+				"a": []byte("1"),
+			},
+		})).To(Succeed())
+
+		Expect(producer.produced).To(HaveLen(1))
+		record := producer.produced[0]
+		Expect(record.Key).To(Equal([]byte("key")))
+		Expect(record.Value).To(Equal([]byte("payload")))
+		Expect(record.Headers).To(Equal([]kgo.RecordHeader{
+			{Key: "a", Value: []byte("1")},
+			{Key: "b", Value: []byte("2")},
+		}))
+	})
+
+	It("uses the namespace as the topic by default", func() {
+		Expect(publisher.Publish(ctx, outbox.Message{})).To(Succeed())
+		Expect(producer.produced[0].Topic).To(Equal("orders"))
+	})
+
+	It("fails without a namespace or topic", func() {
+		Expect(publisher.Publish(context.Background(), outbox.Message{})).ToNot(Succeed())
+	})
+
+	When("a topic is configured", func() {
+		BeforeEach(func() {
+			cfg.Topic = "events"
+		})
+
+		It("produces to the topic", func() {
+			Expect(publisher.Publish(ctx, outbox.Message{})).To(Succeed())
+			Expect(producer.produced[0].Topic).To(Equal("events"))
+		})
+	})
+
+	When("a topic func is configured", func() {
+		BeforeEach(func() {
+			cfg.Topic = "ignored"
+			cfg.TopicFunc = func(namespace string) string {
+				return "outbox." + namespace
+			}
+		})
+
+		It("derives the topic from the namespace", func() {
+			Expect(publisher.Publish(ctx, outbox.Message{})).To(Succeed())
+			Expect(producer.produced[0].Topic).To(Equal("outbox.orders"))
+		})
+	})
+
+	When("some records fail to produce", func() {
+		produceErr := errors.New("produce failed")
+
+		BeforeEach(func() {
+			producer.failures["second"] = produceErr
+		})
+
+		It("reports errors aligned with the messages", func() {
+			err := publisher.Publish(ctx,
+				outbox.Message{Payload: []byte("first")},
+				outbox.Message{Payload: []byte("second")},
+				outbox.Message{Payload: []byte("third")},
+			)
+
+			var publishErr *outbox.PublishError
+			Expect(errors.As(err, &publishErr)).To(BeTrue())
+			Expect(publishErr.Errors).To(Equal([]error{nil, produceErr, nil}))
+		})
+	})
+})
@@ -0,0 +1,187 @@
+// Package multi implements outbox.Publisher by fanning messages out to several other outbox.Publisher
+// implementations, e.g. to publish the same outbox to both a broker and a webhook
+package multi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.uber.org/multierr"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// Config configures the behaviour of the Publisher
+type Config struct {
+	// Publishers are the publishers every message is published to
+	Publishers []outbox.Publisher
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if len(c.Publishers) == 0 {
+		return errors.New("no publishers provided")
+	}
+
+	for idx, publisher := range c.Publishers {
+		if publisher == nil {
+			return fmt.Errorf("publisher %d is nil", idx)
+		}
+	}
+
+	return nil
+}
+
+// Publisher implements outbox.Publisher by publishing every message to each of the configured publishers. A message
+// is only considered published once every publisher has published it, otherwise it is reported as failed so that the
+// outbox.Outbox retries it.
+//
+// As retrying a message would publish it again to the publishers that had already published it, the Publisher
+// remembers which publishers have published each message, by its outbox.Message.DedupKey, and only retries the
+// publishers that failed. This is only remembered in memory, until every publisher has published the message or one
+// rejects it permanently, so downstream consumers should still tolerate duplicates, e.g. after a restart or if the
+// message is retried by another processor. Messages without a DedupKey are always published to every publisher.
+type Publisher struct {
+	config Config
+
+	lock sync.Mutex
+	// published records which publishers, by index, have published each message that is yet to be published by all
+	published map[string]map[int]bool
+}
+
+// New attempts to construct a Publisher from the provided Config, if the Config is valid
+func New(cfg Config) (*Publisher, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Publisher{
+		config:    cfg,
+		published: make(map[string]map[int]bool),
+	}, nil
+}
+
+// Publish implements the outbox.Publisher interface, publishing to each publisher concurrently. A message that any
+// publisher fails to publish fails with every error it failed with, and is rejected permanently if any publisher
+// rejected it permanently, as retrying it could never succeed.
+func (p *Publisher) Publish(ctx context.Context, messages ...outbox.Message) error {
+	outcomes := make([][]error, len(p.config.Publishers))
+
+	var wg sync.WaitGroup
+	for idx, publisher := range p.config.Publishers {
+		pending, indices := p.pending(idx, messages)
+		outcomes[idx] = make([]error, len(messages))
+		if len(pending) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(idx int, publisher outbox.Publisher, pending []outbox.Message, indices []int) {
+			defer wg.Done()
+
+			errs := messageErrors(len(pending), publisher.Publish(ctx, pending...))
+			for pendingIdx, err := range errs {
+				if err != nil {
+					err = fmt.Errorf("publisher %d: %w", idx, err)
+				}
+				outcomes[idx][indices[pendingIdx]] = err
+			}
+		}(idx, publisher, pending, indices)
+	}
+	wg.Wait()
+
+	publishErr := &outbox.PublishError{Errors: make([]error, len(messages))}
+	for msgIdx, msg := range messages {
+		var errs []error
+		for idx := range p.config.Publishers {
+			if err := outcomes[idx][msgIdx]; err != nil {
+				errs = append(errs, err)
+			}
+		}
+
+		publishErr.Errors[msgIdx] = multierr.Combine(errs...)
+		p.record(msg, outcomes, msgIdx)
+	}
+
+	if publishErr.ErrorCount() > 0 {
+		return publishErr
+	}
+	return nil
+}
+
+// pending returns the messages the publisher, by index, has yet to publish, along with their indices in messages
+func (p *Publisher) pending(publisher int, messages []outbox.Message) (pending []outbox.Message, indices []int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for idx, msg := range messages {
+		if msg.DedupKey != "" && p.published[msg.DedupKey][publisher] {
+			continue
+		}
+		pending = append(pending, msg)
+		indices = append(indices, idx)
+	}
+
+	return pending, indices
+}
+
+// record remembers which publishers have published the message, forgetting it once every publisher has published it
+// or any has rejected it permanently, as it won't be retried
+func (p *Publisher) record(msg outbox.Message, outcomes [][]error, msgIdx int) {
+	if msg.DedupKey == "" {
+		return
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	published := p.published[msg.DedupKey]
+	if published == nil {
+		published = make(map[int]bool, len(p.config.Publishers))
+	}
+
+	done := true
+	for idx := range p.config.Publishers {
+		err := outcomes[idx][msgIdx]
+		if errors.Is(err, outbox.ErrPermanent) {
+			delete(p.published, msg.DedupKey)
+			return
+		}
+		if err == nil {
+			published[idx] = true
+		}
+		done = done && published[idx]
+	}
+
+	if done {
+		delete(p.published, msg.DedupKey)
+	} else {
+		p.published[msg.DedupKey] = published
+	}
+}
+
+// messageErrors determines the outcome of publishing each of count messages, based on the error returned when
+// publishing them. A PublishError that doesn't describe every message is treated as a total failure.
+func messageErrors(count int, err error) []error {
+	errs := make([]error, count)
+	if err == nil {
+		return errs
+	}
+
+	var publishErr *outbox.PublishError
+	if errors.As(err, &publishErr) && len(publishErr.Errors) == count {
+		copy(errs, publishErr.Errors)
+		return errs
+	}
+
+	for idx := range errs {
+		errs[idx] = err
+	}
+
+	return errs
+}
+
+var _ outbox.Publisher = (*Publisher)(nil)
@@ -0,0 +1,128 @@
+package multi_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-logr/logr"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/publisher/multi"
+)
+
+var _ = Describe("Publisher", func() {
+	var ctx context.Context
+	var kafka, webhook *fake.Publisher
+	var webhookDown bool
+	var publisher *multi.Publisher
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		webhookDown = false
+
+		kafka = &fake.Publisher{
+			Logger: logr.Discard(),
+		}
+		webhook = &fake.Publisher{
+			Logger: logr.Discard(),
+			FailFunc: func(msg outbox.Message) error {
+				if webhookDown {
+					return errors.New("webhook unavailable")
+				}
+				if string(msg.Payload) == "invalid" {
+					return outbox.Permanent(errors.New("webhook rejected message"))
+				}
+				return nil
+			},
+		}
+
+		var err error
+		publisher, err = multi.New(multi.Config{
+			Publishers: []outbox.Publisher{kafka, webhook},
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("requires publishers", func() {
+		_, err := multi.New(multi.Config{})
+		Expect(err).To(MatchError(ContainSubstring("no publishers provided")))
+
+		_, err = multi.New(multi.Config{Publishers: []outbox.Publisher{kafka, nil}})
+		Expect(err).To(MatchError(ContainSubstring("publisher 1 is nil")))
+	})
+
+	It("publishes every message to every publisher", func() {
+		Expect(publisher.Publish(ctx,
+			outbox.Message{Payload: []byte("first"), DedupKey: "1"},
+			outbox.Message{Payload: []byte("second"), DedupKey: "2"},
+		)).To(Succeed())
+
+		Expect(kafka.GetPublishedCount()).To(Equal(2))
+		Expect(webhook.GetPublishedCount()).To(Equal(2))
+	})
+
+	It("fails the messages any publisher fails to publish", func() {
+		webhookDown = true
+
+		err := publisher.Publish(ctx, outbox.Message{Payload: []byte("hello"), DedupKey: "1"})
+		var publishErr *outbox.PublishError
+		Expect(errors.As(err, &publishErr)).To(BeTrue())
+		Expect(publishErr.Errors).To(ConsistOf(MatchError(ContainSubstring("webhook unavailable"))))
+		Expect(kafka.GetPublishedCount()).To(Equal(1))
+	})
+
+	It("only retries the publishers that failed", func() {
+		webhookDown = true
+		msg := outbox.Message{Payload: []byte("hello"), DedupKey: "1"}
+		Expect(publisher.Publish(ctx, msg)).NotTo(Succeed())
+
+		webhookDown = false
+		Expect(publisher.Publish(ctx, msg)).To(Succeed())
+		Expect(kafka.GetPublishedCount()).To(Equal(1))
+		Expect(webhook.GetPublishedCount()).To(Equal(1))
+
+		// once published by every publisher the message is forgotten, so publishing it again reaches both
+		Expect(publisher.Publish(ctx, msg)).To(Succeed())
+		Expect(kafka.GetPublishedCount()).To(Equal(2))
+	})
+
+	It("rejects messages permanently if any publisher does", func() {
+		err := publisher.Publish(ctx, outbox.Message{Payload: []byte("invalid"), DedupKey: "1"})
+		var publishErr *outbox.PublishError
+		Expect(errors.As(err, &publishErr)).To(BeTrue())
+		Expect(publishErr.Errors[0]).To(MatchError(outbox.ErrPermanent))
+	})
+
+	It("retries messages through the Outbox until every publisher has published them", func() {
+		clock := clockwork.NewFakeClock()
+		storage := &fake.EntryStorage{
+			Clock: clock,
+		}
+		ob, err := outbox.New(outbox.Config{
+			Clock:       clock,
+			Storage:     storage,
+			Publisher:   publisher,
+			ProcessorID: "test",
+		})
+		Expect(err).To(Succeed())
+
+		Expect(ob.Publish(ctx, nil, outbox.Message{Payload: []byte("hello")})).To(Succeed())
+
+		webhookDown = true
+		_, err = ob.PumpOutbox(ctx)
+		Expect(err).To(MatchError(ContainSubstring("failed to publish 1/1 messages")))
+		Expect(storage.CountEntries()).To(Equal(1))
+
+		// the entry's claim expires, so the next pump claims and retries it
+		clock.Advance(outbox.DefaultClaimDuration)
+		webhookDown = false
+		Expect(ob.PumpOutbox(ctx)).To(Equal(1))
+		Expect(storage.CountEntries()).To(Equal(0))
+		Expect(kafka.GetPublishedCount()).To(Equal(1))
+		Expect(webhook.GetPublishedCount()).To(Equal(1))
+	})
+})
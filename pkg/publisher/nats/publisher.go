@@ -0,0 +1,182 @@
+// Package nats implements outbox.Publisher on top of NATS JetStream, using the nats.go client
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// DefaultKeyHeader is the header the message Key is published in if Config.KeyHeader is not provided
+const DefaultKeyHeader = "Outboxen-Key"
+
+// JetStream asynchronously publishes messages to JetStream, and is implemented by jetstream.JetStream
+type JetStream interface {
+	PublishMsgAsync(msg *nats.Msg, opts ...jetstream.PublishOpt) (jetstream.PubAckFuture, error)
+}
+
+// SubjectFunc derives the subject to publish to from the namespace the messages were published in
+type SubjectFunc func(namespace string) string
+
+// MsgIDFunc derives the JetStream message ID of a message, used by the server to discard duplicates
+type MsgIDFunc func(msg outbox.Message) string
+
+// Config configures the behaviour of the Publisher
+type Config struct {
+	// JetStream is used to publish messages, typically created with jetstream.New
+	JetStream JetStream
+	// Subject is the subject all messages are published to, unless SubjectFunc is provided
+	Subject string
+	// SubjectFunc derives the subject from the namespace of the messages being published, taking precedence
+	// over Subject. If neither is provided the namespace is used as the subject.
+	SubjectFunc SubjectFunc
+	// KeyHeader is the header the message Key is published in, defaults to DefaultKeyHeader
+	KeyHeader string
+	// KeyToken appends the message Key to the subject as a final token, so that streams and consumers can
+	// filter on it. Keys must then be valid subject tokens, and messages without a Key fail to publish.
+	KeyToken bool
+	// MsgID derives the Nats-Msg-Id of each message, so that JetStream discards messages the outbox
+	// publishes more than once within the stream's duplicate window. Defaults to the message DedupKey.
+	// Messages are not deduplicated if it returns an empty ID.
+	MsgID MsgIDFunc
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.JetStream == nil {
+		return errors.New("no jetstream provided")
+	}
+
+	if c.SubjectFunc == nil {
+		subject := c.Subject
+		c.SubjectFunc = func(namespace string) string {
+			if subject != "" {
+				return subject
+			}
+			return namespace
+		}
+	}
+
+	if c.KeyHeader == "" {
+		c.KeyHeader = DefaultKeyHeader
+	}
+
+	if c.MsgID == nil {
+		c.MsgID = func(msg outbox.Message) string {
+			return msg.DedupKey
+		}
+	}
+
+	return nil
+}
+
+// Publisher implements outbox.Publisher by publishing each message to a JetStream stream, waiting for the
+// server to acknowledge every message before returning
+type Publisher struct {
+	config Config
+}
+
+// New attempts to construct a Publisher from the provided Config, if the Config is valid
+func New(cfg Config) (*Publisher, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Publisher{
+		config: cfg,
+	}, nil
+}
+
+// Publish implements the outbox.Publisher interface. If only some of the messages are not acknowledged, the
+// returned error is an *outbox.PublishError aligned with the provided messages.
+func (p *Publisher) Publish(ctx context.Context, messages ...outbox.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	namespace := outbox.NamespaceFromContext(ctx)
+	subject := p.config.SubjectFunc(namespace)
+	if subject == "" {
+		return fmt.Errorf("no subject for namespace %q", namespace)
+	}
+
+	// publish every message before waiting on any acknowledgement, so the batch costs one round trip
+	errs := make([]error, len(messages))
+	futures := make([]jetstream.PubAckFuture, len(messages))
+	for i, msg := range messages {
+		natsMsg, err := p.natsMsg(subject, msg)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		var opts []jetstream.PublishOpt
+		if id := p.config.MsgID(msg); id != "" {
+			opts = append(opts, jetstream.WithMsgID(id))
+		}
+
+		futures[i], errs[i] = p.config.JetStream.PublishMsgAsync(natsMsg, opts...)
+	}
+
+	failed := false
+	for i, future := range futures {
+		if future != nil {
+			errs[i] = awaitAck(ctx, future)
+		}
+		if errs[i] != nil {
+			failed = true
+		}
+	}
+
+	if failed {
+		return &outbox.PublishError{
+			Errors: errs,
+		}
+	}
+
+	return nil
+}
+
+// natsMsg converts a message to the NATS message to publish to the subject
+func (p *Publisher) natsMsg(subject string, msg outbox.Message) (*nats.Msg, error) {
+	if p.config.KeyToken {
+		if len(msg.Key) == 0 {
+			return nil, errors.New("message has no key to append to the subject")
+		}
+		subject = subject + "." + string(msg.Key)
+	}
+
+	header := nats.Header{}
+	for key, value := range msg.Headers {
+		header.Set(key, string(value))
+	}
+	if len(msg.Key) > 0 {
+		header.Set(p.config.KeyHeader, string(msg.Key))
+	}
+
+	return &nats.Msg{
+		Subject: subject,
+		Header:  header,
+		Data:    msg.Payload,
+	}, nil
+}
+
+// awaitAck waits for the server to acknowledge a published message, or the context to be done
+func awaitAck(ctx context.Context, future jetstream.PubAckFuture) error {
+	select {
+	case <-future.Ok():
+		return nil
+	case err := <-future.Err():
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+var _ outbox.Publisher = (*Publisher)(nil)
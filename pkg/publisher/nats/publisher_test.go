@@ -0,0 +1,176 @@
+package nats_test
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	natspublisher "github.com/omaskery/outboxen/pkg/publisher/nats"
+)
+
+var _ = Describe("Publisher", func() {
+	var ctx context.Context
+	var storeDir string
+	var srv *server.Server
+	var conn *nats.Conn
+	var js jetstream.JetStream
+	var stream jetstream.Stream
+	var cfg natspublisher.Config
+	var publisher *natspublisher.Publisher
+
+	// consume fetches every message in the stream
+	consume := func() []jetstream.Msg {
+		info, err := stream.Info(ctx)
+		Expect(err).To(Succeed())
+
+		consumer, err := stream.OrderedConsumer(ctx, jetstream.OrderedConsumerConfig{})
+		Expect(err).To(Succeed())
+
+		var msgs []jetstream.Msg
+		for uint64(len(msgs)) < info.State.Msgs {
+			msg, err := consumer.Next(jetstream.FetchMaxWait(time.Second))
+			Expect(err).To(Succeed())
+			msgs = append(msgs, msg)
+		}
+		return msgs
+	}
+
+	BeforeEach(func() {
+		ctx = outbox.WithNamespace(context.Background(), "orders")
+
+		var err error
+		storeDir, err = os.MkdirTemp("", "outboxen-nats")
+		Expect(err).To(Succeed())
+
+		srv, err = server.NewServer(&server.Options{
+			Host:      "127.0.0.1",
+			Port:      -1,
+			JetStream: true,
+			StoreDir:  storeDir,
+		})
+		Expect(err).To(Succeed())
+		srv.Start()
+		Expect(srv.ReadyForConnections(5 * time.Second)).To(BeTrue())
+
+		conn, err = nats.Connect(srv.ClientURL())
+		Expect(err).To(Succeed())
+		js, err = jetstream.New(conn)
+		Expect(err).To(Succeed())
+
+		stream, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     "ORDERS",
+			Subjects: []string{"orders", "orders.accepted"},
+		})
+		Expect(err).To(Succeed())
+
+		cfg = natspublisher.Config{
+			JetStream: js,
+		}
+	})
+
+	AfterEach(func() {
+		conn.Close()
+		srv.Shutdown()
+		srv.WaitForShutdown()
+		Expect(os.RemoveAll(storeDir)).To(Succeed())
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		publisher, err = natspublisher.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("fails to construct without a jetstream", func() {
+		_, err := natspublisher.New(natspublisher.Config{})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("publishes messages to the namespace subject", func() {
+		Expect(publisher.Publish(ctx, outbox.Message{
+			Key:     []byte("key"),
+			Payload: []byte("payload"),
+			Headers: map[string][]byte{"Header": []byte("value")},
+		})).To(Succeed())
+
+		msgs := consume()
+		Expect(msgs).To(HaveLen(1))
+		Expect(msgs[0].Subject()).To(Equal("orders"))
+		Expect(msgs[0].Data()).To(Equal([]byte("payload")))
+		Expect(msgs[0].Headers().Get("Header")).To(Equal("value"))
+		Expect(msgs[0].Headers().Get(natspublisher.DefaultKeyHeader)).To(Equal("key"))
+	})
+
+	It("deduplicates messages by their dedup key", func() {
+		msg := outbox.Message{Payload: []byte("payload"), DedupKey: "dedup-key"}
+		Expect(publisher.Publish(ctx, msg)).To(Succeed())
+		Expect(publisher.Publish(ctx, msg)).To(Succeed())
+
+		msgs := consume()
+		Expect(msgs).To(HaveLen(1))
+		Expect(msgs[0].Headers().Get(nats.MsgIdHdr)).To(Equal("dedup-key"))
+	})
+
+	When("a subject is configured", func() {
+		BeforeEach(func() {
+			cfg.Subject = "orders"
+			ctx = context.Background()
+		})
+
+		It("publishes to it regardless of namespace", func() {
+			Expect(publisher.Publish(ctx, outbox.Message{Payload: []byte("payload")})).To(Succeed())
+			Expect(consume()).To(HaveLen(1))
+		})
+	})
+
+	When("the key is appended to the subject", func() {
+		BeforeEach(func() {
+			cfg.KeyToken = true
+		})
+
+		It("only fails the messages that no stream accepts", func() {
+			err := publisher.Publish(ctx,
+				outbox.Message{Key: []byte("rejected"), Payload: []byte("1")},
+				outbox.Message{Key: []byte("accepted"), Payload: []byte("2")},
+				outbox.Message{Payload: []byte("3")},
+			)
+
+			var publishErr *outbox.PublishError
+			Expect(err).To(BeAssignableToTypeOf(publishErr))
+			publishErr = err.(*outbox.PublishError)
+			Expect(publishErr.Errors).To(HaveLen(3))
+			Expect(publishErr.Errors[0]).ToNot(Succeed())
+			Expect(publishErr.Errors[1]).To(Succeed())
+			Expect(publishErr.Errors[2]).ToNot(Succeed())
+
+			msgs := consume()
+			Expect(msgs).To(HaveLen(1))
+			Expect(msgs[0].Subject()).To(Equal("orders.accepted"))
+		})
+	})
+
+	When("message IDs are configured", func() {
+		BeforeEach(func() {
+			cfg.MsgID = func(msg outbox.Message) string {
+				return string(msg.Key)
+			}
+		})
+
+		It("deduplicates messages published more than once", func() {
+			msg := outbox.Message{Key: []byte("id"), Payload: []byte("payload")}
+			Expect(publisher.Publish(ctx, msg)).To(Succeed())
+			Expect(publisher.Publish(ctx, msg)).To(Succeed())
+
+			msgs := consume()
+			Expect(msgs).To(HaveLen(1))
+			Expect(msgs[0].Headers().Get(nats.MsgIdHdr)).To(Equal("id"))
+		})
+	})
+})
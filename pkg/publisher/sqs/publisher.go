@@ -0,0 +1,207 @@
+// Package sqs implements outbox.Publisher on top of Amazon SQS, using the AWS SDK for Go v2
+package sqs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// MaxBatchSize is the most messages SQS accepts in a single SendMessageBatch call
+const MaxBatchSize = 10
+
+// DefaultMessageGroupID is the message group ID of messages without a Key sent to a FIFO queue
+const DefaultMessageGroupID = "outboxen"
+
+// Client sends batches of messages to SQS, and is implemented by *sqs.Client
+type Client interface {
+	SendMessageBatch(ctx context.Context, params *sqs.SendMessageBatchInput, optFns ...func(*sqs.Options)) (*sqs.SendMessageBatchOutput, error)
+}
+
+// QueueURLFunc derives the URL of the queue to send to from the namespace the messages were published in
+type QueueURLFunc func(namespace string) string
+
+// DeduplicationIDFunc derives the deduplication ID of a message sent to a FIFO queue
+type DeduplicationIDFunc func(msg outbox.Message) string
+
+// Config configures the behaviour of the Publisher
+type Config struct {
+	// Client is used to send messages, typically a *sqs.Client
+	Client Client
+	// QueueURL is the URL of the queue all messages are sent to, unless QueueURLFunc is provided
+	QueueURL string
+	// QueueURLFunc derives the queue URL from the namespace of the messages being published, taking
+	// precedence over QueueURL. One of QueueURL or QueueURLFunc must be provided.
+	QueueURLFunc QueueURLFunc
+	// DeduplicationID derives the deduplication ID of messages sent to FIFO queues, so that SQS discards
+	// messages the outbox sends more than once within the deduplication interval. Defaults to the message
+	// DedupKey. If it returns an empty ID, FIFO queues must have content-based deduplication enabled.
+	DeduplicationID DeduplicationIDFunc
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.Client == nil {
+		return errors.New("no client provided")
+	}
+
+	if c.QueueURLFunc == nil {
+		if c.QueueURL == "" {
+			return errors.New("no queue URL provided")
+		}
+
+		queueURL := c.QueueURL
+		c.QueueURLFunc = func(string) string {
+			return queueURL
+		}
+	}
+
+	if c.DeduplicationID == nil {
+		c.DeduplicationID = func(msg outbox.Message) string {
+			return msg.DedupKey
+		}
+	}
+
+	return nil
+}
+
+// Publisher implements outbox.Publisher by sending messages to an SQS queue in batches of up to MaxBatchSize.
+// Messages sent to FIFO queues use their Key as the message group ID, so that messages with the same key are
+// received in the order they were published.
+type Publisher struct {
+	config Config
+}
+
+// New attempts to construct a Publisher from the provided Config, if the Config is valid
+func New(cfg Config) (*Publisher, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Publisher{
+		config: cfg,
+	}, nil
+}
+
+// Publish implements the outbox.Publisher interface. If only some of the messages fail to send, the
+// returned error is an *outbox.PublishError aligned with the provided messages.
+func (p *Publisher) Publish(ctx context.Context, messages ...outbox.Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	namespace := outbox.NamespaceFromContext(ctx)
+	queueURL := p.config.QueueURLFunc(namespace)
+	if queueURL == "" {
+		return fmt.Errorf("no queue URL for namespace %q", namespace)
+	}
+	fifo := strings.HasSuffix(queueURL, ".fifo")
+
+	errs := make([]error, len(messages))
+	for start := 0; start < len(messages); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		p.sendBatch(ctx, queueURL, fifo, messages, start, end, errs)
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return &outbox.PublishError{
+				Errors: errs,
+			}
+		}
+	}
+
+	return nil
+}
+
+// sendBatch sends messages[start:end] in a single call, recording the outcome of each message in errs. Each
+// entry is identified by its index in messages, so the response can be aligned with the input.
+func (p *Publisher) sendBatch(ctx context.Context, queueURL string, fifo bool, messages []outbox.Message, start, end int, errs []error) {
+	entries := make([]types.SendMessageBatchRequestEntry, 0, end-start)
+	for i := start; i < end; i++ {
+		entries = append(entries, p.batchEntry(strconv.Itoa(i), fifo, messages[i]))
+	}
+
+	output, err := p.config.Client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+		QueueUrl: aws.String(queueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		for i := start; i < end; i++ {
+			errs[i] = fmt.Errorf("error sending message batch: %w", err)
+		}
+		return
+	}
+
+	// assume failure until SQS reports otherwise, in case an entry is missing from the response
+	for i := start; i < end; i++ {
+		errs[i] = errors.New("no result for message in batch response")
+	}
+	for _, success := range output.Successful {
+		if i, ok := entryIndex(success.Id, start, end); ok {
+			errs[i] = nil
+		}
+	}
+	for _, failure := range output.Failed {
+		if i, ok := entryIndex(failure.Id, start, end); ok {
+			errs[i] = fmt.Errorf("error sending message: %s: %s", aws.ToString(failure.Code), aws.ToString(failure.Message))
+		}
+	}
+}
+
+// batchEntry converts a message to the batch entry to send with the given ID
+func (p *Publisher) batchEntry(id string, fifo bool, msg outbox.Message) types.SendMessageBatchRequestEntry {
+	entry := types.SendMessageBatchRequestEntry{
+		Id:          aws.String(id),
+		MessageBody: aws.String(string(msg.Payload)),
+	}
+
+	if len(msg.Headers) > 0 {
+		entry.MessageAttributes = make(map[string]types.MessageAttributeValue, len(msg.Headers))
+		for key, value := range msg.Headers {
+			entry.MessageAttributes[key] = types.MessageAttributeValue{
+				DataType:    aws.String("Binary"),
+				BinaryValue: value,
+			}
+		}
+	}
+
+	if fifo {
+		groupID := DefaultMessageGroupID
+		if len(msg.Key) > 0 {
+			groupID = string(msg.Key)
+		}
+		entry.MessageGroupId = aws.String(groupID)
+
+		if dedupID := p.config.DeduplicationID(msg); dedupID != "" {
+			entry.MessageDeduplicationId = aws.String(dedupID)
+		}
+	}
+
+	return entry
+}
+
+// entryIndex parses the index of the message a batch entry ID refers to, ignoring IDs outside of the batch
+func entryIndex(id *string, start, end int) (int, bool) {
+	i, err := strconv.Atoi(aws.ToString(id))
+	if err != nil || i < start || i >= end {
+		return 0, false
+	}
+
+	return i, true
+}
+
+var _ outbox.Publisher = (*Publisher)(nil)
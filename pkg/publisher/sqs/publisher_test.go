@@ -0,0 +1,218 @@
+package sqs_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awssqs "github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/publisher/sqs"
+)
+
+// mockClient records each batch sent, failing entries whose body has a configured failure code and
+// failing whole calls whose index has a configured error
+type mockClient struct {
+	batches    []*awssqs.SendMessageBatchInput
+	failures   map[string]string
+	callErrors map[int]error
+}
+
+func (m *mockClient) SendMessageBatch(_ context.Context, params *awssqs.SendMessageBatchInput, _ ...func(*awssqs.Options)) (*awssqs.SendMessageBatchOutput, error) {
+	call := len(m.batches)
+	m.batches = append(m.batches, params)
+	if err := m.callErrors[call]; err != nil {
+		return nil, err
+	}
+
+	output := &awssqs.SendMessageBatchOutput{}
+	for _, entry := range params.Entries {
+		if code, ok := m.failures[aws.ToString(entry.MessageBody)]; ok {
+			output.Failed = append(output.Failed, types.BatchResultErrorEntry{
+				Id:      entry.Id,
+				Code:    aws.String(code),
+				Message: aws.String("rejected"),
+			})
+			continue
+		}
+		output.Successful = append(output.Successful, types.SendMessageBatchResultEntry{
+			Id: entry.Id,
+		})
+	}
+	return output, nil
+}
+
+var _ = Describe("Publisher", func() {
+	const queueURL = "https://sqs.eu-west-1.amazonaws.com/123456789012/orders"
+
+	var ctx context.Context
+	var client *mockClient
+	var cfg sqs.Config
+	var publisher *sqs.Publisher
+
+	messages := func(n int) []outbox.Message {
+		result := make([]outbox.Message, 0, n)
+		for i := 0; i < n; i++ {
+			result = append(result, outbox.Message{Payload: []byte(fmt.Sprintf("message-%d", i))})
+		}
+		return result
+	}
+
+	publishErrors := func(err error) []error {
+		var publishErr *outbox.PublishError
+		Expect(errors.As(err, &publishErr)).To(BeTrue())
+		return publishErr.Errors
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		client = &mockClient{
+			failures:   map[string]string{},
+			callErrors: map[int]error{},
+		}
+		cfg = sqs.Config{
+			Client:   client,
+			QueueURL: queueURL,
+		}
+	})
+
+	JustBeforeEach(func() {
+		var err error
+		publisher, err = sqs.New(cfg)
+		Expect(err).To(Succeed())
+	})
+
+	It("fails to construct without a client", func() {
+		_, err := sqs.New(sqs.Config{QueueURL: queueURL})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("fails to construct without a queue", func() {
+		_, err := sqs.New(sqs.Config{Client: client})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("maps messages onto batch entries", func() {
+		Expect(publisher.Publish(ctx, outbox.Message{
+			Key:     []byte("key"),
+			Payload: []byte("payload"),
+			Headers: map[string][]byte{"header": []byte("value")},
+		})).To(Succeed())
+
+		Expect(client.batches).To(HaveLen(1))
+		Expect(aws.ToString(client.batches[0].QueueUrl)).To(Equal(queueURL))
+
+		entry := client.batches[0].Entries[0]
+		Expect(aws.ToString(entry.MessageBody)).To(Equal("payload"))
+		Expect(entry.MessageAttributes).To(HaveKeyWithValue("header", types.MessageAttributeValue{
+			DataType:    aws.String("Binary"),
+			BinaryValue: []byte("value"),
+		}))
+		Expect(entry.MessageGroupId).To(BeNil())
+		Expect(entry.MessageDeduplicationId).To(BeNil())
+	})
+
+	It("sends at most ten messages per call", func() {
+		Expect(publisher.Publish(ctx, messages(25)...)).To(Succeed())
+
+		Expect(client.batches).To(HaveLen(3))
+		Expect(client.batches[0].Entries).To(HaveLen(10))
+		Expect(client.batches[1].Entries).To(HaveLen(10))
+		Expect(client.batches[2].Entries).To(HaveLen(5))
+	})
+
+	It("aligns partial batch failures with the published messages", func() {
+		client.failures["message-3"] = "InvalidMessageContents"
+		client.failures["message-14"] = "InvalidMessageContents"
+
+		errs := publishErrors(publisher.Publish(ctx, messages(20)...))
+		Expect(errs).To(HaveLen(20))
+		for i, err := range errs {
+			if i == 3 || i == 14 {
+				Expect(err).To(MatchError(ContainSubstring("InvalidMessageContents")))
+			} else {
+				Expect(err).To(Succeed())
+			}
+		}
+	})
+
+	It("fails every message of a batch whose call fails", func() {
+		client.callErrors[1] = errors.New("throttled")
+
+		errs := publishErrors(publisher.Publish(ctx, messages(15)...))
+		for i, err := range errs {
+			if i >= 10 {
+				Expect(err).To(MatchError(ContainSubstring("throttled")))
+			} else {
+				Expect(err).To(Succeed())
+			}
+		}
+	})
+
+	When("the queue is a FIFO queue", func() {
+		BeforeEach(func() {
+			cfg.QueueURL = queueURL + ".fifo"
+		})
+
+		It("deduplicates messages by their dedup key", func() {
+			Expect(publisher.Publish(ctx,
+				outbox.Message{Payload: []byte("1"), DedupKey: "dedup-key"},
+				outbox.Message{Payload: []byte("2")},
+			)).To(Succeed())
+
+			entries := client.batches[0].Entries
+			Expect(aws.ToString(entries[0].MessageDeduplicationId)).To(Equal("dedup-key"))
+			Expect(entries[1].MessageDeduplicationId).To(BeNil())
+		})
+
+		It("groups messages by key", func() {
+			Expect(publisher.Publish(ctx,
+				outbox.Message{Key: []byte("key"), Payload: []byte("1")},
+				outbox.Message{Payload: []byte("2")},
+			)).To(Succeed())
+
+			entries := client.batches[0].Entries
+			Expect(aws.ToString(entries[0].MessageGroupId)).To(Equal("key"))
+			Expect(aws.ToString(entries[1].MessageGroupId)).To(Equal(sqs.DefaultMessageGroupID))
+		})
+
+		When("a deduplication ID is configured", func() {
+			BeforeEach(func() {
+				cfg.DeduplicationID = func(msg outbox.Message) string {
+					return string(msg.Payload)
+				}
+			})
+
+			It("deduplicates messages by it", func() {
+				Expect(publisher.Publish(ctx, outbox.Message{Payload: []byte("1"), DedupKey: "dedup-key"})).To(Succeed())
+				Expect(aws.ToString(client.batches[0].Entries[0].MessageDeduplicationId)).To(Equal("1"))
+			})
+		})
+	})
+
+	When("the queue is chosen by namespace", func() {
+		BeforeEach(func() {
+			cfg.QueueURLFunc = func(namespace string) string {
+				if namespace == "" {
+					return ""
+				}
+				return queueURL + "-" + namespace
+			}
+		})
+
+		It("sends to the namespace's queue", func() {
+			Expect(publisher.Publish(outbox.WithNamespace(ctx, "eu"), messages(1)...)).To(Succeed())
+			Expect(aws.ToString(client.batches[0].QueueUrl)).To(Equal(queueURL + "-eu"))
+		})
+
+		It("fails if the namespace has no queue", func() {
+			Expect(publisher.Publish(ctx, messages(1)...)).ToNot(Succeed())
+			Expect(client.batches).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,285 @@
+// Package gorm implements outbox.ProcessorStorage using GORM, so that applications which already build their
+// persistence on GORM can write outbox entries in the same transaction as the rest of their changes.
+//
+// The outbox table can be created with Storage.AutoMigrate, which maps Entry onto the configured table.
+package gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	gormlib "gorm.io/gorm"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/internal/sqlutil"
+)
+
+// DefaultTableName is the table used to store outbox entries if Config.TableName is not provided
+const DefaultTableName = sqlutil.DefaultTableName
+
+// Clock abstracts the time package
+type Clock = sqlutil.Clock
+
+// Entry is the GORM model of a row in the outbox table
+type Entry struct {
+	ID                 string     `gorm:"primaryKey;size:36"`
+	Namespace          string     `gorm:"size:255;not null;default:''"`
+	Key                []byte     `gorm:"column:message_key"`
+	Payload            []byte     `gorm:"column:payload"`
+	Headers            []byte     `gorm:"column:headers"`
+	TraceContext       []byte     `gorm:"column:trace_context"`
+	DedupKey           string     `gorm:"size:255;not null;default:''"`
+	NotBefore          *time.Time `gorm:"column:not_before"`
+	Attempts           int        `gorm:"not null;default:0"`
+	Priority           int        `gorm:"not null;default:0"`
+	ProcessorID        *string    `gorm:"size:255;index"`
+	ProcessingDeadline *time.Time `gorm:"column:processing_deadline"`
+	CreatedAt          time.Time  `gorm:"not null"`
+}
+
+// Config configures the behaviour of the Storage
+type Config struct {
+	// DB is the GORM handle used by the processor to claim, retrieve and delete entries
+	DB *gormlib.DB
+	// TableName is the name of the outbox table, defaults to DefaultTableName. It must be an unquoted
+	// identifier of letters, digits and underscores.
+	TableName string
+	// Clock abstracts the time package, defaults to a real clock implementation. It should agree with the
+	// outbox.Config Clock, as it determines when claims expire and when scheduled entries become due.
+	Clock Clock
+	// IDGenerator generates the IDs of the entries, defaults to outbox.UUIDGenerator
+	IDGenerator outbox.IDGenerator
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.DB == nil {
+		return errors.New("no database provided")
+	}
+
+	if c.IDGenerator == nil {
+		c.IDGenerator = outbox.UUIDGenerator
+	}
+
+	return sqlutil.DefaultAndValidate(&c.TableName, &c.Clock)
+}
+
+// Storage implements outbox.ProcessorStorage using GORM
+type Storage struct {
+	config Config
+}
+
+// New attempts to construct a Storage from the provided Config, if the Config is valid
+func New(cfg Config) (*Storage, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Storage{
+		config: cfg,
+	}, nil
+}
+
+// AutoMigrate creates or updates the outbox table to match the Entry model
+func (s *Storage) AutoMigrate(ctx context.Context) error {
+	if err := s.table(s.config.DB.WithContext(ctx)).AutoMigrate(&Entry{}); err != nil {
+		return fmt.Errorf("error migrating table %s: %w", s.config.TableName, err)
+	}
+
+	return nil
+}
+
+// Publish records the provided messages in the outbox table. The txn must be the *gorm.DB of the
+// application's ongoing transaction (e.g. as passed to the callback of gorm.DB.Transaction), so that the
+// messages are only recorded if it commits. If txn is nil, the messages are recorded in a transaction of their own on
+// the configured DB, which has committed by the time Publish returns.
+func (s *Storage) Publish(ctx context.Context, txn interface{}, messages ...outbox.Message) error {
+	if txn == nil {
+		return s.config.DB.WithContext(ctx).Transaction(func(tx *gormlib.DB) error {
+			return s.Publish(ctx, tx, messages...)
+		})
+	}
+
+	db, ok := txn.(*gormlib.DB)
+	if !ok {
+		return fmt.Errorf("unsupported transaction type %T, expected *gorm.DB", txn)
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	namespace := outbox.NamespaceFromContext(ctx)
+	now := s.now()
+
+	entries := make([]Entry, 0, len(messages))
+	for _, msg := range messages {
+		headers, err := sqlutil.MarshalJSON(msg.Headers)
+		if err != nil {
+			return fmt.Errorf("error encoding headers: %w", err)
+		}
+
+		traceContext, err := sqlutil.MarshalJSON(msg.TraceContext)
+		if err != nil {
+			return fmt.Errorf("error encoding trace context: %w", err)
+		}
+
+		var notBefore *time.Time
+		if msg.NotBefore != nil {
+			utc := msg.NotBefore.UTC()
+			notBefore = &utc
+		}
+
+		entries = append(entries, Entry{
+			ID:           s.config.IDGenerator(msg),
+			Namespace:    namespace,
+			Key:          msg.Key,
+			Payload:      msg.Payload,
+			Headers:      headers,
+			TraceContext: traceContext,
+			DedupKey:     msg.DedupKey,
+			NotBefore:    notBefore,
+			Priority:     msg.Priority,
+			CreatedAt:    now,
+		})
+	}
+
+	if err := s.table(db.WithContext(ctx)).Create(&entries).Error; err != nil {
+		return fmt.Errorf("error inserting outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	now := s.now()
+
+	err := s.table(s.config.DB.WithContext(ctx)).
+		Where("(processor_id IS NULL OR processing_deadline IS NULL OR processing_deadline <= ?)", now).
+		Where("(not_before IS NULL OR not_before <= ?)", now).
+		Scopes(inNamespace(ctx)).
+		Updates(map[string]interface{}{
+			"processor_id":        processorID,
+			"processing_deadline": claimDeadline.UTC(),
+			"attempts":            gormlib.Expr("attempts + 1"),
+		}).Error
+	if err != nil {
+		return fmt.Errorf("error claiming outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// RenewClaim implements the outbox.ProcessorStorage interface
+func (s *Storage) RenewClaim(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	err := s.table(s.config.DB.WithContext(ctx)).
+		Where("processor_id = ?", processorID).
+		Update("processing_deadline", claimDeadline.UTC()).Error
+	if err != nil {
+		return fmt.Errorf("error renewing claim on outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// GetClaimedEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) GetClaimedEntries(ctx context.Context, processorID string, batchSize int) ([]outbox.ClaimedEntry, error) {
+	var rows []Entry
+	err := s.table(s.config.DB.WithContext(ctx)).
+		Where("processor_id = ?", processorID).
+		Where("(not_before IS NULL OR not_before <= ?)", s.now()).
+		Scopes(inNamespace(ctx)).
+		Order(sqlutil.OrderBy(ctx, "message_key")).
+		Limit(batchSize).
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("error querying claimed outbox entries: %w", err)
+	}
+
+	entries := make([]outbox.ClaimedEntry, 0, len(rows))
+	for _, row := range rows {
+		entry := outbox.ClaimedEntry{
+			Namespace: row.Namespace,
+			ID:        row.ID,
+			Key:       row.Key,
+			Payload:   row.Payload,
+			NotBefore: row.NotBefore,
+			Attempts:  row.Attempts,
+			DedupKey:  row.DedupKey,
+			Priority:  row.Priority,
+			CreatedAt: row.CreatedAt,
+		}
+
+		if err := sqlutil.UnmarshalJSON(row.Headers, &entry.Headers); err != nil {
+			return nil, fmt.Errorf("error decoding headers of entry %s: %w", row.ID, err)
+		}
+		if err := sqlutil.UnmarshalJSON(row.TraceContext, &entry.TraceContext); err != nil {
+			return nil, fmt.Errorf("error decoding trace context of entry %s: %w", row.ID, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// DeleteEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) DeleteEntries(ctx context.Context, entryIDs ...string) error {
+	if len(entryIDs) == 0 {
+		return nil
+	}
+
+	if err := s.table(s.config.DB.WithContext(ctx)).Where("id IN ?", entryIDs).Delete(&Entry{}).Error; err != nil {
+		return fmt.Errorf("error deleting outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// CountPending implements the outbox.PendingCounter interface
+func (s *Storage) CountPending(ctx context.Context) (int, error) {
+	var count int64
+	if err := s.table(s.config.DB.WithContext(ctx)).Scopes(inNamespace(ctx)).Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("error counting outbox entries: %w", err)
+	}
+
+	return int(count), nil
+}
+
+// Ping implements the outbox.HealthChecker interface by pinging the database
+func (s *Storage) Ping(ctx context.Context) error {
+	db, err := s.config.DB.WithContext(ctx).DB()
+	if err != nil {
+		return fmt.Errorf("error getting database: %w", err)
+	}
+
+	return db.PingContext(ctx)
+}
+
+// table scopes the provided handle to the configured outbox table
+func (s *Storage) table(db *gormlib.DB) *gormlib.DB {
+	return db.Table(s.config.TableName)
+}
+
+// inNamespace restricts queries to the namespace of the context, if it has one
+func inNamespace(ctx context.Context) func(*gormlib.DB) *gormlib.DB {
+	return func(db *gormlib.DB) *gormlib.DB {
+		if namespace, ok := outbox.LookupNamespace(ctx); ok {
+			return db.Where("namespace = ?", namespace)
+		}
+		return db
+	}
+}
+
+// now returns the current time in UTC, so that times compare consistently whatever the database
+func (s *Storage) now() time.Time {
+	return s.config.Clock.Now().UTC()
+}
+
+var _ outbox.ProcessorStorage = (*Storage)(nil)
+var _ outbox.HealthChecker = (*Storage)(nil)
+var _ outbox.PendingCounter = (*Storage)(nil)
@@ -0,0 +1,144 @@
+package gorm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"gorm.io/driver/sqlite"
+	gormlib "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/gorm"
+	"github.com/omaskery/outboxen/pkg/storage/storagetest"
+)
+
+// widget stands in for the application's own state, written in the same transaction as outbox entries
+type widget struct {
+	ID   uint
+	Name string
+}
+
+var _ = Describe("Storage", func() {
+	var ctx context.Context
+	var db *gormlib.DB
+	var clock clockwork.FakeClock
+	var storage *gorm.Storage
+
+	count := func(model interface{}, table string) (count int64) {
+		Expect(db.Table(table).Model(model).Count(&count).Error).To(Succeed())
+		return
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+
+		var err error
+		dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", uuid.NewString())
+		db, err = gormlib.Open(sqlite.Open(dsn), &gormlib.Config{
+			Logger: logger.Default.LogMode(logger.Silent),
+		})
+		Expect(err).To(Succeed())
+		Expect(db.AutoMigrate(&widget{})).To(Succeed())
+
+		// SQLite only supports one writer at a time, so serialise concurrent claims rather than fail them
+		sqlDB, err := db.DB()
+		Expect(err).To(Succeed())
+		sqlDB.SetMaxOpenConns(1)
+
+		storage, err = gorm.New(gorm.Config{
+			DB:    db,
+			Clock: clock,
+		})
+		Expect(err).To(Succeed())
+		Expect(storage.AutoMigrate(ctx)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		sqlDB, err := db.DB()
+		Expect(err).To(Succeed())
+		Expect(sqlDB.Close()).To(Succeed())
+	})
+
+	storagetest.DescribeProcessorStorage(func() storagetest.Harness {
+		return storagetest.Harness{
+			Storage: storage,
+			Clock:   clock,
+			Publish: func(ctx context.Context, messages ...outbox.Message) error {
+				return db.Transaction(func(tx *gormlib.DB) error {
+					return storage.Publish(ctx, tx, messages...)
+				})
+			},
+			CountEntries: func(context.Context) (int, error) {
+				var count int64
+				err := db.Table(gorm.DefaultTableName).Model(&gorm.Entry{}).Count(&count).Error
+				return int(count), err
+			},
+		}
+	})
+
+	It("fails to construct without a database", func() {
+		_, err := gorm.New(gorm.Config{})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("requires a gorm transaction to publish", func() {
+		Expect(storage.Publish(ctx, "not a transaction", outbox.Message{})).ToNot(Succeed())
+	})
+
+	It("publishes in a transaction of its own without a gorm transaction", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("first")}, outbox.Message{})).To(Succeed())
+		Expect(count(&gorm.Entry{}, gorm.DefaultTableName)).To(BeEquivalentTo(2))
+	})
+
+	It("commits published messages along with the surrounding transaction", func() {
+		Expect(db.Transaction(func(tx *gormlib.DB) error {
+			if err := tx.Create(&widget{Name: "committed"}).Error; err != nil {
+				return err
+			}
+			return storage.Publish(ctx, tx, outbox.Message{Payload: []byte("committed")})
+		})).To(Succeed())
+
+		Expect(count(&widget{}, "widgets")).To(BeNumerically("==", 1))
+		Expect(count(&gorm.Entry{}, gorm.DefaultTableName)).To(BeNumerically("==", 1))
+	})
+
+	It("rolls back published messages along with the surrounding transaction", func() {
+		rollback := errors.New("rollback")
+		Expect(db.Transaction(func(tx *gormlib.DB) error {
+			if err := tx.Create(&widget{Name: "rolled back"}).Error; err != nil {
+				return err
+			}
+			if err := storage.Publish(ctx, tx, outbox.Message{Payload: []byte("rolled back")}); err != nil {
+				return err
+			}
+			return rollback
+		})).To(MatchError(rollback))
+
+		Expect(count(&widget{}, "widgets")).To(BeNumerically("==", 0))
+		Expect(count(&gorm.Entry{}, gorm.DefaultTableName)).To(BeNumerically("==", 0))
+	})
+
+	It("uses the configured table name", func() {
+		custom, err := gorm.New(gorm.Config{
+			DB:        db,
+			TableName: "custom_outbox",
+			Clock:     clock,
+		})
+		Expect(err).To(Succeed())
+		Expect(custom.AutoMigrate(ctx)).To(Succeed())
+
+		Expect(db.Transaction(func(tx *gormlib.DB) error {
+			return custom.Publish(ctx, tx, outbox.Message{})
+		})).To(Succeed())
+
+		Expect(count(&gorm.Entry{}, "custom_outbox")).To(BeNumerically("==", 1))
+		Expect(count(&gorm.Entry{}, gorm.DefaultTableName)).To(BeNumerically("==", 0))
+	})
+})
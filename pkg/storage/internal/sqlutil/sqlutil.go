@@ -0,0 +1,128 @@
+// Package sqlutil holds the pieces shared by the SQL backed outbox.ProcessorStorage implementations under pkg/storage
+package sqlutil
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/multierr"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// DefaultTableName is the table used to store outbox entries if no table name is configured
+const DefaultTableName = "outbox_entries"
+
+// Clock abstracts the time package
+type Clock interface {
+	Now() time.Time
+}
+
+// Execer executes SQL statements, and is implemented by *sql.Tx as well as *sql.DB and *sql.Conn
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// identifierPattern matches table names that are safe to interpolate into queries without quoting
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// DefaultAndValidate provides the default table name and clock where none are configured, and ensures the table
+// name is safe to interpolate into queries
+func DefaultAndValidate(tableName *string, clock *Clock) error {
+	if *tableName == "" {
+		*tableName = DefaultTableName
+	}
+	if !identifierPattern.MatchString(*tableName) {
+		return fmt.Errorf("invalid table name %q, must be an unquoted SQL identifier", *tableName)
+	}
+
+	if *clock == nil {
+		*clock = clockwork.NewRealClock()
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes maps as JSON, or nil (NULL) if they are empty
+func MarshalJSON(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case map[string][]byte:
+		if len(m) == 0 {
+			return nil, nil
+		}
+	case map[string]string:
+		if len(m) == 0 {
+			return nil, nil
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes JSON into v, leaving it untouched if data is nil (NULL)
+func UnmarshalJSON(data []byte, v interface{}) error {
+	if data == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// NamespaceArg converts the namespace of the context, as reported by outbox.LookupNamespace, into a query argument
+// for a condition such as (? IS NULL OR namespace = ?), which is NULL if the context has no namespace
+func NamespaceArg(ctx context.Context) interface{} {
+	namespace, ok := outbox.LookupNamespace(ctx)
+	if !ok {
+		return nil
+	}
+
+	return namespace
+}
+
+// OrderBy provides the columns to order claimed entries by, as requested by outbox.OrderingFromContext. Entries are
+// ordered highest priority first and then oldest first, or grouped by the keyColumn and then oldest first for
+// outbox.OrderingPerKey.
+func OrderBy(ctx context.Context, keyColumn string) string {
+	if outbox.OrderingFromContext(ctx) == outbox.OrderingPerKey {
+		return keyColumn + ", created_at, id"
+	}
+
+	return "priority DESC, created_at, id"
+}
+
+// NullableString converts encoded JSON into a query argument for a JSON column, which is NULL if data is nil
+func NullableString(data []byte) interface{} {
+	if data == nil {
+		return nil
+	}
+
+	return string(data)
+}
+
+// InTx runs fn within a new transaction on the db, committing the transaction if fn succeeds and rolling it back
+// otherwise
+func InTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+
+	if err := fn(tx); err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			err = multierr.Combine(err, rollbackErr)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
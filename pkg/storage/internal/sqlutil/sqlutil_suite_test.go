@@ -0,0 +1,13 @@
+package sqlutil_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSqlutil(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Sqlutil Suite")
+}
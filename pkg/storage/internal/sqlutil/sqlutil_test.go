@@ -0,0 +1,89 @@
+package sqlutil_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/internal/sqlutil"
+)
+
+var _ = Describe("DefaultAndValidate", func() {
+	It("provides defaults", func() {
+		tableName := ""
+		var clock sqlutil.Clock
+
+		Expect(sqlutil.DefaultAndValidate(&tableName, &clock)).To(Succeed())
+		Expect(tableName).To(Equal(sqlutil.DefaultTableName))
+		Expect(clock).ToNot(BeNil())
+	})
+
+	DescribeTable("validates the table name",
+		func(tableName string, valid bool) {
+			var clock sqlutil.Clock
+			if valid {
+				Expect(sqlutil.DefaultAndValidate(&tableName, &clock)).To(Succeed())
+			} else {
+				Expect(sqlutil.DefaultAndValidate(&tableName, &clock)).ToNot(Succeed())
+			}
+		},
+		Entry("plain identifier", "outbox", true),
+		Entry("underscores and digits", "_outbox_2", true),
+		Entry("leading digit", "2outbox", false),
+		Entry("schema qualified", "public.outbox", false),
+		Entry("whitespace", "outbox entries", false),
+		Entry("injection", "outbox; DROP TABLE users", false),
+		Entry("quoted", `"outbox"`, false),
+	)
+})
+
+var _ = Describe("NamespaceArg", func() {
+	It("is NULL without a namespace", func() {
+		Expect(sqlutil.NamespaceArg(context.Background())).To(BeNil())
+	})
+
+	It("is the namespace of the context, even if empty", func() {
+		Expect(sqlutil.NamespaceArg(outbox.WithNamespace(context.Background(), "namespace"))).To(Equal("namespace"))
+		Expect(sqlutil.NamespaceArg(outbox.WithNamespace(context.Background(), ""))).To(Equal(""))
+	})
+})
+
+var _ = Describe("OrderBy", func() {
+	It("orders entries highest priority first, then oldest first", func() {
+		Expect(sqlutil.OrderBy(context.Background(), "key")).To(Equal("priority DESC, created_at, id"))
+	})
+
+	It("groups entries by key for per key ordering", func() {
+		ctx := outbox.WithOrdering(context.Background(), outbox.OrderingPerKey)
+		Expect(sqlutil.OrderBy(ctx, "message_key")).To(Equal("message_key, created_at, id"))
+	})
+})
+
+var _ = Describe("JSON columns", func() {
+	It("encodes empty maps as NULL", func() {
+		Expect(sqlutil.MarshalJSON(map[string][]byte{})).To(BeNil())
+		Expect(sqlutil.MarshalJSON(map[string]string(nil))).To(BeNil())
+		Expect(sqlutil.NullableString(nil)).To(BeNil())
+	})
+
+	It("round trips maps", func() {
+		headers := map[string][]byte{"header": []byte("value")}
+
+		data, err := sqlutil.MarshalJSON(headers)
+		Expect(err).To(Succeed())
+		Expect(sqlutil.NullableString(data)).To(Equal(string(data)))
+
+		var decoded map[string][]byte
+		Expect(sqlutil.UnmarshalJSON(data, &decoded)).To(Succeed())
+		Expect(decoded).To(Equal(headers))
+	})
+
+	It("leaves the target untouched for NULL", func() {
+		decoded := map[string]string{"untouched": "true"}
+		Expect(sqlutil.UnmarshalJSON(nil, &decoded)).To(Succeed())
+		Expect(decoded).To(HaveKey("untouched"))
+	})
+})
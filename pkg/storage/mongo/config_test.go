@@ -0,0 +1,22 @@
+package mongo_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/omaskery/outboxen/pkg/storage/mongo"
+)
+
+var _ = Describe("Config", func() {
+	It("requires a collection", func() {
+		_, err := mongo.New(mongo.Config{})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("defaults the clock", func() {
+		cfg := mongo.Config{Collection: &mongodriver.Collection{}}
+		Expect(cfg.DefaultAndValidate()).To(Succeed())
+		Expect(cfg.Clock).ToNot(BeNil())
+	})
+})
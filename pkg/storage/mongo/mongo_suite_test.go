@@ -0,0 +1,19 @@
+package mongo_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The integration tests require a MongoDB replica set, as transactions are unavailable on standalone servers,
+// provided via the OUTBOXEN_MONGO_URI environment variable, e.g.:
+//
+//	docker run --rm -p 27017:27017 mongo:7 --replSet rs0
+//	docker exec <container> mongosh --eval "rs.initiate()"
+//	OUTBOXEN_MONGO_URI="mongodb://localhost:27017/?replicaSet=rs0&directConnection=true" go test -tags integration ./...
+func TestMongo(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Mongo Suite")
+}
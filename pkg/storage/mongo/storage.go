@@ -0,0 +1,306 @@
+// Package mongo implements outbox.ProcessorStorage on top of a MongoDB collection, using the official
+// go.mongodb.org/mongo-driver. Publishing joins the application's ongoing transaction, which requires MongoDB to
+// be deployed as a replica set or sharded cluster.
+//
+// Times are stored as nanoseconds since the Unix epoch, rather than as BSON dates, so that scheduled entries keep
+// the precision of their NotBefore. The index used to find claimed entries can be created with
+// Storage.CreateIndexes.
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// Clock abstracts the time package
+type Clock interface {
+	Now() time.Time
+}
+
+// Config configures the behaviour of the Storage
+type Config struct {
+	// Collection is the collection outbox entries are stored in
+	Collection *mongo.Collection
+	// Clock abstracts the time package, defaults to a real clock implementation. It should agree with the
+	// outbox.Config Clock, as it determines when claims expire and when scheduled entries become due.
+	Clock Clock
+	// IDGenerator generates the IDs of the entries, defaults to outbox.UUIDGenerator
+	IDGenerator outbox.IDGenerator
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.Collection == nil {
+		return errors.New("no collection provided")
+	}
+
+	if c.Clock == nil {
+		c.Clock = clockwork.NewRealClock()
+	}
+
+	if c.IDGenerator == nil {
+		c.IDGenerator = outbox.UUIDGenerator
+	}
+
+	return nil
+}
+
+// document is the representation of an outbox entry in the collection
+type document struct {
+	ID                 string            `bson:"_id"`
+	Namespace          string            `bson:"namespace"`
+	Key                []byte            `bson:"key"`
+	Payload            []byte            `bson:"payload"`
+	Headers            map[string][]byte `bson:"headers,omitempty"`
+	TraceContext       map[string]string `bson:"trace_context,omitempty"`
+	DedupKey           string            `bson:"dedup_key"`
+	NotBefore          *int64            `bson:"not_before"`
+	Attempts           int               `bson:"attempts"`
+	Priority           int               `bson:"priority"`
+	ProcessorID        *string           `bson:"processor_id"`
+	ProcessingDeadline *int64            `bson:"processing_deadline"`
+	CreatedAt          int64             `bson:"created_at"`
+}
+
+// Storage implements outbox.ProcessorStorage using a MongoDB collection
+type Storage struct {
+	config Config
+}
+
+// New attempts to construct a Storage from the provided Config, if the Config is valid
+func New(cfg Config) (*Storage, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Storage{
+		config: cfg,
+	}, nil
+}
+
+// CreateIndexes creates the index used to find the entries claimed by a processor, if it does not already exist
+func (s *Storage) CreateIndexes(ctx context.Context) error {
+	_, err := s.config.Collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "processor_id", Value: 1}, {Key: "created_at", Value: 1}},
+	})
+	if err != nil {
+		return fmt.Errorf("error creating indexes on collection %s: %w", s.config.Collection.Name(), err)
+	}
+
+	return nil
+}
+
+// Publish records the provided messages in the outbox collection. The txn must be the mongo.SessionContext, or
+// mongo.Session, of the application's ongoing transaction, so that the messages are only recorded if it commits. If
+// txn is nil, the messages are recorded in a transaction of their own, which has committed by the time Publish returns,
+// which like any MongoDB transaction requires a replica set or sharded cluster.
+func (s *Storage) Publish(ctx context.Context, txn interface{}, messages ...outbox.Message) error {
+	var sessionCtx mongo.SessionContext
+	switch session := txn.(type) {
+	case nil:
+		return s.config.Collection.Database().Client().UseSession(ctx, func(sessionCtx mongo.SessionContext) error {
+			_, err := sessionCtx.WithTransaction(sessionCtx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+				return nil, s.Publish(ctx, sessionCtx, messages...)
+			})
+			return err
+		})
+	case mongo.SessionContext:
+		sessionCtx = session
+	case mongo.Session:
+		sessionCtx = mongo.NewSessionContext(ctx, session)
+	default:
+		return fmt.Errorf("unsupported transaction type %T, expected mongo.SessionContext or mongo.Session", txn)
+	}
+
+	if len(messages) == 0 {
+		return nil
+	}
+
+	namespace := outbox.NamespaceFromContext(ctx)
+	now := s.config.Clock.Now().UnixNano()
+
+	documents := make([]interface{}, 0, len(messages))
+	for _, msg := range messages {
+		var notBefore *int64
+		if msg.NotBefore != nil {
+			nanos := msg.NotBefore.UnixNano()
+			notBefore = &nanos
+		}
+
+		documents = append(documents, document{
+			ID:           s.config.IDGenerator(msg),
+			Namespace:    namespace,
+			Key:          msg.Key,
+			Payload:      msg.Payload,
+			Headers:      msg.Headers,
+			TraceContext: msg.TraceContext,
+			DedupKey:     msg.DedupKey,
+			NotBefore:    notBefore,
+			Priority:     msg.Priority,
+			CreatedAt:    now,
+		})
+	}
+
+	if _, err := s.config.Collection.InsertMany(sessionCtx, documents); err != nil {
+		return fmt.Errorf("error inserting outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimEntries implements the outbox.ProcessorStorage interface. Each document is updated atomically, and only if
+// it still matches the filter, so an entry is only ever claimed by one of many concurrent processors.
+func (s *Storage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	now := s.config.Clock.Now().UnixNano()
+
+	filter := bson.D{
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "processor_id", Value: nil}},
+			bson.D{{Key: "processing_deadline", Value: nil}},
+			bson.D{{Key: "processing_deadline", Value: bson.D{{Key: "$lte", Value: now}}}},
+		}},
+	}
+	filter = append(filter, dueFilter(now)...)
+	filter = append(filter, namespaceFilter(ctx)...)
+
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "processor_id", Value: processorID},
+			{Key: "processing_deadline", Value: claimDeadline.UnixNano()},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+	}
+
+	if _, err := s.config.Collection.UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("error claiming outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// RenewClaim implements the outbox.ProcessorStorage interface
+func (s *Storage) RenewClaim(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	filter := bson.D{{Key: "processor_id", Value: processorID}}
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "processing_deadline", Value: claimDeadline.UnixNano()}}}}
+
+	if _, err := s.config.Collection.UpdateMany(ctx, filter, update); err != nil {
+		return fmt.Errorf("error renewing claim on outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// GetClaimedEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) GetClaimedEntries(ctx context.Context, processorID string, batchSize int) ([]outbox.ClaimedEntry, error) {
+	filter := bson.D{{Key: "processor_id", Value: processorID}}
+	filter = append(filter, dueFilter(s.config.Clock.Now().UnixNano())...)
+	filter = append(filter, namespaceFilter(ctx)...)
+
+	sort := bson.D{{Key: "priority", Value: -1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}
+	if outbox.OrderingFromContext(ctx) == outbox.OrderingPerKey {
+		sort = bson.D{{Key: "key", Value: 1}, {Key: "created_at", Value: 1}, {Key: "_id", Value: 1}}
+	}
+
+	cursor, err := s.config.Collection.Find(ctx, filter, options.Find().SetSort(sort).SetLimit(int64(batchSize)))
+	if err != nil {
+		return nil, fmt.Errorf("error querying claimed outbox entries: %w", err)
+	}
+
+	var documents []document
+	if err := cursor.All(ctx, &documents); err != nil {
+		return nil, fmt.Errorf("error reading claimed outbox entries: %w", err)
+	}
+
+	entries := make([]outbox.ClaimedEntry, 0, len(documents))
+	for _, doc := range documents {
+		entry := outbox.ClaimedEntry{
+			ID:           doc.ID,
+			Namespace:    doc.Namespace,
+			Key:          doc.Key,
+			Payload:      doc.Payload,
+			Headers:      doc.Headers,
+			TraceContext: doc.TraceContext,
+			DedupKey:     doc.DedupKey,
+			Attempts:     doc.Attempts,
+			Priority:     doc.Priority,
+			CreatedAt:    time.Unix(0, doc.CreatedAt),
+		}
+		if doc.NotBefore != nil {
+			notBefore := time.Unix(0, *doc.NotBefore)
+			entry.NotBefore = &notBefore
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// DeleteEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) DeleteEntries(ctx context.Context, entryIDs ...string) error {
+	if len(entryIDs) == 0 {
+		return nil
+	}
+
+	filter := bson.D{{Key: "_id", Value: bson.D{{Key: "$in", Value: entryIDs}}}}
+	if _, err := s.config.Collection.DeleteMany(ctx, filter); err != nil {
+		return fmt.Errorf("error deleting outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// CountPending implements the outbox.PendingCounter interface
+func (s *Storage) CountPending(ctx context.Context) (int, error) {
+	filter := namespaceFilter(ctx)
+	if filter == nil {
+		filter = bson.D{}
+	}
+
+	count, err := s.config.Collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, fmt.Errorf("error counting outbox entries: %w", err)
+	}
+
+	return int(count), nil
+}
+
+// Ping implements the outbox.HealthChecker interface by pinging the primary of the collection's deployment
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.config.Collection.Database().Client().Ping(ctx, nil)
+}
+
+// dueFilter matches entries that are not scheduled after now
+func dueFilter(now int64) bson.D {
+	return bson.D{{Key: "$and", Value: bson.A{
+		bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "not_before", Value: nil}},
+			bson.D{{Key: "not_before", Value: bson.D{{Key: "$lte", Value: now}}}},
+		}}},
+	}}}
+}
+
+// namespaceFilter matches entries in the namespace of the context, as reported by outbox.LookupNamespace, or every
+// entry if the context has no namespace
+func namespaceFilter(ctx context.Context) bson.D {
+	namespace, ok := outbox.LookupNamespace(ctx)
+	if !ok {
+		return nil
+	}
+
+	return bson.D{{Key: "namespace", Value: namespace}}
+}
+
+var _ outbox.ProcessorStorage = (*Storage)(nil)
+var _ outbox.HealthChecker = (*Storage)(nil)
+var _ outbox.PendingCounter = (*Storage)(nil)
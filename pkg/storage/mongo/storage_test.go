@@ -0,0 +1,132 @@
+//go:build integration
+// +build integration
+
+package mongo_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.mongodb.org/mongo-driver/bson"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/mongo"
+	"github.com/omaskery/outboxen/pkg/storage/storagetest"
+)
+
+var _ = Describe("Storage", func() {
+	var ctx context.Context
+	var client *mongodriver.Client
+	var collection *mongodriver.Collection
+	var clock clockwork.FakeClock
+	var storage *mongo.Storage
+
+	publish := func(ctx context.Context, messages ...outbox.Message) error {
+		session, err := client.StartSession()
+		if err != nil {
+			return err
+		}
+		defer session.EndSession(ctx)
+
+		_, err = session.WithTransaction(ctx, func(sessionCtx mongodriver.SessionContext) (interface{}, error) {
+			return nil, storage.Publish(ctx, sessionCtx, messages...)
+		})
+		return err
+	}
+
+	countEntries := func(ctx context.Context) (int, error) {
+		count, err := collection.CountDocuments(ctx, bson.D{})
+		return int(count), err
+	}
+
+	BeforeEach(func() {
+		uri := os.Getenv("OUTBOXEN_MONGO_URI")
+		if uri == "" {
+			Skip("OUTBOXEN_MONGO_URI not set")
+		}
+
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+
+		var err error
+		client, err = mongodriver.Connect(ctx, options.Client().ApplyURI(uri))
+		Expect(err).To(Succeed())
+
+		collection = client.Database("outboxen").Collection(fmt.Sprintf("outbox_test_%s", uuid.New().String()[:8]))
+		// collections can't be created implicitly within a transaction on older servers
+		Expect(client.Database("outboxen").CreateCollection(ctx, collection.Name())).To(Succeed())
+
+		storage, err = mongo.New(mongo.Config{
+			Collection: collection,
+			Clock:      clock,
+		})
+		Expect(err).To(Succeed())
+		Expect(storage.CreateIndexes(ctx)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if client == nil {
+			return
+		}
+
+		Expect(collection.Drop(ctx)).To(Succeed())
+		Expect(client.Disconnect(ctx)).To(Succeed())
+		client = nil
+	})
+
+	storagetest.DescribeProcessorStorage(func() storagetest.Harness {
+		return storagetest.Harness{
+			Storage:      storage,
+			Clock:        clock,
+			Publish:      publish,
+			CountEntries: countEntries,
+		}
+	})
+
+	It("requires a session to publish", func() {
+		Expect(storage.Publish(ctx, "not a session", outbox.Message{})).ToNot(Succeed())
+	})
+
+	It("publishes in a transaction of its own without a session", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("first")}, outbox.Message{})).To(Succeed())
+		Expect(countEntries(ctx)).To(Equal(2))
+	})
+
+	It("discards published messages if the transaction aborts", func() {
+		session, err := client.StartSession()
+		Expect(err).To(Succeed())
+		defer session.EndSession(ctx)
+
+		rollback := errors.New("rollback")
+		_, err = session.WithTransaction(ctx, func(sessionCtx mongodriver.SessionContext) (interface{}, error) {
+			if err := storage.Publish(ctx, sessionCtx, outbox.Message{Payload: []byte("rolled back")}); err != nil {
+				return nil, err
+			}
+			return nil, rollback
+		})
+		Expect(err).To(MatchError(rollback))
+
+		Expect(countEntries(ctx)).To(Equal(0))
+	})
+
+	It("joins the transaction of a session", func() {
+		session, err := client.StartSession()
+		Expect(err).To(Succeed())
+		defer session.EndSession(ctx)
+
+		Expect(session.StartTransaction()).To(Succeed())
+		Expect(storage.Publish(ctx, session, outbox.Message{Payload: []byte("committed")})).To(Succeed())
+		Expect(countEntries(ctx)).To(Equal(0))
+
+		Expect(session.CommitTransaction(ctx)).To(Succeed())
+		Expect(countEntries(ctx)).To(Equal(1))
+	})
+})
@@ -0,0 +1,18 @@
+package mysql_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// The integration tests require a MySQL 8+ database, provided via the OUTBOXEN_MYSQL_DSN environment
+// variable, e.g.:
+//
+//	docker run --rm -e MYSQL_ROOT_PASSWORD=outboxen -e MYSQL_DATABASE=outboxen -p 3306:3306 mysql:8
+//	OUTBOXEN_MYSQL_DSN="root:outboxen@tcp(localhost:3306)/outboxen?parseTime=true" go test -tags integration ./...
+func TestMySQL(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "MySQL Suite")
+}
@@ -0,0 +1,18 @@
+-- Schema for the default "outbox_entries" table used by the mysql storage, requires MySQL 8+.
+-- If you configure a different Config.TableName, substitute it below.
+CREATE TABLE IF NOT EXISTS outbox_entries (
+    id                  CHAR(36)     NOT NULL PRIMARY KEY,
+    namespace           VARCHAR(255) NOT NULL DEFAULT '',
+    message_key         BLOB,
+    payload             LONGBLOB,
+    headers             JSON,
+    trace_context       JSON,
+    dedup_key           VARCHAR(255) NOT NULL DEFAULT '',
+    not_before          DATETIME(6),
+    attempts            INT          NOT NULL DEFAULT 0,
+    priority            INT          NOT NULL DEFAULT 0,
+    processor_id        VARCHAR(255),
+    processing_deadline DATETIME(6),
+    created_at          DATETIME(6)  NOT NULL,
+    INDEX outbox_entries_processor_id_idx (processor_id, created_at)
+);
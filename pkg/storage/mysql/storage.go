@@ -0,0 +1,328 @@
+// Package mysql implements outbox.ProcessorStorage on top of a MySQL 8+ database, using the database/sql
+// package. Any MySQL driver may be used, e.g. github.com/go-sql-driver/mysql, but it must be configured to
+// parse DATETIME columns into time.Time values (parseTime=true for github.com/go-sql-driver/mysql).
+//
+// The expected table schema can be found in schema.sql, or created with Storage.CreateTable.
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/multierr"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/internal/sqlutil"
+)
+
+// DefaultTableName is the table used to store outbox entries if Config.TableName is not provided
+const DefaultTableName = sqlutil.DefaultTableName
+
+//go:embed schema.sql
+var schema string
+
+// Clock abstracts the time package
+type Clock = sqlutil.Clock
+
+// Execer executes SQL statements, and is implemented by *sql.Tx as well as *sql.DB and *sql.Conn
+type Execer = sqlutil.Execer
+
+// Config configures the behaviour of the Storage
+type Config struct {
+	// DB is the database containing the outbox table
+	DB *sql.DB
+	// TableName is the name of the outbox table, defaults to DefaultTableName. It is interpolated into
+	// queries, so must be an unquoted identifier of letters, digits and underscores.
+	TableName string
+	// Clock abstracts the time package, defaults to a real clock implementation. It should agree with the
+	// outbox.Config Clock, as it determines when claims expire and when scheduled entries become due.
+	Clock Clock
+	// IDGenerator generates the IDs of the entries, defaults to outbox.UUIDGenerator
+	IDGenerator outbox.IDGenerator
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.DB == nil {
+		return errors.New("no database provided")
+	}
+
+	if c.IDGenerator == nil {
+		c.IDGenerator = outbox.UUIDGenerator
+	}
+
+	return sqlutil.DefaultAndValidate(&c.TableName, &c.Clock)
+}
+
+// Storage implements outbox.ProcessorStorage using a MySQL table
+type Storage struct {
+	config Config
+}
+
+// New attempts to construct a Storage from the provided Config, if the Config is valid
+func New(cfg Config) (*Storage, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Storage{
+		config: cfg,
+	}, nil
+}
+
+// CreateTable creates the outbox table, and its indexes, if they do not already exist
+func (s *Storage) CreateTable(ctx context.Context) error {
+	table := s.config.TableName
+
+	if _, err := s.config.DB.ExecContext(ctx, strings.ReplaceAll(schema, DefaultTableName, table)); err != nil {
+		return fmt.Errorf("error creating table %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// Publish records the provided messages in the outbox table. The txn must be an Execer, typically the
+// *sql.Tx of the application's ongoing transaction, so that the messages are only recorded if it commits. If txn is
+// nil, the messages are recorded in a transaction of their own, which has committed by the time Publish returns.
+func (s *Storage) Publish(ctx context.Context, txn interface{}, messages ...outbox.Message) error {
+	if txn == nil {
+		return sqlutil.InTx(ctx, s.config.DB, func(tx *sql.Tx) error {
+			return s.Publish(ctx, tx, messages...)
+		})
+	}
+
+	execer, ok := txn.(Execer)
+	if !ok {
+		return fmt.Errorf("unsupported transaction type %T, expected *sql.Tx", txn)
+	}
+
+	namespace := outbox.NamespaceFromContext(ctx)
+	now := s.now()
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (id, namespace, message_key, payload, headers, trace_context, dedup_key, not_before, priority, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.config.TableName)
+
+	for _, msg := range messages {
+		headers, err := sqlutil.MarshalJSON(msg.Headers)
+		if err != nil {
+			return fmt.Errorf("error encoding headers: %w", err)
+		}
+
+		traceContext, err := sqlutil.MarshalJSON(msg.TraceContext)
+		if err != nil {
+			return fmt.Errorf("error encoding trace context: %w", err)
+		}
+
+		var notBefore interface{}
+		if msg.NotBefore != nil {
+			notBefore = msg.NotBefore.UTC()
+		}
+
+		_, err = execer.ExecContext(ctx, query,
+			s.config.IDGenerator(msg), namespace, msg.Key, msg.Payload, sqlutil.NullableString(headers),
+			sqlutil.NullableString(traceContext), msg.DedupKey, notBefore, msg.Priority, now)
+		if err != nil {
+			return fmt.Errorf("error inserting outbox entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ClaimEntries implements the outbox.ProcessorStorage interface. As MySQL lacks UPDATE ... RETURNING, and
+// can't skip locked rows in an UPDATE, the claimable rows are first locked in a transaction with
+// SELECT ... FOR UPDATE SKIP LOCKED, so rows being claimed by another processor are skipped rather than
+// waited for, before being marked as belonging to the processor. GetClaimedEntries then retrieves them.
+func (s *Storage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) (err error) {
+	tx, err := s.config.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting claim transaction: %w", err)
+	}
+	defer func() {
+		if err == nil {
+			return
+		}
+		if rollbackErr := tx.Rollback(); rollbackErr != nil && !errors.Is(rollbackErr, sql.ErrTxDone) {
+			err = multierr.Combine(err, rollbackErr)
+		}
+	}()
+
+	ids, err := s.lockClaimableEntries(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	if len(ids) > 0 {
+		placeholders, args := inList(ids)
+		query := fmt.Sprintf(`
+			UPDATE %s SET processor_id = ?, processing_deadline = ?, attempts = attempts + 1
+			WHERE id IN (%s)
+		`, s.config.TableName, placeholders)
+
+		args = append([]interface{}{processorID, claimDeadline.UTC()}, args...)
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("error claiming outbox entries: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing claim transaction: %w", err)
+	}
+
+	return nil
+}
+
+// lockClaimableEntries locks the rows that are unclaimed, or whose claim has expired, and are due, returning
+// their IDs. Rows locked by another transaction are skipped.
+func (s *Storage) lockClaimableEntries(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	query := fmt.Sprintf(`
+		SELECT id FROM %s
+		WHERE (processor_id IS NULL OR processing_deadline IS NULL OR processing_deadline <= ?)
+			AND (not_before IS NULL OR not_before <= ?)
+			AND (? IS NULL OR namespace = ?)
+		FOR UPDATE SKIP LOCKED
+	`, s.config.TableName)
+
+	now := s.now()
+	namespace := sqlutil.NamespaceArg(ctx)
+	rows, err := tx.QueryContext(ctx, query, now, now, namespace, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("error locking claimable outbox entries: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error reading claimable outbox entry: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading claimable outbox entries: %w", err)
+	}
+
+	return ids, nil
+}
+
+// RenewClaim implements the outbox.ProcessorStorage interface
+func (s *Storage) RenewClaim(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	query := fmt.Sprintf(`UPDATE %s SET processing_deadline = ? WHERE processor_id = ?`, s.config.TableName)
+
+	if _, err := s.config.DB.ExecContext(ctx, query, claimDeadline.UTC(), processorID); err != nil {
+		return fmt.Errorf("error renewing claim on outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// GetClaimedEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) GetClaimedEntries(ctx context.Context, processorID string, batchSize int) ([]outbox.ClaimedEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT id, namespace, message_key, payload, headers, trace_context, dedup_key, not_before, attempts, priority, created_at
+		FROM %s
+		WHERE processor_id = ? AND (not_before IS NULL OR not_before <= ?) AND (? IS NULL OR namespace = ?)
+		ORDER BY %s
+		LIMIT ?
+	`, s.config.TableName, sqlutil.OrderBy(ctx, "message_key"))
+
+	namespace := sqlutil.NamespaceArg(ctx)
+	rows, err := s.config.DB.QueryContext(ctx, query, processorID, s.now(), namespace, namespace, batchSize)
+	if err != nil {
+		return nil, fmt.Errorf("error querying claimed outbox entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []outbox.ClaimedEntry
+	for rows.Next() {
+		var entry outbox.ClaimedEntry
+		var headers, traceContext []byte
+		var notBefore sql.NullTime
+
+		err := rows.Scan(&entry.ID, &entry.Namespace, &entry.Key, &entry.Payload, &headers, &traceContext,
+			&entry.DedupKey, &notBefore, &entry.Attempts, &entry.Priority, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error reading claimed outbox entry: %w", err)
+		}
+
+		if err := sqlutil.UnmarshalJSON(headers, &entry.Headers); err != nil {
+			return nil, fmt.Errorf("error decoding headers of entry %s: %w", entry.ID, err)
+		}
+		if err := sqlutil.UnmarshalJSON(traceContext, &entry.TraceContext); err != nil {
+			return nil, fmt.Errorf("error decoding trace context of entry %s: %w", entry.ID, err)
+		}
+		if notBefore.Valid {
+			entry.NotBefore = &notBefore.Time
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading claimed outbox entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) DeleteEntries(ctx context.Context, entryIDs ...string) error {
+	if len(entryIDs) == 0 {
+		return nil
+	}
+
+	placeholders, args := inList(entryIDs)
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id IN (%s)`, s.config.TableName, placeholders)
+	if _, err := s.config.DB.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("error deleting outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// CountPending implements the outbox.PendingCounter interface
+func (s *Storage) CountPending(ctx context.Context) (int, error) {
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE (? IS NULL OR namespace = ?)`, s.config.TableName)
+
+	namespace := sqlutil.NamespaceArg(ctx)
+	var count int
+	if err := s.config.DB.QueryRowContext(ctx, query, namespace, namespace).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting outbox entries: %w", err)
+	}
+
+	return count, nil
+}
+
+// Ping implements the outbox.HealthChecker interface by pinging the database
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.config.DB.PingContext(ctx)
+}
+
+// inList builds the placeholders and arguments for an IN (...) clause matching the provided IDs
+func inList(ids []string) (string, []interface{}) {
+	placeholders := make([]string, 0, len(ids))
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	return strings.Join(placeholders, ", "), args
+}
+
+// now returns the current time in UTC, as DATETIME columns carry no time zone
+func (s *Storage) now() time.Time {
+	return s.config.Clock.Now().UTC()
+}
+
+var _ outbox.ProcessorStorage = (*Storage)(nil)
+var _ outbox.HealthChecker = (*Storage)(nil)
+var _ outbox.PendingCounter = (*Storage)(nil)
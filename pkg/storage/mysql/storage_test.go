@@ -0,0 +1,165 @@
+//go:build integration
+// +build integration
+
+package mysql_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/google/uuid"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/mysql"
+	"github.com/omaskery/outboxen/pkg/storage/storagetest"
+)
+
+var _ = Describe("Storage", func() {
+	var ctx context.Context
+	var db *sql.DB
+	var clock clockwork.FakeClock
+	var storage *mysql.Storage
+	var table string
+
+	publish := func(ctx context.Context, messages ...outbox.Message) error {
+		txn, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := storage.Publish(ctx, txn, messages...); err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+		return txn.Commit()
+	}
+
+	countEntries := func(ctx context.Context) (count int, err error) {
+		row := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table))
+		err = row.Scan(&count)
+		return
+	}
+
+	BeforeEach(func() {
+		dsn := os.Getenv("OUTBOXEN_MYSQL_DSN")
+		if dsn == "" {
+			Skip("OUTBOXEN_MYSQL_DSN not set")
+		}
+
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+
+		var err error
+		db, err = sql.Open("mysql", dsn)
+		Expect(err).To(Succeed())
+
+		table = fmt.Sprintf("outbox_test_%s", uuid.New().String()[:8])
+		storage, err = mysql.New(mysql.Config{
+			DB:        db,
+			TableName: table,
+			Clock:     clock,
+		})
+		Expect(err).To(Succeed())
+		Expect(storage.CreateTable(ctx)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if db == nil {
+			return
+		}
+
+		_, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", table))
+		Expect(err).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+		db = nil
+	})
+
+	storagetest.DescribeProcessorStorage(func() storagetest.Harness {
+		return storagetest.Harness{
+			Storage:      storage,
+			Clock:        clock,
+			Publish:      publish,
+			CountEntries: countEntries,
+		}
+	})
+
+	It("requires a transaction to publish", func() {
+		Expect(storage.Publish(ctx, "not a transaction", outbox.Message{})).ToNot(Succeed())
+	})
+
+	It("publishes in a transaction of its own without a transaction", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("first")}, outbox.Message{})).To(Succeed())
+		Expect(countEntries(ctx)).To(Equal(2))
+	})
+
+	It("discards published messages if the transaction rolls back", func() {
+		txn, err := db.BeginTx(ctx, nil)
+		Expect(err).To(Succeed())
+		Expect(storage.Publish(ctx, txn, outbox.Message{Payload: []byte("rolled back")})).To(Succeed())
+		Expect(txn.Rollback()).To(Succeed())
+
+		Expect(countEntries(ctx)).To(Equal(0))
+	})
+
+	When("another transaction holds a lock on an entry", func() {
+		var locker *sql.Tx
+
+		BeforeEach(func() {
+			Expect(publish(ctx, outbox.Message{Payload: []byte("locked")})).To(Succeed())
+			Expect(publish(ctx, outbox.Message{Payload: []byte("unlocked")})).To(Succeed())
+
+			var err error
+			locker, err = db.BeginTx(ctx, nil)
+			Expect(err).To(Succeed())
+
+			var id string
+			row := locker.QueryRowContext(ctx,
+				fmt.Sprintf("SELECT id FROM %s WHERE payload = ? FOR UPDATE", table), []byte("locked"))
+			Expect(row.Scan(&id)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			if locker != nil {
+				_ = locker.Rollback()
+			}
+		})
+
+		It("claims the other entries without waiting for the lock", func() {
+			claimCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+			defer cancel()
+
+			Expect(storage.ClaimEntries(claimCtx, "processor", clock.Now().Add(time.Minute))).To(Succeed())
+
+			entries, err := storage.GetClaimedEntries(ctx, "processor", 10)
+			Expect(err).To(Succeed())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Payload).To(Equal([]byte("unlocked")))
+		})
+
+		It("claims the entry once the lock is released", func() {
+			Expect(locker.Rollback()).To(Succeed())
+			locker = nil
+
+			Expect(storage.ClaimEntries(ctx, "processor", clock.Now().Add(time.Minute))).To(Succeed())
+			Expect(storage.GetClaimedEntries(ctx, "processor", 10)).To(HaveLen(2))
+		})
+	})
+
+	It("stores times in UTC regardless of the clock's location", func() {
+		local := clock.Now().In(time.FixedZone("UTC+5", 5*60*60)).Add(time.Minute)
+		Expect(publish(ctx, outbox.Message{NotBefore: &local})).To(Succeed())
+
+		Expect(storage.ClaimEntries(ctx, "processor", clock.Now().Add(time.Minute))).To(Succeed())
+		Expect(storage.GetClaimedEntries(ctx, "processor", 10)).To(BeEmpty())
+
+		clock.Advance(time.Minute)
+		Expect(storage.ClaimEntries(ctx, "processor", clock.Now().Add(time.Minute))).To(Succeed())
+		Expect(storage.GetClaimedEntries(ctx, "processor", 10)).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,19 @@
+-- Schema for the default "outbox_entries" table used by the postgres storage.
+-- If you configure a different Config.TableName, substitute it below.
+CREATE TABLE IF NOT EXISTS outbox_entries (
+    id                  UUID PRIMARY KEY,
+    namespace           TEXT        NOT NULL DEFAULT '',
+    key                 BYTEA,
+    payload             BYTEA,
+    headers             JSONB,
+    trace_context       JSONB,
+    dedup_key           TEXT        NOT NULL DEFAULT '',
+    not_before          TIMESTAMPTZ,
+    attempts            INTEGER     NOT NULL DEFAULT 0,
+    priority            INTEGER     NOT NULL DEFAULT 0,
+    processor_id        TEXT,
+    processing_deadline TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS outbox_entries_processor_id_idx ON outbox_entries (processor_id, created_at);
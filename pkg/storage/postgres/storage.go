@@ -0,0 +1,252 @@
+// Package postgres implements outbox.ProcessorStorage on top of a PostgreSQL database, using the
+// database/sql package. Any PostgreSQL driver may be used, e.g. github.com/jackc/pgx/v5/stdlib.
+//
+// The expected table schema can be found in schema.sql, or created with Storage.CreateTable.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/internal/sqlutil"
+)
+
+// DefaultTableName is the table used to store outbox entries if Config.TableName is not provided
+const DefaultTableName = sqlutil.DefaultTableName
+
+//go:embed schema.sql
+var schema string
+
+// Clock abstracts the time package
+type Clock = sqlutil.Clock
+
+// Execer executes SQL statements, and is implemented by *sql.Tx as well as *sql.DB and *sql.Conn
+type Execer = sqlutil.Execer
+
+// Config configures the behaviour of the Storage
+type Config struct {
+	// DB is the database containing the outbox table
+	DB *sql.DB
+	// TableName is the name of the outbox table, defaults to DefaultTableName. It is interpolated into
+	// queries, so must be an unquoted identifier of letters, digits and underscores.
+	TableName string
+	// Clock abstracts the time package, defaults to a real clock implementation. It should agree with the
+	// outbox.Config Clock, as it determines when claims expire and when scheduled entries become due.
+	Clock Clock
+	// IDGenerator generates the IDs of the entries, defaults to outbox.UUIDGenerator
+	IDGenerator outbox.IDGenerator
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.DB == nil {
+		return errors.New("no database provided")
+	}
+
+	if c.IDGenerator == nil {
+		c.IDGenerator = outbox.UUIDGenerator
+	}
+
+	return sqlutil.DefaultAndValidate(&c.TableName, &c.Clock)
+}
+
+// Storage implements outbox.ProcessorStorage using a PostgreSQL table
+type Storage struct {
+	config Config
+}
+
+// New attempts to construct a Storage from the provided Config, if the Config is valid
+func New(cfg Config) (*Storage, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Storage{
+		config: cfg,
+	}, nil
+}
+
+// CreateTable creates the outbox table, and its indexes, if they do not already exist
+func (s *Storage) CreateTable(ctx context.Context) error {
+	table := s.config.TableName
+
+	if _, err := s.config.DB.ExecContext(ctx, strings.ReplaceAll(schema, DefaultTableName, table)); err != nil {
+		return fmt.Errorf("error creating table %s: %w", table, err)
+	}
+
+	return nil
+}
+
+// Publish records the provided messages in the outbox table. The txn must be an Execer, typically the
+// *sql.Tx of the application's ongoing transaction, so that the messages are only recorded if it commits. If txn is
+// nil, the messages are recorded in a transaction of their own, which has committed by the time Publish returns.
+func (s *Storage) Publish(ctx context.Context, txn interface{}, messages ...outbox.Message) error {
+	if txn == nil {
+		return sqlutil.InTx(ctx, s.config.DB, func(tx *sql.Tx) error {
+			return s.Publish(ctx, tx, messages...)
+		})
+	}
+
+	execer, ok := txn.(Execer)
+	if !ok {
+		return fmt.Errorf("unsupported transaction type %T, expected *sql.Tx", txn)
+	}
+
+	namespace := outbox.NamespaceFromContext(ctx)
+	now := s.config.Clock.Now()
+
+	query := fmt.Sprintf(`
+		INSERT INTO %s (id, namespace, key, payload, headers, trace_context, dedup_key, not_before, priority, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`, s.config.TableName)
+
+	for _, msg := range messages {
+		headers, err := sqlutil.MarshalJSON(msg.Headers)
+		if err != nil {
+			return fmt.Errorf("error encoding headers: %w", err)
+		}
+
+		traceContext, err := sqlutil.MarshalJSON(msg.TraceContext)
+		if err != nil {
+			return fmt.Errorf("error encoding trace context: %w", err)
+		}
+
+		_, err = execer.ExecContext(ctx, query,
+			s.config.IDGenerator(msg), namespace, msg.Key, msg.Payload, sqlutil.NullableString(headers),
+			sqlutil.NullableString(traceContext), msg.DedupKey, msg.NotBefore, msg.Priority, now)
+		if err != nil {
+			return fmt.Errorf("error inserting outbox entry: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ClaimEntries implements the outbox.ProcessorStorage interface. Rows locked by another processor's
+// ongoing claim are skipped rather than waited for.
+func (s *Storage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	query := fmt.Sprintf(`
+		UPDATE %[1]s SET processor_id = $1, processing_deadline = $2, attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM %[1]s
+			WHERE (processor_id IS NULL OR processing_deadline IS NULL OR processing_deadline <= $3)
+				AND (not_before IS NULL OR not_before <= $3)
+				AND ($4::text IS NULL OR namespace = $4)
+			FOR UPDATE SKIP LOCKED
+		)
+	`, s.config.TableName)
+
+	_, err := s.config.DB.ExecContext(ctx, query, processorID, claimDeadline, s.config.Clock.Now(), sqlutil.NamespaceArg(ctx))
+	if err != nil {
+		return fmt.Errorf("error claiming outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// RenewClaim implements the outbox.ProcessorStorage interface
+func (s *Storage) RenewClaim(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	query := fmt.Sprintf(`UPDATE %s SET processing_deadline = $1 WHERE processor_id = $2`, s.config.TableName)
+
+	if _, err := s.config.DB.ExecContext(ctx, query, claimDeadline, processorID); err != nil {
+		return fmt.Errorf("error renewing claim on outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// GetClaimedEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) GetClaimedEntries(ctx context.Context, processorID string, batchSize int) ([]outbox.ClaimedEntry, error) {
+	query := fmt.Sprintf(`
+		SELECT id, namespace, key, payload, headers, trace_context, dedup_key, not_before, attempts, priority, created_at
+		FROM %s
+		WHERE processor_id = $1 AND (not_before IS NULL OR not_before <= $2) AND ($4::text IS NULL OR namespace = $4)
+		ORDER BY %s
+		LIMIT $3
+	`, s.config.TableName, sqlutil.OrderBy(ctx, "key"))
+
+	rows, err := s.config.DB.QueryContext(ctx, query, processorID, s.config.Clock.Now(), batchSize, sqlutil.NamespaceArg(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("error querying claimed outbox entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []outbox.ClaimedEntry
+	for rows.Next() {
+		var entry outbox.ClaimedEntry
+		var headers, traceContext []byte
+		var notBefore sql.NullTime
+
+		err := rows.Scan(&entry.ID, &entry.Namespace, &entry.Key, &entry.Payload, &headers, &traceContext,
+			&entry.DedupKey, &notBefore, &entry.Attempts, &entry.Priority, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error reading claimed outbox entry: %w", err)
+		}
+
+		if err := sqlutil.UnmarshalJSON(headers, &entry.Headers); err != nil {
+			return nil, fmt.Errorf("error decoding headers of entry %s: %w", entry.ID, err)
+		}
+		if err := sqlutil.UnmarshalJSON(traceContext, &entry.TraceContext); err != nil {
+			return nil, fmt.Errorf("error decoding trace context of entry %s: %w", entry.ID, err)
+		}
+		if notBefore.Valid {
+			entry.NotBefore = &notBefore.Time
+		}
+
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading claimed outbox entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) DeleteEntries(ctx context.Context, entryIDs ...string) error {
+	if len(entryIDs) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(entryIDs))
+	args := make([]interface{}, 0, len(entryIDs))
+	for idx, id := range entryIDs {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", idx+1))
+		args = append(args, id)
+	}
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE id IN (%s)`, s.config.TableName, strings.Join(placeholders, ", "))
+	if _, err := s.config.DB.ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("error deleting outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// CountPending implements the outbox.PendingCounter interface
+func (s *Storage) CountPending(ctx context.Context) (int, error) {
+	query := fmt.Sprintf(`SELECT COUNT(*) FROM %s WHERE ($1::text IS NULL OR namespace = $1)`, s.config.TableName)
+
+	var count int
+	if err := s.config.DB.QueryRowContext(ctx, query, sqlutil.NamespaceArg(ctx)).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting outbox entries: %w", err)
+	}
+
+	return count, nil
+}
+
+// Ping implements the outbox.HealthChecker interface by pinging the database
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.config.DB.PingContext(ctx)
+}
+
+var _ outbox.ProcessorStorage = (*Storage)(nil)
+var _ outbox.HealthChecker = (*Storage)(nil)
+var _ outbox.PendingCounter = (*Storage)(nil)
@@ -0,0 +1,108 @@
+//go:build integration
+// +build integration
+
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/google/uuid"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/postgres"
+	"github.com/omaskery/outboxen/pkg/storage/storagetest"
+)
+
+var _ = Describe("Storage", func() {
+	var ctx context.Context
+	var db *sql.DB
+	var clock clockwork.FakeClock
+	var storage *postgres.Storage
+	var table string
+
+	publish := func(ctx context.Context, messages ...outbox.Message) error {
+		txn, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := storage.Publish(ctx, txn, messages...); err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+		return txn.Commit()
+	}
+
+	countEntries := func(ctx context.Context) (count int, err error) {
+		row := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", table))
+		err = row.Scan(&count)
+		return
+	}
+
+	BeforeEach(func() {
+		dsn := os.Getenv("OUTBOXEN_POSTGRES_DSN")
+		if dsn == "" {
+			Skip("OUTBOXEN_POSTGRES_DSN not set")
+		}
+
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+
+		var err error
+		db, err = sql.Open("pgx", dsn)
+		Expect(err).To(Succeed())
+
+		table = fmt.Sprintf("outbox_test_%s", uuid.New().String()[:8])
+		storage, err = postgres.New(postgres.Config{
+			DB:        db,
+			TableName: table,
+			Clock:     clock,
+		})
+		Expect(err).To(Succeed())
+		Expect(storage.CreateTable(ctx)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if db == nil {
+			return
+		}
+
+		_, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %s", table))
+		Expect(err).To(Succeed())
+		Expect(db.Close()).To(Succeed())
+		db = nil
+	})
+
+	storagetest.DescribeProcessorStorage(func() storagetest.Harness {
+		return storagetest.Harness{
+			Storage:      storage,
+			Clock:        clock,
+			Publish:      publish,
+			CountEntries: countEntries,
+		}
+	})
+
+	It("requires a transaction to publish", func() {
+		Expect(storage.Publish(ctx, "not a transaction", outbox.Message{})).ToNot(Succeed())
+	})
+
+	It("publishes in a transaction of its own without a transaction", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("first")}, outbox.Message{})).To(Succeed())
+		Expect(countEntries(ctx)).To(Equal(2))
+	})
+
+	It("discards published messages if the transaction rolls back", func() {
+		txn, err := db.BeginTx(ctx, nil)
+		Expect(err).To(Succeed())
+		Expect(storage.Publish(ctx, txn, outbox.Message{Payload: []byte("rolled back")})).To(Succeed())
+		Expect(txn.Rollback()).To(Succeed())
+
+		Expect(countEntries(ctx)).To(Equal(0))
+	})
+})
@@ -0,0 +1,22 @@
+package redis_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	redislib "github.com/redis/go-redis/v9"
+
+	"github.com/omaskery/outboxen/pkg/storage/redis"
+)
+
+var _ = Describe("Config", func() {
+	It("requires a client", func() {
+		_, err := redis.New(redis.Config{})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("defaults the key prefix", func() {
+		cfg := redis.Config{Client: redislib.NewClient(&redislib.Options{})}
+		Expect(cfg.DefaultAndValidate()).To(Succeed())
+		Expect(cfg.KeyPrefix).To(Equal(redis.DefaultKeyPrefix))
+	})
+})
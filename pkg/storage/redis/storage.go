@@ -0,0 +1,392 @@
+// Package redis implements outbox.ProcessorStorage on top of Redis, using github.com/redis/go-redis, for a simple
+// at least once pipeline without a SQL database.
+//
+// Redis can't take part in a transaction with the application's own database, so the txn provided to
+// Storage.Publish is ignored and messages are recorded as soon as Publish returns. This storage is only suitable
+// where messages don't need to be published atomically with other state changes.
+//
+// Each entry is stored in a hash, while a sorted set holds the ID of every entry scored by the time, in
+// microseconds since the Unix epoch, at which it may next be claimed: when it becomes due for unclaimed entries,
+// or when the claim expires for claimed entries. A set per processor holds the IDs of the entries it has claimed.
+package redis
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// DefaultKeyPrefix is the prefix of every key used to store outbox entries if Config.KeyPrefix is not provided.
+// Its braces form a Redis Cluster hash tag, keeping every key in the same slot so they can be used together in
+// scripts.
+const DefaultKeyPrefix = "{outbox}"
+
+// Clock abstracts the time package
+type Clock interface {
+	Now() time.Time
+}
+
+// Config configures the behaviour of the Storage
+type Config struct {
+	// Client is the Redis client used to store outbox entries
+	Client redis.UniversalClient
+	// KeyPrefix is the prefix of every key used to store outbox entries, defaults to DefaultKeyPrefix. When using
+	// Redis Cluster, it must contain a hash tag so that every key is in the same slot.
+	KeyPrefix string
+	// Clock abstracts the time package, defaults to a real clock implementation. It should agree with the
+	// outbox.Config Clock, as it determines when claims expire and when scheduled entries become due.
+	Clock Clock
+	// IDGenerator generates the IDs of the entries, defaults to outbox.UUIDGenerator
+	IDGenerator outbox.IDGenerator
+}
+
+// DefaultAndValidate ensures the configuration is valid and, where possible, provides reasonable
+// default values where no value is provided
+func (c *Config) DefaultAndValidate() error {
+	if c.Client == nil {
+		return errors.New("no client provided")
+	}
+
+	if c.KeyPrefix == "" {
+		c.KeyPrefix = DefaultKeyPrefix
+	}
+
+	if c.Clock == nil {
+		c.Clock = clockwork.NewRealClock()
+	}
+
+	if c.IDGenerator == nil {
+		c.IDGenerator = outbox.UUIDGenerator
+	}
+
+	return nil
+}
+
+// record holds the parts of an entry that don't change once it is published
+type record struct {
+	Key          []byte            `json:"key,omitempty"`
+	Payload      []byte            `json:"payload,omitempty"`
+	Headers      map[string][]byte `json:"headers,omitempty"`
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+	DedupKey     string            `json:"dedup_key,omitempty"`
+	NotBefore    *time.Time        `json:"not_before,omitempty"`
+	Priority     int               `json:"priority,omitempty"`
+	CreatedAt    time.Time         `json:"created_at"`
+}
+
+// claimScript claims every entry that may be claimed by the deadline, optionally only those in a namespace, moving
+// each from the claimed set of its previous processor, if any, to that of the claiming processor
+var claimScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+for _, id in ipairs(ids) do
+	local entry = ARGV[4] .. id
+	if ARGV[6] == '0' or redis.call('HGET', entry, 'namespace') == ARGV[7] then
+		local previous = redis.call('HGET', entry, 'processor_id')
+		if previous then
+			redis.call('SREM', ARGV[5] .. previous, id)
+		end
+		redis.call('HSET', entry, 'processor_id', ARGV[3])
+		redis.call('HINCRBY', entry, 'attempts', 1)
+		redis.call('ZADD', KEYS[1], ARGV[2], id)
+		redis.call('SADD', KEYS[2], id)
+	end
+end
+return 0
+`)
+
+// renewScript extends the claim deadline of every entry in the claimed set of a processor
+var renewScript = redis.NewScript(`
+for _, id in ipairs(redis.call('SMEMBERS', KEYS[2])) do
+	redis.call('ZADD', KEYS[1], 'XX', ARGV[1], id)
+end
+return 0
+`)
+
+// deleteScript deletes entries, removing them from the claimed set of their processor, if any
+var deleteScript = redis.NewScript(`
+for idx = 3, #ARGV do
+	local id = ARGV[idx]
+	local entry = ARGV[1] .. id
+	local processor = redis.call('HGET', entry, 'processor_id')
+	if processor then
+		redis.call('SREM', ARGV[2] .. processor, id)
+	end
+	redis.call('DEL', entry)
+	redis.call('ZREM', KEYS[1], id)
+end
+return 0
+`)
+
+// countScript counts the entries in a namespace, which Redis doesn't index, so every entry's namespace is read
+var countScript = redis.NewScript(`
+local count = 0
+for _, id in ipairs(redis.call('ZRANGE', KEYS[1], 0, -1)) do
+	if redis.call('HGET', ARGV[1] .. id, 'namespace') == ARGV[2] then
+		count = count + 1
+	end
+end
+return count
+`)
+
+// Storage implements outbox.ProcessorStorage using Redis
+type Storage struct {
+	config Config
+}
+
+// New attempts to construct a Storage from the provided Config, if the Config is valid
+func New(cfg Config) (*Storage, error) {
+	if err := cfg.DefaultAndValidate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	return &Storage{
+		config: cfg,
+	}, nil
+}
+
+// Publish records the provided messages in Redis. The txn is ignored, as Redis can't take part in the
+// application's transaction, so the messages are recorded whether or not the application's transaction commits.
+func (s *Storage) Publish(ctx context.Context, txn interface{}, messages ...outbox.Message) error {
+	namespace := outbox.NamespaceFromContext(ctx)
+	now := s.config.Clock.Now()
+
+	_, err := s.config.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, msg := range messages {
+			data, err := json.Marshal(record{
+				Key:          msg.Key,
+				Payload:      msg.Payload,
+				Headers:      msg.Headers,
+				TraceContext: msg.TraceContext,
+				DedupKey:     msg.DedupKey,
+				NotBefore:    msg.NotBefore,
+				Priority:     msg.Priority,
+				CreatedAt:    now,
+			})
+			if err != nil {
+				return fmt.Errorf("error encoding outbox entry: %w", err)
+			}
+
+			due := now
+			if msg.NotBefore != nil {
+				due = *msg.NotBefore
+			}
+
+			id := s.config.IDGenerator(msg)
+			pipe.HSet(ctx, s.entryKey(id), "namespace", namespace, "attempts", 0, "record", data)
+			pipe.ZAdd(ctx, s.pendingKey(), redis.Z{Score: score(due), Member: id})
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error inserting outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimEntries implements the outbox.ProcessorStorage interface. Entries are claimed by a script, which Redis runs
+// atomically, so an entry is only ever claimed by one of many concurrent processors.
+func (s *Storage) ClaimEntries(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	hasNamespace := "0"
+	namespace, ok := outbox.LookupNamespace(ctx)
+	if ok {
+		hasNamespace = "1"
+	}
+
+	keys := []string{s.pendingKey(), s.claimedKey(processorID)}
+	err := claimScript.Run(ctx, s.config.Client, keys,
+		score(s.config.Clock.Now()), score(claimDeadline), processorID, s.entryKey(""), s.claimedKey(""),
+		hasNamespace, namespace).Err()
+	if err != nil {
+		return fmt.Errorf("error claiming outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// RenewClaim implements the outbox.ProcessorStorage interface
+func (s *Storage) RenewClaim(ctx context.Context, processorID string, claimDeadline time.Time) error {
+	keys := []string{s.pendingKey(), s.claimedKey(processorID)}
+	if err := renewScript.Run(ctx, s.config.Client, keys, score(claimDeadline)).Err(); err != nil {
+		return fmt.Errorf("error renewing claim on outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// GetClaimedEntries implements the outbox.ProcessorStorage interface. Redis can't sort the claimed entries by
+// their contents, so every entry claimed by the processor is read before the oldest batch is returned.
+func (s *Storage) GetClaimedEntries(ctx context.Context, processorID string, batchSize int) ([]outbox.ClaimedEntry, error) {
+	ids, err := s.config.Client.SMembers(ctx, s.claimedKey(processorID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("error querying claimed outbox entries: %w", err)
+	}
+
+	cmds, err := s.config.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			pipe.HMGet(ctx, s.entryKey(id), "namespace", "attempts", "processor_id", "record")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error querying claimed outbox entries: %w", err)
+	}
+
+	namespace, scoped := outbox.LookupNamespace(ctx)
+	now := s.config.Clock.Now()
+
+	var entries []outbox.ClaimedEntry
+	for idx, cmd := range cmds {
+		fields := cmd.(*redis.SliceCmd).Val()
+		if fields[3] == nil || fields[2] != processorID {
+			// the entry was deleted or reclaimed since its ID was read
+			continue
+		}
+
+		entry := outbox.ClaimedEntry{
+			ID:        ids[idx],
+			Namespace: fields[0].(string),
+		}
+		if scoped && entry.Namespace != namespace {
+			continue
+		}
+
+		if entry.Attempts, err = strconv.Atoi(fields[1].(string)); err != nil {
+			return nil, fmt.Errorf("error decoding attempts of entry %s: %w", entry.ID, err)
+		}
+
+		var rec record
+		if err := json.Unmarshal([]byte(fields[3].(string)), &rec); err != nil {
+			return nil, fmt.Errorf("error decoding entry %s: %w", entry.ID, err)
+		}
+		if rec.NotBefore != nil && rec.NotBefore.After(now) {
+			continue
+		}
+
+		entry.Key = rec.Key
+		entry.Payload = rec.Payload
+		entry.Headers = rec.Headers
+		entry.TraceContext = rec.TraceContext
+		entry.DedupKey = rec.DedupKey
+		entry.NotBefore = rec.NotBefore
+		entry.Priority = rec.Priority
+		entry.CreatedAt = rec.CreatedAt
+
+		entries = append(entries, entry)
+	}
+
+	perKey := outbox.OrderingFromContext(ctx) == outbox.OrderingPerKey
+	sort.Sort(claimOrder{entries: entries, perKey: perKey})
+
+	if len(entries) > batchSize {
+		entries = entries[:batchSize]
+	}
+
+	return entries, nil
+}
+
+// DeleteEntries implements the outbox.ProcessorStorage interface
+func (s *Storage) DeleteEntries(ctx context.Context, entryIDs ...string) error {
+	if len(entryIDs) == 0 {
+		return nil
+	}
+
+	args := make([]interface{}, 0, len(entryIDs)+2)
+	args = append(args, s.entryKey(""), s.claimedKey(""))
+	for _, id := range entryIDs {
+		args = append(args, id)
+	}
+
+	if err := deleteScript.Run(ctx, s.config.Client, []string{s.pendingKey()}, args...).Err(); err != nil {
+		return fmt.Errorf("error deleting outbox entries: %w", err)
+	}
+
+	return nil
+}
+
+// CountPending implements the outbox.PendingCounter interface. Counting the entries in a namespace reads the
+// namespace of every entry, so takes longer the more entries there are in any namespace.
+func (s *Storage) CountPending(ctx context.Context) (int, error) {
+	var count int64
+	var err error
+	if namespace, ok := outbox.LookupNamespace(ctx); ok {
+		count, err = countScript.Run(ctx, s.config.Client, []string{s.pendingKey()}, s.entryKey(""), namespace).Int64()
+	} else {
+		count, err = s.config.Client.ZCard(ctx, s.pendingKey()).Result()
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error counting outbox entries: %w", err)
+	}
+
+	return int(count), nil
+}
+
+// Ping implements the outbox.HealthChecker interface by pinging the Redis server
+func (s *Storage) Ping(ctx context.Context) error {
+	return s.config.Client.Ping(ctx).Err()
+}
+
+// pendingKey is the sorted set of entry IDs, scored by when they may next be claimed
+func (s *Storage) pendingKey() string {
+	return s.config.KeyPrefix + ":pending"
+}
+
+// claimedKey is the set of IDs of the entries claimed by a processor
+func (s *Storage) claimedKey(processorID string) string {
+	return s.config.KeyPrefix + ":claimed:" + processorID
+}
+
+// entryKey is the hash holding an entry
+func (s *Storage) entryKey(id string) string {
+	return s.config.KeyPrefix + ":entry:" + id
+}
+
+// score converts a time into a sorted set score, in microseconds since the Unix epoch so that it is represented
+// exactly by the float64 scores of Redis
+func score(t time.Time) float64 {
+	return float64(t.UnixMicro())
+}
+
+// claimOrder sorts claimed entries highest priority first and then oldest first, or grouped by key and then oldest
+// first if perKey is set
+type claimOrder struct {
+	entries []outbox.ClaimedEntry
+	perKey  bool
+}
+
+func (c claimOrder) Len() int {
+	return len(c.entries)
+}
+
+func (c claimOrder) Less(i, j int) bool {
+	a, b := c.entries[i], c.entries[j]
+	if c.perKey {
+		if cmp := bytes.Compare(a.Key, b.Key); cmp != 0 {
+			return cmp < 0
+		}
+	} else if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.ID < b.ID
+}
+
+func (c claimOrder) Swap(i, j int) {
+	c.entries[i], c.entries[j] = c.entries[j], c.entries[i]
+}
+
+var _ outbox.ProcessorStorage = (*Storage)(nil)
+var _ outbox.HealthChecker = (*Storage)(nil)
+var _ outbox.PendingCounter = (*Storage)(nil)
@@ -0,0 +1,86 @@
+package redis_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	redislib "github.com/redis/go-redis/v9"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/redis"
+	"github.com/omaskery/outboxen/pkg/storage/storagetest"
+)
+
+var _ = Describe("Storage", func() {
+	const processorID = "processor"
+
+	var ctx context.Context
+	var server *miniredis.Miniredis
+	var client *redislib.Client
+	var clock clockwork.FakeClock
+	var storage *redis.Storage
+
+	newStorage := func(keyPrefix string) *redis.Storage {
+		storage, err := redis.New(redis.Config{
+			Client:    client,
+			KeyPrefix: keyPrefix,
+			Clock:     clock,
+		})
+		Expect(err).To(Succeed())
+		return storage
+	}
+
+	countEntries := func(ctx context.Context) (int, error) {
+		count, err := client.ZCard(ctx, redis.DefaultKeyPrefix+":pending").Result()
+		return int(count), err
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+
+		var err error
+		server, err = miniredis.Run()
+		Expect(err).To(Succeed())
+		client = redislib.NewClient(&redislib.Options{Addr: server.Addr()})
+
+		storage = newStorage("")
+	})
+
+	AfterEach(func() {
+		Expect(client.Close()).To(Succeed())
+		server.Close()
+	})
+
+	storagetest.DescribeProcessorStorage(func() storagetest.Harness {
+		return storagetest.Harness{
+			Storage: storage,
+			Clock:   clock,
+			Publish: func(ctx context.Context, messages ...outbox.Message) error {
+				return storage.Publish(ctx, nil, messages...)
+			},
+			CountEntries: countEntries,
+		}
+	})
+
+	It("ignores the transaction", func() {
+		Expect(storage.Publish(ctx, "not a transaction", outbox.Message{})).To(Succeed())
+		Expect(countEntries(ctx)).To(Equal(1))
+	})
+
+	It("keeps the entries of outboxes with different key prefixes apart", func() {
+		other := newStorage("{other}")
+		Expect(other.Publish(ctx, nil, outbox.Message{Payload: []byte("other")})).To(Succeed())
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("default")})).To(Succeed())
+
+		Expect(storage.ClaimEntries(ctx, processorID, clock.Now().Add(time.Minute))).To(Succeed())
+		entries, err := storage.GetClaimedEntries(ctx, processorID, 10)
+		Expect(err).To(Succeed())
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Payload).To(Equal([]byte("default")))
+	})
+})
@@ -0,0 +1,28 @@
+package sqlite_test
+
+import (
+	"database/sql"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/storage/sqlite"
+)
+
+var _ = Describe("Config", func() {
+	It("requires a database", func() {
+		_, err := sqlite.New(sqlite.Config{})
+		Expect(err).ToNot(Succeed())
+	})
+
+	It("defaults the table name", func() {
+		cfg := sqlite.Config{DB: &sql.DB{}}
+		Expect(cfg.DefaultAndValidate()).To(Succeed())
+		Expect(cfg.TableName).To(Equal(sqlite.DefaultTableName))
+	})
+
+	It("rejects table names that are unsafe to interpolate into queries", func() {
+		cfg := sqlite.Config{DB: &sql.DB{}, TableName: "outbox; DROP TABLE users"}
+		Expect(cfg.DefaultAndValidate()).ToNot(Succeed())
+	})
+})
@@ -0,0 +1,20 @@
+-- Schema for the default "outbox_entries" table used by the sqlite storage. Times are stored as nanoseconds since
+-- the Unix epoch, so that they compare correctly regardless of how the driver formats time.Time values.
+-- If you configure a different Config.TableName, substitute it below.
+CREATE TABLE IF NOT EXISTS outbox_entries (
+    id                  TEXT    NOT NULL PRIMARY KEY,
+    namespace           TEXT    NOT NULL DEFAULT '',
+    message_key         BLOB,
+    payload             BLOB,
+    headers             TEXT,
+    trace_context       TEXT,
+    dedup_key           TEXT    NOT NULL DEFAULT '',
+    not_before          INTEGER,
+    attempts            INTEGER NOT NULL DEFAULT 0,
+    priority            INTEGER NOT NULL DEFAULT 0,
+    processor_id        TEXT,
+    processing_deadline INTEGER,
+    created_at          INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS outbox_entries_processor_id_idx ON outbox_entries (processor_id, created_at);
@@ -0,0 +1,116 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/sqlite"
+	"github.com/omaskery/outboxen/pkg/storage/storagetest"
+)
+
+var _ = Describe("Storage", func() {
+	const processorID = "processor"
+
+	var ctx context.Context
+	var db *sql.DB
+	var clock clockwork.FakeClock
+	var storage *sqlite.Storage
+
+	publish := func(ctx context.Context, messages ...outbox.Message) error {
+		txn, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := storage.Publish(ctx, txn, messages...); err != nil {
+			_ = txn.Rollback()
+			return err
+		}
+		return txn.Commit()
+	}
+
+	countEntries := func(ctx context.Context) (count int, err error) {
+		row := db.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", sqlite.DefaultTableName))
+		err = row.Scan(&count)
+		return
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		clock = clockwork.NewFakeClock()
+
+		var err error
+		db, err = sql.Open("sqlite3", ":memory:")
+		Expect(err).To(Succeed())
+		// every connection to :memory: opens a separate database, so they must all share the one connection
+		db.SetMaxOpenConns(1)
+
+		storage, err = sqlite.New(sqlite.Config{
+			DB:    db,
+			Clock: clock,
+		})
+		Expect(err).To(Succeed())
+		Expect(storage.CreateTable(ctx)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).To(Succeed())
+	})
+
+	storagetest.DescribeProcessorStorage(func() storagetest.Harness {
+		return storagetest.Harness{
+			Storage:      storage,
+			Clock:        clock,
+			Publish:      publish,
+			CountEntries: countEntries,
+		}
+	})
+
+	It("requires a transaction to publish", func() {
+		Expect(storage.Publish(ctx, "not a transaction", outbox.Message{})).ToNot(Succeed())
+	})
+
+	It("publishes in a transaction of its own without a transaction", func() {
+		Expect(storage.Publish(ctx, nil, outbox.Message{Payload: []byte("first")}, outbox.Message{})).To(Succeed())
+		Expect(countEntries(ctx)).To(Equal(2))
+	})
+
+	It("discards published messages if the transaction rolls back", func() {
+		txn, err := db.BeginTx(ctx, nil)
+		Expect(err).To(Succeed())
+		Expect(storage.Publish(ctx, txn, outbox.Message{Payload: []byte("rolled back")})).To(Succeed())
+		Expect(txn.Rollback()).To(Succeed())
+
+		Expect(countEntries(ctx)).To(Equal(0))
+	})
+
+	It("deletes more entries than fit in a single statement", func() {
+		const entryCount = 2*sqlite.MaxDeleteBatchSize + 1
+
+		messages := make([]outbox.Message, entryCount)
+		Expect(publish(ctx, messages...)).To(Succeed())
+		Expect(publish(ctx, outbox.Message{Payload: []byte("kept")})).To(Succeed())
+
+		Expect(storage.ClaimEntries(ctx, processorID, clock.Now().Add(time.Minute))).To(Succeed())
+		entries, err := storage.GetClaimedEntries(ctx, processorID, entryCount+1)
+		Expect(err).To(Succeed())
+
+		var ids []string
+		for _, entry := range entries {
+			if string(entry.Payload) != "kept" {
+				ids = append(ids, entry.ID)
+			}
+		}
+		Expect(ids).To(HaveLen(entryCount))
+
+		Expect(storage.DeleteEntries(ctx, ids...)).To(Succeed())
+		Expect(countEntries(ctx)).To(Equal(1))
+	})
+})
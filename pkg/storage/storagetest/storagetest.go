@@ -0,0 +1,379 @@
+// Package storagetest provides Ginkgo specs describing the behaviour every outbox.ProcessorStorage implementation
+// must have, so that implementations can verify themselves against the same contract.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+)
+
+// Harness provides the specs with an empty storage to test
+type Harness struct {
+	// Storage is the implementation under test, which must contain no entries
+	Storage outbox.ProcessorStorage
+	// Clock is the fake clock used by Storage to determine when claims expire and entries become due
+	Clock clockwork.FakeClock
+	// Publish records the messages in Storage, in whatever transaction the implementation requires,
+	// committing them before returning
+	Publish func(ctx context.Context, messages ...outbox.Message) error
+	// CountEntries counts all entries in Storage, regardless of whether they are claimed
+	CountEntries func(ctx context.Context) (int, error)
+}
+
+// DescribeProcessorStorage registers specs verifying the outbox.ProcessorStorage contract. The setup function is
+// called before each spec to provide a fresh Harness, and may use Ginkgo's Skip if the storage is unavailable.
+func DescribeProcessorStorage(setup func() Harness) {
+	Describe("ProcessorStorage contract", func() {
+		const processorID = "processor"
+		const claimDuration = time.Minute
+
+		var ctx context.Context
+		var h Harness
+
+		publish := func(messages ...outbox.Message) {
+			Expect(h.Publish(ctx, messages...)).To(Succeed())
+		}
+
+		claim := func(processorID string) {
+			Expect(h.Storage.ClaimEntries(ctx, processorID, h.Clock.Now().Add(claimDuration))).To(Succeed())
+		}
+
+		claimed := func(processorID string, batchSize int) []outbox.ClaimedEntry {
+			entries, err := h.Storage.GetClaimedEntries(ctx, processorID, batchSize)
+			Expect(err).To(Succeed())
+			return entries
+		}
+
+		count := func() int {
+			n, err := h.CountEntries(ctx)
+			Expect(err).To(Succeed())
+			return n
+		}
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			h = setup()
+		})
+
+		It("round trips every field of a message", func() {
+			notBefore := h.Clock.Now().Add(-time.Second).UTC().Truncate(time.Microsecond)
+			msg := outbox.Message{
+				Key:          []byte("key"),
+				Payload:      []byte("payload"),
+				Headers:      map[string][]byte{"header": []byte("value")},
+				NotBefore:    &notBefore,
+				TraceContext: map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+				DedupKey:     "dedup-key",
+				Priority:     7,
+			}
+			publishedAt := h.Clock.Now()
+			Expect(h.Publish(outbox.WithNamespace(ctx, "namespace"), msg)).To(Succeed())
+
+			claim(processorID)
+			entries := claimed(processorID, 10)
+			Expect(entries).To(HaveLen(1))
+
+			entry := entries[0]
+			Expect(entry.ID).ToNot(BeEmpty())
+			Expect(entry.Namespace).To(Equal("namespace"))
+			Expect(entry.Key).To(Equal(msg.Key))
+			Expect(entry.Payload).To(Equal(msg.Payload))
+			Expect(entry.Headers).To(Equal(msg.Headers))
+			Expect(entry.TraceContext).To(Equal(msg.TraceContext))
+			Expect(entry.DedupKey).To(Equal(msg.DedupKey))
+			Expect(entry.Priority).To(Equal(msg.Priority))
+			Expect(entry.NotBefore.Equal(notBefore)).To(BeTrue())
+			Expect(entry.CreatedAt).To(BeTemporally("==", publishedAt))
+		})
+
+		It("omits optional fields that were not provided", func() {
+			publish(outbox.Message{Payload: []byte("bare")})
+
+			claim(processorID)
+			entries := claimed(processorID, 10)
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Headers).To(BeEmpty())
+			Expect(entries[0].TraceContext).To(BeEmpty())
+			Expect(entries[0].NotBefore).To(BeNil())
+			Expect(entries[0].DedupKey).To(BeEmpty())
+		})
+
+		It("only retrieves entries claimed by the processor", func() {
+			publish(outbox.Message{})
+
+			Expect(claimed(processorID, 10)).To(BeEmpty())
+			claim("other")
+			Expect(claimed(processorID, 10)).To(BeEmpty())
+		})
+
+		It("retrieves at most a batch of entries, oldest first", func() {
+			for i := 0; i < 3; i++ {
+				publish(outbox.Message{Payload: []byte(fmt.Sprintf("message-%d", i))})
+				h.Clock.Advance(time.Second)
+			}
+
+			claim(processorID)
+			entries := claimed(processorID, 2)
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Payload).To(Equal([]byte("message-0")))
+			Expect(entries[1].Payload).To(Equal([]byte("message-1")))
+		})
+
+		It("retrieves higher priority entries first, oldest first within each priority", func() {
+			for i, priority := range []int{0, 1, -1, 1, 0} {
+				publish(outbox.Message{Payload: []byte(fmt.Sprintf("%d-%d", priority, i)), Priority: priority})
+				h.Clock.Advance(time.Second)
+			}
+
+			claim(processorID)
+			entries := claimed(processorID, 4)
+
+			payloads := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				payloads = append(payloads, string(entry.Payload))
+			}
+			Expect(payloads).To(Equal([]string{"1-1", "1-3", "0-0", "0-4"}))
+		})
+
+		It("ignores priority when grouping entries by key for per key ordering", func() {
+			publish(outbox.Message{Key: []byte("a"), Payload: []byte("low")})
+			h.Clock.Advance(time.Second)
+			publish(outbox.Message{Key: []byte("a"), Payload: []byte("high"), Priority: 1})
+
+			claim(processorID)
+			entries, err := h.Storage.GetClaimedEntries(outbox.WithOrdering(ctx, outbox.OrderingPerKey), processorID, 10)
+			Expect(err).To(Succeed())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Payload).To(Equal([]byte("low")))
+			Expect(entries[1].Payload).To(Equal([]byte("high")))
+		})
+
+		It("groups entries by key, oldest first, for per key ordering", func() {
+			for i, key := range []string{"b", "a", "b", "a"} {
+				publish(outbox.Message{Key: []byte(key), Payload: []byte(fmt.Sprintf("%s-%d", key, i))})
+				h.Clock.Advance(time.Second)
+			}
+
+			claim(processorID)
+			entries, err := h.Storage.GetClaimedEntries(outbox.WithOrdering(ctx, outbox.OrderingPerKey), processorID, 3)
+			Expect(err).To(Succeed())
+
+			payloads := make([]string, 0, len(entries))
+			for _, entry := range entries {
+				payloads = append(payloads, string(entry.Payload))
+			}
+			Expect(payloads).To(Equal([]string{"a-1", "a-3", "b-0"}))
+		})
+
+		It("counts each claim as an attempt", func() {
+			publish(outbox.Message{})
+
+			claim(processorID)
+			Expect(claimed(processorID, 10)[0].Attempts).To(Equal(1))
+
+			h.Clock.Advance(claimDuration)
+			claim(processorID)
+			Expect(claimed(processorID, 10)[0].Attempts).To(Equal(2))
+		})
+
+		It("does not claim entries claimed by another processor until their claim expires", func() {
+			publish(outbox.Message{Payload: []byte("contended")})
+
+			claim("first")
+			claim("second")
+			Expect(claimed("second", 10)).To(BeEmpty())
+			Expect(claimed("first", 10)[0].Attempts).To(Equal(1))
+
+			h.Clock.Advance(claimDuration)
+			claim("second")
+			Expect(claimed("second", 10)).To(HaveLen(1))
+			Expect(claimed("first", 10)).To(BeEmpty())
+		})
+
+		It("claims each entry for exactly one of many concurrent processors", func() {
+			const processors = 5
+			const messages = 50
+			for i := 0; i < messages; i++ {
+				publish(outbox.Message{Payload: []byte(fmt.Sprintf("message-%d", i))})
+			}
+
+			var wg sync.WaitGroup
+			for i := 0; i < processors; i++ {
+				wg.Add(1)
+				go func(processorID string) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					claim(processorID)
+				}(fmt.Sprintf("processor-%d", i))
+			}
+			wg.Wait()
+
+			seen := map[string]bool{}
+			for i := 0; i < processors; i++ {
+				for _, entry := range claimed(fmt.Sprintf("processor-%d", i), messages) {
+					Expect(seen).ToNot(HaveKey(entry.ID))
+					seen[entry.ID] = true
+				}
+			}
+			Expect(seen).To(HaveLen(messages))
+		})
+
+		It("keeps entries claimed by the processor while it renews its claim", func() {
+			publish(outbox.Message{Payload: []byte("slow")})
+
+			claim("first")
+			h.Clock.Advance(claimDuration / 2)
+			Expect(h.Storage.RenewClaim(ctx, "first", h.Clock.Now().Add(claimDuration))).To(Succeed())
+
+			h.Clock.Advance(claimDuration / 2)
+			claim("second")
+			Expect(claimed("second", 10)).To(BeEmpty())
+			Expect(claimed("first", 10)).To(HaveLen(1))
+
+			h.Clock.Advance(claimDuration / 2)
+			claim("second")
+			Expect(claimed("second", 10)).To(HaveLen(1))
+		})
+
+		It("only renews the claims of the processor", func() {
+			publish(outbox.Message{})
+
+			claim("first")
+			Expect(h.Storage.RenewClaim(ctx, "second", h.Clock.Now().Add(2*claimDuration))).To(Succeed())
+
+			h.Clock.Advance(claimDuration)
+			claim("second")
+			Expect(claimed("second", 10)).To(HaveLen(1))
+		})
+
+		It("only claims and retrieves entries in the namespace of the context", func() {
+			publish(outbox.Message{Payload: []byte("default")})
+			Expect(h.Publish(outbox.WithNamespace(ctx, "first"), outbox.Message{Payload: []byte("first")})).To(Succeed())
+			Expect(h.Publish(outbox.WithNamespace(ctx, "second"), outbox.Message{Payload: []byte("second")})).To(Succeed())
+
+			firstCtx := outbox.WithNamespace(ctx, "first")
+			Expect(h.Storage.ClaimEntries(firstCtx, processorID, h.Clock.Now().Add(claimDuration))).To(Succeed())
+			entries := claimed(processorID, 10)
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Namespace).To(Equal("first"))
+
+			// an empty namespace is a namespace of its own
+			defaultCtx := outbox.WithNamespace(ctx, "")
+			Expect(h.Storage.ClaimEntries(defaultCtx, processorID, h.Clock.Now().Add(claimDuration))).To(Succeed())
+			entries, err := h.Storage.GetClaimedEntries(defaultCtx, processorID, 10)
+			Expect(err).To(Succeed())
+			Expect(entries).To(HaveLen(1))
+			Expect(entries[0].Payload).To(Equal([]byte("default")))
+
+			claim(processorID)
+			Expect(claimed(processorID, 10)).To(HaveLen(3))
+		})
+
+		It("does not claim entries until they are due", func() {
+			notBefore := h.Clock.Now().Add(time.Minute)
+			publish(outbox.Message{NotBefore: &notBefore})
+
+			claim(processorID)
+			Expect(claimed(processorID, 10)).To(BeEmpty())
+
+			h.Clock.Advance(time.Minute)
+			claim(processorID)
+			Expect(claimed(processorID, 10)).To(HaveLen(1))
+		})
+
+		It("deletes only the specified entries", func() {
+			publish(outbox.Message{}, outbox.Message{})
+			claim(processorID)
+			entries := claimed(processorID, 10)
+
+			Expect(h.Storage.DeleteEntries(ctx)).To(Succeed())
+			Expect(count()).To(Equal(2))
+
+			Expect(h.Storage.DeleteEntries(ctx, entries[0].ID)).To(Succeed())
+			Expect(count()).To(Equal(1))
+			Expect(claimed(processorID, 10)).To(ConsistOf(entries[1]))
+		})
+
+		It("reports that it is reachable, if it implements outbox.HealthChecker", func() {
+			checker, ok := h.Storage.(outbox.HealthChecker)
+			if !ok {
+				Skip("storage does not implement outbox.HealthChecker")
+			}
+
+			Expect(checker.Ping(ctx)).To(Succeed())
+		})
+
+		It("peeks at entries without claiming them, if it implements outbox.EntryPeeker", func() {
+			peeker, ok := h.Storage.(outbox.EntryPeeker)
+			if !ok {
+				Skip("storage does not implement outbox.EntryPeeker")
+			}
+
+			publish(outbox.Message{Payload: []byte("1")}, outbox.Message{Payload: []byte("2")})
+			claim("other")
+
+			peeked, err := peeker.PeekEntries(ctx, 10)
+			Expect(err).To(Succeed())
+			Expect(peeked).To(HaveLen(2))
+
+			claim(processorID)
+			Expect(claimed(processorID, 10)).To(BeEmpty())
+			Expect(claimed("other", 10)).To(ConsistOf(peeked))
+		})
+
+		It("counts pending entries, if it implements outbox.PendingCounter", func() {
+			counter, ok := h.Storage.(outbox.PendingCounter)
+			if !ok {
+				Skip("storage does not implement outbox.PendingCounter")
+			}
+
+			countPending := func(ctx context.Context) int {
+				n, err := counter.CountPending(ctx)
+				Expect(err).To(Succeed())
+				return n
+			}
+
+			Expect(countPending(ctx)).To(Equal(0))
+
+			later := h.Clock.Now().Add(time.Hour)
+			publish(outbox.Message{Payload: []byte("1")}, outbox.Message{Payload: []byte("2"), NotBefore: &later})
+			Expect(h.Publish(outbox.WithNamespace(ctx, "other"), outbox.Message{Payload: []byte("3")})).To(Succeed())
+			claim("other")
+
+			Expect(countPending(ctx)).To(Equal(3))
+			Expect(countPending(outbox.WithNamespace(ctx, "other"))).To(Equal(1))
+
+			entries := claimed("other", 10)
+			Expect(entries).To(HaveLen(2))
+			Expect(h.Storage.DeleteEntries(ctx, entries[0].ID, entries[1].ID)).To(Succeed())
+			Expect(countPending(ctx)).To(Equal(1))
+		})
+
+		It("publishes entries through an outbox", func() {
+			publisher := &fake.Publisher{}
+			ob, err := outbox.New(outbox.Config{
+				Clock:       h.Clock,
+				Storage:     h.Storage,
+				Publisher:   publisher,
+				ProcessorID: processorID,
+				BatchSize:   2,
+			})
+			Expect(err).To(Succeed())
+
+			publish(outbox.Message{Payload: []byte("1")}, outbox.Message{Payload: []byte("2")}, outbox.Message{Payload: []byte("3")})
+			Expect(ob.PumpOutbox(ctx)).Error().To(Succeed())
+
+			Expect(publisher.GetPublishedCount()).To(Equal(3))
+			Expect(count()).To(Equal(0))
+		})
+	})
+}
@@ -0,0 +1,13 @@
+package storagetest_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestStoragetest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Storagetest Suite")
+}
@@ -0,0 +1,32 @@
+package storagetest_test
+
+import (
+	"context"
+
+	"github.com/jonboulle/clockwork"
+	. "github.com/onsi/ginkgo"
+
+	"github.com/omaskery/outboxen/pkg/fake"
+	"github.com/omaskery/outboxen/pkg/outbox"
+	"github.com/omaskery/outboxen/pkg/storage/storagetest"
+)
+
+var _ = Describe("fake.EntryStorage", func() {
+	storagetest.DescribeProcessorStorage(func() storagetest.Harness {
+		clock := clockwork.NewFakeClock()
+		storage := &fake.EntryStorage{
+			Clock: clock,
+		}
+
+		return storagetest.Harness{
+			Storage: storage,
+			Clock:   clock,
+			Publish: func(ctx context.Context, messages ...outbox.Message) error {
+				return storage.Publish(ctx, nil, messages...)
+			},
+			CountEntries: func(context.Context) (int, error) {
+				return storage.CountEntries(), nil
+			},
+		}
+	})
+})